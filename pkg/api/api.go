@@ -274,11 +274,15 @@ func (a *API) RegisterAPI(httpPathPrefix string, actualCfg any, defaultCfg any)
 }
 
 // RegisterRuntimeConfig registers the endpoints associates with the runtime configuration
-func (a *API) RegisterRuntimeConfig(runtimeConfigHandler http.HandlerFunc) {
+func (a *API) RegisterRuntimeConfig(runtimeConfigHandler, statusHandler, listenersHandler http.HandlerFunc) {
 	a.indexPage.AddLink(SectionAdminEndpoints, "/runtime_config", "Current Runtime Config (incl. Overrides)")
 	a.indexPage.AddLink(SectionAdminEndpoints, "/runtime_config?mode=diff", "Current Runtime Config (show only values that differ from the defaults)")
+	a.indexPage.AddLink(SectionAdminEndpoints, "/runtime_config/status", "Runtime Config Reload Status")
+	a.indexPage.AddLink(SectionAdminEndpoints, "/runtime_config/listeners", "Runtime Config Listeners")
 
 	a.RegisterRoute("/runtime_config", runtimeConfigHandler, false, "GET")
+	a.RegisterRoute("/runtime_config/status", statusHandler, false, "GET")
+	a.RegisterRoute("/runtime_config/listeners", listenersHandler, false, "GET")
 }
 
 // RegisterDistributor registers the endpoints associated with the distributor.
@@ -416,6 +420,12 @@ func (a *API) RegisterCompactor(c *compactor.Compactor) {
 	a.RegisterRoute("/compactor/ring", http.HandlerFunc(c.RingHandler), false, "GET", "POST")
 }
 
+// RegisterStoreGatewayClients registers the page exposing the health of the querier's store-gateway clients.
+func (a *API) RegisterStoreGatewayClients(q *querier.BlocksStoreQueryable) {
+	a.indexPage.AddLink(SectionAdminEndpoints, "/querier/store-gateway-clients", "Store Gateway Clients Health")
+	a.RegisterRoute("/querier/store-gateway-clients", http.HandlerFunc(q.StoreGatewayClientsHandler), false, "GET")
+}
+
 type Distributor interface {
 	querier.Distributor
 	UserStatsHandler(w http.ResponseWriter, r *http.Request)
@@ -166,6 +166,7 @@ func (t *Cortex) initRuntimeConfig() (services.Service, error) {
 	}
 	runtimeConfigLoader := runtimeConfigLoader{cfg: t.Cfg}
 	t.Cfg.RuntimeConfig.Loader = runtimeConfigLoader.load
+	t.Cfg.RuntimeConfig.Merger = runtimeconfig.NewSectionMerger(t.Cfg.RuntimeConfig.MergeStrategies)
 
 	// make sure to set default limits before we start loading configuration into memory
 	validation.SetDefaultLimitsForYAMLUnmarshalling(t.Cfg.LimitsConfig)
@@ -173,22 +174,13 @@ func (t *Cortex) initRuntimeConfig() (services.Service, error) {
 	registerer := prometheus.WrapRegistererWithPrefix("cortex_", prometheus.DefaultRegisterer)
 	logger := util_log.Logger
 	bucketClientFactory := func(ctx context.Context) (objstore.Bucket, error) {
-		// When directory is an empty string but the runtime-config.file is an absolute path,
-		// the filesystem.NewBucketClient will treat it as a relative path based on the current working directory
-		// that the process is running in.
-		if t.Cfg.RuntimeConfig.StorageConfig.Backend == bucket.Filesystem {
-			if t.Cfg.RuntimeConfig.StorageConfig.Filesystem.Directory == "" {
-				// Check if runtime-config.file is an absolute path
-				if t.Cfg.RuntimeConfig.LoadPath[0] == '/' {
-					// If it is, set the directory to the root directory so that the filesystem bucket
-					// will treat it as an absolute path. This is to maintain backwards compatibility
-					// with the previous behavior of the runtime-config.file of allowing relative and absolute paths.
-					t.Cfg.RuntimeConfig.StorageConfig.Filesystem.Directory = "/"
-				}
-			}
-		}
+		setRuntimeConfigFilesystemDirectory(&t.Cfg.RuntimeConfig.StorageConfig, t.Cfg.RuntimeConfig.LoadPath)
 		return bucket.NewClient(ctx, t.Cfg.RuntimeConfig.StorageConfig, nil, "runtime-config", logger, registerer)
 	}
+	t.Cfg.RuntimeConfig.FallbackBucketClientFactory = func(ctx context.Context, index int, source runtimeconfig.Source) (objstore.Bucket, error) {
+		setRuntimeConfigFilesystemDirectory(&source.StorageConfig, source.LoadPath)
+		return bucket.NewClient(ctx, source.StorageConfig, nil, fmt.Sprintf("runtime-config-fallback-%d", index), logger, registerer)
+	}
 	serv, err := runtimeconfig.New(t.Cfg.RuntimeConfig, registerer, logger, bucketClientFactory)
 	if err == nil {
 		// TenantLimits just delegates to RuntimeConfig and doesn't have any state or need to do
@@ -198,10 +190,29 @@ func (t *Cortex) initRuntimeConfig() (services.Service, error) {
 	}
 
 	t.RuntimeConfig = serv
-	t.API.RegisterRuntimeConfig(runtimeConfigHandler(t.RuntimeConfig, t.Cfg.LimitsConfig))
+	t.API.RegisterRuntimeConfig(runtimeConfigHandler(t.RuntimeConfig, t.Cfg.LimitsConfig), t.RuntimeConfig.StatusHandler, t.RuntimeConfig.ListenersHandler)
 	return serv, err
 }
 
+// setRuntimeConfigFilesystemDirectory sets the directory of the filesystem bucket the runtime
+// config files at loadPath are read from, if not set.
+func setRuntimeConfigFilesystemDirectory(cfg *bucket.Config, loadPath string) {
+	// When directory is an empty string but the runtime-config.file is an absolute path,
+	// the filesystem.NewBucketClient will treat it as a relative path based on the current working directory
+	// that the process is running in.
+	if cfg.Backend == bucket.Filesystem {
+		if cfg.Filesystem.Directory == "" {
+			// Check if runtime-config.file is an absolute path
+			if loadPath[0] == '/' {
+				// If it is, set the directory to the root directory so that the filesystem bucket
+				// will treat it as an absolute path. This is to maintain backwards compatibility
+				// with the previous behavior of the runtime-config.file of allowing relative and absolute paths.
+				cfg.Filesystem.Directory = "/"
+			}
+		}
+	}
+}
+
 func (t *Cortex) initOverrides() (services.Service, error) {
 	t.Overrides = validation.NewOverrides(t.Cfg.LimitsConfig, t.TenantLimits)
 	// overrides don't have operational state, nor do they need to do anything more in starting/stopping phase,
@@ -432,6 +443,7 @@ func (t *Cortex) initStoreQueryables() (services.Service, error) {
 		return nil, fmt.Errorf("failed to initialize querier: %v", err)
 	} else {
 		queriable = q
+		t.API.RegisterStoreGatewayClients(q)
 		if t.Cfg.Querier.EnableParquetQueryable {
 			pq, err := querier.NewParquetQueryable(t.Cfg.Querier, t.Cfg.BlocksStorage, t.Overrides, q, util_log.Logger, prometheus.DefaultRegisterer)
 			if err != nil {
@@ -930,7 +942,7 @@ func (t *Cortex) setupModuleManager() error {
 		Flusher:                  {Overrides, API},
 		Queryable:                {Overrides, DistributorService, Overrides, Ring, API, StoreQueryable, MemberlistKV},
 		Querier:                  {TenantFederation},
-		StoreQueryable:           {Overrides, Overrides, MemberlistKV, GrpcClientService},
+		StoreQueryable:           {API, Overrides, Overrides, MemberlistKV, GrpcClientService},
 		QueryFrontendTripperware: {API, Overrides},
 		QueryFrontend:            {QueryFrontendTripperware},
 		QueryScheduler:           {API, Overrides},
@@ -1,6 +1,7 @@
 package cortex
 
 import (
+	"bytes"
 	"errors"
 	"io"
 	"net/http"
@@ -31,6 +32,15 @@ type RuntimeConfigValues struct {
 	IngesterChunkStreaming *bool `yaml:"ingester_stream_chunks_when_using_blocks"`
 
 	IngesterLimits *ingester.InstanceLimits `yaml:"ingester_limits"`
+
+	// tenantSizes holds the size, in bytes, of each tenant's overrides section.
+	// It's only computed when a max tenant config size is configured.
+	tenantSizes map[string]int
+}
+
+// TenantSizes implements runtimeconfig.TenantSizer.
+func (v *RuntimeConfigValues) TenantSizes() map[string]int {
+	return v.tenantSizes
 }
 
 // runtimeConfigTenantLimits provides per-tenant limit overrides based on a runtimeconfig.Manager
@@ -67,12 +77,17 @@ type runtimeConfigLoader struct {
 func (l runtimeConfigLoader) load(r io.Reader) (any, error) {
 	var overrides = &RuntimeConfigValues{}
 
-	decoder := yaml.NewDecoder(r)
+	buf, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+
+	decoder := yaml.NewDecoder(bytes.NewReader(buf))
 	decoder.SetStrict(true)
 
 	// Decode the first document. An empty document (EOF) is OK.
 	if err := decoder.Decode(&overrides); err != nil && !errors.Is(err, io.EOF) {
-		return nil, err
+		return nil, runtimeconfig.NewYAMLDecodeError(buf, err)
 	}
 
 	// Ensure the provided YAML config is not composed of multiple documents,
@@ -95,9 +110,36 @@ func (l runtimeConfigLoader) load(r io.Reader) (any, error) {
 		}
 	}
 
+	if overrides != nil && l.cfg.RuntimeConfig.MaxTenantConfigSize > 0 {
+		if overrides.tenantSizes, err = tenantOverridesSizes(buf); err != nil {
+			return nil, err
+		}
+	}
+
 	return overrides, nil
 }
 
+// tenantOverridesSizes returns the size, in bytes, of each tenant's overrides section
+// in the given runtime config file.
+func tenantOverridesSizes(buf []byte) (map[string]int, error) {
+	var raw struct {
+		TenantLimits map[string]yaml.MapSlice `yaml:"overrides"`
+	}
+	if err := yaml.Unmarshal(buf, &raw); err != nil {
+		return nil, err
+	}
+
+	sizes := make(map[string]int, len(raw.TenantLimits))
+	for tenant, section := range raw.TenantLimits {
+		out, err := yaml.Marshal(section)
+		if err != nil {
+			return nil, err
+		}
+		sizes[tenant] = len(out)
+	}
+	return sizes, nil
+}
+
 func multiClientRuntimeConfigChannel(manager *runtimeconfig.Manager) func() <-chan kv.MultiRuntimeConfig {
 	if manager == nil {
 		return nil
@@ -112,7 +154,7 @@ func multiClientRuntimeConfigChannel(manager *runtimeconfig.Manager) func() <-ch
 			outCh <- cfg.Multi
 		}
 
-		ch := manager.CreateListenerChannel(1)
+		ch := manager.CreateNamedListenerChannel("multi-kv", 1)
 		go func() {
 			for val := range ch {
 				if cfg, ok := val.(*RuntimeConfigValues); ok && cfg != nil {
@@ -9,6 +9,7 @@ import (
 
 	"github.com/cortexproject/cortex/pkg/distributor"
 	"github.com/cortexproject/cortex/pkg/ingester"
+	"github.com/cortexproject/cortex/pkg/util/runtimeconfig"
 	"github.com/cortexproject/cortex/pkg/util/validation"
 )
 
@@ -60,6 +61,25 @@ overrides:
 	require.Equal(t, limits, *loadedLimits["1236"])
 }
 
+func TestLoadRuntimeConfig_ShouldComputeTenantSizes(t *testing.T) {
+	yamlFile := strings.NewReader(`
+overrides:
+  'user1':
+    ingestion_rate: 1500
+  'user2':
+    ingestion_rate: 1500
+    ingestion_burst_size: 15000
+`)
+	loader := runtimeConfigLoader{cfg: Config{RuntimeConfig: runtimeconfig.Config{MaxTenantConfigSize: 1024}}}
+	runtimeCfg, err := loader.load(yamlFile)
+	require.NoError(t, err)
+
+	sizes := runtimeCfg.(*RuntimeConfigValues).TenantSizes()
+	require.Len(t, sizes, 2)
+	assert.Equal(t, len("ingestion_rate: 1500\n"), sizes["user1"])
+	assert.Equal(t, len("ingestion_rate: 1500\ningestion_burst_size: 15000\n"), sizes["user2"])
+}
+
 func TestLoadRuntimeConfig_ShouldLoadEmptyFile(t *testing.T) {
 	yamlFile := strings.NewReader(`
 # This is an empty YAML.
@@ -121,6 +141,24 @@ overrides:
 	}
 }
 
+func TestLoadRuntimeConfig_ShouldReturnLocatedErrorOnMalformedConfig(t *testing.T) {
+	yamlFile := strings.NewReader(`
+overrides:
+  '1234':
+    ingestion_rate: 1500
+    ingestion_burst_size: abc
+`)
+
+	actual, err := runtimeConfigLoader{}.load(yamlFile)
+	require.EqualError(t, err, "parse: line 5, column 27, near \"ingestion_burst_size: abc\": cannot unmarshal !!str `abc` into int")
+	assert.Nil(t, actual)
+
+	var decodeErr *runtimeconfig.DecodeError
+	require.ErrorAs(t, err, &decodeErr)
+	assert.Equal(t, 5, decodeErr.Line)
+	assert.Equal(t, 27, decodeErr.Column)
+}
+
 func TestLoad_ShouldNotErrorWithCertainTarget(t *testing.T) {
 
 	tests := []struct {
@@ -1,18 +1,43 @@
 package querier
 
 import (
+	"cmp"
 	"context"
+	"fmt"
+	"io"
+	"slices"
 
+	"github.com/oklog/ulid/v2"
+	"github.com/pkg/errors"
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/model/histogram"
 	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/storage"
+	"github.com/prometheus/prometheus/tsdb/chunkenc"
+	"github.com/prometheus/prometheus/util/annotations"
+	"github.com/thanos-io/thanos/pkg/compact/downsample"
 	"github.com/thanos-io/thanos/pkg/store/storepb"
 
+	"github.com/cortexproject/cortex/pkg/chunk"
+	"github.com/cortexproject/cortex/pkg/querier/batch"
 	"github.com/cortexproject/cortex/pkg/storage/tsdb/bucketindex"
+	"github.com/cortexproject/cortex/pkg/storegateway/storegatewaypb"
+	"github.com/cortexproject/cortex/pkg/util/validation"
 )
 
 type contextKey int
 
 var (
-	blockCtxKey contextKey = 0
+	blockCtxKey   contextKey = 0
+	queryIDCtxKey contextKey = 4
+
+	// ErrTooManySeries is returned by the series sets exceeding their max number of series. It's
+	// a limit error, so that it's returned to the client with the 422 status code.
+	ErrTooManySeries = validation.LimitError("the query exceeded the maximum number of series")
+
+	// ErrOversizedSeriesResponse is returned by the series sets receiving a series with too many
+	// chunks, or a response exceeding its max size, from a store-gateway.
+	ErrOversizedSeriesResponse = errors.New("the store-gateway series response exceeded the maximum size")
 )
 
 func InjectBlocksIntoContext(ctx context.Context, blocks ...*bucketindex.Block) context.Context {
@@ -27,7 +52,77 @@ func ExtractBlocksFromContext(ctx context.Context) ([]*bucketindex.Block, bool)
 	return nil, false
 }
 
-func convertMatchersToLabelMatcher(matchers []*labels.Matcher) []storepb.LabelMatcher {
+// InjectQueryID injects the ID of the query in the context, so that it's forwarded to the
+// store-gateways queried with it, and the logs of the same query can be correlated.
+func InjectQueryID(ctx context.Context, queryID string) context.Context {
+	return context.WithValue(ctx, queryIDCtxKey, queryID)
+}
+
+// ExtractQueryID returns the ID of the query injected in the context with InjectQueryID.
+func ExtractQueryID(ctx context.Context) (string, bool) {
+	queryID, ok := ctx.Value(queryIDCtxKey).(string)
+	return queryID, ok && queryID != ""
+}
+
+// InjectBlocksWithTimeRange is like InjectBlocksIntoContext, but only injects the blocks
+// with samples within the provided range. Input minT and maxT are both inclusive.
+func InjectBlocksWithTimeRange(ctx context.Context, minT, maxT int64, blocks ...*bucketindex.Block) context.Context {
+	return InjectBlocksIntoContext(ctx, filterBlocksWithin(blocks, minT, maxT)...)
+}
+
+// ExtractBlocksForRange is like ExtractBlocksFromContext, but only returns the blocks with
+// samples within the provided range. Input minT and maxT are both inclusive.
+func ExtractBlocksForRange(ctx context.Context, minT, maxT int64) ([]*bucketindex.Block, bool) {
+	blocks, ok := ExtractBlocksFromContext(ctx)
+	if !ok {
+		return nil, false
+	}
+	return filterBlocksWithin(blocks, minT, maxT), true
+}
+
+// ValidateBlocks returns an error for each block listed more than once, and for each pair of
+// blocks with overlapping time ranges. The blocks are expected to belong to the same tenant,
+// like the ones injected into the context.
+func ValidateBlocks(blocks []*bucketindex.Block) []error {
+	var errs []error
+
+	seen := make(map[ulid.ULID]struct{}, len(blocks))
+	unique := make([]*bucketindex.Block, 0, len(blocks))
+	for _, b := range blocks {
+		if _, ok := seen[b.ID]; ok {
+			errs = append(errs, errors.Errorf("duplicate block %s", b.ID))
+			continue
+		}
+		seen[b.ID] = struct{}{}
+		unique = append(unique, b)
+	}
+
+	// NOTE: Block intervals are half-open: [MinTime, MaxTime).
+	slices.SortFunc(unique, func(a, b *bucketindex.Block) int {
+		return cmp.Compare(a.MinTime, b.MinTime)
+	})
+	for i, a := range unique {
+		for _, b := range unique[i+1:] {
+			if b.MinTime >= a.MaxTime {
+				break
+			}
+			errs = append(errs, errors.Errorf("block %s with time range [%d, %d) overlaps block %s with time range [%d, %d)", a.ID, a.MinTime, a.MaxTime, b.ID, b.MinTime, b.MaxTime))
+		}
+	}
+	return errs
+}
+
+func filterBlocksWithin(blocks []*bucketindex.Block, minT, maxT int64) []*bucketindex.Block {
+	filtered := make([]*bucketindex.Block, 0, len(blocks))
+	for _, b := range blocks {
+		if b.Within(minT, maxT) {
+			filtered = append(filtered, b)
+		}
+	}
+	return filtered
+}
+
+func convertMatchersToLabelMatcher(matchers []*labels.Matcher) ([]storepb.LabelMatcher, error) {
 	var converted []storepb.LabelMatcher
 	for _, m := range matchers {
 		var t storepb.LabelMatcher_Type
@@ -40,6 +135,8 @@ func convertMatchersToLabelMatcher(matchers []*labels.Matcher) []storepb.LabelMa
 			t = storepb.LabelMatcher_RE
 		case labels.MatchNotRegexp:
 			t = storepb.LabelMatcher_NRE
+		default:
+			return nil, errors.Errorf("unsupported label matcher type %d for label %s", m.Type, m.Name)
 		}
 
 		converted = append(converted, storepb.LabelMatcher{
@@ -48,19 +145,190 @@ func convertMatchersToLabelMatcher(matchers []*labels.Matcher) []storepb.LabelMa
 			Value: m.Value,
 		})
 	}
-	return converted
+	return converted, nil
+}
+
+// convertLabelMatchersToMatchers is the inverse of convertMatchersToLabelMatcher. It fails
+// if the value of any regex matcher isn't a valid regex.
+func convertLabelMatchersToMatchers(matchers []storepb.LabelMatcher) ([]*labels.Matcher, error) {
+	var converted []*labels.Matcher
+	for _, m := range matchers {
+		var t labels.MatchType
+		switch m.Type {
+		case storepb.LabelMatcher_EQ:
+			t = labels.MatchEqual
+		case storepb.LabelMatcher_NEQ:
+			t = labels.MatchNotEqual
+		case storepb.LabelMatcher_RE:
+			t = labels.MatchRegexp
+		case storepb.LabelMatcher_NRE:
+			t = labels.MatchNotRegexp
+		default:
+			return nil, errors.Errorf("unsupported label matcher type %d for label %s", m.Type, m.Name)
+		}
+
+		matcher, err := labels.NewMatcher(t, m.Name, m.Value)
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid label matcher for label %s", m.Name)
+		}
+		converted = append(converted, matcher)
+	}
+	return converted, nil
+}
+
+// newLabelValuesRequest returns the store LabelValuesRequest for the values of the label in
+// the [minT, maxT] time range, restricted to the series matching the matchers.
+func newLabelValuesRequest(label string, minT, maxT, limit int64, matchers []*labels.Matcher) (*storepb.LabelValuesRequest, error) {
+	if label == "" {
+		return nil, errors.New("label values request requires a label name")
+	}
+	if minT > maxT {
+		return nil, errors.Errorf("label values request start time %d is after the end time %d", minT, maxT)
+	}
+
+	converted, err := convertMatchersToLabelMatcher(matchers)
+	if err != nil {
+		return nil, err
+	}
+
+	return &storepb.LabelValuesRequest{
+		Label:    label,
+		Start:    minT,
+		End:      maxT,
+		Limit:    limit,
+		Matchers: converted,
+	}, nil
+}
+
+// SeriesOption sets a hint of the store SeriesRequest built by NewSeriesRequest.
+type SeriesOption func(*storepb.SeriesRequest)
+
+// WithMaxResolution sets the max resolution window, in milliseconds, of the chunks returned
+// by the store. It defaults to the raw resolution.
+func WithMaxResolution(window int64) SeriesOption {
+	return func(req *storepb.SeriesRequest) {
+		req.MaxResolutionWindow = window
+	}
+}
+
+// WithSkipChunks sets whether the store only returns the labels of the series, without
+// their chunks.
+func WithSkipChunks(skipChunks bool) SeriesOption {
+	return func(req *storepb.SeriesRequest) {
+		req.SkipChunks = skipChunks
+	}
+}
+
+// WithAggregates sets the aggregates of the downsampled chunks returned by the store. It
+// defaults to count and sum.
+func WithAggregates(aggrs ...storepb.Aggr) SeriesOption {
+	return func(req *storepb.SeriesRequest) {
+		req.Aggregates = aggrs
+	}
+}
+
+// NewSeriesRequest returns the store SeriesRequest for the series matching the matchers in
+// the [minT, maxT] time range, with the hints set by the options applied over the defaults:
+// the raw resolution, the count and sum aggregates, the chunks included and the request
+// aborted on partial responses.
+func NewSeriesRequest(minT, maxT int64, matchers []*labels.Matcher, opts ...SeriesOption) (*storepb.SeriesRequest, error) {
+	if minT > maxT {
+		return nil, errors.Errorf("series request start time %d is after the end time %d", minT, maxT)
+	}
+
+	converted, err := convertMatchersToLabelMatcher(matchers)
+	if err != nil {
+		return nil, err
+	}
+	return newSeriesRequest(minT, maxT, converted, opts...), nil
+}
+
+// newSeriesRequest is like NewSeriesRequest, but with the matchers already converted.
+func newSeriesRequest(minT, maxT int64, matchers []storepb.LabelMatcher, opts ...SeriesOption) *storepb.SeriesRequest {
+	req := &storepb.SeriesRequest{
+		MinTime:                 minT,
+		MaxTime:                 maxT,
+		Matchers:                matchers,
+		PartialResponseStrategy: storepb.PartialResponseStrategy_ABORT,
+		// TODO: support more downsample levels when downsampling is supported.
+		MaxResolutionWindow: downsample.ResLevel0,
+		Aggregates:          defaultAggrs,
+	}
+	for _, opt := range opts {
+		opt(req)
+	}
+	return req
+}
+
+// appendEnforcedMatchers returns the user matchers along with the enforced ones, skipping
+// the enforced matchers identical to a user one. It returns an error if a user matcher
+// doesn't match the value of an enforced equality matcher for the same label, since the
+// query couldn't select any series.
+func appendEnforcedMatchers(userMatchers []*labels.Matcher, enforced []*labels.Matcher) ([]*labels.Matcher, error) {
+	merged := make([]*labels.Matcher, 0, len(userMatchers)+len(enforced))
+	merged = append(merged, userMatchers...)
+
+	for _, e := range enforced {
+		duplicate := false
+		for _, u := range userMatchers {
+			if u.Name != e.Name {
+				continue
+			}
+			if u.Type == e.Type && u.Value == e.Value {
+				duplicate = true
+				continue
+			}
+			if e.Type == labels.MatchEqual && !u.Matches(e.Value) {
+				return nil, errors.Errorf("label matcher %s conflicts with the enforced label matcher %s", u.String(), e.String())
+			}
+		}
+
+		if !duplicate {
+			merged = append(merged, e)
+		}
+	}
+	return merged, nil
+}
+
+// convertEnforcedMatchersToLabelMatcher is like convertMatchersToLabelMatcher, but also
+// converts the enforced matchers, merged with appendEnforcedMatchers.
+func convertEnforcedMatchersToLabelMatcher(userMatchers []*labels.Matcher, enforced []*labels.Matcher) ([]storepb.LabelMatcher, error) {
+	merged, err := appendEnforcedMatchers(userMatchers, enforced)
+	if err != nil {
+		return nil, err
+	}
+	return convertMatchersToLabelMatcher(merged)
 }
 
 // storeSeriesSet implements a storepb SeriesSet against a list of storepb.Series.
 type storeSeriesSet struct {
 	series []*storepb.Series
 	i      int
+	err    error
 }
 
+// newStoreSeriesSet returns a storeSeriesSet for the input series, which must be already
+// sorted by labels.
 func newStoreSeriesSet(s []*storepb.Series) *storeSeriesSet {
 	return &storeSeriesSet{series: s, i: -1}
 }
 
+// newSortedStoreSeriesSet is like newStoreSeriesSet, but sorts the input series by labels.
+// The input slice is sorted in place.
+func newSortedStoreSeriesSet(s []*storepb.Series) *storeSeriesSet {
+	slices.SortStableFunc(s, func(a, b *storepb.Series) int {
+		return labels.Compare(a.PromLabels(), b.PromLabels())
+	})
+	return newStoreSeriesSet(s)
+}
+
+// withErr sets the error returned by Err(), used to surface the failure of the store
+// response the series have been read from.
+func (s *storeSeriesSet) withErr(err error) *storeSeriesSet {
+	s.err = err
+	return s
+}
+
 func (s *storeSeriesSet) Next() bool {
 	if s.i >= len(s.series)-1 {
 		return false
@@ -69,10 +337,550 @@ func (s *storeSeriesSet) Next() bool {
 	return true
 }
 
-func (*storeSeriesSet) Err() error {
-	return nil
+func (s *storeSeriesSet) Err() error {
+	return s.err
 }
 
+// At returns the current series. The labels are not cached, since their conversion from
+// the proto labels is a no-copy cast, so that repeated calls don't allocate.
 func (s *storeSeriesSet) At() (labels.Labels, []storepb.AggrChunk) {
 	return s.series[s.i].PromLabels(), s.series[s.i].Chunks
 }
+
+// limitedStoreSeriesSet is like storeSeriesSet, but fails with ErrTooManySeries instead of
+// yielding more than maxSeries series.
+type limitedStoreSeriesSet struct {
+	*storeSeriesSet
+
+	maxSeries int
+	err       error
+}
+
+// newLimitedStoreSeriesSet returns a limitedStoreSeriesSet for the input series, which must be
+// already sorted by labels. A maxSeries of 0 means unlimited.
+func newLimitedStoreSeriesSet(s []*storepb.Series, maxSeries int) *limitedStoreSeriesSet {
+	return &limitedStoreSeriesSet{storeSeriesSet: newStoreSeriesSet(s), maxSeries: maxSeries}
+}
+
+func (s *limitedStoreSeriesSet) Next() bool {
+	if s.err != nil || !s.storeSeriesSet.Next() {
+		return false
+	}
+	if s.maxSeries > 0 && s.i >= s.maxSeries {
+		s.err = fmt.Errorf("%w (limit: %d)", ErrTooManySeries, s.maxSeries)
+		return false
+	}
+	return true
+}
+
+func (s *limitedStoreSeriesSet) Err() error {
+	if s.err != nil {
+		return s.err
+	}
+	return s.storeSeriesSet.Err()
+}
+
+// clippedStoreSeriesSet is like storeSeriesSet, but drops the chunks entirely outside of
+// the [minT, maxT] time range. The chunks partially overlapping the time range are kept
+// as is, so samples outside of the time range can still be returned.
+type clippedStoreSeriesSet struct {
+	*storeSeriesSet
+
+	minT, maxT int64
+}
+
+// newClippedStoreSeriesSet returns a clippedStoreSeriesSet for the input series, which must
+// be already sorted by labels.
+func newClippedStoreSeriesSet(s []*storepb.Series, minT, maxT int64) *clippedStoreSeriesSet {
+	return &clippedStoreSeriesSet{storeSeriesSet: newStoreSeriesSet(s), minT: minT, maxT: maxT}
+}
+
+func (s *clippedStoreSeriesSet) At() (labels.Labels, []storepb.AggrChunk) {
+	lbls, chks := s.storeSeriesSet.At()
+	return lbls, clipAggrChunks(chks, s.minT, s.maxT)
+}
+
+// labelsOnlyStoreSeriesSet is like storeSeriesSet, but yields the series without chunks,
+// for the requests which only need the series labels.
+type labelsOnlyStoreSeriesSet struct {
+	*storeSeriesSet
+}
+
+// newLabelsOnlyStoreSeriesSet returns a labelsOnlyStoreSeriesSet for the input series, which
+// must be already sorted by labels. The chunks of the input series, if any, are dropped so
+// that they're not retained.
+func newLabelsOnlyStoreSeriesSet(s []*storepb.Series) *labelsOnlyStoreSeriesSet {
+	for _, series := range s {
+		series.Chunks = nil
+	}
+	return &labelsOnlyStoreSeriesSet{storeSeriesSet: newStoreSeriesSet(s)}
+}
+
+func (s *labelsOnlyStoreSeriesSet) At() (labels.Labels, []storepb.AggrChunk) {
+	lbls, _ := s.storeSeriesSet.At()
+	return lbls, nil
+}
+
+// clipAggrChunks returns the chunks overlapping the [minT, maxT] time range. The input
+// slice is returned as is if no chunk is dropped, otherwise it's left untouched.
+func clipAggrChunks(chks []storepb.AggrChunk, minT, maxT int64) []storepb.AggrChunk {
+	for i, c := range chks {
+		if c.MaxTime >= minT && c.MinTime <= maxT {
+			continue
+		}
+
+		clipped := make([]storepb.AggrChunk, i, len(chks)-1)
+		copy(clipped, chks[:i])
+		for _, c := range chks[i+1:] {
+			if c.MaxTime >= minT && c.MinTime <= maxT {
+				clipped = append(clipped, c)
+			}
+		}
+		return clipped
+	}
+	return chks
+}
+
+// lazyStoreSeriesSet is like storeSeriesSet, but the chunks of the current series are only
+// accessed on Chunks(), so that callers only needing the labels, e.g. serving the series
+// API, never touch them. The series are released once iterated, so that their chunks can
+// be garbage collected while the rest of the set is being consumed.
+type lazyStoreSeriesSet struct {
+	series []*storepb.Series
+	curr   *storepb.Series
+}
+
+// newLazyStoreSeriesSet returns a lazyStoreSeriesSet for the input series, which must be
+// already sorted by labels.
+func newLazyStoreSeriesSet(s []*storepb.Series) *lazyStoreSeriesSet {
+	return &lazyStoreSeriesSet{series: s}
+}
+
+func (s *lazyStoreSeriesSet) Next() bool {
+	if len(s.series) == 0 {
+		s.curr = nil
+		return false
+	}
+
+	s.curr = s.series[0]
+	s.series[0] = nil
+	s.series = s.series[1:]
+	return true
+}
+
+// Labels returns the labels of the current series.
+func (s *lazyStoreSeriesSet) Labels() labels.Labels {
+	return s.curr.PromLabels()
+}
+
+// Chunks returns the chunks of the current series.
+func (s *lazyStoreSeriesSet) Chunks() []storepb.AggrChunk {
+	return s.curr.Chunks
+}
+
+func (s *lazyStoreSeriesSet) At() (labels.Labels, []storepb.AggrChunk) {
+	return s.Labels(), s.Chunks()
+}
+
+func (s *lazyStoreSeriesSet) Err() error {
+	return nil
+}
+
+// teeStoreSeriesSet forwards the iteration to the inner storepb SeriesSet, e.g. a
+// storeSeriesSet, and passes the series to fn each time At() is called, to observe the
+// series returned by the store-gateways while debugging.
+type teeStoreSeriesSet struct {
+	storepb.SeriesSet
+
+	fn func(labels.Labels, []storepb.AggrChunk)
+}
+
+func newTeeStoreSeriesSet(inner storepb.SeriesSet, fn func(labels.Labels, []storepb.AggrChunk)) *teeStoreSeriesSet {
+	return &teeStoreSeriesSet{SeriesSet: inner, fn: fn}
+}
+
+func (s *teeStoreSeriesSet) At() (labels.Labels, []storepb.AggrChunk) {
+	lbls, chunks := s.SeriesSet.At()
+	s.fn(lbls, chunks)
+	return lbls, chunks
+}
+
+// mergedStoreSeriesSet merges sorted storepb SeriesSets by labels. The series with the same
+// labels in multiple sets, e.g. returned by replicated store-gateways, are merged into a
+// single series, dropping the duplicate chunks.
+type mergedStoreSeriesSet struct {
+	sets []storepb.SeriesSet
+	// ok tracks, for each set, whether it's positioned on a series not consumed yet.
+	ok []bool
+	// partialResponse is true if the errors of the sets are returned as warnings, as long as
+	// at least one set succeeded.
+	partialResponse bool
+
+	curLabels labels.Labels
+	curChunks []storepb.AggrChunk
+}
+
+func newMergedStoreSeriesSet(sets ...storepb.SeriesSet) *mergedStoreSeriesSet {
+	m := &mergedStoreSeriesSet{sets: sets, ok: make([]bool, len(sets))}
+	for i, s := range sets {
+		m.ok[i] = s.Next()
+	}
+	return m
+}
+
+func (m *mergedStoreSeriesSet) Next() bool {
+	var (
+		minLabels labels.Labels
+		found     bool
+	)
+	for i, s := range m.sets {
+		if !m.ok[i] {
+			continue
+		}
+		if lbls, _ := s.At(); !found || labels.Compare(lbls, minLabels) < 0 {
+			minLabels, found = lbls, true
+		}
+	}
+	if !found {
+		return false
+	}
+
+	var chunks []storepb.AggrChunk
+	for i, s := range m.sets {
+		if !m.ok[i] {
+			continue
+		}
+		if lbls, c := s.At(); labels.Equal(lbls, minLabels) {
+			chunks = append(chunks, c...)
+			m.ok[i] = s.Next()
+		}
+	}
+
+	m.curLabels = minLabels
+	m.curChunks = dedupAggrChunks(chunks)
+	return true
+}
+
+func (m *mergedStoreSeriesSet) At() (labels.Labels, []storepb.AggrChunk) {
+	return m.curLabels, m.curChunks
+}
+
+// withPartialResponse makes the set return the series of the sets which succeeded, with the
+// errors of the other ones returned by Warnings() instead of Err(). Err() only fails if all
+// the sets failed.
+func (m *mergedStoreSeriesSet) withPartialResponse() *mergedStoreSeriesSet {
+	m.partialResponse = true
+	return m
+}
+
+// Err returns the error of the first failed set, or, with partial responses, the error of
+// the first set if all of them failed.
+func (m *mergedStoreSeriesSet) Err() error {
+	errs := m.errs()
+	if len(errs) == 0 || (m.partialResponse && len(errs) < len(m.sets)) {
+		return nil
+	}
+	return errs[0]
+}
+
+// Warnings returns, with partial responses, the errors of the failed sets if at least one
+// set succeeded, so that they can be returned along with the partial result.
+func (m *mergedStoreSeriesSet) Warnings() []error {
+	if !m.partialResponse {
+		return nil
+	}
+	if errs := m.errs(); len(errs) < len(m.sets) {
+		return errs
+	}
+	return nil
+}
+
+func (m *mergedStoreSeriesSet) errs() []error {
+	var errs []error
+	for _, s := range m.sets {
+		if err := s.Err(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errs
+}
+
+// dedupAggrChunks sorts the chunks by time and drops the exact duplicates, which are
+// chunks with the same time range and encoding.
+func dedupAggrChunks(chunks []storepb.AggrChunk) []storepb.AggrChunk {
+	type chunkKey struct {
+		minTime, maxTime int64
+		encoding         storepb.Chunk_Encoding
+	}
+
+	slices.SortStableFunc(chunks, func(a, b storepb.AggrChunk) int {
+		if a.MinTime != b.MinTime {
+			return cmp.Compare(a.MinTime, b.MinTime)
+		}
+		return cmp.Compare(a.MaxTime, b.MaxTime)
+	})
+
+	seen := make(map[chunkKey]struct{}, len(chunks))
+	deduped := chunks[:0]
+	for _, c := range chunks {
+		// Only the raw chunks can be compared by encoding.
+		if c.Raw != nil {
+			key := chunkKey{minTime: c.MinTime, maxTime: c.MaxTime, encoding: c.Raw.Type}
+			if _, ok := seen[key]; ok {
+				continue
+			}
+			seen[key] = struct{}{}
+		}
+		deduped = append(deduped, c)
+	}
+	return deduped
+}
+
+// Sample is a sample decoded from a store-gateway chunk. Depending on the chunk encoding,
+// either F, H or FH is set.
+type Sample struct {
+	T  int64
+	F  float64
+	H  *histogram.Histogram
+	FH *histogram.FloatHistogram
+}
+
+// DecodeAggrChunks decodes the raw chunks into their samples, in the order of the chunks. It
+// fails on chunks without raw data or with an unsupported encoding.
+func DecodeAggrChunks(chunks []storepb.AggrChunk) ([]Sample, error) {
+	var samples []Sample
+	for i, c := range chunks {
+		chk, err := decodeAggrChunk(i, c)
+		if err != nil {
+			return nil, err
+		}
+
+		it := chk.Iterator(nil)
+		for vt := it.Next(); vt != chunkenc.ValNone; vt = it.Next() {
+			switch vt {
+			case chunkenc.ValFloat:
+				t, f := it.At()
+				samples = append(samples, Sample{T: t, F: f})
+			case chunkenc.ValHistogram:
+				t, h := it.AtHistogram(nil)
+				samples = append(samples, Sample{T: t, H: h})
+			case chunkenc.ValFloatHistogram:
+				t, fh := it.AtFloatHistogram(nil)
+				samples = append(samples, Sample{T: t, FH: fh})
+			}
+		}
+		if err := it.Err(); err != nil {
+			return nil, errors.Wrapf(err, "chunk %d", i)
+		}
+	}
+	return samples, nil
+}
+
+// decodeAggrChunk returns the chunk of the raw data of c, the i-th chunk of a series.
+func decodeAggrChunk(i int, c storepb.AggrChunk) (chunkenc.Chunk, error) {
+	if c.Raw == nil {
+		return nil, errors.Errorf("chunk %d has no raw data", i)
+	}
+
+	var enc chunkenc.Encoding
+	switch c.Raw.Type {
+	case storepb.Chunk_XOR:
+		enc = chunkenc.EncXOR
+	case storepb.Chunk_HISTOGRAM:
+		enc = chunkenc.EncHistogram
+	case storepb.Chunk_FLOAT_HISTOGRAM:
+		enc = chunkenc.EncFloatHistogram
+	default:
+		return nil, errors.Errorf("chunk %d has unsupported encoding %s", i, c.Raw.Type)
+	}
+
+	chk, err := chunkenc.FromData(enc, c.Raw.Data)
+	if err != nil {
+		return nil, errors.Wrapf(err, "chunk %d", i)
+	}
+	return chk, nil
+}
+
+// promSeriesSet adapts a storepb SeriesSet, e.g. a storeSeriesSet, to the Prometheus
+// storage.SeriesSet interface. The samples of the chunks are only decoded while the series
+// are iterated, and the overlapping chunks are merged.
+type promSeriesSet struct {
+	set storepb.SeriesSet
+	cur storage.Series
+	err error
+}
+
+func newPromSeriesSet(set storepb.SeriesSet) storage.SeriesSet {
+	return &promSeriesSet{set: set}
+}
+
+func (s *promSeriesSet) Next() bool {
+	if s.err != nil || !s.set.Next() {
+		return false
+	}
+
+	lbls, aggrChunks := s.set.At()
+	chunks := make([]chunk.Chunk, 0, len(aggrChunks))
+	for i, c := range aggrChunks {
+		chk, err := decodeAggrChunk(i, c)
+		if err != nil {
+			s.err = errors.Wrapf(err, "series %s", lbls)
+			return false
+		}
+		chunks = append(chunks, chunk.NewChunk(lbls, chk, model.Time(c.MinTime), model.Time(c.MaxTime)))
+	}
+
+	s.cur = &promSeries{lbls: lbls, chunks: chunks}
+	return true
+}
+
+func (s *promSeriesSet) At() storage.Series {
+	return s.cur
+}
+
+func (s *promSeriesSet) Err() error {
+	if s.err != nil {
+		return s.err
+	}
+	return s.set.Err()
+}
+
+func (s *promSeriesSet) Warnings() annotations.Annotations {
+	return nil
+}
+
+// promSeries is a storage.Series iterating the samples of its store-gateway chunks.
+type promSeries struct {
+	lbls   labels.Labels
+	chunks []chunk.Chunk
+}
+
+func (s *promSeries) Labels() labels.Labels {
+	return s.lbls
+}
+
+func (s *promSeries) Iterator(it chunkenc.Iterator) chunkenc.Iterator {
+	return batch.NewChunkMergeIterator(it, s.chunks, 0, 0)
+}
+
+// storeStreamSeriesSet implements a storepb SeriesSet reading the series from a store-gateway
+// Series() stream as they're received, instead of buffering the whole response.
+type storeStreamSeriesSet struct {
+	// recv returns the next message of the stream, and io.EOF once there are no more.
+	recv func() (*storepb.SeriesResponse, error)
+
+	// maxChunksPerSeries and maxResponseBytes guard against oversized responses, e.g. from a
+	// buggy store-gateway. 0 means unlimited.
+	maxChunksPerSeries int
+	maxResponseBytes   int
+	responseBytes      int
+
+	// pending holds the series received in a batch and not consumed yet.
+	pending  []*storepb.Series
+	cur      *storepb.Series
+	warnings annotations.Annotations
+	done     bool
+	err      error
+}
+
+func newStoreStreamSeriesSet(stream storegatewaypb.StoreGateway_SeriesClient) *storeStreamSeriesSet {
+	return newStoreStreamSeriesSetWithLimits(stream, 0, 0)
+}
+
+// newStreamingStoreSeriesSet returns a storeStreamSeriesSet pulling the series one at a time
+// from recv on Next(), so that only the current series is retained instead of the whole
+// response. recv returns io.EOF once there are no more series, and may return a nil series,
+// e.g. for the stream responses not carrying a series, which is skipped.
+func newStreamingStoreSeriesSet(recv func() (*storepb.Series, error)) *storeStreamSeriesSet {
+	return &storeStreamSeriesSet{recv: func() (*storepb.SeriesResponse, error) {
+		series, err := recv()
+		if err != nil || series == nil {
+			return &storepb.SeriesResponse{}, err
+		}
+		return storepb.NewSeriesResponse(series), nil
+	}}
+}
+
+// newStoreStreamSeriesSetWithLimits is like newStoreStreamSeriesSet, but fails with
+// ErrOversizedSeriesResponse, instead of yielding the series, as soon as a series with more
+// than maxChunksPerSeries chunks is received, or the received messages exceed maxResponseBytes
+// in total. A limit of 0 means unlimited.
+func newStoreStreamSeriesSetWithLimits(stream storegatewaypb.StoreGateway_SeriesClient, maxChunksPerSeries, maxResponseBytes int) *storeStreamSeriesSet {
+	return &storeStreamSeriesSet{recv: stream.Recv, maxChunksPerSeries: maxChunksPerSeries, maxResponseBytes: maxResponseBytes}
+}
+
+func (s *storeStreamSeriesSet) Next() bool {
+	// Release the previous series while waiting for the next one.
+	s.cur = nil
+
+	for len(s.pending) == 0 {
+		if s.done || s.err != nil {
+			return false
+		}
+
+		resp, err := s.recv()
+		if errors.Is(err, io.EOF) {
+			s.done = true
+			return false
+		}
+		if err != nil {
+			s.err = err
+			return false
+		}
+
+		if s.maxResponseBytes > 0 {
+			s.responseBytes += resp.Size()
+			if s.responseBytes > s.maxResponseBytes {
+				s.err = fmt.Errorf("%w: the response is larger than %d bytes", ErrOversizedSeriesResponse, s.maxResponseBytes)
+				s.pending = nil
+				return false
+			}
+		}
+
+		// Response may either contain series, batch, warning or hints.
+		if series := resp.GetSeries(); series != nil {
+			s.pending = append(s.pending, series)
+		}
+		if b := resp.GetBatch(); b != nil {
+			s.pending = append(s.pending, b.Series...)
+		}
+		if w := resp.GetWarning(); w != "" {
+			s.warnings.Add(errors.New(w))
+		}
+	}
+
+	s.cur = s.pending[0]
+	s.pending = s.pending[1:]
+	if s.maxChunksPerSeries > 0 && len(s.cur.Chunks) > s.maxChunksPerSeries {
+		s.err = fmt.Errorf("%w: series %s has %d chunks (limit: %d)", ErrOversizedSeriesResponse, s.cur.PromLabels(), len(s.cur.Chunks), s.maxChunksPerSeries)
+		s.cur, s.pending = nil, nil
+		return false
+	}
+	return true
+}
+
+func (s *storeStreamSeriesSet) Err() error {
+	return s.err
+}
+
+func (s *storeStreamSeriesSet) At() (labels.Labels, []storepb.AggrChunk) {
+	return s.cur.PromLabels(), s.cur.Chunks
+}
+
+// Warnings returns the warnings received so far from the stream.
+func (s *storeStreamSeriesSet) Warnings() annotations.Annotations {
+	return s.warnings
+}
+
+// forEachStreamedSeries calls f for each series as soon as it's received from the
+// store-gateway stream, so that callers can progressively process the response.
+// Iteration stops at the first error returned by f.
+func forEachStreamedSeries(stream storegatewaypb.StoreGateway_SeriesClient, f func(*storepb.Series) error) (annotations.Annotations, error) {
+	set := newStoreStreamSeriesSet(stream)
+	for set.Next() {
+		if err := f(set.cur); err != nil {
+			return set.Warnings(), err
+		}
+	}
+	return set.Warnings(), set.Err()
+}
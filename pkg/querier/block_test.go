@@ -0,0 +1,1038 @@
+package querier
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"runtime"
+	"slices"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/oklog/ulid/v2"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/tsdb/chunkenc"
+	"github.com/prometheus/prometheus/tsdb/tsdbutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/thanos-io/thanos/pkg/store/labelpb"
+	"github.com/thanos-io/thanos/pkg/store/storepb"
+	"google.golang.org/grpc"
+
+	"github.com/cortexproject/cortex/pkg/cortexpb"
+	"github.com/cortexproject/cortex/pkg/storage/tsdb/bucketindex"
+	"github.com/cortexproject/cortex/pkg/util/validation"
+)
+
+func TestForEachStreamedSeries_ShouldDeliverSeriesIncrementally(t *testing.T) {
+	stream := &storeGatewaySeriesStreamMock{responses: make(chan *storepb.SeriesResponse)}
+	received := make(chan labels.Labels)
+	done := make(chan error)
+
+	go func() {
+		warnings, err := forEachStreamedSeries(stream, func(s *storepb.Series) error {
+			received <- s.PromLabels()
+			return nil
+		})
+		assert.Len(t, warnings, 1)
+		done <- err
+	}()
+
+	series := []labels.Labels{
+		labels.FromStrings("__name__", "series_1"),
+		labels.FromStrings("__name__", "series_2"),
+		labels.FromStrings("__name__", "series_3"),
+	}
+
+	// Each series should be delivered before the next response is even sent.
+	stream.responses <- storepb.NewSeriesResponse(&storepb.Series{Labels: labelpb.ZLabelsFromPromLabels(series[0])})
+	requireReceived(t, received, series[0])
+
+	stream.responses <- storepb.NewWarnSeriesResponse(io.ErrUnexpectedEOF)
+	stream.responses <- &storepb.SeriesResponse{Result: &storepb.SeriesResponse_Batch{Batch: &storepb.SeriesBatch{Series: []*storepb.Series{
+		{Labels: labelpb.ZLabelsFromPromLabels(series[1])},
+		{Labels: labelpb.ZLabelsFromPromLabels(series[2])},
+	}}}}
+	requireReceived(t, received, series[1])
+	requireReceived(t, received, series[2])
+
+	close(stream.responses)
+	select {
+	case err := <-done:
+		require.NoError(t, err)
+	case <-time.After(time.Second):
+		t.Fatal("iteration has not completed")
+	}
+}
+
+func requireReceived(t *testing.T, received <-chan labels.Labels, expected labels.Labels) {
+	select {
+	case actual := <-received:
+		require.Equal(t, expected, actual)
+	case <-time.After(time.Second):
+		t.Fatalf("series %s has not been delivered", expected.String())
+	}
+}
+
+// storeGatewaySeriesStreamMock is a store-gateway Series() stream returning the responses
+// sent on the channel, and io.EOF once it's closed.
+type storeGatewaySeriesStreamMock struct {
+	grpc.ClientStream
+
+	responses chan *storepb.SeriesResponse
+}
+
+func (m *storeGatewaySeriesStreamMock) Recv() (*storepb.SeriesResponse, error) {
+	resp, ok := <-m.responses
+	if !ok {
+		return nil, io.EOF
+	}
+	return resp, nil
+}
+
+func TestStoreStreamSeriesSet_ShouldRejectOversizedResponses(t *testing.T) {
+	newSeries := func(name string, chunks int) *storepb.Series {
+		return &storepb.Series{
+			Labels: labelpb.ZLabelsFromPromLabels(labels.FromStrings("__name__", name)),
+			Chunks: make([]storepb.AggrChunk, chunks),
+		}
+	}
+	newStream := func(responses ...*storepb.SeriesResponse) *storeGatewaySeriesStreamMock {
+		stream := &storeGatewaySeriesStreamMock{responses: make(chan *storepb.SeriesResponse, len(responses))}
+		for _, resp := range responses {
+			stream.responses <- resp
+		}
+		close(stream.responses)
+		return stream
+	}
+	responses := []*storepb.SeriesResponse{
+		storepb.NewSeriesResponse(newSeries("series_1", 2)),
+		storepb.NewSeriesResponse(newSeries("series_2", 3)),
+		storepb.NewSeriesResponse(newSeries("series_3", 1)),
+	}
+
+	tests := map[string]struct {
+		maxChunksPerSeries int
+		maxResponseBytes   int
+		expectedSeries     int
+		expectedErr        string
+	}{
+		"should yield all the series with no limit": {
+			expectedSeries: 3,
+		},
+		"should yield all the series within the limits": {
+			maxChunksPerSeries: 3,
+			maxResponseBytes:   responses[0].Size() + responses[1].Size() + responses[2].Size(),
+			expectedSeries:     3,
+		},
+		"should fail on a series with too many chunks": {
+			maxChunksPerSeries: 2,
+			expectedSeries:     1,
+			expectedErr:        `the store-gateway series response exceeded the maximum size: series {__name__="series_2"} has 3 chunks (limit: 2)`,
+		},
+		"should fail once the response is too large": {
+			maxResponseBytes: responses[0].Size() + responses[1].Size(),
+			expectedSeries:   2,
+			expectedErr:      fmt.Sprintf("the store-gateway series response exceeded the maximum size: the response is larger than %d bytes", responses[0].Size()+responses[1].Size()),
+		},
+	}
+
+	for testName, testData := range tests {
+		t.Run(testName, func(t *testing.T) {
+			set := newStoreStreamSeriesSetWithLimits(newStream(responses...), testData.maxChunksPerSeries, testData.maxResponseBytes)
+
+			count := 0
+			for set.Next() {
+				count++
+			}
+			assert.Equal(t, testData.expectedSeries, count)
+			assert.False(t, set.Next())
+
+			if testData.expectedErr == "" {
+				require.NoError(t, set.Err())
+				return
+			}
+			require.ErrorIs(t, set.Err(), ErrOversizedSeriesResponse)
+			require.EqualError(t, set.Err(), testData.expectedErr)
+		})
+	}
+}
+
+func TestNewSortedStoreSeriesSet(t *testing.T) {
+	series := []labels.Labels{
+		labels.FromStrings("__name__", "series_2"),
+		labels.FromStrings("__name__", "series_1", "instance", "b"),
+		labels.FromStrings("__name__", "series_1", "instance", "a"),
+	}
+
+	var input []*storepb.Series
+	for _, s := range series {
+		input = append(input, &storepb.Series{Labels: labelpb.ZLabelsFromPromLabels(s)})
+	}
+
+	expectedErr := errors.New("partial response")
+	set := newSortedStoreSeriesSet(input).withErr(expectedErr)
+
+	var actual []labels.Labels
+	for set.Next() {
+		lbls, _ := set.At()
+		actual = append(actual, lbls)
+	}
+
+	assert.Equal(t, []labels.Labels{series[2], series[1], series[0]}, actual)
+	assert.Equal(t, expectedErr, set.Err())
+
+	// The already sorted fast path carries no error by default.
+	assert.NoError(t, newStoreSeriesSet(nil).Err())
+}
+
+func TestClippedStoreSeriesSet(t *testing.T) {
+	input := []*storepb.Series{
+		{Labels: labelpb.ZLabelsFromPromLabels(labels.FromStrings("__name__", "series_1")), Chunks: []storepb.AggrChunk{
+			{MinTime: 0, MaxTime: 9},
+			{MinTime: 10, MaxTime: 19},
+			{MinTime: 20, MaxTime: 29},
+			{MinTime: 30, MaxTime: 39},
+			{MinTime: 40, MaxTime: 49},
+		}},
+		{Labels: labelpb.ZLabelsFromPromLabels(labels.FromStrings("__name__", "series_2")), Chunks: []storepb.AggrChunk{
+			{MinTime: 15, MaxTime: 25},
+		}},
+		{Labels: labelpb.ZLabelsFromPromLabels(labels.FromStrings("__name__", "series_3")), Chunks: []storepb.AggrChunk{
+			{MinTime: 50, MaxTime: 59},
+		}},
+	}
+	backing := slices.Clone(input[0].Chunks)
+
+	set := newClippedStoreSeriesSet(input, 19, 30)
+
+	// The chunks partially overlapping the time range are kept.
+	require.True(t, set.Next())
+	lbls, chks := set.At()
+	assert.Equal(t, labels.FromStrings("__name__", "series_1"), lbls)
+	assert.Equal(t, []storepb.AggrChunk{{MinTime: 10, MaxTime: 19}, {MinTime: 20, MaxTime: 29}, {MinTime: 30, MaxTime: 39}}, chks)
+
+	// The input chunks are left untouched.
+	assert.Equal(t, backing, input[0].Chunks)
+
+	require.True(t, set.Next())
+	_, chks = set.At()
+	assert.Equal(t, []storepb.AggrChunk{{MinTime: 15, MaxTime: 25}}, chks)
+
+	require.True(t, set.Next())
+	_, chks = set.At()
+	assert.Empty(t, chks)
+
+	require.False(t, set.Next())
+	require.NoError(t, set.Err())
+}
+
+func TestLazyStoreSeriesSet(t *testing.T) {
+	input := []*storepb.Series{
+		{Labels: labelpb.ZLabelsFromPromLabels(labels.FromStrings("__name__", "series_1")), Chunks: []storepb.AggrChunk{{MinTime: 10, MaxTime: 20}}},
+		{Labels: labelpb.ZLabelsFromPromLabels(labels.FromStrings("__name__", "series_2")), Chunks: []storepb.AggrChunk{{MinTime: 30, MaxTime: 40}}},
+	}
+	backing := slices.Clone(input)
+
+	set := newLazyStoreSeriesSet(input)
+
+	require.True(t, set.Next())
+	assert.Equal(t, labels.FromStrings("__name__", "series_1"), set.Labels())
+	require.True(t, set.Next())
+	assert.Equal(t, labels.FromStrings("__name__", "series_2"), set.Labels())
+	assert.Equal(t, backing[1].Chunks, set.Chunks())
+
+	lbls, chks := set.At()
+	assert.Equal(t, labels.FromStrings("__name__", "series_2"), lbls)
+	assert.Equal(t, backing[1].Chunks, chks)
+
+	require.False(t, set.Next())
+	require.NoError(t, set.Err())
+
+	// The iterated series have been released.
+	assert.Equal(t, []*storepb.Series{nil, nil}, input)
+}
+
+func TestLimitedStoreSeriesSet(t *testing.T) {
+	series := []*storepb.Series{
+		{Labels: labelpb.ZLabelsFromPromLabels(labels.FromStrings("__name__", "series_1"))},
+		{Labels: labelpb.ZLabelsFromPromLabels(labels.FromStrings("__name__", "series_2"))},
+		{Labels: labelpb.ZLabelsFromPromLabels(labels.FromStrings("__name__", "series_3"))},
+	}
+
+	tests := map[string]struct {
+		maxSeries     int
+		expectedCount int
+		expectedErr   bool
+	}{
+		"unlimited": {
+			maxSeries:     0,
+			expectedCount: 3,
+		},
+		"limit equal to the number of series": {
+			maxSeries:     3,
+			expectedCount: 3,
+		},
+		"limit exceeded": {
+			maxSeries:     2,
+			expectedCount: 2,
+			expectedErr:   true,
+		},
+	}
+
+	for testName, testData := range tests {
+		t.Run(testName, func(t *testing.T) {
+			set := newLimitedStoreSeriesSet(series, testData.maxSeries)
+
+			count := 0
+			for set.Next() {
+				lbls, _ := set.At()
+				assert.Equal(t, series[count].PromLabels(), lbls)
+				count++
+			}
+			assert.Equal(t, testData.expectedCount, count)
+			require.False(t, set.Next())
+
+			if !testData.expectedErr {
+				require.NoError(t, set.Err())
+				return
+			}
+			require.ErrorIs(t, set.Err(), ErrTooManySeries)
+			assert.True(t, validation.IsLimitError(set.Err()))
+			assert.EqualError(t, set.Err(), "the query exceeded the maximum number of series (limit: 2)")
+		})
+	}
+}
+
+func TestStreamingStoreSeriesSet(t *testing.T) {
+	series1 := &storepb.Series{Labels: labelpb.ZLabelsFromPromLabels(labels.FromStrings("__name__", "series_1")), Chunks: []storepb.AggrChunk{{MinTime: 10, MaxTime: 20}}}
+	series2 := &storepb.Series{Labels: labelpb.ZLabelsFromPromLabels(labels.FromStrings("__name__", "series_2")), Chunks: []storepb.AggrChunk{{MinTime: 30, MaxTime: 40}}}
+
+	// recv returns the responses in order, then fails the test if called again.
+	newRecv := func(t *testing.T, responses ...any) func() (*storepb.Series, error) {
+		return func() (*storepb.Series, error) {
+			require.NotEmpty(t, responses, "recv called after the end of the stream")
+			resp := responses[0]
+			responses = responses[1:]
+			if err, ok := resp.(error); ok {
+				return nil, err
+			}
+			return resp.(*storepb.Series), nil
+		}
+	}
+
+	t.Run("should pull the series one at a time until the end of the stream", func(t *testing.T) {
+		received := 0
+		recv := newRecv(t, series1, (*storepb.Series)(nil), series2, io.EOF)
+		set := newStreamingStoreSeriesSet(func() (*storepb.Series, error) {
+			received++
+			return recv()
+		})
+		assert.Equal(t, 0, received)
+
+		require.True(t, set.Next())
+		assert.Equal(t, 1, received)
+		lbls, chks := set.At()
+		assert.Equal(t, labels.FromStrings("__name__", "series_1"), lbls)
+		assert.Equal(t, series1.Chunks, chks)
+
+		// The nil series is skipped.
+		require.True(t, set.Next())
+		assert.Equal(t, 3, received)
+		lbls, chks = set.At()
+		assert.Equal(t, labels.FromStrings("__name__", "series_2"), lbls)
+		assert.Equal(t, series2.Chunks, chks)
+
+		require.False(t, set.Next())
+		require.False(t, set.Next())
+		require.NoError(t, set.Err())
+	})
+
+	t.Run("should surface the stream error", func(t *testing.T) {
+		set := newStreamingStoreSeriesSet(newRecv(t, series1, errors.New("stream failed")))
+
+		require.True(t, set.Next())
+		require.NoError(t, set.Err())
+
+		require.False(t, set.Next())
+		require.False(t, set.Next())
+		require.EqualError(t, set.Err(), "stream failed")
+	})
+}
+
+func TestStoreSeriesSet_AtShouldNotAllocate(t *testing.T) {
+	set := newStoreSeriesSet([]*storepb.Series{
+		{Labels: labelpb.ZLabelsFromPromLabels(labels.FromStrings("__name__", "series_1"))},
+		{Labels: labelpb.ZLabelsFromPromLabels(labels.FromStrings("__name__", "series_2"))},
+	})
+
+	// Repeated calls on the same series don't re-allocate the labels.
+	require.True(t, set.Next())
+	var lbls labels.Labels
+	assert.Zero(t, testing.AllocsPerRun(10, func() {
+		lbls, _ = set.At()
+	}))
+	assert.Equal(t, labels.FromStrings("__name__", "series_1"), lbls)
+
+	require.True(t, set.Next())
+	lbls, _ = set.At()
+	assert.Equal(t, labels.FromStrings("__name__", "series_2"), lbls)
+
+	require.False(t, set.Next())
+}
+
+func BenchmarkStoreSeriesSet_At(b *testing.B) {
+	const (
+		numSeries = 1000
+		numCalls  = 4
+	)
+
+	series := make([]*storepb.Series, 0, numSeries)
+	for i := 0; i < numSeries; i++ {
+		series = append(series, &storepb.Series{
+			Labels: labelpb.ZLabelsFromPromLabels(labels.FromStrings("__name__", "series", "i", strconv.Itoa(i), "job", "test")),
+		})
+	}
+
+	// Get the labels of each series multiple times, as done by the PromQL engine.
+	b.ReportAllocs()
+	for n := 0; n < b.N; n++ {
+		set := newStoreSeriesSet(series)
+		for set.Next() {
+			for c := 0; c < numCalls; c++ {
+				lbls, _ := set.At()
+				_ = lbls
+			}
+		}
+	}
+}
+
+func BenchmarkStoreSeriesSet_Labels(b *testing.B) {
+	const (
+		numSeries = 1000
+		numChunks = 10
+	)
+
+	generateSeries := func() []*storepb.Series {
+		series := make([]*storepb.Series, 0, numSeries)
+		for i := 0; i < numSeries; i++ {
+			chks := make([]storepb.AggrChunk, 0, numChunks)
+			for j := 0; j < numChunks; j++ {
+				chks = append(chks, storepb.AggrChunk{Raw: &storepb.Chunk{Type: storepb.Chunk_XOR, Data: make([]byte, 1024)}})
+			}
+			series = append(series, &storepb.Series{
+				Labels: labelpb.ZLabelsFromPromLabels(labels.FromStrings("__name__", "series", "i", strconv.Itoa(i))),
+				Chunks: chks,
+			})
+		}
+		return series
+	}
+
+	// Report the heap in use after iterating half of the series, when the chunks of the
+	// iterated series are not referenced anymore by the lazy set.
+	run := func(b *testing.B, newSet func([]*storepb.Series) storepb.SeriesSet) {
+		b.ReportAllocs()
+
+		var heapInUse uint64
+		for n := 0; n < b.N; n++ {
+			set := newSet(generateSeries())
+			for i := 0; set.Next(); i++ {
+				lbls, _ := set.At()
+				_ = lbls
+
+				if i == numSeries/2 {
+					var stats runtime.MemStats
+					runtime.GC()
+					runtime.ReadMemStats(&stats)
+					heapInUse += stats.HeapInuse
+				}
+			}
+		}
+		b.ReportMetric(float64(heapInUse)/float64(b.N), "heap-inuse-bytes/op")
+	}
+
+	b.Run("eager", func(b *testing.B) {
+		run(b, func(s []*storepb.Series) storepb.SeriesSet { return newStoreSeriesSet(s) })
+	})
+
+	b.Run("lazy", func(b *testing.B) {
+		run(b, func(s []*storepb.Series) storepb.SeriesSet { return newLazyStoreSeriesSet(s) })
+	})
+}
+
+func TestDecodeAggrChunks(t *testing.T) {
+	h := tsdbutil.GenerateTestHistogram(1)
+	fh := tsdbutil.GenerateTestFloatHistogram(2)
+	encoded := mockSeriesResponse(labels.EmptyLabels(), nil,
+		[]cortexpb.Histogram{cortexpb.HistogramToHistogramProto(4000, h)},
+		[]cortexpb.Histogram{cortexpb.FloatHistogramToHistogramProto(5000, fh)},
+	).GetSeries().Chunks
+
+	chunks := []storepb.AggrChunk{
+		// XOR chunk with the samples (1000, 1.5), (2000, 2.5) and (3000, 4).
+		{MinTime: 1000, MaxTime: 3000, Raw: &storepb.Chunk{Type: storepb.Chunk_XOR, Data: []byte{
+			0x0, 0x3, 0xd0, 0xf, 0x3f, 0xf8, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0xe8, 0x7, 0xc2, 0x6f, 0xff, 0xd0, 0x1, 0x40,
+		}}},
+		encoded[0],
+		encoded[1],
+	}
+
+	samples, err := DecodeAggrChunks(chunks)
+	require.NoError(t, err)
+	require.Len(t, samples, 5)
+	assert.Equal(t, []Sample{{T: 1000, F: 1.5}, {T: 2000, F: 2.5}, {T: 3000, F: 4}}, samples[:3])
+	assert.Equal(t, int64(4000), samples[3].T)
+	assert.True(t, h.Equals(samples[3].H), "unexpected histogram %s", samples[3].H)
+	assert.Equal(t, int64(5000), samples[4].T)
+	assert.True(t, fh.Equals(samples[4].FH), "unexpected float histogram %s", samples[4].FH)
+
+	samples, err = DecodeAggrChunks(nil)
+	require.NoError(t, err)
+	assert.Empty(t, samples)
+
+	_, err = DecodeAggrChunks([]storepb.AggrChunk{chunks[0], {MinTime: 4000, MaxTime: 5000}})
+	assert.EqualError(t, err, "chunk 1 has no raw data")
+
+	_, err = DecodeAggrChunks([]storepb.AggrChunk{{Raw: &storepb.Chunk{Type: storepb.Chunk_Encoding(10), Data: chunks[0].Raw.Data}}})
+	assert.EqualError(t, err, "chunk 0 has unsupported encoding 10")
+}
+
+func TestTeeStoreSeriesSet(t *testing.T) {
+	series := []*storepb.Series{
+		{Labels: labelpb.ZLabelsFromPromLabels(labels.FromStrings("a", "1")), Chunks: []storepb.AggrChunk{{MinTime: 10, MaxTime: 20}}},
+		{Labels: labelpb.ZLabelsFromPromLabels(labels.FromStrings("a", "2"))},
+	}
+
+	var observed []labels.Labels
+	var observedChunks int
+	set := newTeeStoreSeriesSet(newStoreSeriesSet(series), func(lbls labels.Labels, chunks []storepb.AggrChunk) {
+		observed = append(observed, lbls)
+		observedChunks += len(chunks)
+	})
+
+	var actual []labels.Labels
+	for set.Next() {
+		lbls, _ := set.At()
+		actual = append(actual, lbls)
+	}
+	require.NoError(t, set.Err())
+
+	expected := []labels.Labels{labels.FromStrings("a", "1"), labels.FromStrings("a", "2")}
+	assert.Equal(t, expected, actual)
+	assert.Equal(t, expected, observed)
+	assert.Equal(t, 1, observedChunks)
+}
+
+func TestLabelsOnlyStoreSeriesSet(t *testing.T) {
+	series := []*storepb.Series{
+		{Labels: labelpb.ZLabelsFromPromLabels(labels.FromStrings("a", "1")), Chunks: []storepb.AggrChunk{{MinTime: 10, MaxTime: 20}}},
+		{Labels: labelpb.ZLabelsFromPromLabels(labels.FromStrings("a", "2"))},
+	}
+
+	set := newLabelsOnlyStoreSeriesSet(series)
+
+	var actual []labels.Labels
+	for set.Next() {
+		lbls, chunks := set.At()
+		assert.Empty(t, chunks)
+		actual = append(actual, lbls)
+	}
+	require.NoError(t, set.Err())
+	assert.Equal(t, []labels.Labels{labels.FromStrings("a", "1"), labels.FromStrings("a", "2")}, actual)
+
+	// The chunks of the input series are not retained.
+	assert.Nil(t, series[0].Chunks)
+}
+
+func TestPromSeriesSet(t *testing.T) {
+	h := tsdbutil.GenerateTestHistogram(1)
+	xorChunk := storepb.AggrChunk{MinTime: 1000, MaxTime: 3000, Raw: &storepb.Chunk{Type: storepb.Chunk_XOR, Data: []byte{
+		0x0, 0x3, 0xd0, 0xf, 0x3f, 0xf8, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0xe8, 0x7, 0xc2, 0x6f, 0xff, 0xd0, 0x1, 0x40,
+	}}}
+	// The sample at 2000 overlaps with the XOR chunk.
+	floatChunks := mockSeriesResponse(labels.EmptyLabels(), []cortexpb.Sample{{TimestampMs: 2000, Value: 2.5}, {TimestampMs: 4000, Value: 5}}, nil, nil).GetSeries().Chunks
+	histogramChunks := mockSeriesResponse(labels.EmptyLabels(), nil, []cortexpb.Histogram{cortexpb.HistogramToHistogramProto(6000, h)}, nil).GetSeries().Chunks
+
+	set := newPromSeriesSet(newStoreSeriesSet([]*storepb.Series{
+		{Labels: labelpb.ZLabelsFromPromLabels(labels.FromStrings("a", "1")), Chunks: append([]storepb.AggrChunk{xorChunk}, floatChunks...)},
+		{Labels: labelpb.ZLabelsFromPromLabels(labels.FromStrings("a", "2")), Chunks: histogramChunks},
+		{Labels: labelpb.ZLabelsFromPromLabels(labels.FromStrings("a", "3")), Chunks: []storepb.AggrChunk{xorChunk, {MinTime: 4000, MaxTime: 5000}}},
+	}))
+
+	require.True(t, set.Next())
+	series := set.At()
+	assert.Equal(t, labels.FromStrings("a", "1"), series.Labels())
+
+	it := series.Iterator(nil)
+	var samples []Sample
+	for vt := it.Next(); vt != chunkenc.ValNone; vt = it.Next() {
+		require.Equal(t, chunkenc.ValFloat, vt)
+		ts, f := it.At()
+		samples = append(samples, Sample{T: ts, F: f})
+	}
+	require.NoError(t, it.Err())
+	assert.Equal(t, []Sample{{T: 1000, F: 1.5}, {T: 2000, F: 2.5}, {T: 3000, F: 4}, {T: 4000, F: 5}}, samples)
+
+	it = series.Iterator(it)
+	require.Equal(t, chunkenc.ValFloat, it.Seek(3500))
+	assert.Equal(t, int64(4000), it.AtT())
+	require.Equal(t, chunkenc.ValFloat, it.Seek(1000))
+	assert.Equal(t, int64(4000), it.AtT())
+	assert.Equal(t, chunkenc.ValNone, it.Seek(4001))
+	require.NoError(t, it.Err())
+
+	require.True(t, set.Next())
+	series = set.At()
+	assert.Equal(t, labels.FromStrings("a", "2"), series.Labels())
+
+	it = series.Iterator(it)
+	require.Equal(t, chunkenc.ValHistogram, it.Next())
+	ts, actual := it.AtHistogram(nil)
+	assert.Equal(t, int64(6000), ts)
+	assert.True(t, h.Equals(actual), "unexpected histogram %s", actual)
+	assert.Equal(t, chunkenc.ValNone, it.Next())
+	require.NoError(t, it.Err())
+
+	// The series set fails on the chunks which can't be decoded.
+	require.False(t, set.Next())
+	assert.EqualError(t, set.Err(), `series {a="3"}: chunk 1 has no raw data`)
+}
+
+func TestConvertMatchersToLabelMatcher(t *testing.T) {
+	converted, err := convertMatchersToLabelMatcher([]*labels.Matcher{
+		labels.MustNewMatcher(labels.MatchEqual, "a", "1"),
+		labels.MustNewMatcher(labels.MatchNotEqual, "b", "2"),
+		labels.MustNewMatcher(labels.MatchRegexp, "c", "3"),
+		labels.MustNewMatcher(labels.MatchNotRegexp, "d", "4"),
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []storepb.LabelMatcher{
+		{Type: storepb.LabelMatcher_EQ, Name: "a", Value: "1"},
+		{Type: storepb.LabelMatcher_NEQ, Name: "b", Value: "2"},
+		{Type: storepb.LabelMatcher_RE, Name: "c", Value: "3"},
+		{Type: storepb.LabelMatcher_NRE, Name: "d", Value: "4"},
+	}, converted)
+
+	// An unknown matcher type must not be converted to an equal matcher.
+	_, err = convertMatchersToLabelMatcher([]*labels.Matcher{{Type: labels.MatchType(42), Name: "a", Value: "1"}})
+	require.EqualError(t, err, "unsupported label matcher type 42 for label a")
+}
+
+func TestConvertLabelMatchersToMatchers(t *testing.T) {
+	matchers := []*labels.Matcher{
+		labels.MustNewMatcher(labels.MatchEqual, "a", "1"),
+		labels.MustNewMatcher(labels.MatchNotEqual, "b", "2"),
+		labels.MustNewMatcher(labels.MatchRegexp, "c", "3|4"),
+		labels.MustNewMatcher(labels.MatchNotRegexp, "d", "5.*"),
+	}
+
+	// The matchers round-trip through their proto representation.
+	converted, err := convertMatchersToLabelMatcher(matchers)
+	require.NoError(t, err)
+	roundTripped, err := convertLabelMatchersToMatchers(converted)
+	require.NoError(t, err)
+	require.Len(t, roundTripped, len(matchers))
+	for i, m := range matchers {
+		assert.Equal(t, m.String(), roundTripped[i].String())
+		assert.Equal(t, m.Matches(m.Value), roundTripped[i].Matches(m.Value))
+	}
+	assert.True(t, roundTripped[2].Matches("4"))
+	assert.False(t, roundTripped[3].Matches("55"))
+
+	_, err = convertLabelMatchersToMatchers([]storepb.LabelMatcher{{Type: storepb.LabelMatcher_Type(42), Name: "a", Value: "1"}})
+	require.EqualError(t, err, "unsupported label matcher type 42 for label a")
+
+	_, err = convertLabelMatchersToMatchers([]storepb.LabelMatcher{{Type: storepb.LabelMatcher_RE, Name: "a", Value: "("}})
+	require.ErrorContains(t, err, "invalid label matcher for label a")
+}
+
+func TestConvertEnforcedMatchersToLabelMatcher(t *testing.T) {
+	enforced := []*labels.Matcher{
+		labels.MustNewMatcher(labels.MatchEqual, "namespace", "a"),
+		labels.MustNewMatcher(labels.MatchNotEqual, "env", "dev"),
+	}
+
+	tests := map[string]struct {
+		userMatchers []*labels.Matcher
+		expected     []storepb.LabelMatcher
+		expectedErr  string
+	}{
+		"should append the enforced matchers": {
+			userMatchers: []*labels.Matcher{labels.MustNewMatcher(labels.MatchEqual, "__name__", "up")},
+			expected: []storepb.LabelMatcher{
+				{Type: storepb.LabelMatcher_EQ, Name: "__name__", Value: "up"},
+				{Type: storepb.LabelMatcher_EQ, Name: "namespace", Value: "a"},
+				{Type: storepb.LabelMatcher_NEQ, Name: "env", Value: "dev"},
+			},
+		},
+		"should deduplicate identical matchers": {
+			userMatchers: []*labels.Matcher{
+				labels.MustNewMatcher(labels.MatchEqual, "namespace", "a"),
+				labels.MustNewMatcher(labels.MatchNotEqual, "env", "dev"),
+			},
+			expected: []storepb.LabelMatcher{
+				{Type: storepb.LabelMatcher_EQ, Name: "namespace", Value: "a"},
+				{Type: storepb.LabelMatcher_NEQ, Name: "env", Value: "dev"},
+			},
+		},
+		"should keep user matchers compatible with the enforced ones": {
+			userMatchers: []*labels.Matcher{labels.MustNewMatcher(labels.MatchRegexp, "namespace", "a|b")},
+			expected: []storepb.LabelMatcher{
+				{Type: storepb.LabelMatcher_RE, Name: "namespace", Value: "a|b"},
+				{Type: storepb.LabelMatcher_EQ, Name: "namespace", Value: "a"},
+				{Type: storepb.LabelMatcher_NEQ, Name: "env", Value: "dev"},
+			},
+		},
+		"should fail on user matcher conflicting with an enforced equality matcher": {
+			userMatchers: []*labels.Matcher{labels.MustNewMatcher(labels.MatchEqual, "namespace", "b")},
+			expectedErr:  `label matcher namespace="b" conflicts with the enforced label matcher namespace="a"`,
+		},
+		"should fail on user negative matcher excluding the enforced value": {
+			userMatchers: []*labels.Matcher{labels.MustNewMatcher(labels.MatchNotEqual, "namespace", "a")},
+			expectedErr:  `label matcher namespace!="a" conflicts with the enforced label matcher namespace="a"`,
+		},
+	}
+
+	for testName, testData := range tests {
+		t.Run(testName, func(t *testing.T) {
+			converted, err := convertEnforcedMatchersToLabelMatcher(testData.userMatchers, enforced)
+			if testData.expectedErr != "" {
+				require.EqualError(t, err, testData.expectedErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, testData.expected, converted)
+		})
+	}
+}
+
+func TestNewLabelValuesRequest(t *testing.T) {
+	tests := map[string]struct {
+		label       string
+		minT, maxT  int64
+		matchers    []*labels.Matcher
+		expected    *storepb.LabelValuesRequest
+		expectedErr string
+	}{
+		"should convert the matchers": {
+			label: "job",
+			minT:  10,
+			maxT:  20,
+			matchers: []*labels.Matcher{
+				labels.MustNewMatcher(labels.MatchEqual, "env", "prod"),
+				labels.MustNewMatcher(labels.MatchNotRegexp, "pod", "test-.*"),
+			},
+			expected: &storepb.LabelValuesRequest{
+				Label: "job",
+				Start: 10,
+				End:   20,
+				Limit: 5,
+				Matchers: []storepb.LabelMatcher{
+					{Type: storepb.LabelMatcher_EQ, Name: "env", Value: "prod"},
+					{Type: storepb.LabelMatcher_NRE, Name: "pod", Value: "test-.*"},
+				},
+			},
+		},
+		"should accept no matchers": {
+			label:    "job",
+			minT:     10,
+			maxT:     10,
+			expected: &storepb.LabelValuesRequest{Label: "job", Start: 10, End: 10, Limit: 5},
+		},
+		"should fail on empty label name": {
+			minT:        10,
+			maxT:        20,
+			expectedErr: "label values request requires a label name",
+		},
+		"should fail if the start time is after the end time": {
+			label:       "job",
+			minT:        20,
+			maxT:        10,
+			expectedErr: "label values request start time 20 is after the end time 10",
+		},
+		"should fail on unsupported matcher type": {
+			label:       "job",
+			minT:        10,
+			maxT:        20,
+			matchers:    []*labels.Matcher{{Type: labels.MatchType(100), Name: "env", Value: "prod"}},
+			expectedErr: "unsupported label matcher type 100 for label env",
+		},
+	}
+
+	for testName, testData := range tests {
+		t.Run(testName, func(t *testing.T) {
+			req, err := newLabelValuesRequest(testData.label, testData.minT, testData.maxT, 5, testData.matchers)
+			if testData.expectedErr != "" {
+				require.EqualError(t, err, testData.expectedErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, testData.expected, req)
+		})
+	}
+}
+
+func TestNewSeriesRequest(t *testing.T) {
+	matchers := []*labels.Matcher{labels.MustNewMatcher(labels.MatchEqual, "env", "prod")}
+	convertedMatchers := []storepb.LabelMatcher{{Type: storepb.LabelMatcher_EQ, Name: "env", Value: "prod"}}
+
+	tests := map[string]struct {
+		minT, maxT  int64
+		matchers    []*labels.Matcher
+		opts        []SeriesOption
+		expected    *storepb.SeriesRequest
+		expectedErr string
+	}{
+		"should set the default hints": {
+			minT:     10,
+			maxT:     20,
+			matchers: matchers,
+			expected: &storepb.SeriesRequest{
+				MinTime:                 10,
+				MaxTime:                 20,
+				Matchers:                convertedMatchers,
+				PartialResponseStrategy: storepb.PartialResponseStrategy_ABORT,
+				Aggregates:              []storepb.Aggr{storepb.Aggr_COUNT, storepb.Aggr_SUM},
+			},
+		},
+		"should apply the options": {
+			minT:     10,
+			maxT:     20,
+			matchers: matchers,
+			opts:     []SeriesOption{WithMaxResolution(300000), WithSkipChunks(true), WithAggregates(storepb.Aggr_MAX)},
+			expected: &storepb.SeriesRequest{
+				MinTime:                 10,
+				MaxTime:                 20,
+				Matchers:                convertedMatchers,
+				PartialResponseStrategy: storepb.PartialResponseStrategy_ABORT,
+				MaxResolutionWindow:     300000,
+				SkipChunks:              true,
+				Aggregates:              []storepb.Aggr{storepb.Aggr_MAX},
+			},
+		},
+		"should fail if the start time is after the end time": {
+			minT:        20,
+			maxT:        10,
+			expectedErr: "series request start time 20 is after the end time 10",
+		},
+		"should fail on unsupported matcher type": {
+			minT:        10,
+			maxT:        20,
+			matchers:    []*labels.Matcher{{Type: labels.MatchType(100), Name: "env", Value: "prod"}},
+			expectedErr: "unsupported label matcher type 100 for label env",
+		},
+	}
+
+	for testName, testData := range tests {
+		t.Run(testName, func(t *testing.T) {
+			req, err := NewSeriesRequest(testData.minT, testData.maxT, testData.matchers, testData.opts...)
+			if testData.expectedErr != "" {
+				require.EqualError(t, err, testData.expectedErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, testData.expected, req)
+		})
+	}
+}
+
+func TestValidateBlocks(t *testing.T) {
+	// Block intervals are half-open: [MinTime, MaxTime).
+	block1 := &bucketindex.Block{ID: ulid.MustNew(1, nil), MinTime: 10, MaxTime: 20}
+	block2 := &bucketindex.Block{ID: ulid.MustNew(2, nil), MinTime: 20, MaxTime: 30}
+	block3 := &bucketindex.Block{ID: ulid.MustNew(3, nil), MinTime: 15, MaxTime: 25}
+
+	tests := map[string]struct {
+		blocks   []*bucketindex.Block
+		expected []string
+	}{
+		"no blocks": {},
+		"adjacent blocks": {
+			blocks: []*bucketindex.Block{block2, block1},
+		},
+		"duplicate block": {
+			blocks:   []*bucketindex.Block{block1, block2, block1},
+			expected: []string{fmt.Sprintf("duplicate block %s", block1.ID)},
+		},
+		"overlapping blocks": {
+			blocks: []*bucketindex.Block{block2, block3, block1},
+			expected: []string{
+				fmt.Sprintf("block %s with time range [10, 20) overlaps block %s with time range [15, 25)", block1.ID, block3.ID),
+				fmt.Sprintf("block %s with time range [15, 25) overlaps block %s with time range [20, 30)", block3.ID, block2.ID),
+			},
+		},
+	}
+
+	for testName, testData := range tests {
+		t.Run(testName, func(t *testing.T) {
+			var actual []string
+			for _, err := range ValidateBlocks(testData.blocks) {
+				actual = append(actual, err.Error())
+			}
+			assert.Equal(t, testData.expected, actual)
+		})
+	}
+}
+
+func TestExtractBlocksForRange(t *testing.T) {
+	// Block intervals are half-open: [MinTime, MaxTime).
+	block1 := &bucketindex.Block{ID: ulid.MustNew(1, nil), MinTime: 10, MaxTime: 20}
+	block2 := &bucketindex.Block{ID: ulid.MustNew(2, nil), MinTime: 20, MaxTime: 30}
+	block3 := &bucketindex.Block{ID: ulid.MustNew(3, nil), MinTime: 30, MaxTime: 40}
+
+	tests := map[string]struct {
+		minT, maxT int64
+		expected   []*bucketindex.Block
+	}{
+		"range covering all blocks": {
+			minT:     0,
+			maxT:     100,
+			expected: []*bucketindex.Block{block1, block2, block3},
+		},
+		"range ending exactly at the min time of a block": {
+			minT:     0,
+			maxT:     20,
+			expected: []*bucketindex.Block{block1, block2},
+		},
+		"range starting exactly at the max time of a block": {
+			minT:     20,
+			maxT:     25,
+			expected: []*bucketindex.Block{block2},
+		},
+		"range starting right before the max time of a block": {
+			minT:     19,
+			maxT:     19,
+			expected: []*bucketindex.Block{block1},
+		},
+		"range not overlapping any block": {
+			minT:     40,
+			maxT:     50,
+			expected: []*bucketindex.Block{},
+		},
+	}
+
+	for testName, testData := range tests {
+		t.Run(testName, func(t *testing.T) {
+			ctx := InjectBlocksIntoContext(context.Background(), block1, block2, block3)
+			actual, ok := ExtractBlocksForRange(ctx, testData.minT, testData.maxT)
+			require.True(t, ok)
+			assert.Equal(t, testData.expected, actual)
+
+			// Injecting with the time range prunes the blocks upfront.
+			ctx = InjectBlocksWithTimeRange(context.Background(), testData.minT, testData.maxT, block1, block2, block3)
+			actual, ok = ExtractBlocksFromContext(ctx)
+			require.True(t, ok)
+			assert.Equal(t, testData.expected, actual)
+		})
+	}
+
+	_, ok := ExtractBlocksForRange(context.Background(), 0, 100)
+	assert.False(t, ok)
+}
+
+func TestMergedStoreSeriesSet_ShouldMergeOverlappingReplicas(t *testing.T) {
+	series1 := labels.FromStrings("__name__", "series_1")
+	series2 := labels.FromStrings("__name__", "series_2")
+	series3 := labels.FromStrings("__name__", "series_3")
+
+	chunk := func(minT, maxT int64, encoding storepb.Chunk_Encoding) storepb.AggrChunk {
+		return storepb.AggrChunk{MinTime: minT, MaxTime: maxT, Raw: &storepb.Chunk{Type: encoding}}
+	}
+	storeSeries := func(lbls labels.Labels, chunks ...storepb.AggrChunk) *storepb.Series {
+		return &storepb.Series{Labels: labelpb.ZLabelsFromPromLabels(lbls), Chunks: chunks}
+	}
+
+	// Both replicas return series_1 and series_2 for the same block, while only the first
+	// replica has series_1 data from another block and only the second one has series_3.
+	replica1 := newStoreSeriesSet([]*storepb.Series{
+		storeSeries(series1, chunk(0, 10, storepb.Chunk_XOR), chunk(20, 30, storepb.Chunk_XOR)),
+		storeSeries(series2, chunk(0, 10, storepb.Chunk_XOR)),
+	})
+	replica2 := newStoreSeriesSet([]*storepb.Series{
+		storeSeries(series1, chunk(0, 10, storepb.Chunk_XOR)),
+		storeSeries(series2, chunk(0, 10, storepb.Chunk_XOR), chunk(0, 10, storepb.Chunk_HISTOGRAM)),
+		storeSeries(series3, chunk(10, 20, storepb.Chunk_XOR)),
+	})
+
+	set := newMergedStoreSeriesSet(replica1, replica2)
+
+	type seriesChunks struct {
+		labels labels.Labels
+		chunks []storepb.AggrChunk
+	}
+	var actual []seriesChunks
+	for set.Next() {
+		lbls, chunks := set.At()
+		actual = append(actual, seriesChunks{labels: lbls, chunks: chunks})
+	}
+	require.NoError(t, set.Err())
+
+	assert.Equal(t, []seriesChunks{
+		{labels: series1, chunks: []storepb.AggrChunk{chunk(0, 10, storepb.Chunk_XOR), chunk(20, 30, storepb.Chunk_XOR)}},
+		// Chunks with the same time range but a different encoding are not duplicates.
+		{labels: series2, chunks: []storepb.AggrChunk{chunk(0, 10, storepb.Chunk_XOR), chunk(0, 10, storepb.Chunk_HISTOGRAM)}},
+		{labels: series3, chunks: []storepb.AggrChunk{chunk(10, 20, storepb.Chunk_XOR)}},
+	}, actual)
+}
+
+func TestMergedStoreSeriesSet_ShouldPropagateErrors(t *testing.T) {
+	expectedErr := errors.New("replica failed")
+
+	set := newMergedStoreSeriesSet(
+		newStoreSeriesSet(nil),
+		newStoreSeriesSet(nil).withErr(expectedErr),
+	)
+	require.False(t, set.Next())
+	assert.Equal(t, expectedErr, set.Err())
+	assert.Empty(t, set.Warnings())
+}
+
+func TestMergedStoreSeriesSet_PartialResponse(t *testing.T) {
+	series1 := labels.FromStrings("__name__", "series_1")
+	series2 := labels.FromStrings("__name__", "series_2")
+	storeSeries := func(lbls labels.Labels) *storepb.Series {
+		return &storepb.Series{Labels: labelpb.ZLabelsFromPromLabels(lbls)}
+	}
+	err1 := errors.New("store-gateway 1 failed")
+	err2 := errors.New("store-gateway 2 failed")
+
+	t.Run("should return the series of the succeeded sets with the errors as warnings", func(t *testing.T) {
+		set := newMergedStoreSeriesSet(
+			newStoreSeriesSet([]*storepb.Series{storeSeries(series1)}),
+			// The store-gateway failed mid-stream, after returning a series.
+			newStoreSeriesSet([]*storepb.Series{storeSeries(series2)}).withErr(err1),
+			newStoreSeriesSet(nil).withErr(err2),
+		).withPartialResponse()
+
+		var actual []labels.Labels
+		for set.Next() {
+			lbls, _ := set.At()
+			actual = append(actual, lbls)
+		}
+		require.NoError(t, set.Err())
+		assert.Equal(t, []labels.Labels{series1, series2}, actual)
+		assert.Equal(t, []error{err1, err2}, set.Warnings())
+	})
+
+	t.Run("should fail if all the sets failed", func(t *testing.T) {
+		set := newMergedStoreSeriesSet(
+			newStoreSeriesSet(nil).withErr(err1),
+			newStoreSeriesSet(nil).withErr(err2),
+		).withPartialResponse()
+
+		require.False(t, set.Next())
+		assert.Equal(t, err1, set.Err())
+		assert.Empty(t, set.Warnings())
+	})
+
+	t.Run("should not return warnings if all the sets succeeded", func(t *testing.T) {
+		set := newMergedStoreSeriesSet(newStoreSeriesSet([]*storepb.Series{storeSeries(series1)})).withPartialResponse()
+
+		require.True(t, set.Next())
+		require.False(t, set.Next())
+		require.NoError(t, set.Err())
+		assert.Empty(t, set.Warnings())
+	})
+}
@@ -16,7 +16,6 @@ import (
 	"github.com/thanos-io/thanos/pkg/discovery/dns"
 	"github.com/thanos-io/thanos/pkg/extprom"
 
-	"github.com/cortexproject/cortex/pkg/ring/client"
 	"github.com/cortexproject/cortex/pkg/util/services"
 )
 
@@ -26,7 +25,7 @@ type blocksStoreBalancedSet struct {
 	services.Service
 
 	serviceAddresses []string
-	clientsPool      *client.Pool
+	clientsPool      *storeGatewayClientPool
 	dnsProvider      *dns.Provider
 
 	logger log.Logger
@@ -60,6 +59,10 @@ func (s *blocksStoreBalancedSet) resolve(ctx context.Context) error {
 	return nil
 }
 
+func (s *blocksStoreBalancedSet) clientsHealth() []StoreGatewayClientHealth {
+	return s.clientsPool.clientsHealth()
+}
+
 func (s *blocksStoreBalancedSet) GetClientsFor(_ string, blockIDs []ulid.ULID, exclude map[ulid.ULID][]string, _ map[ulid.ULID]map[string]int) (map[BlocksStoreClient][]ulid.ULID, error) {
 	addresses := s.dnsProvider.Addresses()
 	if len(addresses) == 0 {
@@ -20,7 +20,7 @@ func TestBlocksStoreBalancedSet_GetClientsFor(t *testing.T) {
 	t.Parallel()
 
 	const numGets = 1000
-	serviceAddrs := []string{"127.0.0.1", "127.0.0.2"}
+	serviceAddrs := []string{"127.0.0.1:9095", "127.0.0.2:9095"}
 	block1 := ulid.MustNew(1, nil)
 
 	ctx := context.Background()
@@ -54,6 +54,12 @@ func TestBlocksStoreBalancedSet_GetClientsFor(t *testing.T) {
 	}
 
 	assert.NoError(t, testutil.GatherAndCompare(reg, strings.NewReader(`
+		# HELP cortex_storegateway_client_connections_closed_total Total number of connections to store-gateways closed.
+		# TYPE cortex_storegateway_client_connections_closed_total counter
+		cortex_storegateway_client_connections_closed_total{client="querier"} 0
+		# HELP cortex_storegateway_client_connections_created_total Total number of connections created to store-gateways.
+		# TYPE cortex_storegateway_client_connections_created_total counter
+		cortex_storegateway_client_connections_created_total{client="querier"} 2
 		# HELP cortex_storegateway_client_dns_failures_total The number of DNS lookup failures
 		# TYPE cortex_storegateway_client_dns_failures_total counter
 		cortex_storegateway_client_dns_failures_total 0
@@ -62,8 +68,12 @@ func TestBlocksStoreBalancedSet_GetClientsFor(t *testing.T) {
 		cortex_storegateway_client_dns_lookups_total 0
 		# HELP cortex_storegateway_client_dns_provider_results The number of resolved endpoints for each configured address
 		# TYPE cortex_storegateway_client_dns_provider_results gauge
-		cortex_storegateway_client_dns_provider_results{addr="127.0.0.1"} 1
-		cortex_storegateway_client_dns_provider_results{addr="127.0.0.2"} 1
+		cortex_storegateway_client_dns_provider_results{addr="127.0.0.1:9095"} 1
+		cortex_storegateway_client_dns_provider_results{addr="127.0.0.2:9095"} 1
+		# HELP cortex_storegateway_client_inflight_requests The current number of in-flight requests to each store-gateway.
+		# TYPE cortex_storegateway_client_inflight_requests gauge
+		cortex_storegateway_client_inflight_requests{address="127.0.0.1:9095",client="querier"} 0
+		cortex_storegateway_client_inflight_requests{address="127.0.0.2:9095",client="querier"} 0
 		# HELP cortex_storegateway_clients The current number of store-gateway clients in the pool.
 		# TYPE cortex_storegateway_clients gauge
 		cortex_storegateway_clients{client="querier"} 2
@@ -84,47 +94,47 @@ func TestBlocksStoreBalancedSet_GetClientsFor_Exclude(t *testing.T) {
 		expectedErr     error
 	}{
 		"no exclude": {
-			serviceAddrs: []string{"127.0.0.1"},
+			serviceAddrs: []string{"127.0.0.1:9095"},
 			queryBlocks:  []ulid.ULID{block1, block2},
 			expectedClients: map[string][]ulid.ULID{
-				"127.0.0.1": {block1, block2},
+				"127.0.0.1:9095": {block1, block2},
 			},
 		},
 		"single instance available and excluded for a non-queried block": {
-			serviceAddrs: []string{"127.0.0.1"},
+			serviceAddrs: []string{"127.0.0.1:9095"},
 			queryBlocks:  []ulid.ULID{block1},
 			exclude: map[ulid.ULID][]string{
-				block2: {"127.0.0.1"},
+				block2: {"127.0.0.1:9095"},
 			},
 			expectedClients: map[string][]ulid.ULID{
-				"127.0.0.1": {block1},
+				"127.0.0.1:9095": {block1},
 			},
 		},
 		"single instance available and excluded for the queried block": {
-			serviceAddrs: []string{"127.0.0.1"},
+			serviceAddrs: []string{"127.0.0.1:9095"},
 			queryBlocks:  []ulid.ULID{block1},
 			exclude: map[ulid.ULID][]string{
-				block1: {"127.0.0.1"},
+				block1: {"127.0.0.1:9095"},
 			},
 			expectedErr: fmt.Errorf("no store-gateway instance left after filtering out excluded instances for block %s", block1.String()),
 		},
 		"multiple instances available and one is excluded for the queried blocks": {
-			serviceAddrs: []string{"127.0.0.1", "127.0.0.2"},
+			serviceAddrs: []string{"127.0.0.1:9095", "127.0.0.2:9095"},
 			queryBlocks:  []ulid.ULID{block1, block2},
 			exclude: map[ulid.ULID][]string{
-				block1: {"127.0.0.1"},
-				block2: {"127.0.0.2"},
+				block1: {"127.0.0.1:9095"},
+				block2: {"127.0.0.2:9095"},
 			},
 			expectedClients: map[string][]ulid.ULID{
-				"127.0.0.1": {block2},
-				"127.0.0.2": {block1},
+				"127.0.0.1:9095": {block2},
+				"127.0.0.2:9095": {block1},
 			},
 		},
 		"multiple instances available and all are excluded for the queried block": {
-			serviceAddrs: []string{"127.0.0.1", "127.0.0.2"},
+			serviceAddrs: []string{"127.0.0.1:9095", "127.0.0.2:9095"},
 			queryBlocks:  []ulid.ULID{block1, block2},
 			exclude: map[ulid.ULID][]string{
-				block1: {"127.0.0.1", "127.0.0.2"},
+				block1: {"127.0.0.1:9095", "127.0.0.2:9095"},
 			},
 			expectedErr: fmt.Errorf("no store-gateway instance left after filtering out excluded instances for block %s", block1.String()),
 		},
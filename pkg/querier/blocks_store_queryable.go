@@ -24,7 +24,6 @@ import (
 	"github.com/prometheus/prometheus/storage"
 	"github.com/prometheus/prometheus/util/annotations"
 	"github.com/thanos-io/thanos/pkg/block"
-	"github.com/thanos-io/thanos/pkg/compact/downsample"
 	"github.com/thanos-io/thanos/pkg/extprom"
 	"github.com/thanos-io/thanos/pkg/pool"
 	thanosquery "github.com/thanos-io/thanos/pkg/query"
@@ -50,6 +49,7 @@ import (
 	"github.com/cortexproject/cortex/pkg/util/limiter"
 	util_log "github.com/cortexproject/cortex/pkg/util/log"
 	"github.com/cortexproject/cortex/pkg/util/multierror"
+	"github.com/cortexproject/cortex/pkg/util/requestmeta"
 	"github.com/cortexproject/cortex/pkg/util/services"
 	"github.com/cortexproject/cortex/pkg/util/spanlogger"
 	"github.com/cortexproject/cortex/pkg/util/users"
@@ -67,8 +67,40 @@ var (
 	errNoStoreGatewayAddress  = errors.New("no store-gateway address configured")
 	errMaxChunksPerQueryLimit = "the query hit the max number of chunks limit while fetching chunks from store-gateways for %s (limit: %d)"
 	defaultAggrs              = []storepb.Aggr{storepb.Aggr_COUNT, storepb.Aggr_SUM}
+
+	disableDedupCtxKey contextKey = 3
 )
 
+// InjectDisableDedup flags the context so that the series returned by different store-gateways
+// for the same labels are returned as is, instead of being merged together.
+func InjectDisableDedup(ctx context.Context, disable bool) context.Context {
+	return context.WithValue(ctx, disableDedupCtxKey, disable)
+}
+
+func isDedupDisabled(ctx context.Context) bool {
+	disabled, ok := ctx.Value(disableDedupCtxKey).(bool)
+	return ok && disabled
+}
+
+// injectRequestQueryID injects in the context, unless a query ID has already been injected,
+// the ID of the request the querier logs along with the query, or else its trace ID, so that
+// it's forwarded to the store-gateways as the query ID.
+func injectRequestQueryID(ctx context.Context) context.Context {
+	if _, ok := ExtractQueryID(ctx); ok {
+		return ctx
+	}
+
+	queryID := requestmeta.RequestIdFromContext(ctx)
+	if queryID == "" {
+		queryID, _ = util_log.ExtractSampledTraceID(ctx)
+	}
+	// The trace ID of a noop span is all zeros.
+	if strings.Trim(queryID, "0") == "" {
+		return ctx
+	}
+	return InjectQueryID(ctx, queryID)
+}
+
 // BlocksStoreSet is the interface used to get the clients to query series on a set of blocks.
 type BlocksStoreSet interface {
 	services.Service
@@ -104,11 +136,13 @@ type BlocksStoreLimits interface {
 
 	MaxChunksPerQueryFromStore(userID string) int
 	StoreGatewayTenantShardSize(userID string) float64
+	StoreGatewayPartialResponse(userID string) bool
 }
 
 type blocksStoreQueryableMetrics struct {
-	storesHit prometheus.Histogram
-	refetches prometheus.Histogram
+	storesHit     prometheus.Histogram
+	refetches     prometheus.Histogram
+	chunksFetched prometheus.Histogram
 }
 
 func newBlocksStoreQueryableMetrics(reg prometheus.Registerer) *blocksStoreQueryableMetrics {
@@ -125,6 +159,13 @@ func newBlocksStoreQueryableMetrics(reg prometheus.Registerer) *blocksStoreQuery
 			Help:      "Number of re-fetches attempted while querying store-gateway instances due to missing blocks.",
 			Buckets:   []float64{0, 1, 2, 4, 8},
 		}),
+		chunksFetched: promauto.With(reg).NewHistogram(prometheus.HistogramOpts{
+			Namespace:   "cortex",
+			Name:        "storegateway_client_chunks_fetched",
+			Help:        "Number of chunks fetched by a single series request to a store-gateway.",
+			Buckets:     prometheus.ExponentialBuckets(1, 4, 10),
+			ConstLabels: prometheus.Labels{"client": "querier"},
+		}),
 	}
 }
 
@@ -142,6 +183,7 @@ type BlocksStoreQueryable struct {
 	limits          BlocksStoreLimits
 
 	storeGatewayQueryStatsEnabled           bool
+	storeGatewayDebugSeries                 bool
 	storeGatewayConsistencyCheckMaxAttempts int
 	storeGatewaySeriesBatchSize             int64
 
@@ -175,6 +217,7 @@ func NewBlocksStoreQueryable(
 		metrics:                                 newBlocksStoreQueryableMetrics(reg),
 		limits:                                  limits,
 		storeGatewayQueryStatsEnabled:           config.StoreGatewayQueryStatsEnabled,
+		storeGatewayDebugSeries:                 config.StoreGatewayDebugSeries,
 		storeGatewayConsistencyCheckMaxAttempts: config.StoreGatewayConsistencyCheckMaxAttempts,
 		storeGatewaySeriesBatchSize:             config.StoreGatewaySeriesBatchSize,
 	}
@@ -307,6 +350,7 @@ func (q *BlocksStoreQueryable) Querier(mint, maxt int64) (storage.Querier, error
 		logger:                                  q.logger,
 		queryStoreAfter:                         q.queryStoreAfter,
 		storeGatewayQueryStatsEnabled:           q.storeGatewayQueryStatsEnabled,
+		storeGatewayDebugSeries:                 q.storeGatewayDebugSeries,
 		storeGatewayConsistencyCheckMaxAttempts: q.storeGatewayConsistencyCheckMaxAttempts,
 		storeGatewaySeriesBatchSize:             q.storeGatewaySeriesBatchSize,
 	}, nil
@@ -329,6 +373,10 @@ type blocksStoreQuerier struct {
 	// using `info` level.
 	storeGatewayQueryStatsEnabled bool
 
+	// If enabled, each series returned by the store gateways is logged
+	// using `debug` level.
+	storeGatewayDebugSeries bool
+
 	// The maximum number of times we attempt fetching missing blocks from different Store Gateways.
 	storeGatewayConsistencyCheckMaxAttempts int
 
@@ -339,10 +387,11 @@ type blocksStoreQuerier struct {
 // Select implements storage.Querier interface.
 // The bool passed is ignored because the series is always sorted.
 func (q *blocksStoreQuerier) Select(ctx context.Context, _ bool, sp *storage.SelectHints, matchers ...*labels.Matcher) storage.SeriesSet {
-	return q.selectSorted(ctx, sp, matchers...)
+	return q.selectSorted(injectRequestQueryID(ctx), sp, matchers...)
 }
 
 func (q *blocksStoreQuerier) LabelNames(ctx context.Context, hints *storage.LabelHints, matchers ...*labels.Matcher) ([]string, annotations.Annotations, error) {
+	ctx = injectRequestQueryID(ctx)
 	userID, err := users.TenantID(ctx)
 	if err != nil {
 		return nil, nil, err
@@ -357,11 +406,15 @@ func (q *blocksStoreQuerier) LabelNames(ctx context.Context, hints *storage.Labe
 		limit = int64(hints.Limit)
 	}
 
+	convertedMatchers, err := convertMatchersToLabelMatcher(matchers)
+	if err != nil {
+		return nil, nil, err
+	}
+
 	var (
-		resMtx            sync.Mutex
-		resNameSets       = [][]string{}
-		resWarnings       = annotations.Annotations(nil)
-		convertedMatchers = convertMatchersToLabelMatcher(matchers)
+		resMtx      sync.Mutex
+		resNameSets = [][]string{}
+		resWarnings = annotations.Annotations(nil)
 	)
 
 	queryFunc := func(clients map[BlocksStoreClient][]ulid.ULID, minT, maxT int64) ([]ulid.ULID, error, error) {
@@ -386,6 +439,7 @@ func (q *blocksStoreQuerier) LabelNames(ctx context.Context, hints *storage.Labe
 }
 
 func (q *blocksStoreQuerier) LabelValues(ctx context.Context, name string, hints *storage.LabelHints, matchers ...*labels.Matcher) ([]string, annotations.Annotations, error) {
+	ctx = injectRequestQueryID(ctx)
 	userID, err := users.TenantID(ctx)
 	if err != nil {
 		return nil, nil, err
@@ -452,12 +506,13 @@ func (q *blocksStoreQuerier) selectSorted(ctx context.Context, sp *storage.Selec
 
 		maxChunksLimit  = q.limits.MaxChunksPerQueryFromStore(userID)
 		leftChunksLimit = maxChunksLimit
+		partialResponse = q.limits.StoreGatewayPartialResponse(userID) && !isDedupDisabled(ctx)
 
 		resultMtx sync.Mutex
 	)
 
 	queryFunc := func(clients map[BlocksStoreClient][]ulid.ULID, minT, maxT int64) ([]ulid.ULID, error, error) {
-		seriesSets, queriedBlocks, warnings, numChunks, err, retryableError := q.fetchSeriesFromStores(spanCtx, sp, userID, clients, minT, maxT, limit, matchers, maxChunksLimit, leftChunksLimit)
+		seriesSets, queriedBlocks, warnings, numChunks, err, retryableError := q.fetchSeriesFromStores(spanCtx, sp, userID, clients, minT, maxT, limit, matchers, maxChunksLimit, leftChunksLimit, partialResponse)
 		if err != nil {
 			return nil, err, retryableError
 		}
@@ -485,11 +540,39 @@ func (q *blocksStoreQuerier) selectSorted(ctx context.Context, sp *storage.Selec
 		storage.EmptySeriesSet()
 	}
 
+	if isDedupDisabled(ctx) {
+		return series.NewSeriesSetWithWarnings(concatSeriesSets(resSeriesSets, int(limit)), resWarnings)
+	}
+
 	return series.NewSeriesSetWithWarnings(
 		storage.NewMergeSeriesSet(resSeriesSets, int(limit), storage.ChainedSeriesMerge),
 		resWarnings)
 }
 
+// concatSeriesSets returns all the series of the input sets sorted by labels, keeping the
+// series with the same labels from different sets as distinct series.
+func concatSeriesSets(sets []storage.SeriesSet, limit int) storage.SeriesSet {
+	var all []storage.Series
+	for _, set := range sets {
+		for set.Next() {
+			all = append(all, set.At())
+		}
+		if err := set.Err(); err != nil {
+			return storage.ErrSeriesSet(err)
+		}
+	}
+
+	sort.SliceStable(all, func(i, j int) bool {
+		return labels.Compare(all[i].Labels(), all[j].Labels()) < 0
+	})
+	if limit > 0 && len(all) > limit {
+		all = all[:limit]
+	}
+	// The series are already sorted, and sorting them again wouldn't keep the order of the
+	// series with the same labels.
+	return series.NewConcreteSeriesSet(false, all)
+}
+
 func (q *blocksStoreQuerier) queryWithConsistencyCheck(ctx context.Context, logger log.Logger, minT, maxT int64, matchers []*labels.Matcher,
 	userID string, queryFunc func(clients map[BlocksStoreClient][]ulid.ULID, minT, maxT int64) ([]ulid.ULID, error, error)) error {
 	// If queryStoreAfter is enabled, we do manipulate the query maxt to query samples up until
@@ -518,6 +601,10 @@ func (q *blocksStoreQuerier) queryWithConsistencyCheck(ctx context.Context, logg
 	// if blocks were already discovered, we should use then
 	if b, ok := ExtractBlocksFromContext(ctx); ok {
 		knownBlocks = b
+
+		for _, err := range ValidateBlocks(b) {
+			level.Warn(logger).Log("msg", "inconsistent blocks found in the context", "err", err)
+		}
 	}
 	if err != nil {
 		return err
@@ -617,12 +704,13 @@ func (q *blocksStoreQuerier) fetchSeriesFromStores(
 	matchers []*labels.Matcher,
 	maxChunksLimit int,
 	leftChunksLimit int,
+	partialResponse bool,
 ) ([]storage.SeriesSet, []ulid.ULID, annotations.Annotations, int, error, error) {
 	var (
 		reqCtx        = grpc_metadata.AppendToOutgoingContext(ctx, cortex_tsdb.TenantIDExternalLabel, userID)
 		g, gCtx       = errgroup.WithContext(reqCtx)
 		mtx           = sync.Mutex{}
-		seriesSets    = []storage.SeriesSet(nil)
+		storeSets     = []storepb.SeriesSet(nil)
 		warnings      = annotations.Annotations(nil)
 		queriedBlocks = []ulid.ULID(nil)
 		numChunks     = atomic.NewInt32(0)
@@ -637,13 +725,16 @@ func (q *blocksStoreQuerier) fetchSeriesFromStores(
 	if err != nil {
 		return nil, nil, nil, 0, err, merr.Err()
 	}
-	convertedMatchers := convertMatchersToLabelMatcher(matchers)
+	convertedMatchers, err := convertMatchersToLabelMatcher(matchers)
+	if err != nil {
+		return nil, nil, nil, 0, err, merr.Err()
+	}
 
 	// Concurrently fetch series from all clients.
 	for c, blockIDs := range clients {
 		// Change variables scope since it will be used in a goroutine.
 
-		g.Go(func() error {
+		fetch := func() error {
 			// See: https://github.com/prometheus/prometheus/pull/8050
 			// TODO(goutham): we should ideally be passing the hints down to the storage layer
 			// and let the TSDB return us data with no chunks as in prometheus#8050.
@@ -652,9 +743,9 @@ func (q *blocksStoreQuerier) fetchSeriesFromStores(
 			// Only fail the function if we have validation error. We should return blocks that were successfully
 			// retrieved.
 			seriesQueryStats := &hintspb.QueryStats{}
-			skipChunks := sp != nil && sp.Func == "series"
+			labelsOnly := isLabelsOnlyRequest(sp)
 
-			req, err := createSeriesRequest(minT, maxT, limit, convertedMatchers, sp, shardingInfo, skipChunks, blockIDs, defaultAggrs, q.storeGatewaySeriesBatchSize)
+			req, err := createSeriesRequest(minT, maxT, limit, convertedMatchers, sp, shardingInfo, labelsOnly, blockIDs, defaultAggrs, q.storeGatewaySeriesBatchSize)
 			if err != nil {
 				return errors.Wrapf(err, "failed to create series request")
 			}
@@ -787,6 +878,12 @@ func (q *blocksStoreQuerier) fetchSeriesFromStores(
 			chunkBytes := countChunkBytes(mySeries...)
 			dataBytes := countDataBytes(mySeries...)
 
+			// The chunks are counted once the stream, the series set of the request, has been
+			// consumed. Counting them while the series set built below is consumed would miss
+			// the requests whose query fails before consuming it, and only count the chunks
+			// left after clipping them to the query time range.
+			q.metrics.chunksFetched.Observe(float64(chunksCount))
+
 			reqStats.AddFetchedSeries(uint64(numSeries))
 			reqStats.AddFetchedChunks(chunksCount)
 			reqStats.AddFetchedSamples(numSamples)
@@ -837,11 +934,37 @@ func (q *blocksStoreQuerier) fetchSeriesFromStores(
 			// Store the result.
 			mtx.Lock()
 			// TODO: change other aggregations when downsampling is enabled.
-			seriesSets = append(seriesSets, thanosquery.NewPromSeriesSet(newStoreSeriesSet(mySeries), minT, maxT, defaultAggrs, nil))
+			var set storepb.SeriesSet
+			if labelsOnly {
+				set = newLabelsOnlyStoreSeriesSet(mySeries)
+			} else {
+				set = newClippedStoreSeriesSet(mySeries, minT, maxT)
+			}
+			if q.storeGatewayDebugSeries {
+				set = newTeeStoreSeriesSet(set, debugSeriesLogger(util_log.WithContext(ctx, q.logger), c.RemoteAddress()))
+			}
+			storeSets = append(storeSets, set)
 			warnings.Merge(myWarnings)
 			queriedBlocks = append(queriedBlocks, myQueriedBlocks...)
 			mtx.Unlock()
 
+			return nil
+		}
+
+		g.Go(func() error {
+			err := fetch()
+			if err == nil || !partialResponse || !isPartialResponseError(gCtx, err) {
+				return err
+			}
+
+			// The store-gateway failure is returned as a warning along with the series of
+			// the other store-gateways. Its blocks aren't queried again on other
+			// store-gateways, since the failure isn't retryable.
+			level.Warn(spanLog).Log("msg", "returning partial response", "err", err)
+			mtx.Lock()
+			storeSets = append(storeSets, newStoreSeriesSet(nil).withErr(err))
+			queriedBlocks = append(queriedBlocks, blockIDs...)
+			mtx.Unlock()
 			return nil
 		})
 	}
@@ -851,9 +974,35 @@ func (q *blocksStoreQuerier) fetchSeriesFromStores(
 		return nil, nil, nil, 0, err, merr.Err()
 	}
 
+	if partialResponse && len(storeSets) > 0 {
+		merged := newMergedStoreSeriesSet(storeSets...).withPartialResponse()
+		if err := merged.Err(); err != nil {
+			return nil, nil, nil, 0, err, merr.Err()
+		}
+		for _, w := range merged.Warnings() {
+			warnings.Add(w)
+		}
+		storeSets = []storepb.SeriesSet{merged}
+	}
+
+	seriesSets := make([]storage.SeriesSet, 0, len(storeSets))
+	for _, set := range storeSets {
+		seriesSets = append(seriesSets, thanosquery.NewPromSeriesSet(set, minT, maxT, defaultAggrs, nil))
+	}
 	return seriesSets, queriedBlocks, warnings, int(numChunks.Load()), nil, merr.Err()
 }
 
+// isPartialResponseError returns whether the failure of a store-gateway Series request can be
+// returned as a warning with partial responses. The limit and access denied errors, and the
+// errors of the canceled requests, always fail the query.
+func isPartialResponseError(ctx context.Context, err error) bool {
+	if ctx.Err() != nil || validation.IsLimitError(err) {
+		return false
+	}
+	var accessDenied validation.AccessDeniedError
+	return !errors.As(err, &accessDenied)
+}
+
 func (q *blocksStoreQuerier) fetchLabelNamesFromStore(
 	ctx context.Context,
 	userID string,
@@ -1066,6 +1215,24 @@ func (q *blocksStoreQuerier) fetchLabelValuesFromStore(
 	return valueSets, warnings, queriedBlocks, nil, merr.Err()
 }
 
+// debugSeriesLogger returns a function logging the series returned by the given store-gateway
+// at debug level.
+func debugSeriesLogger(logger log.Logger, instance string) func(labels.Labels, []storepb.AggrChunk) {
+	return func(lbls labels.Labels, chunks []storepb.AggrChunk) {
+		var minT, maxT int64
+		if len(chunks) > 0 {
+			minT, maxT = chunks[0].MinTime, chunks[len(chunks)-1].MaxTime
+		}
+		level.Debug(logger).Log("msg", "series returned by store-gateway", "instance", instance, "series", lbls.String(), "chunks", len(chunks), "min_time", minT, "max_time", maxT)
+	}
+}
+
+// isLabelsOnlyRequest returns whether the select hints only require the labels of the
+// series, e.g. for the /api/v1/series requests, so that no chunk has to be fetched.
+func isLabelsOnlyRequest(sp *storage.SelectHints) bool {
+	return sp != nil && sp.Func == "series"
+}
+
 func createSeriesRequest(minT, maxT, limit int64, matchers []storepb.LabelMatcher, selectHints *storage.SelectHints, shardingInfo *storepb.ShardInfo, skipChunks bool, blockIDs []ulid.ULID, aggrs []storepb.Aggr, batchSize int64) (*storepb.SeriesRequest, error) {
 	// Selectively query only specific blocks.
 	hints := &hintspb.SeriesRequestHints{
@@ -1084,20 +1251,11 @@ func createSeriesRequest(minT, maxT, limit int64, matchers []storepb.LabelMatche
 		return nil, errors.Wrapf(err, "failed to marshal series request hints")
 	}
 
-	req := &storepb.SeriesRequest{
-		MinTime:                 minT,
-		MaxTime:                 maxT,
-		Limit:                   limit,
-		Matchers:                matchers,
-		PartialResponseStrategy: storepb.PartialResponseStrategy_ABORT,
-		Hints:                   anyHints,
-		SkipChunks:              skipChunks,
-		ShardInfo:               shardingInfo,
-		// TODO: support more downsample levels when downsampling is supported.
-		Aggregates:          aggrs,
-		MaxResolutionWindow: downsample.ResLevel0,
-		ResponseBatchSize:   batchSize,
-	}
+	req := newSeriesRequest(minT, maxT, matchers, WithSkipChunks(skipChunks), WithAggregates(aggrs...))
+	req.Limit = limit
+	req.Hints = anyHints
+	req.ShardInfo = shardingInfo
+	req.ResponseBatchSize = batchSize
 
 	if selectHints != nil {
 		req.QueryHints = &storepb.QueryHints{
@@ -1139,12 +1297,9 @@ func createLabelNamesRequest(minT, maxT, limit int64, blockIDs []ulid.ULID, matc
 }
 
 func createLabelValuesRequest(minT, maxT, limit int64, label string, blockIDs []ulid.ULID, matchers ...*labels.Matcher) (*storepb.LabelValuesRequest, error) {
-	req := &storepb.LabelValuesRequest{
-		Start:    minT,
-		End:      maxT,
-		Limit:    limit,
-		Label:    label,
-		Matchers: convertMatchersToLabelMatcher(matchers),
+	req, err := newLabelValuesRequest(label, minT, maxT, limit, matchers)
+	if err != nil {
+		return nil, err
 	}
 
 	// Selectively query only specific blocks.
@@ -40,6 +40,7 @@ import (
 
 	"github.com/cortexproject/cortex/pkg/chunk/encoding"
 	"github.com/cortexproject/cortex/pkg/cortexpb"
+	"github.com/cortexproject/cortex/pkg/querier/series"
 	"github.com/cortexproject/cortex/pkg/storage/tsdb/bucketindex"
 	"github.com/cortexproject/cortex/pkg/storegateway"
 	"github.com/cortexproject/cortex/pkg/storegateway/storegatewaypb"
@@ -626,6 +627,22 @@ func TestBlocksStoreQuerier_Select(t *testing.T) {
 				cortex_querier_storegateway_refetches_per_query_bucket{le="+Inf"} 1
 				cortex_querier_storegateway_refetches_per_query_sum 0
 				cortex_querier_storegateway_refetches_per_query_count 1
+
+				# HELP cortex_storegateway_client_chunks_fetched Number of chunks fetched by a single series request to a store-gateway.
+				# TYPE cortex_storegateway_client_chunks_fetched histogram
+				cortex_storegateway_client_chunks_fetched_bucket{client="querier",le="1"} 0
+				cortex_storegateway_client_chunks_fetched_bucket{client="querier",le="4"} 3
+				cortex_storegateway_client_chunks_fetched_bucket{client="querier",le="16"} 3
+				cortex_storegateway_client_chunks_fetched_bucket{client="querier",le="64"} 3
+				cortex_storegateway_client_chunks_fetched_bucket{client="querier",le="256"} 3
+				cortex_storegateway_client_chunks_fetched_bucket{client="querier",le="1024"} 3
+				cortex_storegateway_client_chunks_fetched_bucket{client="querier",le="4096"} 3
+				cortex_storegateway_client_chunks_fetched_bucket{client="querier",le="16384"} 3
+				cortex_storegateway_client_chunks_fetched_bucket{client="querier",le="65536"} 3
+				cortex_storegateway_client_chunks_fetched_bucket{client="querier",le="262144"} 3
+				cortex_storegateway_client_chunks_fetched_bucket{client="querier",le="+Inf"} 3
+				cortex_storegateway_client_chunks_fetched_sum{client="querier"} 6
+				cortex_storegateway_client_chunks_fetched_count{client="querier"} 3
 			`,
 		},
 		"multiple store-gateway instances holds the required blocks with overlapping series with limit (multiple returned series)": {
@@ -920,6 +937,22 @@ func TestBlocksStoreQuerier_Select(t *testing.T) {
 				cortex_querier_storegateway_refetches_per_query_bucket{le="+Inf"} 1
 				cortex_querier_storegateway_refetches_per_query_sum 2
 				cortex_querier_storegateway_refetches_per_query_count 1
+
+				# HELP cortex_storegateway_client_chunks_fetched Number of chunks fetched by a single series request to a store-gateway.
+				# TYPE cortex_storegateway_client_chunks_fetched histogram
+				cortex_storegateway_client_chunks_fetched_bucket{client="querier",le="1"} 4
+				cortex_storegateway_client_chunks_fetched_bucket{client="querier",le="4"} 4
+				cortex_storegateway_client_chunks_fetched_bucket{client="querier",le="16"} 4
+				cortex_storegateway_client_chunks_fetched_bucket{client="querier",le="64"} 4
+				cortex_storegateway_client_chunks_fetched_bucket{client="querier",le="256"} 4
+				cortex_storegateway_client_chunks_fetched_bucket{client="querier",le="1024"} 4
+				cortex_storegateway_client_chunks_fetched_bucket{client="querier",le="4096"} 4
+				cortex_storegateway_client_chunks_fetched_bucket{client="querier",le="16384"} 4
+				cortex_storegateway_client_chunks_fetched_bucket{client="querier",le="65536"} 4
+				cortex_storegateway_client_chunks_fetched_bucket{client="querier",le="262144"} 4
+				cortex_storegateway_client_chunks_fetched_bucket{client="querier",le="+Inf"} 4
+				cortex_storegateway_client_chunks_fetched_sum{client="querier"} 4
+				cortex_storegateway_client_chunks_fetched_count{client="querier"} 4
 			`,
 		},
 		"max chunks per query limit greater then the number of chunks fetched": {
@@ -1793,6 +1826,229 @@ func TestOverrideBlockDiscovery(t *testing.T) {
 	require.NoError(t, ss.Err())
 }
 
+func TestBlocksStoreQuerier_SelectShouldHonorDisableDedup(t *testing.T) {
+	t.Parallel()
+
+	const (
+		metricName = "test_metric"
+		minT       = int64(10)
+		maxT       = int64(20)
+	)
+
+	block1 := ulid.MustNew(1, nil)
+	block2 := ulid.MustNew(2, nil)
+	seriesLabels := labels.FromStrings(labels.MetricName, metricName)
+
+	tests := map[string]struct {
+		disableDedup   bool
+		expectedSeries int
+	}{
+		"dedup enabled (default)": {
+			disableDedup:   false,
+			expectedSeries: 1,
+		},
+		"dedup disabled": {
+			disableDedup:   true,
+			expectedSeries: 2,
+		},
+	}
+
+	for testName, testData := range tests {
+		t.Run(testName, func(t *testing.T) {
+			t.Parallel()
+
+			// Both store-gateways return the same series, as it happens when the blocks
+			// have been replicated.
+			stores := &blocksStoreSetMock{mockedResponses: []any{
+				map[BlocksStoreClient][]ulid.ULID{
+					&storeGatewayClientMock{remoteAddr: "1.1.1.1", mockedSeriesResponses: []*storepb.SeriesResponse{
+						mockSeriesResponse(seriesLabels, []cortexpb.Sample{{Value: 1, TimestampMs: minT}}, nil, nil),
+						mockHintsResponse(block1),
+					}}: {block1},
+					&storeGatewayClientMock{remoteAddr: "2.2.2.2", mockedSeriesResponses: []*storepb.SeriesResponse{
+						mockSeriesResponse(seriesLabels, []cortexpb.Sample{{Value: 1, TimestampMs: minT}}, nil, nil),
+						mockHintsResponse(block2),
+					}}: {block2},
+				},
+			}}
+			finder := &blocksFinderMock{}
+			finder.On("GetBlocks", mock.Anything, "user-1", minT, maxT, mock.Anything).Return(bucketindex.Blocks{
+				&bucketindex.Block{ID: block1},
+				&bucketindex.Block{ID: block2},
+			}, map[ulid.ULID]*bucketindex.BlockDeletionMark(nil), nil)
+
+			q := &blocksStoreQuerier{
+				minT:        minT,
+				maxT:        maxT,
+				finder:      finder,
+				stores:      stores,
+				consistency: NewBlocksConsistencyChecker(0, 0, log.NewNopLogger(), nil),
+				logger:      log.NewNopLogger(),
+				metrics:     newBlocksStoreQueryableMetrics(prometheus.NewPedanticRegistry()),
+				limits:      &blocksStoreLimitsMock{},
+
+				storeGatewayConsistencyCheckMaxAttempts: 3,
+			}
+
+			ctx := user.InjectOrgID(context.Background(), "user-1")
+			ctx = limiter.AddQueryLimiterToContext(ctx, limiter.NewQueryLimiter(0, 0, 0, 0))
+			if testData.disableDedup {
+				ctx = InjectDisableDedup(ctx, true)
+			}
+
+			set := q.Select(ctx, true, nil, labels.MustNewMatcher(labels.MatchEqual, labels.MetricName, metricName))
+
+			var actualSeries []labels.Labels
+			for set.Next() {
+				actualSeries = append(actualSeries, set.At().Labels())
+			}
+			require.NoError(t, set.Err())
+			require.Len(t, actualSeries, testData.expectedSeries)
+			for _, lbls := range actualSeries {
+				assert.Equal(t, seriesLabels, lbls)
+			}
+		})
+	}
+}
+
+func TestConcatSeriesSets(t *testing.T) {
+	t.Parallel()
+
+	// Each set returns the same series, whose single sample is the index of the set.
+	var sets []storage.SeriesSet
+	for i := 0; i < 10; i++ {
+		var setSeries []storage.Series
+		for _, name := range []string{"c", "a", "b"} {
+			setSeries = append(setSeries, series.NewConcreteSeries(labels.FromStrings(labels.MetricName, name), []model.SamplePair{{Timestamp: 1, Value: model.SampleValue(i)}}))
+		}
+		sets = append(sets, series.NewConcreteSeriesSet(false, setSeries))
+	}
+
+	set := concatSeriesSets(sets, 0)
+
+	// The series are sorted by labels and, for the same labels, in the order of the sets.
+	var actual []string
+	for set.Next() {
+		it := set.At().Iterator(nil)
+		require.Equal(t, chunkenc.ValFloat, it.Next())
+		_, v := it.At()
+		actual = append(actual, fmt.Sprintf("%s/%d", set.At().Labels().Get(labels.MetricName), int(v)))
+	}
+	require.NoError(t, set.Err())
+
+	var expected []string
+	for _, name := range []string{"a", "b", "c"} {
+		for i := 0; i < 10; i++ {
+			expected = append(expected, fmt.Sprintf("%s/%d", name, i))
+		}
+	}
+	assert.Equal(t, expected, actual)
+}
+
+func TestBlocksStoreQuerier_SelectShouldReturnPartialResponse(t *testing.T) {
+	t.Parallel()
+
+	const (
+		metricName = "test_metric"
+		minT       = int64(10)
+		maxT       = int64(20)
+	)
+
+	block1 := ulid.MustNew(1, nil)
+	block2 := ulid.MustNew(2, nil)
+	series1 := labels.FromStrings(labels.MetricName, metricName, "series", "1")
+	series2 := labels.FromStrings(labels.MetricName, metricName, "series", "2")
+	failure := status.Error(codes.Internal, "store-gateway failure")
+
+	tests := map[string]struct {
+		partialResponse  bool
+		store2Err        error
+		store1Err        error
+		expectedSeries   []labels.Labels
+		expectedWarnings int
+		expectedErr      string
+	}{
+		"should return all the series if no store-gateway fails": {
+			partialResponse: true,
+			expectedSeries:  []labels.Labels{series1, series2},
+		},
+		"should fail the query if disabled": {
+			store2Err:   failure,
+			expectedErr: "store-gateway failure",
+		},
+		"should return the series of the other store-gateways with a warning if enabled": {
+			partialResponse:  true,
+			store2Err:        failure,
+			expectedSeries:   []labels.Labels{series1},
+			expectedWarnings: 1,
+		},
+		"should fail the query if all the store-gateways fail": {
+			partialResponse: true,
+			store1Err:       failure,
+			store2Err:       failure,
+			expectedErr:     "store-gateway failure",
+		},
+		"should fail the query on limit errors": {
+			partialResponse: true,
+			store2Err:       status.Error(codes.ResourceExhausted, "limit exceeded"),
+			expectedErr:     "limit exceeded",
+		},
+	}
+
+	for testName, testData := range tests {
+		t.Run(testName, func(t *testing.T) {
+			t.Parallel()
+
+			stores := &blocksStoreSetMock{mockedResponses: []any{
+				map[BlocksStoreClient][]ulid.ULID{
+					&storeGatewayClientMock{remoteAddr: "1.1.1.1", mockedSeriesStreamErr: testData.store1Err, mockedSeriesResponses: []*storepb.SeriesResponse{
+						mockSeriesResponse(series1, []cortexpb.Sample{{Value: 1, TimestampMs: minT}}, nil, nil),
+						mockHintsResponse(block1),
+					}}: {block1},
+					&storeGatewayClientMock{remoteAddr: "2.2.2.2", mockedSeriesStreamErr: testData.store2Err, mockedSeriesResponses: []*storepb.SeriesResponse{
+						mockSeriesResponse(series2, []cortexpb.Sample{{Value: 2, TimestampMs: minT}}, nil, nil),
+						mockHintsResponse(block2),
+					}}: {block2},
+				},
+			}}
+			finder := &blocksFinderMock{}
+			finder.On("GetBlocks", mock.Anything, "user-1", minT, maxT, mock.Anything).Return(bucketindex.Blocks{
+				&bucketindex.Block{ID: block1},
+				&bucketindex.Block{ID: block2},
+			}, map[ulid.ULID]*bucketindex.BlockDeletionMark(nil), nil)
+
+			q := &blocksStoreQuerier{
+				minT:        minT,
+				maxT:        maxT,
+				finder:      finder,
+				stores:      stores,
+				consistency: NewBlocksConsistencyChecker(0, 0, log.NewNopLogger(), nil),
+				logger:      log.NewNopLogger(),
+				metrics:     newBlocksStoreQueryableMetrics(prometheus.NewPedanticRegistry()),
+				limits:      &blocksStoreLimitsMock{storeGatewayPartialResponse: testData.partialResponse},
+
+				storeGatewayConsistencyCheckMaxAttempts: 1,
+			}
+
+			ctx := user.InjectOrgID(context.Background(), "user-1")
+			ctx = limiter.AddQueryLimiterToContext(ctx, limiter.NewQueryLimiter(0, 0, 0, 0))
+			set := q.Select(ctx, true, nil, labels.MustNewMatcher(labels.MatchEqual, labels.MetricName, metricName))
+
+			var actualSeries []labels.Labels
+			for set.Next() {
+				actualSeries = append(actualSeries, set.At().Labels())
+			}
+			if testData.expectedErr != "" {
+				require.ErrorContains(t, set.Err(), testData.expectedErr)
+				return
+			}
+			require.NoError(t, set.Err())
+			assert.Equal(t, testData.expectedSeries, actualSeries)
+			assert.Len(t, set.Warnings(), testData.expectedWarnings)
+		})
+	}
+}
+
 func TestBlocksStoreQuerier_Labels(t *testing.T) {
 	t.Parallel()
 
@@ -2345,7 +2601,8 @@ func TestBlocksStoreQuerier_Labels(t *testing.T) {
 
 					// Assert on metrics (optional, only for test cases defining it).
 					if testData.expectedMetrics != "" {
-						assert.NoError(t, testutil.GatherAndCompare(reg, strings.NewReader(testData.expectedMetrics)))
+						assert.NoError(t, testutil.GatherAndCompare(reg, strings.NewReader(testData.expectedMetrics),
+							"cortex_querier_storegateway_instances_hit_per_query", "cortex_querier_storegateway_refetches_per_query"))
 					}
 				}
 
@@ -2366,7 +2623,8 @@ func TestBlocksStoreQuerier_Labels(t *testing.T) {
 
 					// Assert on metrics (optional, only for test cases defining it).
 					if testData.expectedMetrics != "" {
-						assert.NoError(t, testutil.GatherAndCompare(reg, strings.NewReader(testData.expectedMetrics)))
+						assert.NoError(t, testutil.GatherAndCompare(reg, strings.NewReader(testData.expectedMetrics),
+							"cortex_querier_storegateway_instances_hit_per_query", "cortex_querier_storegateway_refetches_per_query"))
 					}
 				}
 			}
@@ -2718,6 +2976,7 @@ func (m *storeGatewaySeriesClientMock) Recv() (*storepb.SeriesResponse, error) {
 type blocksStoreLimitsMock struct {
 	maxChunksPerQuery           int
 	storeGatewayTenantShardSize float64
+	storeGatewayPartialResponse bool
 }
 
 func (m *blocksStoreLimitsMock) MaxChunksPerQueryFromStore(_ string) int {
@@ -2728,6 +2987,10 @@ func (m *blocksStoreLimitsMock) StoreGatewayTenantShardSize(_ string) float64 {
 	return m.storeGatewayTenantShardSize
 }
 
+func (m *blocksStoreLimitsMock) StoreGatewayPartialResponse(_ string) bool {
+	return m.storeGatewayPartialResponse
+}
+
 func (m *blocksStoreLimitsMock) S3SSEType(_ string) string {
 	return ""
 }
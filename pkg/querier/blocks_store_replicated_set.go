@@ -6,8 +6,10 @@ import (
 	"math"
 	"math/rand"
 	"slices"
+	"time"
 
 	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
 	"github.com/oklog/ulid/v2"
 	"github.com/pkg/errors"
 	"github.com/prometheus/client_golang/prometheus"
@@ -20,6 +22,10 @@ import (
 	"github.com/cortexproject/cortex/pkg/util/services"
 )
 
+// leavingInstancesCheckInterval is how frequently the ring is checked for store-gateways
+// LEAVING it, in order to close their connections.
+const leavingInstancesCheckInterval = 5 * time.Second
+
 type loadBalancingStrategy int
 
 const (
@@ -33,7 +39,7 @@ type blocksStoreReplicationSet struct {
 	services.Service
 
 	storesRing        *ring.Ring
-	clientsPool       *client.Pool
+	clientsPool       *storeGatewayClientPool
 	shardingStrategy  string
 	balancingStrategy loadBalancingStrategy
 	limits            BlocksStoreLimits
@@ -41,6 +47,8 @@ type blocksStoreReplicationSet struct {
 	zoneAwarenessEnabled      bool
 	zoneStableShuffleSharding bool
 
+	logger log.Logger
+
 	// Subservices manager.
 	subservices        *services.Manager
 	subservicesWatcher *services.FailureWatcher
@@ -66,6 +74,8 @@ func newBlocksStoreReplicationSet(
 
 		zoneAwarenessEnabled:      zoneAwarenessEnabled,
 		zoneStableShuffleSharding: zoneStableShuffleSharding,
+
+		logger: logger,
 	}
 
 	var err error
@@ -90,22 +100,55 @@ func (s *blocksStoreReplicationSet) starting(ctx context.Context) error {
 }
 
 func (s *blocksStoreReplicationSet) running(ctx context.Context) error {
+	ticker := time.NewTicker(leavingInstancesCheckInterval)
+	defer ticker.Stop()
+
 	for {
 		select {
 		case <-ctx.Done():
 			return nil
+		case <-ticker.C:
+			s.evictLeavingClients()
 		case err := <-s.subservicesWatcher.Chan():
 			return errors.Wrap(err, "blocks store set subservice failed")
 		}
 	}
 }
 
+// evictLeavingClients closes the connections to the store-gateways which are LEAVING the ring,
+// once their in-flight requests have completed.
+// Blocks are only queried from ACTIVE store-gateways, so there's no reason to wait until the
+// pool detects them as stale or failing the health check.
+func (s *blocksStoreReplicationSet) evictLeavingClients() {
+	healthy, unhealthy, err := s.storesRing.GetAllInstanceDescs(ring.Reporting)
+	if err != nil {
+		return
+	}
+
+	registered := s.clientsPool.RegisteredAddresses()
+	for _, instance := range append(healthy, unhealthy...) {
+		if instance.State != ring.LEAVING || !slices.Contains(registered, instance.Addr) {
+			continue
+		}
+
+		level.Info(s.logger).Log("msg", "closing connection to store-gateway leaving the ring", "addr", instance.Addr)
+		s.clientsPool.RemoveClientGracefullyFor(instance.Addr)
+	}
+}
+
 func (s *blocksStoreReplicationSet) stopping(_ error) error {
 	return services.StopManagerAndAwaitStopped(context.Background(), s.subservices)
 }
 
+func (s *blocksStoreReplicationSet) clientsHealth() []StoreGatewayClientHealth {
+	return s.clientsPool.clientsHealth()
+}
+
 func (s *blocksStoreReplicationSet) GetClientsFor(userID string, blockIDs []ulid.ULID, exclude map[ulid.ULID][]string, attemptedBlocksZones map[ulid.ULID]map[string]int) (map[BlocksStoreClient][]ulid.ULID, error) {
 	shards := map[string][]ulid.ULID{}
+	// The requests to a store-gateway are only hedged to the other store-gateways owning all
+	// its blocks, and not excluded for any of them.
+	hedgeTargets := map[string][]string{}
 
 	// If shuffle sharding is enabled, we should build a subring for the user,
 	// otherwise we just use the full ring.
@@ -134,6 +177,20 @@ func (s *blocksStoreReplicationSet) GetClientsFor(userID string, blockIDs []ulid
 			return nil, fmt.Errorf("no store-gateway instance left after checking exclude for block %s", blockID.String())
 		}
 
+		replicas := make([]string, 0, len(set.Instances))
+		for _, replica := range set.Instances {
+			if replica.Addr != instance.Addr && !slices.Contains(exclude[blockID], replica.Addr) {
+				replicas = append(replicas, replica.Addr)
+			}
+		}
+		if _, ok := shards[instance.Addr]; ok {
+			hedgeTargets[instance.Addr] = slices.DeleteFunc(hedgeTargets[instance.Addr], func(addr string) bool {
+				return !slices.Contains(replicas, addr)
+			})
+		} else {
+			hedgeTargets[instance.Addr] = replicas
+		}
+
 		shards[instance.Addr] = append(shards[instance.Addr], blockID)
 		if s.zoneAwarenessEnabled {
 			if _, ok := attemptedBlocksZones[blockID]; !ok {
@@ -147,7 +204,7 @@ func (s *blocksStoreReplicationSet) GetClientsFor(userID string, blockIDs []ulid
 
 	// Get the client for each store-gateway.
 	for addr, blockIDs := range shards {
-		c, err := s.clientsPool.GetClientFor(addr)
+		c, err := s.clientsPool.getHedgedClientFor(addr, hedgeTargets[addr])
 		if err != nil {
 			return nil, errors.Wrapf(err, "failed to get store-gateway client for %s", addr)
 		}
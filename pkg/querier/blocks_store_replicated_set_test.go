@@ -2,7 +2,9 @@ package querier
 
 import (
 	"context"
+	"flag"
 	"fmt"
+	"net"
 	"strconv"
 	"strings"
 	"testing"
@@ -14,6 +16,9 @@ import (
 	"github.com/prometheus/client_golang/prometheus/testutil"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	"github.com/thanos-io/thanos/pkg/store/storepb"
+	"github.com/weaveworks/common/user"
+	"google.golang.org/grpc/health/grpc_health_v1"
 
 	"github.com/cortexproject/cortex/pkg/ring"
 	"github.com/cortexproject/cortex/pkg/ring/kv/consul"
@@ -82,22 +87,22 @@ func TestBlocksStoreReplicationSet_GetClientsFor(t *testing.T) {
 			shardingStrategy:  util.ShardingStrategyDefault,
 			replicationFactor: 1,
 			setup: func(d *ring.Desc) {
-				d.AddIngester("instance-1", "127.0.0.1", "", []uint32{block1Hash + 1}, ring.ACTIVE, registeredAt)
+				d.AddIngester("instance-1", "127.0.0.1:9095", "", []uint32{block1Hash + 1}, ring.ACTIVE, registeredAt)
 			},
 			queryBlocks: []ulid.ULID{block1, block2},
 			expectedClients: map[string][]ulid.ULID{
-				"127.0.0.1": {block1, block2},
+				"127.0.0.1:9095": {block1, block2},
 			},
 		},
 		"default sharding, single instance in the ring with RF = 1 but excluded": {
 			shardingStrategy:  util.ShardingStrategyDefault,
 			replicationFactor: 1,
 			setup: func(d *ring.Desc) {
-				d.AddIngester("instance-1", "127.0.0.1", "", []uint32{block1Hash + 1}, ring.ACTIVE, registeredAt)
+				d.AddIngester("instance-1", "127.0.0.1:9095", "", []uint32{block1Hash + 1}, ring.ACTIVE, registeredAt)
 			},
 			queryBlocks: []ulid.ULID{block1, block2},
 			exclude: map[ulid.ULID][]string{
-				block1: {"127.0.0.1"},
+				block1: {"127.0.0.1:9095"},
 			},
 			expectedErr: fmt.Errorf("no store-gateway instance left after checking exclude for block %s", block1.String()),
 		},
@@ -105,55 +110,55 @@ func TestBlocksStoreReplicationSet_GetClientsFor(t *testing.T) {
 			shardingStrategy:  util.ShardingStrategyDefault,
 			replicationFactor: 1,
 			setup: func(d *ring.Desc) {
-				d.AddIngester("instance-1", "127.0.0.1", "", []uint32{block1Hash + 1}, ring.ACTIVE, registeredAt)
+				d.AddIngester("instance-1", "127.0.0.1:9095", "", []uint32{block1Hash + 1}, ring.ACTIVE, registeredAt)
 			},
 			queryBlocks: []ulid.ULID{block1, block2},
 			exclude: map[ulid.ULID][]string{
-				block3: {"127.0.0.1"},
+				block3: {"127.0.0.1:9095"},
 			},
 			expectedClients: map[string][]ulid.ULID{
-				"127.0.0.1": {block1, block2},
+				"127.0.0.1:9095": {block1, block2},
 			},
 		},
 		"default sharding, single instance in the ring with RF = 2": {
 			shardingStrategy:  util.ShardingStrategyDefault,
 			replicationFactor: 2,
 			setup: func(d *ring.Desc) {
-				d.AddIngester("instance-1", "127.0.0.1", "", []uint32{block1Hash + 1}, ring.ACTIVE, registeredAt)
+				d.AddIngester("instance-1", "127.0.0.1:9095", "", []uint32{block1Hash + 1}, ring.ACTIVE, registeredAt)
 			},
 			queryBlocks: []ulid.ULID{block1, block2},
 			expectedClients: map[string][]ulid.ULID{
-				"127.0.0.1": {block1, block2},
+				"127.0.0.1:9095": {block1, block2},
 			},
 		},
 		"default sharding, multiple instances in the ring with each requested block belonging to a different store-gateway and RF = 1": {
 			shardingStrategy:  util.ShardingStrategyDefault,
 			replicationFactor: 1,
 			setup: func(d *ring.Desc) {
-				d.AddIngester("instance-1", "127.0.0.1", "", []uint32{block1Hash + 1}, ring.ACTIVE, registeredAt)
-				d.AddIngester("instance-2", "127.0.0.2", "", []uint32{block2Hash + 1}, ring.ACTIVE, registeredAt)
-				d.AddIngester("instance-3", "127.0.0.3", "", []uint32{block3Hash + 1}, ring.ACTIVE, registeredAt)
-				d.AddIngester("instance-4", "127.0.0.4", "", []uint32{block4Hash + 1}, ring.ACTIVE, registeredAt)
+				d.AddIngester("instance-1", "127.0.0.1:9095", "", []uint32{block1Hash + 1}, ring.ACTIVE, registeredAt)
+				d.AddIngester("instance-2", "127.0.0.2:9095", "", []uint32{block2Hash + 1}, ring.ACTIVE, registeredAt)
+				d.AddIngester("instance-3", "127.0.0.3:9095", "", []uint32{block3Hash + 1}, ring.ACTIVE, registeredAt)
+				d.AddIngester("instance-4", "127.0.0.4:9095", "", []uint32{block4Hash + 1}, ring.ACTIVE, registeredAt)
 			},
 			queryBlocks: []ulid.ULID{block1, block3, block4},
 			expectedClients: map[string][]ulid.ULID{
-				"127.0.0.1": {block1},
-				"127.0.0.3": {block3},
-				"127.0.0.4": {block4},
+				"127.0.0.1:9095": {block1},
+				"127.0.0.3:9095": {block3},
+				"127.0.0.4:9095": {block4},
 			},
 		},
 		"default sharding, multiple instances in the ring with each requested block belonging to a different store-gateway and RF = 1 but excluded": {
 			shardingStrategy:  util.ShardingStrategyDefault,
 			replicationFactor: 1,
 			setup: func(d *ring.Desc) {
-				d.AddIngester("instance-1", "127.0.0.1", "", []uint32{block1Hash + 1}, ring.ACTIVE, registeredAt)
-				d.AddIngester("instance-2", "127.0.0.2", "", []uint32{block2Hash + 1}, ring.ACTIVE, registeredAt)
-				d.AddIngester("instance-3", "127.0.0.3", "", []uint32{block3Hash + 1}, ring.ACTIVE, registeredAt)
-				d.AddIngester("instance-4", "127.0.0.4", "", []uint32{block4Hash + 1}, ring.ACTIVE, registeredAt)
+				d.AddIngester("instance-1", "127.0.0.1:9095", "", []uint32{block1Hash + 1}, ring.ACTIVE, registeredAt)
+				d.AddIngester("instance-2", "127.0.0.2:9095", "", []uint32{block2Hash + 1}, ring.ACTIVE, registeredAt)
+				d.AddIngester("instance-3", "127.0.0.3:9095", "", []uint32{block3Hash + 1}, ring.ACTIVE, registeredAt)
+				d.AddIngester("instance-4", "127.0.0.4:9095", "", []uint32{block4Hash + 1}, ring.ACTIVE, registeredAt)
 			},
 			queryBlocks: []ulid.ULID{block1, block3, block4},
 			exclude: map[ulid.ULID][]string{
-				block3: {"127.0.0.3"},
+				block3: {"127.0.0.3:9095"},
 			},
 			expectedErr: fmt.Errorf("no store-gateway instance left after checking exclude for block %s", block3.String()),
 		},
@@ -161,62 +166,62 @@ func TestBlocksStoreReplicationSet_GetClientsFor(t *testing.T) {
 			shardingStrategy:  util.ShardingStrategyDefault,
 			replicationFactor: 2,
 			setup: func(d *ring.Desc) {
-				d.AddIngester("instance-1", "127.0.0.1", "", []uint32{block1Hash + 1}, ring.ACTIVE, registeredAt)
-				d.AddIngester("instance-2", "127.0.0.2", "", []uint32{block2Hash + 1}, ring.ACTIVE, registeredAt)
-				d.AddIngester("instance-3", "127.0.0.3", "", []uint32{block3Hash + 1}, ring.ACTIVE, registeredAt)
-				d.AddIngester("instance-4", "127.0.0.4", "", []uint32{block4Hash + 1}, ring.ACTIVE, registeredAt)
+				d.AddIngester("instance-1", "127.0.0.1:9095", "", []uint32{block1Hash + 1}, ring.ACTIVE, registeredAt)
+				d.AddIngester("instance-2", "127.0.0.2:9095", "", []uint32{block2Hash + 1}, ring.ACTIVE, registeredAt)
+				d.AddIngester("instance-3", "127.0.0.3:9095", "", []uint32{block3Hash + 1}, ring.ACTIVE, registeredAt)
+				d.AddIngester("instance-4", "127.0.0.4:9095", "", []uint32{block4Hash + 1}, ring.ACTIVE, registeredAt)
 			},
 			queryBlocks: []ulid.ULID{block1, block3, block4},
 			expectedClients: map[string][]ulid.ULID{
-				"127.0.0.1": {block1},
-				"127.0.0.3": {block3},
-				"127.0.0.4": {block4},
+				"127.0.0.1:9095": {block1},
+				"127.0.0.3:9095": {block3},
+				"127.0.0.4:9095": {block4},
 			},
 		},
 		"default sharding, multiple instances in the ring with multiple requested blocks belonging to the same store-gateway and RF = 2": {
 			shardingStrategy:  util.ShardingStrategyDefault,
 			replicationFactor: 2,
 			setup: func(d *ring.Desc) {
-				d.AddIngester("instance-1", "127.0.0.1", "", []uint32{block1Hash + 1}, ring.ACTIVE, registeredAt)
-				d.AddIngester("instance-2", "127.0.0.2", "", []uint32{block3Hash + 1}, ring.ACTIVE, registeredAt)
+				d.AddIngester("instance-1", "127.0.0.1:9095", "", []uint32{block1Hash + 1}, ring.ACTIVE, registeredAt)
+				d.AddIngester("instance-2", "127.0.0.2:9095", "", []uint32{block3Hash + 1}, ring.ACTIVE, registeredAt)
 			},
 			queryBlocks: []ulid.ULID{block1, block2, block3, block4},
 			expectedClients: map[string][]ulid.ULID{
-				"127.0.0.1": {block1, block4},
-				"127.0.0.2": {block2, block3},
+				"127.0.0.1:9095": {block1, block4},
+				"127.0.0.2:9095": {block2, block3},
 			},
 		},
 		"default sharding, multiple instances in the ring with each requested block belonging to a different store-gateway and RF = 2 and some blocks excluded but with replacement available": {
 			shardingStrategy:  util.ShardingStrategyDefault,
 			replicationFactor: 2,
 			setup: func(d *ring.Desc) {
-				d.AddIngester("instance-1", "127.0.0.1", "", []uint32{block1Hash + 1}, ring.ACTIVE, registeredAt)
-				d.AddIngester("instance-2", "127.0.0.2", "", []uint32{block2Hash + 1}, ring.ACTIVE, registeredAt)
-				d.AddIngester("instance-3", "127.0.0.3", "", []uint32{block3Hash + 1}, ring.ACTIVE, registeredAt)
-				d.AddIngester("instance-4", "127.0.0.4", "", []uint32{block4Hash + 1}, ring.ACTIVE, registeredAt)
+				d.AddIngester("instance-1", "127.0.0.1:9095", "", []uint32{block1Hash + 1}, ring.ACTIVE, registeredAt)
+				d.AddIngester("instance-2", "127.0.0.2:9095", "", []uint32{block2Hash + 1}, ring.ACTIVE, registeredAt)
+				d.AddIngester("instance-3", "127.0.0.3:9095", "", []uint32{block3Hash + 1}, ring.ACTIVE, registeredAt)
+				d.AddIngester("instance-4", "127.0.0.4:9095", "", []uint32{block4Hash + 1}, ring.ACTIVE, registeredAt)
 			},
 			queryBlocks: []ulid.ULID{block1, block3, block4},
 			exclude: map[ulid.ULID][]string{
-				block3: {"127.0.0.3"},
-				block1: {"127.0.0.1"},
+				block3: {"127.0.0.3:9095"},
+				block1: {"127.0.0.1:9095"},
 			},
 			expectedClients: map[string][]ulid.ULID{
-				"127.0.0.2": {block1},
-				"127.0.0.4": {block3, block4},
+				"127.0.0.2:9095": {block1},
+				"127.0.0.4:9095": {block3, block4},
 			},
 		},
 		"default sharding, multiple instances in the ring are JOINING, the requested block + its replicas only belongs to JOINING instances": {
 			shardingStrategy:  util.ShardingStrategyDefault,
 			replicationFactor: 2,
 			setup: func(d *ring.Desc) {
-				d.AddIngester("instance-1", "127.0.0.1", "", []uint32{block1Hash + 1}, ring.JOINING, registeredAt)
-				d.AddIngester("instance-2", "127.0.0.2", "", []uint32{block2Hash + 1}, ring.JOINING, registeredAt)
-				d.AddIngester("instance-3", "127.0.0.3", "", []uint32{block3Hash + 1}, ring.JOINING, registeredAt)
-				d.AddIngester("instance-4", "127.0.0.4", "", []uint32{block4Hash + 1}, ring.ACTIVE, registeredAt)
+				d.AddIngester("instance-1", "127.0.0.1:9095", "", []uint32{block1Hash + 1}, ring.JOINING, registeredAt)
+				d.AddIngester("instance-2", "127.0.0.2:9095", "", []uint32{block2Hash + 1}, ring.JOINING, registeredAt)
+				d.AddIngester("instance-3", "127.0.0.3:9095", "", []uint32{block3Hash + 1}, ring.JOINING, registeredAt)
+				d.AddIngester("instance-4", "127.0.0.4:9095", "", []uint32{block4Hash + 1}, ring.ACTIVE, registeredAt)
 			},
 			queryBlocks: []ulid.ULID{block1},
 			expectedClients: map[string][]ulid.ULID{
-				"127.0.0.4": {block1},
+				"127.0.0.4:9095": {block1},
 			},
 		},
 		//
@@ -227,11 +232,11 @@ func TestBlocksStoreReplicationSet_GetClientsFor(t *testing.T) {
 			tenantShardSize:   1,
 			replicationFactor: 1,
 			setup: func(d *ring.Desc) {
-				d.AddIngester("instance-1", "127.0.0.1", "", []uint32{block1Hash + 1}, ring.ACTIVE, registeredAt)
+				d.AddIngester("instance-1", "127.0.0.1:9095", "", []uint32{block1Hash + 1}, ring.ACTIVE, registeredAt)
 			},
 			queryBlocks: []ulid.ULID{block1, block2},
 			expectedClients: map[string][]ulid.ULID{
-				"127.0.0.1": {block1, block2},
+				"127.0.0.1:9095": {block1, block2},
 			},
 		},
 		"shuffle sharding, single instance in the ring with RF = 1, SS = 1 but excluded": {
@@ -239,11 +244,11 @@ func TestBlocksStoreReplicationSet_GetClientsFor(t *testing.T) {
 			tenantShardSize:   1,
 			replicationFactor: 1,
 			setup: func(d *ring.Desc) {
-				d.AddIngester("instance-1", "127.0.0.1", "", []uint32{block1Hash + 1}, ring.ACTIVE, registeredAt)
+				d.AddIngester("instance-1", "127.0.0.1:9095", "", []uint32{block1Hash + 1}, ring.ACTIVE, registeredAt)
 			},
 			queryBlocks: []ulid.ULID{block1, block2},
 			exclude: map[ulid.ULID][]string{
-				block1: {"127.0.0.1"},
+				block1: {"127.0.0.1:9095"},
 			},
 			expectedErr: fmt.Errorf("no store-gateway instance left after checking exclude for block %s", block1.String()),
 		},
@@ -252,11 +257,11 @@ func TestBlocksStoreReplicationSet_GetClientsFor(t *testing.T) {
 			tenantShardSize:   2,
 			replicationFactor: 2,
 			setup: func(d *ring.Desc) {
-				d.AddIngester("instance-1", "127.0.0.1", "", []uint32{block1Hash + 1}, ring.ACTIVE, registeredAt)
+				d.AddIngester("instance-1", "127.0.0.1:9095", "", []uint32{block1Hash + 1}, ring.ACTIVE, registeredAt)
 			},
 			queryBlocks: []ulid.ULID{block1, block2},
 			expectedClients: map[string][]ulid.ULID{
-				"127.0.0.1": {block1, block2},
+				"127.0.0.1:9095": {block1, block2},
 			},
 		},
 		"shuffle sharding, multiple instances in the ring with RF = 1, SS = 1": {
@@ -264,14 +269,14 @@ func TestBlocksStoreReplicationSet_GetClientsFor(t *testing.T) {
 			tenantShardSize:   1,
 			replicationFactor: 1,
 			setup: func(d *ring.Desc) {
-				d.AddIngester("instance-1", "127.0.0.1", "", []uint32{block1Hash + 1}, ring.ACTIVE, registeredAt)
-				d.AddIngester("instance-2", "127.0.0.2", "", []uint32{block2Hash + 1}, ring.ACTIVE, registeredAt)
-				d.AddIngester("instance-3", "127.0.0.3", "", []uint32{block3Hash + 1}, ring.ACTIVE, registeredAt)
-				d.AddIngester("instance-4", "127.0.0.4", "", []uint32{block4Hash + 1}, ring.ACTIVE, registeredAt)
+				d.AddIngester("instance-1", "127.0.0.1:9095", "", []uint32{block1Hash + 1}, ring.ACTIVE, registeredAt)
+				d.AddIngester("instance-2", "127.0.0.2:9095", "", []uint32{block2Hash + 1}, ring.ACTIVE, registeredAt)
+				d.AddIngester("instance-3", "127.0.0.3:9095", "", []uint32{block3Hash + 1}, ring.ACTIVE, registeredAt)
+				d.AddIngester("instance-4", "127.0.0.4:9095", "", []uint32{block4Hash + 1}, ring.ACTIVE, registeredAt)
 			},
 			queryBlocks: []ulid.ULID{block1, block2, block4},
 			expectedClients: map[string][]ulid.ULID{
-				"127.0.0.1": {block1, block2, block4},
+				"127.0.0.1:9095": {block1, block2, block4},
 			},
 		},
 		"shuffle sharding, multiple instances in the ring with RF = 1, SS = 2": {
@@ -279,15 +284,15 @@ func TestBlocksStoreReplicationSet_GetClientsFor(t *testing.T) {
 			tenantShardSize:   2,
 			replicationFactor: 1,
 			setup: func(d *ring.Desc) {
-				d.AddIngester("instance-1", "127.0.0.1", "", []uint32{block1Hash + 1}, ring.ACTIVE, registeredAt)
-				d.AddIngester("instance-2", "127.0.0.2", "", []uint32{block2Hash + 1}, ring.ACTIVE, registeredAt)
-				d.AddIngester("instance-3", "127.0.0.3", "", []uint32{block3Hash + 1}, ring.ACTIVE, registeredAt)
-				d.AddIngester("instance-4", "127.0.0.4", "", []uint32{block4Hash + 1}, ring.ACTIVE, registeredAt)
+				d.AddIngester("instance-1", "127.0.0.1:9095", "", []uint32{block1Hash + 1}, ring.ACTIVE, registeredAt)
+				d.AddIngester("instance-2", "127.0.0.2:9095", "", []uint32{block2Hash + 1}, ring.ACTIVE, registeredAt)
+				d.AddIngester("instance-3", "127.0.0.3:9095", "", []uint32{block3Hash + 1}, ring.ACTIVE, registeredAt)
+				d.AddIngester("instance-4", "127.0.0.4:9095", "", []uint32{block4Hash + 1}, ring.ACTIVE, registeredAt)
 			},
 			queryBlocks: []ulid.ULID{block1, block2, block4},
 			expectedClients: map[string][]ulid.ULID{
-				"127.0.0.1": {block1, block4},
-				"127.0.0.2": {block2},
+				"127.0.0.1:9095": {block1, block4},
+				"127.0.0.2:9095": {block2},
 			},
 		},
 		"shuffle sharding, multiple instances in the ring with RF = 1, SS = 4": {
@@ -295,16 +300,16 @@ func TestBlocksStoreReplicationSet_GetClientsFor(t *testing.T) {
 			tenantShardSize:   4,
 			replicationFactor: 1,
 			setup: func(d *ring.Desc) {
-				d.AddIngester("instance-1", "127.0.0.1", "", []uint32{block1Hash + 1}, ring.ACTIVE, registeredAt)
-				d.AddIngester("instance-2", "127.0.0.2", "", []uint32{block2Hash + 1}, ring.ACTIVE, registeredAt)
-				d.AddIngester("instance-3", "127.0.0.3", "", []uint32{block3Hash + 1}, ring.ACTIVE, registeredAt)
-				d.AddIngester("instance-4", "127.0.0.4", "", []uint32{block4Hash + 1}, ring.ACTIVE, registeredAt)
+				d.AddIngester("instance-1", "127.0.0.1:9095", "", []uint32{block1Hash + 1}, ring.ACTIVE, registeredAt)
+				d.AddIngester("instance-2", "127.0.0.2:9095", "", []uint32{block2Hash + 1}, ring.ACTIVE, registeredAt)
+				d.AddIngester("instance-3", "127.0.0.3:9095", "", []uint32{block3Hash + 1}, ring.ACTIVE, registeredAt)
+				d.AddIngester("instance-4", "127.0.0.4:9095", "", []uint32{block4Hash + 1}, ring.ACTIVE, registeredAt)
 			},
 			queryBlocks: []ulid.ULID{block1, block2, block4},
 			expectedClients: map[string][]ulid.ULID{
-				"127.0.0.1": {block1},
-				"127.0.0.2": {block2},
-				"127.0.0.4": {block4},
+				"127.0.0.1:9095": {block1},
+				"127.0.0.2:9095": {block2},
+				"127.0.0.4:9095": {block4},
 			},
 		},
 		"shuffle sharding, multiple instances in the ring with RF = 2, SS = 2 with excluded blocks but some replacement available": {
@@ -312,18 +317,18 @@ func TestBlocksStoreReplicationSet_GetClientsFor(t *testing.T) {
 			tenantShardSize:   2,
 			replicationFactor: 2,
 			setup: func(d *ring.Desc) {
-				d.AddIngester("instance-1", "127.0.0.1", "", []uint32{block1Hash + 1}, ring.ACTIVE, registeredAt)
-				d.AddIngester("instance-2", "127.0.0.2", "", []uint32{block2Hash + 1}, ring.ACTIVE, registeredAt)
-				d.AddIngester("instance-3", "127.0.0.3", "", []uint32{block3Hash + 1}, ring.ACTIVE, registeredAt)
-				d.AddIngester("instance-4", "127.0.0.4", "", []uint32{block4Hash + 1}, ring.ACTIVE, registeredAt)
+				d.AddIngester("instance-1", "127.0.0.1:9095", "", []uint32{block1Hash + 1}, ring.ACTIVE, registeredAt)
+				d.AddIngester("instance-2", "127.0.0.2:9095", "", []uint32{block2Hash + 1}, ring.ACTIVE, registeredAt)
+				d.AddIngester("instance-3", "127.0.0.3:9095", "", []uint32{block3Hash + 1}, ring.ACTIVE, registeredAt)
+				d.AddIngester("instance-4", "127.0.0.4:9095", "", []uint32{block4Hash + 1}, ring.ACTIVE, registeredAt)
 			},
 			queryBlocks: []ulid.ULID{block1, block2},
 			exclude: map[ulid.ULID][]string{
-				block1: {"127.0.0.1"},
-				block2: {"127.0.0.1"},
+				block1: {"127.0.0.1:9095"},
+				block2: {"127.0.0.1:9095"},
 			},
 			expectedClients: map[string][]ulid.ULID{
-				"127.0.0.2": {block1, block2},
+				"127.0.0.2:9095": {block1, block2},
 			},
 		},
 		"shuffle sharding, multiple instances in the ring with RF = 2, SS = 2 with excluded blocks and no replacement available": {
@@ -331,15 +336,15 @@ func TestBlocksStoreReplicationSet_GetClientsFor(t *testing.T) {
 			tenantShardSize:   2,
 			replicationFactor: 2,
 			setup: func(d *ring.Desc) {
-				d.AddIngester("instance-1", "127.0.0.1", "", []uint32{block1Hash + 1}, ring.ACTIVE, registeredAt)
-				d.AddIngester("instance-2", "127.0.0.2", "", []uint32{block2Hash + 1}, ring.ACTIVE, registeredAt)
-				d.AddIngester("instance-3", "127.0.0.3", "", []uint32{block3Hash + 1}, ring.ACTIVE, registeredAt)
-				d.AddIngester("instance-4", "127.0.0.4", "", []uint32{block4Hash + 1}, ring.ACTIVE, registeredAt)
+				d.AddIngester("instance-1", "127.0.0.1:9095", "", []uint32{block1Hash + 1}, ring.ACTIVE, registeredAt)
+				d.AddIngester("instance-2", "127.0.0.2:9095", "", []uint32{block2Hash + 1}, ring.ACTIVE, registeredAt)
+				d.AddIngester("instance-3", "127.0.0.3:9095", "", []uint32{block3Hash + 1}, ring.ACTIVE, registeredAt)
+				d.AddIngester("instance-4", "127.0.0.4:9095", "", []uint32{block4Hash + 1}, ring.ACTIVE, registeredAt)
 			},
 			queryBlocks: []ulid.ULID{block1, block2},
 			exclude: map[ulid.ULID][]string{
-				block1: {"127.0.0.1", "127.0.0.2"},
-				block2: {"127.0.0.1"},
+				block1: {"127.0.0.1:9095", "127.0.0.2:9095"},
+				block2: {"127.0.0.1:9095"},
 			},
 			expectedErr: fmt.Errorf("no store-gateway instance left after checking exclude for block %s", block1.String()),
 		},
@@ -348,22 +353,22 @@ func TestBlocksStoreReplicationSet_GetClientsFor(t *testing.T) {
 			tenantShardSize:   3,
 			replicationFactor: 3,
 			setup: func(d *ring.Desc) {
-				d.AddIngester("instance-1", "127.0.0.1", "1", []uint32{block1Hash + 1}, ring.ACTIVE, registeredAt)
-				d.AddIngester("instance-2", "127.0.0.2", "2", []uint32{block2Hash + 1}, ring.ACTIVE, registeredAt)
-				d.AddIngester("instance-3", "127.0.0.3", "3", []uint32{block3Hash + 1}, ring.ACTIVE, registeredAt)
-				d.AddIngester("instance-4", "127.0.0.4", "1", []uint32{block4Hash + 1}, ring.ACTIVE, registeredAt)
-				d.AddIngester("instance-5", "127.0.0.5", "2", []uint32{block5Hash + 1}, ring.ACTIVE, registeredAt)
-				d.AddIngester("instance-6", "127.0.0.6", "3", []uint32{block6Hash + 1}, ring.ACTIVE, registeredAt)
-				d.AddIngester("instance-7", "127.0.0.7", "1", []uint32{block7Hash + 1}, ring.ACTIVE, registeredAt)
-				d.AddIngester("instance-8", "127.0.0.8", "2", []uint32{block8Hash + 1}, ring.ACTIVE, registeredAt)
-				d.AddIngester("instance-9", "127.0.0.9", "3", []uint32{block9Hash + 1}, ring.ACTIVE, registeredAt)
+				d.AddIngester("instance-1", "127.0.0.1:9095", "1", []uint32{block1Hash + 1}, ring.ACTIVE, registeredAt)
+				d.AddIngester("instance-2", "127.0.0.2:9095", "2", []uint32{block2Hash + 1}, ring.ACTIVE, registeredAt)
+				d.AddIngester("instance-3", "127.0.0.3:9095", "3", []uint32{block3Hash + 1}, ring.ACTIVE, registeredAt)
+				d.AddIngester("instance-4", "127.0.0.4:9095", "1", []uint32{block4Hash + 1}, ring.ACTIVE, registeredAt)
+				d.AddIngester("instance-5", "127.0.0.5:9095", "2", []uint32{block5Hash + 1}, ring.ACTIVE, registeredAt)
+				d.AddIngester("instance-6", "127.0.0.6:9095", "3", []uint32{block6Hash + 1}, ring.ACTIVE, registeredAt)
+				d.AddIngester("instance-7", "127.0.0.7:9095", "1", []uint32{block7Hash + 1}, ring.ACTIVE, registeredAt)
+				d.AddIngester("instance-8", "127.0.0.8:9095", "2", []uint32{block8Hash + 1}, ring.ACTIVE, registeredAt)
+				d.AddIngester("instance-9", "127.0.0.9:9095", "3", []uint32{block9Hash + 1}, ring.ACTIVE, registeredAt)
 			},
 			queryBlocks:          []ulid.ULID{block1, block2},
 			zoneAwarenessEnabled: true,
 			attemptedBlocksZones: make(map[ulid.ULID]map[string]int, 0),
 			expectedClients: map[string][]ulid.ULID{
-				"127.0.0.1": {block1},
-				"127.0.0.6": {block2},
+				"127.0.0.1:9095": {block1},
+				"127.0.0.6:9095": {block2},
 			},
 		},
 		"shuffle sharding, multiple instances in the ring with RF = 3, SS = 3, exclude and zone awareness enabled": {
@@ -371,26 +376,26 @@ func TestBlocksStoreReplicationSet_GetClientsFor(t *testing.T) {
 			tenantShardSize:   3,
 			replicationFactor: 3,
 			setup: func(d *ring.Desc) {
-				d.AddIngester("instance-1", "127.0.0.1", "1", []uint32{block1Hash + 1}, ring.ACTIVE, registeredAt)
-				d.AddIngester("instance-2", "127.0.0.2", "2", []uint32{block2Hash + 1}, ring.ACTIVE, registeredAt)
-				d.AddIngester("instance-3", "127.0.0.3", "3", []uint32{block3Hash + 1}, ring.ACTIVE, registeredAt)
-				d.AddIngester("instance-4", "127.0.0.4", "1", []uint32{block4Hash + 1}, ring.ACTIVE, registeredAt)
-				d.AddIngester("instance-5", "127.0.0.5", "2", []uint32{block5Hash + 1}, ring.ACTIVE, registeredAt)
-				d.AddIngester("instance-6", "127.0.0.6", "3", []uint32{block6Hash + 1}, ring.ACTIVE, registeredAt)
-				d.AddIngester("instance-7", "127.0.0.7", "1", []uint32{block7Hash + 1}, ring.ACTIVE, registeredAt)
-				d.AddIngester("instance-8", "127.0.0.8", "2", []uint32{block8Hash + 1}, ring.ACTIVE, registeredAt)
-				d.AddIngester("instance-9", "127.0.0.9", "3", []uint32{block9Hash + 1}, ring.ACTIVE, registeredAt)
+				d.AddIngester("instance-1", "127.0.0.1:9095", "1", []uint32{block1Hash + 1}, ring.ACTIVE, registeredAt)
+				d.AddIngester("instance-2", "127.0.0.2:9095", "2", []uint32{block2Hash + 1}, ring.ACTIVE, registeredAt)
+				d.AddIngester("instance-3", "127.0.0.3:9095", "3", []uint32{block3Hash + 1}, ring.ACTIVE, registeredAt)
+				d.AddIngester("instance-4", "127.0.0.4:9095", "1", []uint32{block4Hash + 1}, ring.ACTIVE, registeredAt)
+				d.AddIngester("instance-5", "127.0.0.5:9095", "2", []uint32{block5Hash + 1}, ring.ACTIVE, registeredAt)
+				d.AddIngester("instance-6", "127.0.0.6:9095", "3", []uint32{block6Hash + 1}, ring.ACTIVE, registeredAt)
+				d.AddIngester("instance-7", "127.0.0.7:9095", "1", []uint32{block7Hash + 1}, ring.ACTIVE, registeredAt)
+				d.AddIngester("instance-8", "127.0.0.8:9095", "2", []uint32{block8Hash + 1}, ring.ACTIVE, registeredAt)
+				d.AddIngester("instance-9", "127.0.0.9:9095", "3", []uint32{block9Hash + 1}, ring.ACTIVE, registeredAt)
 			},
 			queryBlocks:          []ulid.ULID{block1},
 			zoneAwarenessEnabled: true,
 			exclude: map[ulid.ULID][]string{
-				block1: {"127.0.0.1"},
+				block1: {"127.0.0.1:9095"},
 			},
 			attemptedBlocksZones: map[ulid.ULID]map[string]int{
 				block1: {"1": 1},
 			},
 			expectedClients: map[string][]ulid.ULID{
-				"127.0.0.6": {block1},
+				"127.0.0.6:9095": {block1},
 			},
 		},
 		"shuffle sharding, multiple instances in the ring with RF = 3, SS = 3, exclude 2 blocks and zone awareness enabled": {
@@ -398,26 +403,26 @@ func TestBlocksStoreReplicationSet_GetClientsFor(t *testing.T) {
 			tenantShardSize:   3,
 			replicationFactor: 3,
 			setup: func(d *ring.Desc) {
-				d.AddIngester("instance-1", "127.0.0.1", "1", []uint32{block1Hash + 1}, ring.ACTIVE, registeredAt)
-				d.AddIngester("instance-2", "127.0.0.2", "2", []uint32{block2Hash + 1}, ring.ACTIVE, registeredAt)
-				d.AddIngester("instance-3", "127.0.0.3", "3", []uint32{block3Hash + 1}, ring.ACTIVE, registeredAt)
-				d.AddIngester("instance-4", "127.0.0.4", "1", []uint32{block4Hash + 1}, ring.ACTIVE, registeredAt)
-				d.AddIngester("instance-5", "127.0.0.5", "2", []uint32{block5Hash + 1}, ring.ACTIVE, registeredAt)
-				d.AddIngester("instance-6", "127.0.0.6", "3", []uint32{block6Hash + 1}, ring.ACTIVE, registeredAt)
-				d.AddIngester("instance-7", "127.0.0.7", "1", []uint32{block7Hash + 1}, ring.ACTIVE, registeredAt)
-				d.AddIngester("instance-8", "127.0.0.8", "2", []uint32{block8Hash + 1}, ring.ACTIVE, registeredAt)
-				d.AddIngester("instance-9", "127.0.0.9", "3", []uint32{block9Hash + 1}, ring.ACTIVE, registeredAt)
+				d.AddIngester("instance-1", "127.0.0.1:9095", "1", []uint32{block1Hash + 1}, ring.ACTIVE, registeredAt)
+				d.AddIngester("instance-2", "127.0.0.2:9095", "2", []uint32{block2Hash + 1}, ring.ACTIVE, registeredAt)
+				d.AddIngester("instance-3", "127.0.0.3:9095", "3", []uint32{block3Hash + 1}, ring.ACTIVE, registeredAt)
+				d.AddIngester("instance-4", "127.0.0.4:9095", "1", []uint32{block4Hash + 1}, ring.ACTIVE, registeredAt)
+				d.AddIngester("instance-5", "127.0.0.5:9095", "2", []uint32{block5Hash + 1}, ring.ACTIVE, registeredAt)
+				d.AddIngester("instance-6", "127.0.0.6:9095", "3", []uint32{block6Hash + 1}, ring.ACTIVE, registeredAt)
+				d.AddIngester("instance-7", "127.0.0.7:9095", "1", []uint32{block7Hash + 1}, ring.ACTIVE, registeredAt)
+				d.AddIngester("instance-8", "127.0.0.8:9095", "2", []uint32{block8Hash + 1}, ring.ACTIVE, registeredAt)
+				d.AddIngester("instance-9", "127.0.0.9:9095", "3", []uint32{block9Hash + 1}, ring.ACTIVE, registeredAt)
 			},
 			queryBlocks:          []ulid.ULID{block1},
 			zoneAwarenessEnabled: true,
 			exclude: map[ulid.ULID][]string{
-				block1: {"127.0.0.1", "127.0.0.6"},
+				block1: {"127.0.0.1:9095", "127.0.0.6:9095"},
 			},
 			attemptedBlocksZones: map[ulid.ULID]map[string]int{
 				block1: {"1": 1, "3": 1},
 			},
 			expectedClients: map[string][]ulid.ULID{
-				"127.0.0.8": {block1},
+				"127.0.0.8:9095": {block1},
 			},
 		},
 		"shuffle sharding, multiple instances in the ring with RF = 3, SS = 3, exclude 3 blocks and zone awareness enabled": {
@@ -425,20 +430,20 @@ func TestBlocksStoreReplicationSet_GetClientsFor(t *testing.T) {
 			tenantShardSize:   3,
 			replicationFactor: 3,
 			setup: func(d *ring.Desc) {
-				d.AddIngester("instance-1", "127.0.0.1", "1", []uint32{block1Hash + 1}, ring.ACTIVE, registeredAt)
-				d.AddIngester("instance-2", "127.0.0.2", "2", []uint32{block2Hash + 1}, ring.ACTIVE, registeredAt)
-				d.AddIngester("instance-3", "127.0.0.3", "3", []uint32{block3Hash + 1}, ring.ACTIVE, registeredAt)
-				d.AddIngester("instance-4", "127.0.0.4", "1", []uint32{block4Hash + 1}, ring.ACTIVE, registeredAt)
-				d.AddIngester("instance-5", "127.0.0.5", "2", []uint32{block5Hash + 1}, ring.ACTIVE, registeredAt)
-				d.AddIngester("instance-6", "127.0.0.6", "3", []uint32{block6Hash + 1}, ring.ACTIVE, registeredAt)
-				d.AddIngester("instance-7", "127.0.0.7", "1", []uint32{block7Hash + 1}, ring.ACTIVE, registeredAt)
-				d.AddIngester("instance-8", "127.0.0.8", "2", []uint32{block8Hash + 1}, ring.ACTIVE, registeredAt)
-				d.AddIngester("instance-9", "127.0.0.9", "3", []uint32{block9Hash + 1}, ring.ACTIVE, registeredAt)
+				d.AddIngester("instance-1", "127.0.0.1:9095", "1", []uint32{block1Hash + 1}, ring.ACTIVE, registeredAt)
+				d.AddIngester("instance-2", "127.0.0.2:9095", "2", []uint32{block2Hash + 1}, ring.ACTIVE, registeredAt)
+				d.AddIngester("instance-3", "127.0.0.3:9095", "3", []uint32{block3Hash + 1}, ring.ACTIVE, registeredAt)
+				d.AddIngester("instance-4", "127.0.0.4:9095", "1", []uint32{block4Hash + 1}, ring.ACTIVE, registeredAt)
+				d.AddIngester("instance-5", "127.0.0.5:9095", "2", []uint32{block5Hash + 1}, ring.ACTIVE, registeredAt)
+				d.AddIngester("instance-6", "127.0.0.6:9095", "3", []uint32{block6Hash + 1}, ring.ACTIVE, registeredAt)
+				d.AddIngester("instance-7", "127.0.0.7:9095", "1", []uint32{block7Hash + 1}, ring.ACTIVE, registeredAt)
+				d.AddIngester("instance-8", "127.0.0.8:9095", "2", []uint32{block8Hash + 1}, ring.ACTIVE, registeredAt)
+				d.AddIngester("instance-9", "127.0.0.9:9095", "3", []uint32{block9Hash + 1}, ring.ACTIVE, registeredAt)
 			},
 			queryBlocks:          []ulid.ULID{block1},
 			zoneAwarenessEnabled: true,
 			exclude: map[ulid.ULID][]string{
-				block1: {"127.0.0.1", "127.0.0.6", "127.0.0.8"},
+				block1: {"127.0.0.1:9095", "127.0.0.6:9095", "127.0.0.8:9095"},
 			},
 			attemptedBlocksZones: map[ulid.ULID]map[string]int{
 				block1: {"1": 1, "2": 1, "3": 1},
@@ -450,26 +455,26 @@ func TestBlocksStoreReplicationSet_GetClientsFor(t *testing.T) {
 			tenantShardSize:   6,
 			replicationFactor: 6,
 			setup: func(d *ring.Desc) {
-				d.AddIngester("instance-1", "127.0.0.1", "1", []uint32{block1Hash + 1}, ring.ACTIVE, registeredAt)
-				d.AddIngester("instance-2", "127.0.0.2", "2", []uint32{block2Hash + 1}, ring.ACTIVE, registeredAt)
-				d.AddIngester("instance-3", "127.0.0.3", "3", []uint32{block3Hash + 1}, ring.ACTIVE, registeredAt)
-				d.AddIngester("instance-4", "127.0.0.4", "1", []uint32{block4Hash + 1}, ring.ACTIVE, registeredAt)
-				d.AddIngester("instance-5", "127.0.0.5", "2", []uint32{block5Hash + 1}, ring.ACTIVE, registeredAt)
-				d.AddIngester("instance-6", "127.0.0.6", "3", []uint32{block6Hash + 1}, ring.ACTIVE, registeredAt)
-				d.AddIngester("instance-7", "127.0.0.7", "1", []uint32{block7Hash + 1}, ring.ACTIVE, registeredAt)
-				d.AddIngester("instance-8", "127.0.0.8", "2", []uint32{block8Hash + 1}, ring.ACTIVE, registeredAt)
-				d.AddIngester("instance-9", "127.0.0.9", "3", []uint32{block9Hash + 1}, ring.ACTIVE, registeredAt)
+				d.AddIngester("instance-1", "127.0.0.1:9095", "1", []uint32{block1Hash + 1}, ring.ACTIVE, registeredAt)
+				d.AddIngester("instance-2", "127.0.0.2:9095", "2", []uint32{block2Hash + 1}, ring.ACTIVE, registeredAt)
+				d.AddIngester("instance-3", "127.0.0.3:9095", "3", []uint32{block3Hash + 1}, ring.ACTIVE, registeredAt)
+				d.AddIngester("instance-4", "127.0.0.4:9095", "1", []uint32{block4Hash + 1}, ring.ACTIVE, registeredAt)
+				d.AddIngester("instance-5", "127.0.0.5:9095", "2", []uint32{block5Hash + 1}, ring.ACTIVE, registeredAt)
+				d.AddIngester("instance-6", "127.0.0.6:9095", "3", []uint32{block6Hash + 1}, ring.ACTIVE, registeredAt)
+				d.AddIngester("instance-7", "127.0.0.7:9095", "1", []uint32{block7Hash + 1}, ring.ACTIVE, registeredAt)
+				d.AddIngester("instance-8", "127.0.0.8:9095", "2", []uint32{block8Hash + 1}, ring.ACTIVE, registeredAt)
+				d.AddIngester("instance-9", "127.0.0.9:9095", "3", []uint32{block9Hash + 1}, ring.ACTIVE, registeredAt)
 			},
 			queryBlocks:          []ulid.ULID{block1},
 			zoneAwarenessEnabled: true,
 			exclude: map[ulid.ULID][]string{
-				block1: {"127.0.0.1", "127.0.0.6", "127.0.0.8"},
+				block1: {"127.0.0.1:9095", "127.0.0.6:9095", "127.0.0.8:9095"},
 			},
 			attemptedBlocksZones: map[ulid.ULID]map[string]int{
 				block1: {"1": 1, "2": 1, "3": 1},
 			},
 			expectedClients: map[string][]ulid.ULID{
-				"127.0.0.2": {block1},
+				"127.0.0.2:9095": {block1},
 			},
 		},
 		"shuffle sharding, multiple instances in the ring with RF = 6, SS = 6, exclude 2 blocks and zone awareness enabled": {
@@ -477,26 +482,26 @@ func TestBlocksStoreReplicationSet_GetClientsFor(t *testing.T) {
 			tenantShardSize:   6,
 			replicationFactor: 6,
 			setup: func(d *ring.Desc) {
-				d.AddIngester("instance-1", "127.0.0.1", "1", []uint32{block1Hash + 1}, ring.ACTIVE, registeredAt)
-				d.AddIngester("instance-2", "127.0.0.2", "2", []uint32{block2Hash + 1}, ring.ACTIVE, registeredAt)
-				d.AddIngester("instance-3", "127.0.0.3", "3", []uint32{block3Hash + 1}, ring.ACTIVE, registeredAt)
-				d.AddIngester("instance-4", "127.0.0.4", "1", []uint32{block4Hash + 1}, ring.ACTIVE, registeredAt)
-				d.AddIngester("instance-5", "127.0.0.5", "2", []uint32{block5Hash + 1}, ring.ACTIVE, registeredAt)
-				d.AddIngester("instance-6", "127.0.0.6", "3", []uint32{block6Hash + 1}, ring.ACTIVE, registeredAt)
-				d.AddIngester("instance-7", "127.0.0.7", "1", []uint32{block7Hash + 1}, ring.ACTIVE, registeredAt)
-				d.AddIngester("instance-8", "127.0.0.8", "2", []uint32{block8Hash + 1}, ring.ACTIVE, registeredAt)
-				d.AddIngester("instance-9", "127.0.0.9", "3", []uint32{block9Hash + 1}, ring.ACTIVE, registeredAt)
+				d.AddIngester("instance-1", "127.0.0.1:9095", "1", []uint32{block1Hash + 1}, ring.ACTIVE, registeredAt)
+				d.AddIngester("instance-2", "127.0.0.2:9095", "2", []uint32{block2Hash + 1}, ring.ACTIVE, registeredAt)
+				d.AddIngester("instance-3", "127.0.0.3:9095", "3", []uint32{block3Hash + 1}, ring.ACTIVE, registeredAt)
+				d.AddIngester("instance-4", "127.0.0.4:9095", "1", []uint32{block4Hash + 1}, ring.ACTIVE, registeredAt)
+				d.AddIngester("instance-5", "127.0.0.5:9095", "2", []uint32{block5Hash + 1}, ring.ACTIVE, registeredAt)
+				d.AddIngester("instance-6", "127.0.0.6:9095", "3", []uint32{block6Hash + 1}, ring.ACTIVE, registeredAt)
+				d.AddIngester("instance-7", "127.0.0.7:9095", "1", []uint32{block7Hash + 1}, ring.ACTIVE, registeredAt)
+				d.AddIngester("instance-8", "127.0.0.8:9095", "2", []uint32{block8Hash + 1}, ring.ACTIVE, registeredAt)
+				d.AddIngester("instance-9", "127.0.0.9:9095", "3", []uint32{block9Hash + 1}, ring.ACTIVE, registeredAt)
 			},
 			queryBlocks:          []ulid.ULID{block1},
 			zoneAwarenessEnabled: true,
 			exclude: map[ulid.ULID][]string{
-				block1: {"127.0.0.1", "127.0.0.6"},
+				block1: {"127.0.0.1:9095", "127.0.0.6:9095"},
 			},
 			attemptedBlocksZones: map[ulid.ULID]map[string]int{
 				block1: {"1": 1, "3": 1},
 			},
 			expectedClients: map[string][]ulid.ULID{
-				"127.0.0.2": {block1},
+				"127.0.0.2:9095": {block1},
 			},
 		},
 		// This should never happen, just to test the attemptedZoneMap works correctly.
@@ -505,15 +510,15 @@ func TestBlocksStoreReplicationSet_GetClientsFor(t *testing.T) {
 			tenantShardSize:   6,
 			replicationFactor: 6,
 			setup: func(d *ring.Desc) {
-				d.AddIngester("instance-1", "127.0.0.1", "1", []uint32{block1Hash + 1}, ring.ACTIVE, registeredAt)
-				d.AddIngester("instance-2", "127.0.0.2", "2", []uint32{block2Hash + 1}, ring.ACTIVE, registeredAt)
-				d.AddIngester("instance-3", "127.0.0.3", "3", []uint32{block3Hash + 1}, ring.ACTIVE, registeredAt)
-				d.AddIngester("instance-4", "127.0.0.4", "1", []uint32{block4Hash + 1}, ring.ACTIVE, registeredAt)
-				d.AddIngester("instance-5", "127.0.0.5", "2", []uint32{block5Hash + 1}, ring.ACTIVE, registeredAt)
-				d.AddIngester("instance-6", "127.0.0.6", "3", []uint32{block6Hash + 1}, ring.ACTIVE, registeredAt)
-				d.AddIngester("instance-7", "127.0.0.7", "1", []uint32{block7Hash + 1}, ring.ACTIVE, registeredAt)
-				d.AddIngester("instance-8", "127.0.0.8", "2", []uint32{block8Hash + 1}, ring.ACTIVE, registeredAt)
-				d.AddIngester("instance-9", "127.0.0.9", "3", []uint32{block9Hash + 1}, ring.ACTIVE, registeredAt)
+				d.AddIngester("instance-1", "127.0.0.1:9095", "1", []uint32{block1Hash + 1}, ring.ACTIVE, registeredAt)
+				d.AddIngester("instance-2", "127.0.0.2:9095", "2", []uint32{block2Hash + 1}, ring.ACTIVE, registeredAt)
+				d.AddIngester("instance-3", "127.0.0.3:9095", "3", []uint32{block3Hash + 1}, ring.ACTIVE, registeredAt)
+				d.AddIngester("instance-4", "127.0.0.4:9095", "1", []uint32{block4Hash + 1}, ring.ACTIVE, registeredAt)
+				d.AddIngester("instance-5", "127.0.0.5:9095", "2", []uint32{block5Hash + 1}, ring.ACTIVE, registeredAt)
+				d.AddIngester("instance-6", "127.0.0.6:9095", "3", []uint32{block6Hash + 1}, ring.ACTIVE, registeredAt)
+				d.AddIngester("instance-7", "127.0.0.7:9095", "1", []uint32{block7Hash + 1}, ring.ACTIVE, registeredAt)
+				d.AddIngester("instance-8", "127.0.0.8:9095", "2", []uint32{block8Hash + 1}, ring.ACTIVE, registeredAt)
+				d.AddIngester("instance-9", "127.0.0.9:9095", "3", []uint32{block9Hash + 1}, ring.ACTIVE, registeredAt)
 			},
 			queryBlocks:          []ulid.ULID{block1},
 			zoneAwarenessEnabled: true,
@@ -521,7 +526,7 @@ func TestBlocksStoreReplicationSet_GetClientsFor(t *testing.T) {
 				block1: {"1": 1, "3": 1},
 			},
 			expectedClients: map[string][]ulid.ULID{
-				"127.0.0.2": {block1},
+				"127.0.0.2:9095": {block1},
 			},
 		},
 		// This should never happen, just to test the attemptedZoneMap works correctly.
@@ -530,26 +535,26 @@ func TestBlocksStoreReplicationSet_GetClientsFor(t *testing.T) {
 			tenantShardSize:   6,
 			replicationFactor: 6,
 			setup: func(d *ring.Desc) {
-				d.AddIngester("instance-1", "127.0.0.1", "1", []uint32{block1Hash + 1}, ring.ACTIVE, registeredAt)
-				d.AddIngester("instance-2", "127.0.0.2", "2", []uint32{block2Hash + 1}, ring.ACTIVE, registeredAt)
-				d.AddIngester("instance-3", "127.0.0.3", "3", []uint32{block3Hash + 1}, ring.ACTIVE, registeredAt)
-				d.AddIngester("instance-4", "127.0.0.4", "1", []uint32{block4Hash + 1}, ring.ACTIVE, registeredAt)
-				d.AddIngester("instance-5", "127.0.0.5", "2", []uint32{block5Hash + 1}, ring.ACTIVE, registeredAt)
-				d.AddIngester("instance-6", "127.0.0.6", "3", []uint32{block6Hash + 1}, ring.ACTIVE, registeredAt)
-				d.AddIngester("instance-7", "127.0.0.7", "1", []uint32{block7Hash + 1}, ring.ACTIVE, registeredAt)
-				d.AddIngester("instance-8", "127.0.0.8", "2", []uint32{block8Hash + 1}, ring.ACTIVE, registeredAt)
-				d.AddIngester("instance-9", "127.0.0.9", "3", []uint32{block9Hash + 1}, ring.ACTIVE, registeredAt)
+				d.AddIngester("instance-1", "127.0.0.1:9095", "1", []uint32{block1Hash + 1}, ring.ACTIVE, registeredAt)
+				d.AddIngester("instance-2", "127.0.0.2:9095", "2", []uint32{block2Hash + 1}, ring.ACTIVE, registeredAt)
+				d.AddIngester("instance-3", "127.0.0.3:9095", "3", []uint32{block3Hash + 1}, ring.ACTIVE, registeredAt)
+				d.AddIngester("instance-4", "127.0.0.4:9095", "1", []uint32{block4Hash + 1}, ring.ACTIVE, registeredAt)
+				d.AddIngester("instance-5", "127.0.0.5:9095", "2", []uint32{block5Hash + 1}, ring.ACTIVE, registeredAt)
+				d.AddIngester("instance-6", "127.0.0.6:9095", "3", []uint32{block6Hash + 1}, ring.ACTIVE, registeredAt)
+				d.AddIngester("instance-7", "127.0.0.7:9095", "1", []uint32{block7Hash + 1}, ring.ACTIVE, registeredAt)
+				d.AddIngester("instance-8", "127.0.0.8:9095", "2", []uint32{block8Hash + 1}, ring.ACTIVE, registeredAt)
+				d.AddIngester("instance-9", "127.0.0.9:9095", "3", []uint32{block9Hash + 1}, ring.ACTIVE, registeredAt)
 			},
 			queryBlocks:          []ulid.ULID{block1},
 			zoneAwarenessEnabled: true,
 			exclude: map[ulid.ULID][]string{
-				block1: {"127.0.0.2"},
+				block1: {"127.0.0.2:9095"},
 			},
 			attemptedBlocksZones: map[ulid.ULID]map[string]int{
 				block1: {"1": 1, "3": 1},
 			},
 			expectedClients: map[string][]ulid.ULID{
-				"127.0.0.8": {block1},
+				"127.0.0.8:9095": {block1},
 			},
 		},
 	}
@@ -631,7 +636,7 @@ func TestBlocksStoreReplicationSet_GetClientsFor_ShouldSupportRandomLoadBalancin
 	require.NoError(t, ringStore.CAS(ctx, "test", func(in any) (any, bool, error) {
 		d := ring.NewDesc()
 		for n := 1; n <= numInstances; n++ {
-			d.AddIngester(fmt.Sprintf("instance-%d", n), fmt.Sprintf("127.0.0.%d", n), "", []uint32{uint32(n)}, ring.ACTIVE, registeredAt)
+			d.AddIngester(fmt.Sprintf("instance-%d", n), fmt.Sprintf("127.0.0.%d:9095", n), "", []uint32{uint32(n)}, ring.ACTIVE, registeredAt)
 		}
 		return d, true, nil
 	}))
@@ -700,7 +705,7 @@ func TestBlocksStoreReplicationSet_GetClientsFor_ZoneAwareness(t *testing.T) {
 		d := ring.NewDesc()
 		for n := 1; n <= numInstances; n++ {
 			zone := strconv.Itoa((n-1)%3 + 1)
-			d.AddIngester(fmt.Sprintf("instance-%d", n), fmt.Sprintf("127.0.0.%d", n), zone, []uint32{uint32(n)}, ring.ACTIVE, registeredAt)
+			d.AddIngester(fmt.Sprintf("instance-%d", n), fmt.Sprintf("127.0.0.%d:9095", n), zone, []uint32{uint32(n)}, ring.ACTIVE, registeredAt)
 		}
 		return d, true, nil
 	}))
@@ -741,8 +746,9 @@ func TestBlocksStoreReplicationSet_GetClientsFor_ZoneAwareness(t *testing.T) {
 		require.NoError(t, err)
 		require.Len(t, clients, 1)
 		for c := range clients {
-			addr := c.RemoteAddress()
-			parts := strings.Split(addr, ".")
+			host, _, err := net.SplitHostPort(c.RemoteAddress())
+			require.NoError(t, err)
+			parts := strings.Split(host, ".")
 			require.True(t, len(parts) > 3)
 			id, err := strconv.Atoi(parts[3])
 			require.NoError(t, err)
@@ -758,3 +764,120 @@ func getStoreGatewayClientAddrs(clients map[BlocksStoreClient][]ulid.ULID) map[s
 	}
 	return addrs
 }
+
+func TestBlocksStoreReplicationSet_GetClientsFor_ShouldOnlyHedgeToTheReplicasOfTheBlocks(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	block := ulid.MustNew(4, nil)
+	blockHash := cortex_tsdb.HashBlockID(block)
+
+	// The store-gateway not owning the block is the fastest to respond.
+	primaryAddr := startStoreGatewayServer(t, newSlowStoreGatewayServer("primary", time.Minute))
+	replicaAddr := startStoreGatewayServer(t, newSlowStoreGatewayServer("replica", 200*time.Millisecond))
+	nonOwnerAddr := startStoreGatewayServer(t, newSlowStoreGatewayServer("non-owner", 0))
+
+	ringStore, closer := consul.NewInMemoryClient(ring.GetCodec(), log.NewNopLogger(), nil)
+	t.Cleanup(func() { assert.NoError(t, closer.Close()) })
+
+	require.NoError(t, ringStore.CAS(ctx, "test", func(in any) (any, bool, error) {
+		d := ring.NewDesc()
+		d.AddIngester("instance-1", primaryAddr, "", []uint32{blockHash + 1}, ring.ACTIVE, time.Now())
+		d.AddIngester("instance-2", replicaAddr, "", []uint32{blockHash + 2}, ring.ACTIVE, time.Now())
+		d.AddIngester("instance-3", nonOwnerAddr, "", []uint32{blockHash + 3}, ring.ACTIVE, time.Now())
+		return d, true, nil
+	}))
+
+	ringCfg := ring.Config{}
+	flagext.DefaultValues(&ringCfg)
+	ringCfg.ReplicationFactor = 2
+	ringCfg.HeartbeatTimeout = time.Hour
+
+	r, err := ring.NewWithStoreClientAndStrategy(ringCfg, "test", "test", ringStore, ring.NewIgnoreUnhealthyInstancesReplicationStrategy(), nil, nil)
+	require.NoError(t, err)
+
+	clientCfg := ClientConfig{}
+	clientCfg.RegisterFlagsWithPrefix("test", flag.NewFlagSet("test", flag.PanicOnError))
+	clientCfg.Hedging.Delay = 50 * time.Millisecond
+	clientCfg.Hedging.MaxHedges = 2
+
+	s, err := newBlocksStoreReplicationSet(r, util.ShardingStrategyDefault, noLoadBalancing, &blocksStoreLimitsMock{}, clientCfg, log.NewNopLogger(), prometheus.NewPedanticRegistry(), false, false)
+	require.NoError(t, err)
+	require.NoError(t, services.StartAndAwaitRunning(ctx, s))
+	defer services.StopAndAwaitTerminated(ctx, s) //nolint:errcheck
+
+	test.Poll(t, time.Second, true, func() any {
+		all, err := r.GetAllHealthy(ring.Read)
+		return err == nil && len(all.Instances) == 3
+	})
+
+	// All the store-gateways are known to be healthy.
+	for _, addr := range []string{replicaAddr, nonOwnerAddr} {
+		_, err := s.clientsPool.GetClientFor(addr)
+		require.NoError(t, err)
+		s.clientsPool.health.record(addr, &grpc_health_v1.HealthCheckResponse{Status: grpc_health_v1.HealthCheckResponse_SERVING}, nil)
+	}
+
+	clients, err := s.GetClientsFor("user-1", []ulid.ULID{block}, nil, nil)
+	require.NoError(t, err)
+	require.Equal(t, map[string][]ulid.ULID{primaryAddr: {block}}, getStoreGatewayClientAddrs(clients))
+
+	// The request is hedged to the replica of the block only.
+	for c := range clients {
+		resp, err := c.LabelNames(user.InjectOrgID(ctx, "user-1"), &storepb.LabelNamesRequest{})
+		require.NoError(t, err)
+		assert.Equal(t, []string{"replica"}, resp.Names)
+	}
+}
+
+func TestBlocksStoreReplicationSet_ShouldCloseConnectionsToLeavingInstances(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	registeredAt := time.Now()
+
+	// Create a ring.
+	ringStore, closer := consul.NewInMemoryClient(ring.GetCodec(), log.NewNopLogger(), nil)
+	t.Cleanup(func() { assert.NoError(t, closer.Close()) })
+
+	require.NoError(t, ringStore.CAS(ctx, "test", func(in any) (any, bool, error) {
+		d := ring.NewDesc()
+		d.AddIngester("instance-1", "127.0.0.1:9095", "", []uint32{1}, ring.ACTIVE, registeredAt)
+		d.AddIngester("instance-2", "127.0.0.2:9095", "", []uint32{2}, ring.ACTIVE, registeredAt)
+		return d, true, nil
+	}))
+
+	ringCfg := ring.Config{}
+	flagext.DefaultValues(&ringCfg)
+
+	r, err := ring.NewWithStoreClientAndStrategy(ringCfg, "test", "test", ringStore, ring.NewIgnoreUnhealthyInstancesReplicationStrategy(), nil, nil)
+	require.NoError(t, err)
+
+	s, err := newBlocksStoreReplicationSet(r, util.ShardingStrategyDefault, noLoadBalancing, &blocksStoreLimitsMock{}, ClientConfig{}, log.NewNopLogger(), prometheus.NewPedanticRegistry(), false, false)
+	require.NoError(t, err)
+	require.NoError(t, services.StartAndAwaitRunning(ctx, s))
+	defer services.StopAndAwaitTerminated(ctx, s) //nolint:errcheck
+
+	// Open a connection to both store-gateways.
+	for _, addr := range []string{"127.0.0.1:9095", "127.0.0.2:9095"} {
+		_, err := s.clientsPool.GetClientFor(addr)
+		require.NoError(t, err)
+	}
+
+	// Simulate instance-2 leaving the ring.
+	require.NoError(t, ringStore.CAS(ctx, "test", func(in any) (any, bool, error) {
+		d := in.(*ring.Desc)
+		instance := d.Ingesters["instance-2"]
+		instance.State = ring.LEAVING
+		d.Ingesters["instance-2"] = instance
+		return d, true, nil
+	}))
+
+	test.Poll(t, time.Second, ring.LEAVING, func() any {
+		state, _ := r.GetInstanceState("instance-2")
+		return state
+	})
+
+	s.evictLeavingClients()
+	assert.Equal(t, []string{"127.0.0.1:9095"}, s.clientsPool.RegisteredAddresses())
+}
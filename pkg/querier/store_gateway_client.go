@@ -1,47 +1,261 @@
 package querier
 
 import (
+	"context"
 	"flag"
+	"fmt"
+	"math"
+	"math/rand"
+	"net"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/gogo/protobuf/types"
+	"github.com/oklog/ulid/v2"
 	"github.com/pkg/errors"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/thanos-io/thanos/pkg/block"
+	"github.com/thanos-io/thanos/pkg/store/hintspb"
+	"github.com/thanos-io/thanos/pkg/store/storepb"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/backoff"
+	"google.golang.org/grpc/balancer"
+	"google.golang.org/grpc/connectivity"
+	"google.golang.org/grpc/encoding/gzip"
 	"google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/keepalive"
+	"google.golang.org/grpc/resolver"
 
 	"github.com/cortexproject/cortex/pkg/ring/client"
 	"github.com/cortexproject/cortex/pkg/storegateway/storegatewaypb"
+	"github.com/cortexproject/cortex/pkg/util/flagext"
 	"github.com/cortexproject/cortex/pkg/util/grpcclient"
+	"github.com/cortexproject/cortex/pkg/util/grpcencoding/snappy"
+	"github.com/cortexproject/cortex/pkg/util/grpcencoding/snappyblock"
+	"github.com/cortexproject/cortex/pkg/util/grpcencoding/zstd"
+	"github.com/cortexproject/cortex/pkg/util/services"
 	"github.com/cortexproject/cortex/pkg/util/tls"
 )
 
-func newStoreGatewayClientFactory(clientCfg grpcclient.ConfigWithHealthCheck, reg prometheus.Registerer) client.PoolFactory {
+// defaultGRPCConnectTimeout is the gRPC client default connect timeout.
+const defaultGRPCConnectTimeout = 20 * time.Second
+
+// defaultRequestDurationBuckets are the default buckets of the store-gateway client request
+// duration histogram.
+var defaultRequestDurationBuckets = prometheus.ExponentialBuckets(0.008, 4, 7)
+
+var (
+	forceTraceSamplingCtxKey contextKey = 2
+
+	errInvalidTracingSampleRate  = errors.New("store gateway client tracing sample rate should be in the range [0, 1]")
+	errNegativeTLSReloadInterval = errors.New("store gateway client TLS reload interval must not be negative")
+	errNegativeDNSCacheTTL       = errors.New("store gateway client DNS cache TTL must not be negative")
+
+	errNegativePoolUnhealthyThreshold = errors.New("store gateway client pool unhealthy threshold must not be negative")
+
+	errNegativeCompressionMinRequestSize = errors.New("store gateway client gRPC compression min request size must not be negative")
+
+	errNonIncreasingRequestDurationBuckets = errors.New("store gateway client request duration buckets must be strictly increasing")
+
+	errInvalidReconnectBackoffBaseDelay  = errors.New("store gateway client reconnect backoff base delay must be greater than 0")
+	errInvalidReconnectBackoffMultiplier = errors.New("store gateway client reconnect backoff multiplier must be at least 1")
+	errInvalidReconnectBackoffJitter     = errors.New("store gateway client reconnect backoff jitter should be in the range [0, 1]")
+	errInvalidReconnectBackoffMaxDelay   = errors.New("store gateway client reconnect backoff max delay must not be lower than the base delay")
+)
+
+// InjectForceTraceSamplingIntoContext flags the context so that the requests issued to the
+// store-gateways with it are always traced, regardless of the configured sampling rate.
+func InjectForceTraceSamplingIntoContext(ctx context.Context) context.Context {
+	return context.WithValue(ctx, forceTraceSamplingCtxKey, true)
+}
+
+func isTraceSamplingForced(ctx context.Context) bool {
+	forced, ok := ctx.Value(forceTraceSamplingCtxKey).(bool)
+	return ok && forced
+}
+
+// newStoreGatewayTraceSampler returns a sampler tracing the given fraction of the requests,
+// plus the ones explicitly flagged via InjectForceTraceSamplingIntoContext.
+func newStoreGatewayTraceSampler(rate float64) grpcclient.TraceSampler {
+	return func(ctx context.Context) bool {
+		if isTraceSamplingForced(ctx) || rate >= 1 {
+			return true
+		}
+		if rate <= 0 {
+			return false
+		}
+		return rand.Float64() < rate
+	}
+}
+
+func newStoreGatewayClientFactory(clientCfg grpcclient.ConfigWithHealthCheck, clientConfig ClientConfig, inflight *inflightRequests, shadow *shadowRequests, reg prometheus.Registerer) client.PoolFactory {
 	requestDuration := promauto.With(reg).NewHistogramVec(prometheus.HistogramOpts{
 		Namespace:   "cortex",
 		Name:        "storegateway_client_request_duration_seconds",
 		Help:        "Time spent executing requests to the store-gateway.",
-		Buckets:     prometheus.ExponentialBuckets(0.008, 4, 7),
+		Buckets:     clientConfig.requestDurationBuckets(),
 		ConstLabels: prometheus.Labels{"client": "querier"},
 	}, []string{"operation", "status_code"})
+	connectionsCreated := promauto.With(reg).NewCounter(prometheus.CounterOpts{
+		Namespace:   "cortex",
+		Name:        "storegateway_client_connections_created_total",
+		Help:        "Total number of connections created to store-gateways.",
+		ConstLabels: prometheus.Labels{"client": "querier"},
+	})
+	connectionsClosed := promauto.With(reg).NewCounter(prometheus.CounterOpts{
+		Namespace:   "cortex",
+		Name:        "storegateway_client_connections_closed_total",
+		Help:        "Total number of connections to store-gateways closed.",
+		ConstLabels: prometheus.Labels{"client": "querier"},
+	})
+
+	unaryInterceptors, streamInterceptors := grpcclient.InstrumentWithTraceSampler(requestDuration, newStoreGatewayTraceSampler(clientConfig.TracingSampleRate))
+	unaryInterceptors = append([]grpc.UnaryClientInterceptor{inflight.UnaryClientInterceptor}, unaryInterceptors...)
+	streamInterceptors = append([]grpc.StreamClientInterceptor{inflight.StreamClientInterceptor}, streamInterceptors...)
+	unaryInterceptors = append(unaryInterceptors, queryIDUnaryClientInterceptor)
+	streamInterceptors = append(streamInterceptors, queryIDStreamClientInterceptor)
+	if clientConfig.ForwardQueriedBlocks {
+		unaryInterceptors = append(unaryInterceptors, queriedBlocksUnaryClientInterceptor)
+		streamInterceptors = append(streamInterceptors, queriedBlocksStreamClientInterceptor)
+	}
+	if clientConfig.GRPCCompressionMinRequestSize > 0 && clientCfg.GRPCCompression != "" {
+		// The compression is set on each request depending on its size, instead of being a
+		// dial-wide default. It comes before the retries, so that the stream it opens once the
+		// request is sent is retried too.
+		compression := newRequestSizeCompression(clientCfg.GRPCCompression, clientConfig.GRPCCompressionMinRequestSize, clientConfig.GRPCCompressionSeriesOnly, reg)
+		unaryInterceptors = append(unaryInterceptors, compression.UnaryClientInterceptor)
+		streamInterceptors = append(streamInterceptors, compression.StreamClientInterceptor)
+		clientCfg.GRPCCompression = ""
+	} else if clientConfig.GRPCCompressionSeriesOnly && clientCfg.GRPCCompression != "" {
+		// The compression is set on each Series request, instead of being a dial-wide default.
+		streamInterceptors = append(streamInterceptors, seriesCompressionStreamClientInterceptor(clientCfg.GRPCCompression))
+		clientCfg.GRPCCompression = ""
+	}
+	if clientConfig.Retry.MaxRetries > 0 {
+		retry := newStoreGatewayRetry(clientConfig.Retry, requestDuration)
+		unaryInterceptors = append(unaryInterceptors, retry.UnaryClientInterceptor)
+		streamInterceptors = append(streamInterceptors, retry.StreamClientInterceptor)
+	}
+	if clientConfig.AdaptiveTimeout.Enabled {
+		timeouts := newAdaptiveTimeouts(clientConfig.AdaptiveTimeout, reg)
+		unaryInterceptors = append(unaryInterceptors, timeouts.UnaryClientInterceptor)
+		streamInterceptors = append(streamInterceptors, timeouts.StreamClientInterceptor)
+	}
+
+	keepaliveParams := clientConfig.keepaliveParams()
+	connectParams := clientConfig.connectParams()
+
+	var certReloader *clientCertificateReloader
+	if clientConfig.TLSEnabled && clientConfig.TLSReloadInterval > 0 && clientConfig.TLS.CertPath != "" {
+		certReloader = newClientCertificateReloader(clientConfig.TLS, clientConfig.TLSReloadInterval, reg)
+
+		// Do not load the certificate once when building the default dial options, because
+		// it's provided by the reloader.
+		clientCfg.TLS.CertPath, clientCfg.TLS.KeyPath = "", ""
+	}
+
+	var dnsResolver *cachingDNSResolverBuilder
+	if clientConfig.DNSCacheTTL > 0 {
+		dnsResolver = newCachingDNSResolverBuilder(clientConfig.DNSCacheTTL)
+	}
+
+	inflightRequests := newInflightRequestsMetric(reg)
+
+	dial := func(addr string) (*storeGatewayClient, error) {
+		opts := []grpc.DialOption{grpc.WithKeepaliveParams(keepaliveParams), grpc.WithConnectParams(connectParams)}
+		if clientConfig.LoadBalancingPolicy != "" {
+			opts = append(opts, grpc.WithDefaultServiceConfig(loadBalancingServiceConfig(clientConfig.LoadBalancingPolicy)))
+		}
+		if clientConfig.GRPCAuthority != "" {
+			opts = append(opts, grpc.WithAuthority(clientConfig.GRPCAuthority))
+		}
+		if dnsResolver != nil {
+			opts = append(opts, grpc.WithResolvers(dnsResolver))
+		}
+		if certReloader != nil {
+			opt, err := certReloader.dialOption()
+			if err != nil {
+				return nil, err
+			}
+			opts = append(opts, opt)
+		}
+
+		// The concurrency is limited for each store-gateway, and the in-flight requests of each
+		// client are tracked to close it gracefully.
+		limiter := newConcurrencyLimiter(addr, clientConfig.MaxConcurrentRequests, inflightRequests)
+		requests := newInflightRequests()
+		unary := append([]grpc.UnaryClientInterceptor{requests.UnaryClientInterceptor}, append(slices.Clone(unaryInterceptors), limiter.UnaryClientInterceptor)...)
+		stream := append([]grpc.StreamClientInterceptor{requests.StreamClientInterceptor}, append(slices.Clone(streamInterceptors), limiter.StreamClientInterceptor)...)
+
+		c, err := dialStoreGatewayClient(clientCfg, addr, opts, clientConfig.EagerConnect, unary, stream)
+		if err != nil {
+			limiter.close()
+			return nil, err
+		}
+		c.limiter = limiter
+		c.requests = requests
+		return c, nil
+	}
+
+	var circuitBreakerState *prometheus.GaugeVec
+	if clientConfig.CircuitBreaker.Enabled {
+		circuitBreakerState = newCircuitBreakerStateMetric(reg)
+	}
 
 	return func(addr string) (client.PoolClient, error) {
-		return dialStoreGatewayClient(clientCfg, addr, requestDuration)
+		c, err := dial(addr)
+		if err != nil {
+			return nil, err
+		}
+		c.connectionsClosed = connectionsClosed
+		connectionsCreated.Inc()
+
+		var pc storeGatewayPoolClient = c
+		if clientConfig.CircuitBreaker.Enabled {
+			pc = newCircuitBreakerClient(c, clientConfig.CircuitBreaker, circuitBreakerState)
+		}
+		if shadow != nil {
+			pc = &shadowingClient{storeGatewayPoolClient: pc, shadow: shadow}
+		}
+		return pc, nil
 	}
 }
 
-func dialStoreGatewayClient(clientCfg grpcclient.ConfigWithHealthCheck, addr string, requestDuration *prometheus.HistogramVec) (*storeGatewayClient, error) {
-	opts, err := clientCfg.DialOption(grpcclient.Instrument(requestDuration))
+// dialStoreGatewayClient creates a client for the store-gateway at the given address. The
+// extra dial options override the ones built from the client config. If eagerConnect is
+// true, the connection is established upfront, within the configured connect timeout.
+func dialStoreGatewayClient(clientCfg grpcclient.ConfigWithHealthCheck, addr string, extraOpts []grpc.DialOption, eagerConnect bool, unaryInterceptors []grpc.UnaryClientInterceptor, streamInterceptors []grpc.StreamClientInterceptor) (*storeGatewayClient, error) {
+	// The gRPC client connects lazily, so we validate the address upfront to fail fast
+	// instead of failing later while running a query.
+	if err := validateStoreGatewayAddress(addr); err != nil {
+		return nil, errors.Wrapf(err, "invalid store-gateway address %q", addr)
+	}
+
+	opts, err := clientCfg.DialOption(unaryInterceptors, streamInterceptors)
 	if err != nil {
 		return nil, err
 	}
+	opts = append(opts, extraOpts...)
 
 	conn, err := grpc.NewClient(addr, opts...)
 	if err != nil {
 		return nil, errors.Wrapf(err, "failed to dial store-gateway %s", addr)
 	}
 
+	if eagerConnect {
+		if err := waitForConnectionReady(conn, clientCfg.ConnectTimeout); err != nil {
+			_ = conn.Close()
+			return nil, errors.Wrapf(err, "failed to connect to store-gateway %s", addr)
+		}
+	}
+
 	return &storeGatewayClient{
 		StoreGatewayClient: storegatewaypb.NewStoreGatewayClient(conn),
 		HealthClient:       grpc_health_v1.NewHealthClient(conn),
@@ -49,25 +263,179 @@ func dialStoreGatewayClient(clientCfg grpcclient.ConfigWithHealthCheck, addr str
 	}, nil
 }
 
+// loadBalancingServiceConfig returns the gRPC service config selecting the given load
+// balancing policy.
+func loadBalancingServiceConfig(policy string) string {
+	return fmt.Sprintf(`{"loadBalancingConfig": [{%q: {}}]}`, policy)
+}
+
+// waitForConnectionReady connects the lazy client connection and waits until it's ready
+// or the timeout has elapsed. A 0 timeout means using the default gRPC connect timeout.
+func waitForConnectionReady(conn *grpc.ClientConn, timeout time.Duration) error {
+	if timeout <= 0 {
+		timeout = defaultGRPCConnectTimeout
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	conn.Connect()
+	for state := conn.GetState(); state != connectivity.Ready; state = conn.GetState() {
+		if !conn.WaitForStateChange(ctx, state) {
+			return errors.Errorf("connection not ready within %s, last state: %s", timeout, state)
+		}
+	}
+	return nil
+}
+
+// validateGRPCAuthority returns an error if the given authority isn't in the host or host:port
+// form, e.g. if it has a scheme, user info or path.
+func validateGRPCAuthority(authority string) error {
+	u, err := url.Parse("//" + authority)
+	if err != nil {
+		return err
+	}
+	if u.Host != authority || u.Hostname() == "" || strings.HasSuffix(authority, ":") {
+		return errors.New("expected host or host:port")
+	}
+	if port := u.Port(); port != "" {
+		if _, err := strconv.ParseUint(port, 10, 16); err != nil {
+			return errors.Errorf("invalid port %s", port)
+		}
+	}
+	return nil
+}
+
+// validateStoreGatewayAddress returns an error if the given address isn't in the host:port
+// form. The gRPC targets with the scheme of a registered resolver, e.g. dns:///host:port,
+// are left to the resolver to validate.
+func validateStoreGatewayAddress(addr string) error {
+	if addr == "" {
+		return errors.New("empty address")
+	}
+	if u, err := url.Parse(addr); err == nil && u.Scheme != "" && resolver.Get(u.Scheme) != nil {
+		return nil
+	}
+
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return err
+	}
+	if host == "" || port == "" {
+		return errors.New("missing host or port")
+	}
+	return nil
+}
+
 type storeGatewayClient struct {
 	storegatewaypb.StoreGatewayClient
 	grpc_health_v1.HealthClient
 	conn *grpc.ClientConn
+
+	// connectionsClosed, if set, is incremented the first time the client is closed.
+	connectionsClosed prometheus.Counter
+	closeOnce         sync.Once
+
+	// limiter, if set, limits the concurrent requests to the store-gateway.
+	limiter *concurrencyLimiter
+
+	// requests, if set, tracks the in-flight requests of the client. A Series stream is
+	// in-flight until it has been fully consumed or its context is done.
+	requests *inflightRequests
+}
+
+// CloseGracefully rejects the new requests and waits for the in-flight ones to complete,
+// until the context is done, before closing the connection.
+func (c *storeGatewayClient) CloseGracefully(ctx context.Context) error {
+	var err error
+	if c.requests != nil {
+		c.requests.close()
+		if err = c.requests.wait(ctx); err != nil {
+			err = errors.Wrapf(err, "waiting for the in-flight requests to store-gateway %s to complete", c.RemoteAddress())
+		}
+	}
+
+	if closeErr := c.Close(); err == nil {
+		err = closeErr
+	}
+	return err
 }
 
 func (c *storeGatewayClient) Close() error {
+	c.closeOnce.Do(func() {
+		if c.connectionsClosed != nil {
+			c.connectionsClosed.Inc()
+		}
+		if c.limiter != nil {
+			c.limiter.close()
+		}
+	})
 	return c.conn.Close()
 }
 
+// SeriesForContext is like Series, but restricts the request to the blocks injected in the
+// context with InjectBlocksIntoContext, if any, unless its hints already select the blocks
+// to query.
+func (c *storeGatewayClient) SeriesForContext(ctx context.Context, req *storepb.SeriesRequest, opts ...grpc.CallOption) (storegatewaypb.StoreGateway_SeriesClient, error) {
+	req, err := withBlocksFromContext(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	return c.Series(ctx, req, opts...)
+}
+
+// withBlocksFromContext returns a copy of the request whose hints select the blocks injected
+// in the context. The request is returned unchanged if no blocks are injected in the context
+// or its hints already have block matchers.
+func withBlocksFromContext(ctx context.Context, req *storepb.SeriesRequest) (*storepb.SeriesRequest, error) {
+	blocks, ok := ExtractBlocksFromContext(ctx)
+	if !ok {
+		return req, nil
+	}
+
+	hints := &hintspb.SeriesRequestHints{}
+	if req.Hints != nil {
+		if err := types.UnmarshalAny(req.Hints, hints); err != nil {
+			return nil, errors.Wrapf(err, "failed to unmarshal series request hints")
+		}
+		if len(hints.BlockMatchers) > 0 {
+			return req, nil
+		}
+	}
+
+	blockIDs := make([]ulid.ULID, 0, len(blocks))
+	for _, b := range blocks {
+		blockIDs = append(blockIDs, b.ID)
+	}
+	hints.BlockMatchers = []storepb.LabelMatcher{
+		{
+			Type:  storepb.LabelMatcher_RE,
+			Name:  block.BlockIDLabel,
+			Value: strings.Join(convertULIDsToString(blockIDs), "|"),
+		},
+	}
+
+	anyHints, err := types.MarshalAny(hints)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to marshal series request hints")
+	}
+
+	withHints := *req
+	withHints.Hints = anyHints
+	return &withHints, nil
+}
+
+// String returns the client address along with the current state of its connection,
+// so that idle or reconnecting clients can be told apart from healthy ones.
 func (c *storeGatewayClient) String() string {
-	return c.RemoteAddress()
+	return fmt.Sprintf("%s [%s]", c.RemoteAddress(), c.conn.GetState())
 }
 
 func (c *storeGatewayClient) RemoteAddress() string {
 	return c.conn.Target()
 }
 
-func newStoreGatewayClientPool(discovery client.PoolServiceDiscovery, clientConfig ClientConfig, logger log.Logger, reg prometheus.Registerer) *client.Pool {
+func newStoreGatewayClientPool(discovery client.PoolServiceDiscovery, clientConfig ClientConfig, logger log.Logger, reg prometheus.Registerer) *storeGatewayClientPool {
 	// We prefer sane defaults instead of exposing further config options.
 	clientCfg := grpcclient.ConfigWithHealthCheck{
 		Config: grpcclient.Config{
@@ -87,6 +455,10 @@ func newStoreGatewayClientPool(discovery client.PoolServiceDiscovery, clientConf
 		CheckInterval:      time.Minute,
 		HealthCheckEnabled: true,
 		HealthCheckTimeout: 10 * time.Second,
+		HealthCheckService: clientConfig.HealthCheckConfig.ServiceName,
+		UnhealthyThreshold: clientConfig.PoolUnhealthyThreshold,
+		// The stale clients are closed once their in-flight requests have completed.
+		GracefulCloseTimeout: clientConfig.DrainTimeout,
 	}
 
 	clientsCount := promauto.With(reg).NewGauge(prometheus.GaugeOpts{
@@ -96,21 +468,269 @@ func newStoreGatewayClientPool(discovery client.PoolServiceDiscovery, clientConf
 		ConstLabels: map[string]string{"client": "querier"},
 	})
 
-	return client.NewPool("store-gateway", poolCfg, discovery, newStoreGatewayClientFactory(clientCfg, reg), clientsCount, logger)
+	var shadow *shadowRequests
+	if clientConfig.Shadow.Fraction > 0 {
+		shadow = newShadowRequests(clientConfig.Shadow, clientCfg, []grpc.DialOption{grpc.WithKeepaliveParams(clientConfig.keepaliveParams()), grpc.WithConnectParams(clientConfig.connectParams())}, logger, reg)
+	}
+
+	inflight := newInflightRequests()
+	health := newClientsHealth()
+	factory := health.wrapFactory(newStoreGatewayClientFactory(clientCfg, clientConfig, inflight, shadow, reg))
+	p := &storeGatewayClientPool{
+		Pool:         client.NewPool("store-gateway", poolCfg, discovery, factory, clientsCount, logger),
+		inflight:     inflight,
+		health:       health,
+		shadow:       shadow,
+		drainTimeout: clientConfig.DrainTimeout,
+		logger:       logger,
+	}
+	if clientConfig.Hedging.enabled() {
+		p.hedging = newRequestHedging(clientConfig.Hedging, reg)
+	}
+	p.Service = services.NewIdleService(p.starting, p.stopping)
+	return p
+}
+
+// RemoveClients closes and removes the clients of the given store-gateway addresses, without
+// waiting for their in-flight requests to complete, so that new clients are created the next
+// time they're requested. Addresses without a client in the pool are ignored.
+func (p *storeGatewayClientPool) RemoveClients(addrs []string) {
+	registered := map[string]struct{}{}
+	for _, addr := range p.RegisteredAddresses() {
+		registered[addr] = struct{}{}
+	}
+
+	for _, addr := range addrs {
+		if _, ok := registered[addr]; !ok {
+			continue
+		}
+
+		p.RemoveClientFor(addr)
+		level.Info(p.logger).Log("msg", "closed store-gateway client", "addr", addr)
+	}
 }
 
 type ClientConfig struct {
-	TLSEnabled        bool                         `yaml:"tls_enabled"`
-	TLS               tls.ClientConfig             `yaml:",inline"`
-	GRPCCompression   string                       `yaml:"grpc_compression"`
-	HealthCheckConfig grpcclient.HealthCheckConfig `yaml:"healthcheck_config" doc:"description=EXPERIMENTAL: If enabled, gRPC clients perform health checks for each target and fail the request if the target is marked as unhealthy."`
-	ConnectTimeout    time.Duration                `yaml:"connect_timeout"`
+	TLSEnabled                    bool                         `yaml:"tls_enabled"`
+	TLS                           tls.ClientConfig             `yaml:",inline"`
+	TLSReloadInterval             time.Duration                `yaml:"tls_reload_interval"`
+	GRPCCompression               string                       `yaml:"grpc_compression"`
+	GRPCCompressionSeriesOnly     bool                         `yaml:"grpc_compression_series_only"`
+	GRPCCompressionMinRequestSize int                          `yaml:"grpc_compression_min_request_size"`
+	LoadBalancingPolicy           string                       `yaml:"load_balancing_policy"`
+	GRPCAuthority                 string                       `yaml:"grpc_authority"`
+	DNSCacheTTL                   time.Duration                `yaml:"dns_cache_ttl"`
+	HealthCheckConfig             grpcclient.HealthCheckConfig `yaml:"healthcheck_config" doc:"description=EXPERIMENTAL: If enabled, gRPC clients perform health checks for each target and fail the request if the target is marked as unhealthy."`
+	PoolUnhealthyThreshold        int                          `yaml:"pool_unhealthy_threshold"`
+	ConnectTimeout                time.Duration                `yaml:"connect_timeout"`
+	EagerConnect                  bool                         `yaml:"eager_connect"`
+	DrainTimeout                  time.Duration                `yaml:"drain_timeout"`
+	TracingSampleRate             float64                      `yaml:"tracing_sample_rate"`
+	MaxConcurrentRequests         int                          `yaml:"max_concurrent_requests"`
+	ForwardQueriedBlocks          bool                         `yaml:"forward_queried_blocks"`
+	AdaptiveTimeout               AdaptiveTimeoutConfig        `yaml:"adaptive_timeout"`
+	Retry                         RetryConfig                  `yaml:"retry"`
+	CircuitBreaker                CircuitBreakerConfig         `yaml:"circuit_breaker"`
+	Shadow                        ShadowConfig                 `yaml:"shadow"`
+	Hedging                       HedgingConfig                `yaml:"hedging"`
+	ReconnectBackoff              ReconnectBackoffConfig       `yaml:"reconnect_backoff"`
+	RequestDurationBuckets        flagext.Float64SliceCSV      `yaml:"request_duration_buckets"`
+
+	KeepaliveTime                time.Duration `yaml:"keepalive_time"`
+	KeepaliveTimeout             time.Duration `yaml:"keepalive_timeout"`
+	KeepalivePermitWithoutStream bool          `yaml:"keepalive_permit_without_stream"`
 }
 
 func (cfg *ClientConfig) RegisterFlagsWithPrefix(prefix string, f *flag.FlagSet) {
+	cfg.RequestDurationBuckets = slices.Clone(defaultRequestDurationBuckets)
+
 	f.BoolVar(&cfg.TLSEnabled, prefix+".tls-enabled", cfg.TLSEnabled, "Enable TLS for gRPC client connecting to store-gateway.")
-	f.StringVar(&cfg.GRPCCompression, prefix+".grpc-compression", "", "Use compression when sending messages. Supported values are: 'gzip', 'snappy' and '' (disable compression)")
+	f.DurationVar(&cfg.TLSReloadInterval, prefix+".tls-reload-interval", 0, "How frequently the client certificate and key files are reloaded from disk. The reloaded certificate is used by the new connections to store-gateways. 0 to load them only once, at startup.")
+	f.StringVar(&cfg.GRPCCompression, prefix+".grpc-compression", "", "Use compression when sending messages. Supported values are: 'gzip', 'snappy', 'snappy-block', 'zstd' and '' (disable compression)")
+	f.BoolVar(&cfg.GRPCCompressionSeriesOnly, prefix+".grpc-compression-series-only", false, "True to only compress the series requests and responses, leaving the label names and values ones, which are usually small, uncompressed. Only used if the gRPC compression is enabled.")
+	f.IntVar(&cfg.GRPCCompressionMinRequestSize, prefix+".grpc-compression-min-request-size", 0, "If greater than 0, only the requests whose serialized size is greater than this number of bytes, and their responses, are compressed, e.g. the requests with large sets of matchers. Only used if the gRPC compression is enabled. 0 to compress all the requests.")
+	f.StringVar(&cfg.LoadBalancingPolicy, prefix+".load-balancing-policy", "", "The gRPC load balancing policy used to spread the requests across the backends the address of a store-gateway resolves to, e.g. 'round_robin'. Useful when a single DNS address fronts multiple store-gateways. Empty to use the gRPC default, which sends all the requests to the first backend.")
+	f.StringVar(&cfg.GRPCAuthority, prefix+".grpc-authority", "", "If set, the :authority header sent to the store-gateways, instead of their address, in the host or host:port form. Useful when a service mesh routes the requests based on it.")
+	f.DurationVar(&cfg.DNSCacheTTL, prefix+".dns-cache-ttl", 0, "If greater than 0, the store-gateway addresses are resolved by a DNS resolver shared by all the connections, which caches the resolved IPs for this TTL, so that new connections don't resolve again a recently resolved address. 0 to use the standard gRPC DNS resolution of each connection.")
+	f.IntVar(&cfg.PoolUnhealthyThreshold, prefix+".pool-unhealthy-threshold", 1, "The number of consecutive failed health checks required before removing a store-gateway client from the clients pool. It's independent from the health checks of the healthcheck config, which fail the requests to unhealthy store-gateways.")
 	f.DurationVar(&cfg.ConnectTimeout, prefix+".connect-timeout", 5*time.Second, "The maximum amount of time to establish a connection. A value of 0 means using default gRPC client connect timeout 5s.")
+	f.BoolVar(&cfg.EagerConnect, prefix+".eager-connect", false, "True to establish the connection to a store-gateway when its client is created, failing if the store-gateway is not reachable within the connect timeout. If false, the connection is established by the first request.")
+	f.DurationVar(&cfg.DrainTimeout, prefix+".drain-timeout", 0, "The maximum amount of time to wait, on shutdown or when a store-gateway is removed from the ring, for the in-flight requests to store-gateways to complete before closing the connections. It should be lower than the termination grace period. 0 to close the connections immediately.")
+	f.Float64Var(&cfg.TracingSampleRate, prefix+".tracing-sample-rate", 1, "The fraction of requests to store-gateways for which a client span is created, in the range [0, 1]. Requests flagged to be force sampled are always traced.")
+	f.IntVar(&cfg.MaxConcurrentRequests, prefix+".max-concurrent-requests", 0, "The maximum number of concurrent requests to each store-gateway. The requests over the limit wait for a slot until their deadline, and then fail. 0 means unlimited.")
+	f.BoolVar(&cfg.ForwardQueriedBlocks, prefix+".forward-queried-blocks", false, "True to send the IDs of the blocks the querier expects to query to the store-gateways, in the x-cortex-queried-blocks gRPC metadata header. Only useful with store-gateways supporting the header.")
+	f.DurationVar(&cfg.KeepaliveTime, prefix+".keepalive-time", 20*time.Second, "The idle time after which the client pings the store-gateway to check if the connection is still alive. Values lower than 10s are raised to 10s. 0 to disable keepalive pings.")
+	f.DurationVar(&cfg.KeepaliveTimeout, prefix+".keepalive-timeout", 10*time.Second, "The time the client waits for a keepalive ping ack before closing the connection.")
+	f.BoolVar(&cfg.KeepalivePermitWithoutStream, prefix+".keepalive-permit-without-stream", true, "True to send keepalive pings even when there are no active requests.")
+	f.Var(&cfg.RequestDurationBuckets, prefix+".request-duration-buckets", "Comma-separated list of the upper bounds, in seconds, of the buckets of the cortex_storegateway_client_request_duration_seconds histogram. They must be strictly increasing.")
 	cfg.TLS.RegisterFlagsWithPrefix(prefix, f)
 	cfg.HealthCheckConfig.RegisterFlagsWithPrefix(prefix, f)
+	cfg.AdaptiveTimeout.RegisterFlagsWithPrefix(prefix, f)
+	cfg.Retry.RegisterFlagsWithPrefix(prefix, f)
+	cfg.CircuitBreaker.RegisterFlagsWithPrefix(prefix, f)
+	cfg.Shadow.RegisterFlagsWithPrefix(prefix, f)
+	cfg.Hedging.RegisterFlagsWithPrefix(prefix, f)
+	cfg.ReconnectBackoff.RegisterFlagsWithPrefix(prefix, f)
+}
+
+// Validate the config.
+func (cfg *ClientConfig) Validate() error {
+	if cfg.TracingSampleRate < 0 || cfg.TracingSampleRate > 1 {
+		return errInvalidTracingSampleRate
+	}
+
+	if cfg.TLSReloadInterval < 0 {
+		return errNegativeTLSReloadInterval
+	}
+
+	if cfg.MaxConcurrentRequests < 0 {
+		return errNegativeMaxConcurrentRequests
+	}
+
+	if cfg.DNSCacheTTL < 0 {
+		return errNegativeDNSCacheTTL
+	}
+
+	if cfg.PoolUnhealthyThreshold < 0 {
+		return errNegativePoolUnhealthyThreshold
+	}
+
+	if cfg.GRPCCompressionMinRequestSize < 0 {
+		return errNegativeCompressionMinRequestSize
+	}
+
+	switch cfg.GRPCCompression {
+	case gzip.Name, snappy.Name, snappyblock.Name, zstd.Name, "":
+		// valid
+	default:
+		return errors.Errorf("unsupported store gateway client compression type: %s", cfg.GRPCCompression)
+	}
+
+	if cfg.LoadBalancingPolicy != "" && balancer.Get(cfg.LoadBalancingPolicy) == nil {
+		return errors.Errorf("unsupported store gateway client load balancing policy: %s", cfg.LoadBalancingPolicy)
+	}
+
+	if cfg.GRPCAuthority != "" {
+		if err := validateGRPCAuthority(cfg.GRPCAuthority); err != nil {
+			return errors.Wrapf(err, "invalid store gateway client gRPC authority %q", cfg.GRPCAuthority)
+		}
+	}
+
+	if err := cfg.AdaptiveTimeout.Validate(); err != nil {
+		return err
+	}
+
+	if err := cfg.Retry.Validate(); err != nil {
+		return err
+	}
+
+	if err := cfg.CircuitBreaker.Validate(); err != nil {
+		return err
+	}
+
+	if err := cfg.Shadow.Validate(); err != nil {
+		return err
+	}
+
+	if err := cfg.Hedging.Validate(); err != nil {
+		return err
+	}
+
+	if err := cfg.ReconnectBackoff.Validate(); err != nil {
+		return err
+	}
+
+	for i := 1; i < len(cfg.RequestDurationBuckets); i++ {
+		if cfg.RequestDurationBuckets[i] <= cfg.RequestDurationBuckets[i-1] {
+			return errNonIncreasingRequestDurationBuckets
+		}
+	}
+
+	return nil
+}
+
+func (cfg *ClientConfig) keepaliveParams() keepalive.ClientParameters {
+	keepaliveTime := cfg.KeepaliveTime
+	if keepaliveTime <= 0 {
+		// gRPC raises any time lower than 10s to 10s, so we need an infinite time to disable pings.
+		keepaliveTime = time.Duration(math.MaxInt64)
+	}
+
+	return keepalive.ClientParameters{
+		Time:                keepaliveTime,
+		Timeout:             cfg.KeepaliveTimeout,
+		PermitWithoutStream: cfg.KeepalivePermitWithoutStream,
+	}
+}
+
+// requestDurationBuckets returns the buckets of the request duration histogram, defaulting to
+// defaultRequestDurationBuckets.
+func (cfg *ClientConfig) requestDurationBuckets() []float64 {
+	if len(cfg.RequestDurationBuckets) == 0 {
+		return defaultRequestDurationBuckets
+	}
+	return cfg.RequestDurationBuckets
+}
+
+// connectParams returns the parameters of the connections to the store-gateways. They override
+// the ones of the gRPC client config, which always uses the default gRPC reconnect backoff.
+func (cfg *ClientConfig) connectParams() grpc.ConnectParams {
+	minConnectTimeout := cfg.ConnectTimeout
+	if minConnectTimeout <= 0 {
+		minConnectTimeout = defaultGRPCConnectTimeout
+	}
+
+	return grpc.ConnectParams{
+		Backoff:           cfg.ReconnectBackoff.backoffConfig(),
+		MinConnectTimeout: minConnectTimeout,
+	}
+}
+
+// ReconnectBackoffConfig configures the exponential backoff between the attempts to reconnect
+// to a store-gateway. The defaults are the gRPC ones, which are also used if the config is
+// the zero value, e.g. when its flags are not registered.
+type ReconnectBackoffConfig struct {
+	BaseDelay  time.Duration `yaml:"base_delay"`
+	Multiplier float64       `yaml:"multiplier"`
+	Jitter     float64       `yaml:"jitter"`
+	MaxDelay   time.Duration `yaml:"max_delay"`
+}
+
+func (cfg *ReconnectBackoffConfig) RegisterFlagsWithPrefix(prefix string, f *flag.FlagSet) {
+	f.DurationVar(&cfg.BaseDelay, prefix+".reconnect-backoff.base-delay", backoff.DefaultConfig.BaseDelay, "The delay before the first attempt to reconnect to a store-gateway, after a connection failure.")
+	f.Float64Var(&cfg.Multiplier, prefix+".reconnect-backoff.multiplier", backoff.DefaultConfig.Multiplier, "The factor the delay between the attempts to reconnect to a store-gateway is multiplied by after each failed attempt.")
+	f.Float64Var(&cfg.Jitter, prefix+".reconnect-backoff.jitter", backoff.DefaultConfig.Jitter, "The fraction, in the range [0, 1], by which the delay between the attempts to reconnect to a store-gateway is randomized, to spread the reconnections of many queriers.")
+	f.DurationVar(&cfg.MaxDelay, prefix+".reconnect-backoff.max-delay", backoff.DefaultConfig.MaxDelay, "The maximum delay between the attempts to reconnect to a store-gateway.")
+}
+
+func (cfg *ReconnectBackoffConfig) Validate() error {
+	if *cfg == (ReconnectBackoffConfig{}) {
+		return nil
+	}
+	if cfg.BaseDelay <= 0 {
+		return errInvalidReconnectBackoffBaseDelay
+	}
+	if cfg.Multiplier < 1 {
+		return errInvalidReconnectBackoffMultiplier
+	}
+	if cfg.Jitter < 0 || cfg.Jitter > 1 {
+		return errInvalidReconnectBackoffJitter
+	}
+	if cfg.MaxDelay < cfg.BaseDelay {
+		return errInvalidReconnectBackoffMaxDelay
+	}
+	return nil
+}
+
+func (cfg *ReconnectBackoffConfig) backoffConfig() backoff.Config {
+	if *cfg == (ReconnectBackoffConfig{}) {
+		return backoff.DefaultConfig
+	}
+
+	return backoff.Config{
+		BaseDelay:  cfg.BaseDelay,
+		Multiplier: cfg.Multiplier,
+		Jitter:     cfg.Jitter,
+		MaxDelay:   cfg.MaxDelay,
+	}
 }
@@ -0,0 +1,74 @@
+package querier
+
+import (
+	"context"
+	"strings"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+const (
+	// queriedBlocksHeader is the gRPC metadata header listing the IDs of the blocks the
+	// querier expects to query, as injected in the context with InjectBlocksIntoContext.
+	queriedBlocksHeader = "x-cortex-queried-blocks"
+
+	// queryIDHeader is the gRPC metadata header carrying the ID of the query, as injected in
+	// the context with InjectQueryID.
+	queryIDHeader = "x-cortex-query-id"
+
+	storeGatewayMethodPrefix = "/gatewaypb.StoreGateway/"
+)
+
+// queriedBlocksUnaryClientInterceptor forwards the blocks injected in the context to the
+// store-gateways, as a comma-separated list of block IDs in the queriedBlocksHeader.
+func queriedBlocksUnaryClientInterceptor(ctx context.Context, method string, req, reply any, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+	return invoker(injectQueriedBlocksHeader(ctx, method), method, req, reply, cc, opts...)
+}
+
+// queriedBlocksStreamClientInterceptor is like queriedBlocksUnaryClientInterceptor, for
+// the streaming requests.
+func queriedBlocksStreamClientInterceptor(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, streamer grpc.Streamer, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+	return streamer(injectQueriedBlocksHeader(ctx, method), desc, cc, method, opts...)
+}
+
+func injectQueriedBlocksHeader(ctx context.Context, method string) context.Context {
+	if !strings.HasPrefix(method, storeGatewayMethodPrefix) {
+		return ctx
+	}
+
+	blocks, ok := ExtractBlocksFromContext(ctx)
+	if !ok || len(blocks) == 0 {
+		return ctx
+	}
+
+	ids := make([]string, 0, len(blocks))
+	for _, b := range blocks {
+		ids = append(ids, b.ID.String())
+	}
+	return metadata.AppendToOutgoingContext(ctx, queriedBlocksHeader, strings.Join(ids, ","))
+}
+
+// queryIDUnaryClientInterceptor forwards the query ID injected in the context to the
+// store-gateways, in the queryIDHeader.
+func queryIDUnaryClientInterceptor(ctx context.Context, method string, req, reply any, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+	return invoker(injectQueryIDHeader(ctx, method), method, req, reply, cc, opts...)
+}
+
+// queryIDStreamClientInterceptor is like queryIDUnaryClientInterceptor, for the streaming
+// requests.
+func queryIDStreamClientInterceptor(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, streamer grpc.Streamer, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+	return streamer(injectQueryIDHeader(ctx, method), desc, cc, method, opts...)
+}
+
+func injectQueryIDHeader(ctx context.Context, method string) context.Context {
+	if !strings.HasPrefix(method, storeGatewayMethodPrefix) {
+		return ctx
+	}
+
+	queryID, ok := ExtractQueryID(ctx)
+	if !ok {
+		return ctx
+	}
+	return metadata.AppendToOutgoingContext(ctx, queryIDHeader, queryID)
+}
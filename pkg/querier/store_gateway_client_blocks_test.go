@@ -0,0 +1,224 @@
+package querier
+
+import (
+	"context"
+	"flag"
+	"net"
+	"testing"
+
+	"github.com/go-kit/log"
+	"github.com/oklog/ulid/v2"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"github.com/thanos-io/thanos/pkg/store/storepb"
+	"github.com/weaveworks/common/user"
+	"go.uber.org/atomic"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+
+	"github.com/cortexproject/cortex/pkg/storage/tsdb/bucketindex"
+	"github.com/cortexproject/cortex/pkg/storegateway/storegatewaypb"
+	"github.com/cortexproject/cortex/pkg/util/flagext"
+	"github.com/cortexproject/cortex/pkg/util/grpcclient"
+	"github.com/cortexproject/cortex/pkg/util/limiter"
+	"github.com/cortexproject/cortex/pkg/util/requestmeta"
+)
+
+func Test_newStoreGatewayClientFactory_ShouldForwardQueriedBlocks(t *testing.T) {
+	t.Parallel()
+
+	block1 := ulid.MustNew(1, nil)
+	block2 := ulid.MustNew(2, nil)
+
+	tests := map[string]struct {
+		enabled        bool
+		blocks         []*bucketindex.Block
+		expectedHeader string
+	}{
+		"should not forward the blocks if disabled": {
+			enabled: false,
+			blocks:  []*bucketindex.Block{{ID: block1}},
+		},
+		"should not send the header if no blocks have been injected": {
+			enabled: true,
+		},
+		"should forward the injected blocks if enabled": {
+			enabled:        true,
+			blocks:         []*bucketindex.Block{{ID: block1}, {ID: block2}},
+			expectedHeader: block1.String() + "," + block2.String(),
+		},
+	}
+
+	for testName, testData := range tests {
+		t.Run(testName, func(t *testing.T) {
+			t.Parallel()
+
+			srv := &metadataStoreGatewayServer{header: queriedBlocksHeader}
+			grpcServer := grpc.NewServer()
+			t.Cleanup(grpcServer.GracefulStop)
+			storegatewaypb.RegisterStoreGatewayServer(grpcServer, srv)
+
+			listener, err := net.Listen("tcp", "localhost:0")
+			require.NoError(t, err)
+
+			go func() {
+				require.NoError(t, grpcServer.Serve(listener))
+			}()
+
+			clientConfig := ClientConfig{}
+			clientConfig.RegisterFlagsWithPrefix("test", flag.NewFlagSet("test", flag.PanicOnError))
+			clientConfig.ForwardQueriedBlocks = testData.enabled
+
+			cfg := grpcclient.ConfigWithHealthCheck{}
+			flagext.DefaultValues(&cfg)
+
+			factory := newStoreGatewayClientFactory(cfg, clientConfig, newInflightRequests(), nil, prometheus.NewPedanticRegistry())
+			client, err := factory(listener.Addr().String())
+			require.NoError(t, err)
+			defer client.Close() //nolint:errcheck
+
+			ctx := user.InjectOrgID(context.Background(), "test")
+			if testData.blocks != nil {
+				ctx = InjectBlocksIntoContext(ctx, testData.blocks...)
+			}
+
+			_, err = client.(BlocksStoreClient).LabelNames(ctx, &storepb.LabelNamesRequest{})
+			require.NoError(t, err)
+			assert.Equal(t, testData.expectedHeader, srv.labelNamesHeader.Load())
+
+			stream, err := client.(BlocksStoreClient).Series(ctx, &storepb.SeriesRequest{})
+			require.NoError(t, err)
+			_, _ = stream.Recv()
+			assert.Equal(t, testData.expectedHeader, srv.seriesHeader.Load())
+		})
+	}
+}
+
+func Test_newStoreGatewayClientFactory_ShouldForwardQueryID(t *testing.T) {
+	t.Parallel()
+
+	srv := &metadataStoreGatewayServer{header: queryIDHeader}
+	addr := startStoreGatewayServer(t, srv)
+
+	clientConfig := ClientConfig{}
+	clientConfig.RegisterFlagsWithPrefix("test", flag.NewFlagSet("test", flag.PanicOnError))
+
+	cfg := grpcclient.ConfigWithHealthCheck{}
+	flagext.DefaultValues(&cfg)
+
+	factory := newStoreGatewayClientFactory(cfg, clientConfig, newInflightRequests(), nil, prometheus.NewPedanticRegistry())
+	client, err := factory(addr)
+	require.NoError(t, err)
+	defer client.Close() //nolint:errcheck
+
+	// No header is sent if no query ID has been injected.
+	ctx := user.InjectOrgID(context.Background(), "test")
+	_, err = client.(BlocksStoreClient).LabelNames(ctx, &storepb.LabelNamesRequest{})
+	require.NoError(t, err)
+	assert.Empty(t, srv.labelNamesHeader.Load())
+
+	ctx = InjectQueryID(ctx, "query-1")
+	_, err = client.(BlocksStoreClient).LabelNames(ctx, &storepb.LabelNamesRequest{})
+	require.NoError(t, err)
+	assert.Equal(t, "query-1", srv.labelNamesHeader.Load())
+
+	stream, err := client.(BlocksStoreClient).Series(ctx, &storepb.SeriesRequest{})
+	require.NoError(t, err)
+	_, _ = stream.Recv()
+	assert.Equal(t, "query-1", srv.seriesHeader.Load())
+}
+
+func TestBlocksStoreQuerier_Select_ShouldForwardTheRequestIDAsQueryID(t *testing.T) {
+	t.Parallel()
+
+	const (
+		minT = int64(10)
+		maxT = int64(20)
+	)
+
+	block1 := ulid.MustNew(1, nil)
+	srv := &hintsStoreGatewayServer{metadataStoreGatewayServer: metadataStoreGatewayServer{header: queryIDHeader}, blocks: []ulid.ULID{block1}}
+	addr := startStoreGatewayServer(t, srv)
+
+	clientConfig := ClientConfig{}
+	clientConfig.RegisterFlagsWithPrefix("test", flag.NewFlagSet("test", flag.PanicOnError))
+
+	cfg := grpcclient.ConfigWithHealthCheck{}
+	flagext.DefaultValues(&cfg)
+
+	factory := newStoreGatewayClientFactory(cfg, clientConfig, newInflightRequests(), nil, prometheus.NewPedanticRegistry())
+	client, err := factory(addr)
+	require.NoError(t, err)
+	defer client.Close() //nolint:errcheck
+
+	finder := &blocksFinderMock{}
+	finder.On("GetBlocks", mock.Anything, "user-1", minT, maxT, mock.Anything).Return(bucketindex.Blocks{
+		&bucketindex.Block{ID: block1},
+	}, map[ulid.ULID]*bucketindex.BlockDeletionMark(nil), nil)
+
+	q := &blocksStoreQuerier{
+		minT:        minT,
+		maxT:        maxT,
+		finder:      finder,
+		stores:      &blocksStoreSetMock{mockedResponses: []any{map[BlocksStoreClient][]ulid.ULID{client.(BlocksStoreClient): {block1}}}},
+		consistency: NewBlocksConsistencyChecker(0, 0, log.NewNopLogger(), nil),
+		logger:      log.NewNopLogger(),
+		metrics:     newBlocksStoreQueryableMetrics(prometheus.NewPedanticRegistry()),
+		limits:      &blocksStoreLimitsMock{},
+
+		storeGatewayConsistencyCheckMaxAttempts: 1,
+	}
+
+	ctx := user.InjectOrgID(context.Background(), "user-1")
+	ctx = limiter.AddQueryLimiterToContext(ctx, limiter.NewQueryLimiter(0, 0, 0, 0))
+	ctx = requestmeta.ContextWithRequestId(ctx, "request-1")
+
+	set := q.Select(ctx, true, nil, labels.MustNewMatcher(labels.MatchEqual, labels.MetricName, "test_metric"))
+	require.NoError(t, set.Err())
+	assert.Equal(t, "request-1", srv.seriesHeader.Load())
+}
+
+// hintsStoreGatewayServer is a metadataStoreGatewayServer returning the hints of the given
+// queried blocks from the Series requests.
+type hintsStoreGatewayServer struct {
+	metadataStoreGatewayServer
+
+	blocks []ulid.ULID
+}
+
+func (m *hintsStoreGatewayServer) Series(req *storepb.SeriesRequest, srv storegatewaypb.StoreGateway_SeriesServer) error {
+	if err := m.metadataStoreGatewayServer.Series(req, srv); err != nil {
+		return err
+	}
+	return srv.Send(mockHintsResponse(m.blocks...))
+}
+
+// metadataStoreGatewayServer records the given metadata header of the last requests.
+type metadataStoreGatewayServer struct {
+	mockStoreGatewayServer
+
+	header           string
+	labelNamesHeader atomic.String
+	seriesHeader     atomic.String
+}
+
+func (m *metadataStoreGatewayServer) Series(_ *storepb.SeriesRequest, srv storegatewaypb.StoreGateway_SeriesServer) error {
+	m.seriesHeader.Store(headerFromIncomingContext(srv.Context(), m.header))
+	return nil
+}
+
+func (m *metadataStoreGatewayServer) LabelNames(ctx context.Context, _ *storepb.LabelNamesRequest) (*storepb.LabelNamesResponse, error) {
+	m.labelNamesHeader.Store(headerFromIncomingContext(ctx, m.header))
+	return &storepb.LabelNamesResponse{}, nil
+}
+
+func headerFromIncomingContext(ctx context.Context, header string) string {
+	md, _ := metadata.FromIncomingContext(ctx)
+	if values := md.Get(header); len(values) > 0 {
+		return values[0]
+	}
+	return ""
+}
@@ -0,0 +1,261 @@
+package querier
+
+import (
+	"context"
+	"flag"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/thanos-io/thanos/pkg/store/storepb"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/cortexproject/cortex/pkg/storegateway/storegatewaypb"
+)
+
+var (
+	errInvalidCircuitBreakerFailureThreshold = errors.New("store gateway client circuit breaker failure threshold should be greater than 0")
+	errInvalidCircuitBreakerCooldown         = errors.New("store gateway client circuit breaker cooldown should be greater than 0")
+)
+
+// circuitBreakerState is the state of a circuit breaker, as exported by the state metric.
+type circuitBreakerState int
+
+const (
+	circuitBreakerClosed circuitBreakerState = iota
+	circuitBreakerOpen
+	circuitBreakerHalfOpen
+)
+
+// CircuitBreakerConfig configures the per store-gateway circuit breaker.
+type CircuitBreakerConfig struct {
+	Enabled          bool          `yaml:"enabled"`
+	FailureThreshold int           `yaml:"failure_threshold"`
+	Cooldown         time.Duration `yaml:"cooldown"`
+}
+
+func (cfg *CircuitBreakerConfig) RegisterFlagsWithPrefix(prefix string, f *flag.FlagSet) {
+	f.BoolVar(&cfg.Enabled, prefix+".circuit-breaker.enabled", false, "True to enable a circuit breaker for each store-gateway. While open, the requests to the store-gateway fail immediately and are retried on other store-gateways.")
+	f.IntVar(&cfg.FailureThreshold, prefix+".circuit-breaker.failure-threshold", 5, "The number of consecutive failed requests to a store-gateway after which its circuit breaker opens.")
+	f.DurationVar(&cfg.Cooldown, prefix+".circuit-breaker.cooldown", 10*time.Second, "How long the circuit breaker stays open before letting a single request through to check if the store-gateway has recovered.")
+}
+
+func (cfg *CircuitBreakerConfig) Validate() error {
+	if !cfg.Enabled {
+		return nil
+	}
+	if cfg.FailureThreshold <= 0 {
+		return errInvalidCircuitBreakerFailureThreshold
+	}
+	if cfg.Cooldown <= 0 {
+		return errInvalidCircuitBreakerCooldown
+	}
+	return nil
+}
+
+func newCircuitBreakerStateMetric(reg prometheus.Registerer) *prometheus.GaugeVec {
+	return promauto.With(reg).NewGaugeVec(prometheus.GaugeOpts{
+		Namespace:   "cortex",
+		Name:        "storegateway_client_circuit_breaker_state",
+		Help:        "The state of the circuit breaker of each store-gateway (0: closed, 1: open, 2: half-open).",
+		ConstLabels: prometheus.Labels{"client": "querier"},
+	}, []string{"address"})
+}
+
+// circuitBreaker tracks the consecutive failed requests to a single store-gateway.
+type circuitBreaker struct {
+	cfg         CircuitBreakerConfig
+	stateMetric prometheus.Gauge
+
+	mtx      sync.Mutex
+	state    circuitBreakerState
+	failures int
+	openedAt time.Time
+	// probe identifies the request let through by the half-open breaker, if any, 0 otherwise.
+	// Only its outcome changes the state of the breaker while it isn't closed.
+	probe          uint64
+	lastProbe      uint64
+	probeStartedAt time.Time
+}
+
+func newCircuitBreaker(cfg CircuitBreakerConfig, stateMetric prometheus.Gauge) *circuitBreaker {
+	stateMetric.Set(float64(circuitBreakerClosed))
+
+	return &circuitBreaker{
+		cfg:         cfg,
+		stateMetric: stateMetric,
+	}
+}
+
+// allow returns whether a request can be issued and, if it's the probe of a half-open
+// breaker, its identifier, 0 otherwise. Once the cooldown has elapsed, the open breaker
+// turns half-open and lets a single request through.
+func (b *circuitBreaker) allow() (uint64, bool) {
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+
+	switch b.state {
+	case circuitBreakerOpen:
+		if time.Since(b.openedAt) < b.cfg.Cooldown {
+			return 0, false
+		}
+		b.setState(circuitBreakerHalfOpen)
+	case circuitBreakerHalfOpen:
+		// A probe whose outcome is never recorded (e.g. a stream not fully consumed)
+		// shouldn't keep the breaker half-open forever.
+		if b.probe != 0 && time.Since(b.probeStartedAt) < b.cfg.Cooldown {
+			return 0, false
+		}
+	default:
+		return 0, true
+	}
+
+	b.lastProbe++
+	b.probe = b.lastProbe
+	b.probeStartedAt = time.Now()
+	return b.probe, true
+}
+
+// done records the outcome of a request allowed by allow, given the probe identifier
+// returned by allow.
+func (b *circuitBreaker) done(probe uint64, err error) {
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+
+	// The requests allowed before the breaker opened, and the probes given up on, may
+	// complete at any time: only the outcome of the current probe is recorded.
+	if b.state != circuitBreakerClosed {
+		if probe == 0 || probe != b.probe {
+			return
+		}
+		b.probe = 0
+	}
+
+	if !isCircuitBreakerFailure(err) {
+		b.failures = 0
+		b.setState(circuitBreakerClosed)
+		return
+	}
+
+	b.failures++
+	if b.state == circuitBreakerHalfOpen || b.failures >= b.cfg.FailureThreshold {
+		b.openedAt = time.Now()
+		b.setState(circuitBreakerOpen)
+	}
+}
+
+func (b *circuitBreaker) setState(state circuitBreakerState) {
+	b.state = state
+	b.stateMetric.Set(float64(state))
+}
+
+// isCircuitBreakerFailure returns whether the error means the store-gateway is unhealthy.
+// Errors caused by the request itself, or by the caller canceling it, are not failures.
+func isCircuitBreakerFailure(err error) bool {
+	if err == nil || errors.Is(err, context.Canceled) {
+		return false
+	}
+
+	switch status.Code(err) {
+	case codes.Unavailable, codes.DeadlineExceeded, codes.ResourceExhausted:
+		return true
+	default:
+		return false
+	}
+}
+
+// circuitBreakerClient is a store-gateway client failing the requests fast while its
+// circuit breaker is open. The returned error is Unavailable, so that the querier
+// retries the request on another store-gateway.
+type circuitBreakerClient struct {
+	*storeGatewayClient
+
+	breaker     *circuitBreaker
+	stateMetric *prometheus.GaugeVec
+}
+
+func newCircuitBreakerClient(c *storeGatewayClient, cfg CircuitBreakerConfig, stateMetric *prometheus.GaugeVec) *circuitBreakerClient {
+	return &circuitBreakerClient{
+		storeGatewayClient: c,
+		breaker:            newCircuitBreaker(cfg, stateMetric.WithLabelValues(c.RemoteAddress())),
+		stateMetric:        stateMetric,
+	}
+}
+
+func (c *circuitBreakerClient) errOpen() error {
+	return status.Errorf(codes.Unavailable, "circuit breaker open for store-gateway %s", c.RemoteAddress())
+}
+
+func (c *circuitBreakerClient) Series(ctx context.Context, in *storepb.SeriesRequest, opts ...grpc.CallOption) (storegatewaypb.StoreGateway_SeriesClient, error) {
+	probe, ok := c.breaker.allow()
+	if !ok {
+		return nil, c.errOpen()
+	}
+
+	stream, err := c.storeGatewayClient.Series(ctx, in, opts...)
+	if err != nil {
+		c.breaker.done(probe, err)
+		return nil, err
+	}
+	return &circuitBreakerSeriesClient{StoreGateway_SeriesClient: stream, breaker: c.breaker, probe: probe}, nil
+}
+
+func (c *circuitBreakerClient) LabelNames(ctx context.Context, in *storepb.LabelNamesRequest, opts ...grpc.CallOption) (*storepb.LabelNamesResponse, error) {
+	probe, ok := c.breaker.allow()
+	if !ok {
+		return nil, c.errOpen()
+	}
+
+	resp, err := c.storeGatewayClient.LabelNames(ctx, in, opts...)
+	c.breaker.done(probe, err)
+	return resp, err
+}
+
+func (c *circuitBreakerClient) LabelValues(ctx context.Context, in *storepb.LabelValuesRequest, opts ...grpc.CallOption) (*storepb.LabelValuesResponse, error) {
+	probe, ok := c.breaker.allow()
+	if !ok {
+		return nil, c.errOpen()
+	}
+
+	resp, err := c.storeGatewayClient.LabelValues(ctx, in, opts...)
+	c.breaker.done(probe, err)
+	return resp, err
+}
+
+func (c *circuitBreakerClient) Close() error {
+	c.stateMetric.DeleteLabelValues(c.RemoteAddress())
+	return c.storeGatewayClient.Close()
+}
+
+func (c *circuitBreakerClient) CloseGracefully(ctx context.Context) error {
+	c.stateMetric.DeleteLabelValues(c.RemoteAddress())
+	return c.storeGatewayClient.CloseGracefully(ctx)
+}
+
+// circuitBreakerSeriesClient records the outcome of the Series request once the stream ends.
+type circuitBreakerSeriesClient struct {
+	storegatewaypb.StoreGateway_SeriesClient
+
+	breaker *circuitBreaker
+	probe   uint64
+	once    sync.Once
+}
+
+func (s *circuitBreakerSeriesClient) Recv() (*storepb.SeriesResponse, error) {
+	resp, err := s.StoreGateway_SeriesClient.Recv()
+	if err != nil {
+		s.once.Do(func() {
+			if errors.Is(err, io.EOF) {
+				s.breaker.done(s.probe, nil)
+			} else {
+				s.breaker.done(s.probe, err)
+			}
+		})
+	}
+	return resp, err
+}
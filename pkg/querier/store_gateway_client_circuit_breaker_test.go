@@ -0,0 +1,166 @@
+package querier
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/thanos-io/thanos/pkg/store/storepb"
+	"github.com/weaveworks/common/user"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/cortexproject/cortex/pkg/storegateway/storegatewaypb"
+	"github.com/cortexproject/cortex/pkg/util/flagext"
+	"github.com/cortexproject/cortex/pkg/util/grpcclient"
+)
+
+func TestCircuitBreakerClient(t *testing.T) {
+	t.Parallel()
+
+	const cooldown = 200 * time.Millisecond
+
+	srv := &flakyStoreGatewayServer{failures: 3, failureCode: codes.Unavailable}
+
+	grpcServer := grpc.NewServer()
+	t.Cleanup(grpcServer.GracefulStop)
+	storegatewaypb.RegisterStoreGatewayServer(grpcServer, srv)
+
+	listener, err := net.Listen("tcp", "localhost:0")
+	require.NoError(t, err)
+
+	go func() {
+		require.NoError(t, grpcServer.Serve(listener))
+	}()
+
+	cfg := grpcclient.ConfigWithHealthCheck{}
+	flagext.DefaultValues(&cfg)
+
+	reg := prometheus.NewPedanticRegistry()
+	factory := newStoreGatewayClientFactory(cfg, ClientConfig{
+		TracingSampleRate: 1,
+		CircuitBreaker:    CircuitBreakerConfig{Enabled: true, FailureThreshold: 2, Cooldown: cooldown},
+	}, newInflightRequests(), nil, reg)
+	client, err := factory(listener.Addr().String())
+	require.NoError(t, err)
+
+	ctx := user.InjectOrgID(context.Background(), "test")
+	labelNames := func() error {
+		_, err := client.(BlocksStoreClient).LabelNames(ctx, &storepb.LabelNamesRequest{})
+		return err
+	}
+	assertState := func(expected circuitBreakerState) {
+		assert.Equal(t, float64(expected), testutil.ToFloat64(client.(*circuitBreakerClient).breaker.stateMetric))
+	}
+
+	// The breaker opens after the consecutive failures threshold.
+	require.Error(t, labelNames())
+	assertState(circuitBreakerClosed)
+	require.Error(t, labelNames())
+	assertState(circuitBreakerOpen)
+	require.Equal(t, int32(2), srv.requests.Load())
+
+	// While open, requests fail fast with a retryable error.
+	err = labelNames()
+	require.Equal(t, codes.Unavailable, status.Code(err))
+	require.Contains(t, err.Error(), "circuit breaker open")
+	require.True(t, isRetryableError(err))
+	require.Equal(t, int32(2), srv.requests.Load())
+
+	// After the cooldown a single request goes through and, failing, opens the breaker again.
+	time.Sleep(cooldown)
+	require.Error(t, labelNames())
+	require.Equal(t, int32(3), srv.requests.Load())
+	assertState(circuitBreakerOpen)
+
+	// Once the store-gateway has recovered, the breaker closes.
+	time.Sleep(cooldown)
+	require.NoError(t, labelNames())
+	require.Equal(t, int32(4), srv.requests.Load())
+	assertState(circuitBreakerClosed)
+
+	require.NoError(t, testutil.GatherAndCompare(reg, strings.NewReader(`
+		# HELP cortex_storegateway_client_circuit_breaker_state The state of the circuit breaker of each store-gateway (0: closed, 1: open, 2: half-open).
+		# TYPE cortex_storegateway_client_circuit_breaker_state gauge
+		cortex_storegateway_client_circuit_breaker_state{address="`+listener.Addr().String()+`",client="querier"} 0
+	`), "cortex_storegateway_client_circuit_breaker_state"))
+
+	// Closing the client removes its state.
+	require.NoError(t, client.Close())
+	require.NoError(t, testutil.GatherAndCompare(reg, strings.NewReader(""), "cortex_storegateway_client_circuit_breaker_state"))
+}
+
+func TestCircuitBreaker_ShouldIgnoreNonFailureErrors(t *testing.T) {
+	t.Parallel()
+
+	breaker := newCircuitBreaker(CircuitBreakerConfig{Enabled: true, FailureThreshold: 1, Cooldown: time.Minute}, prometheus.NewGauge(prometheus.GaugeOpts{Name: "test"}))
+
+	for _, err := range []error{
+		context.Canceled,
+		status.Error(codes.Canceled, "canceled"),
+		status.Error(codes.PermissionDenied, "denied"),
+		errors.New("not a grpc error"),
+	} {
+		probe, ok := breaker.allow()
+		require.True(t, ok)
+		breaker.done(probe, err)
+	}
+	assert.Equal(t, circuitBreakerClosed, breaker.state)
+
+	probe, ok := breaker.allow()
+	require.True(t, ok)
+	breaker.done(probe, status.Error(codes.Unavailable, "unavailable"))
+	assert.Equal(t, circuitBreakerOpen, breaker.state)
+	_, ok = breaker.allow()
+	assert.False(t, ok)
+}
+
+func TestCircuitBreaker_ShouldOnlyRecordTheProbeOutcomeWhileHalfOpen(t *testing.T) {
+	t.Parallel()
+
+	const cooldown = 100 * time.Millisecond
+
+	breaker := newCircuitBreaker(CircuitBreakerConfig{Enabled: true, FailureThreshold: 1, Cooldown: cooldown}, prometheus.NewGauge(prometheus.GaugeOpts{Name: "test"}))
+
+	// A request issued while the breaker is closed completes after it has opened.
+	late, ok := breaker.allow()
+	require.True(t, ok)
+	probe, ok := breaker.allow()
+	require.True(t, ok)
+	breaker.done(probe, status.Error(codes.Unavailable, "unavailable"))
+	require.Equal(t, circuitBreakerOpen, breaker.state)
+
+	time.Sleep(cooldown)
+	probe, ok = breaker.allow()
+	require.True(t, ok)
+	require.NotZero(t, probe)
+	require.Equal(t, circuitBreakerHalfOpen, breaker.state)
+
+	// The outcome of the late request doesn't let another probe through.
+	breaker.done(late, nil)
+	assert.Equal(t, circuitBreakerHalfOpen, breaker.state)
+	_, ok = breaker.allow()
+	assert.False(t, ok)
+
+	// The probe, given up on after the cooldown, is replaced by another one, and its late
+	// outcome is ignored too.
+	time.Sleep(cooldown)
+	next, ok := breaker.allow()
+	require.True(t, ok)
+	breaker.done(probe, nil)
+	assert.Equal(t, circuitBreakerHalfOpen, breaker.state)
+	_, ok = breaker.allow()
+	assert.False(t, ok)
+
+	// Only the outcome of the current probe closes the breaker.
+	breaker.done(next, nil)
+	assert.Equal(t, circuitBreakerClosed, breaker.state)
+}
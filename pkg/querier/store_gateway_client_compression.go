@@ -0,0 +1,151 @@
+package querier
+
+import (
+	"context"
+	"strconv"
+
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+const storeGatewaySeriesMethod = storeGatewayMethodPrefix + "Series"
+
+// errStreamNotStarted is returned by the streams whose underlying stream is only created
+// once the request is sent, if used before.
+var errStreamNotStarted = errors.New("stream not started: the request has not been sent yet")
+
+// seriesCompressionStreamClientInterceptor compresses the Series requests, and their
+// responses, with the given compressor. It's used instead of the dial-wide compression to
+// leave the other requests, whose responses are usually small, uncompressed.
+func seriesCompressionStreamClientInterceptor(compressor string) grpc.StreamClientInterceptor {
+	return func(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, streamer grpc.Streamer, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+		if method == storeGatewaySeriesMethod {
+			opts = append(opts, grpc.UseCompressor(compressor))
+		}
+		return streamer(ctx, desc, cc, method, opts...)
+	}
+}
+
+// requestSizeCompression compresses the requests, and their responses, whose serialized size
+// is greater than minSize with the given compressor. It's used instead of the dial-wide
+// compression not to waste CPU compressing small requests.
+type requestSizeCompression struct {
+	compressor string
+	minSize    int
+	// seriesOnly is true to only compress the Series requests.
+	seriesOnly bool
+
+	decisions *prometheus.CounterVec
+}
+
+func newRequestSizeCompression(compressor string, minSize int, seriesOnly bool, reg prometheus.Registerer) *requestSizeCompression {
+	return &requestSizeCompression{
+		compressor: compressor,
+		minSize:    minSize,
+		seriesOnly: seriesOnly,
+		decisions: promauto.With(reg).NewCounterVec(prometheus.CounterOpts{
+			Namespace:   "cortex",
+			Name:        "storegateway_client_compression_decisions_total",
+			Help:        "Total number of requests to store-gateways for which the compression has been decided based on the request size, by whether they've been compressed.",
+			ConstLabels: prometheus.Labels{"client": "querier"},
+		}, []string{"compressed"}),
+	}
+}
+
+// compress returns whether the given request should be compressed. The requests whose size
+// can't be computed are not compressed.
+func (c *requestSizeCompression) compress(req any) bool {
+	sizer, ok := req.(interface{ Size() int })
+	compressed := ok && sizer.Size() > c.minSize
+	c.decisions.WithLabelValues(strconv.FormatBool(compressed)).Inc()
+	return compressed
+}
+
+func (c *requestSizeCompression) UnaryClientInterceptor(ctx context.Context, method string, req, reply any, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+	if !c.seriesOnly && c.compress(req) {
+		opts = append(opts, grpc.UseCompressor(c.compressor))
+	}
+	return invoker(ctx, method, req, reply, cc, opts...)
+}
+
+// StreamClientInterceptor defers the creation of the stream until its request is sent, since
+// the compressor can only be chosen when the stream is created.
+func (c *requestSizeCompression) StreamClientInterceptor(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, streamer grpc.Streamer, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+	if c.seriesOnly && method != storeGatewaySeriesMethod {
+		return streamer(ctx, desc, cc, method, opts...)
+	}
+
+	return &requestSizeCompressionClientStream{
+		compression: c,
+		ctx:         ctx,
+		open: func(opts ...grpc.CallOption) (grpc.ClientStream, error) {
+			return streamer(ctx, desc, cc, method, opts...)
+		},
+		opts: opts,
+	}, nil
+}
+
+// requestSizeCompressionClientStream creates the underlying stream when the first request is
+// sent, compressed depending on the request size.
+type requestSizeCompressionClientStream struct {
+	grpc.ClientStream
+
+	compression *requestSizeCompression
+	ctx         context.Context
+	open        func(opts ...grpc.CallOption) (grpc.ClientStream, error)
+	opts        []grpc.CallOption
+}
+
+func (s *requestSizeCompressionClientStream) SendMsg(m any) error {
+	if s.ClientStream == nil {
+		opts := s.opts
+		if s.compression.compress(m) {
+			opts = append(opts, grpc.UseCompressor(s.compression.compressor))
+		}
+
+		stream, err := s.open(opts...)
+		if err != nil {
+			return err
+		}
+		s.ClientStream = stream
+	}
+	return s.ClientStream.SendMsg(m)
+}
+
+func (s *requestSizeCompressionClientStream) RecvMsg(m any) error {
+	if s.ClientStream == nil {
+		return errStreamNotStarted
+	}
+	return s.ClientStream.RecvMsg(m)
+}
+
+func (s *requestSizeCompressionClientStream) CloseSend() error {
+	if s.ClientStream == nil {
+		return errStreamNotStarted
+	}
+	return s.ClientStream.CloseSend()
+}
+
+func (s *requestSizeCompressionClientStream) Header() (metadata.MD, error) {
+	if s.ClientStream == nil {
+		return nil, errStreamNotStarted
+	}
+	return s.ClientStream.Header()
+}
+
+func (s *requestSizeCompressionClientStream) Trailer() metadata.MD {
+	if s.ClientStream == nil {
+		return nil
+	}
+	return s.ClientStream.Trailer()
+}
+
+func (s *requestSizeCompressionClientStream) Context() context.Context {
+	if s.ClientStream == nil {
+		return s.ctx
+	}
+	return s.ClientStream.Context()
+}
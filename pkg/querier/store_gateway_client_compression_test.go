@@ -0,0 +1,235 @@
+package querier
+
+import (
+	"context"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/thanos-io/thanos/pkg/store/labelpb"
+	"github.com/thanos-io/thanos/pkg/store/storepb"
+	"github.com/weaveworks/common/user"
+	"google.golang.org/grpc"
+
+	"github.com/cortexproject/cortex/pkg/storegateway/storegatewaypb"
+	"github.com/cortexproject/cortex/pkg/util/flagext"
+	"github.com/cortexproject/cortex/pkg/util/grpcclient"
+	"github.com/cortexproject/cortex/pkg/util/grpcencoding/zstd"
+)
+
+func Test_newStoreGatewayClientFactory_ShouldOnlyCompressSeriesRequests(t *testing.T) {
+	t.Parallel()
+
+	compression := &compressionStatsHandler{}
+	addr := startStoreGatewayServer(t, &seriesStoreGatewayServer{series: labels.FromStrings("__name__", "test")}, grpc.StatsHandler(compression))
+
+	cfg := grpcclient.ConfigWithHealthCheck{}
+	flagext.DefaultValues(&cfg)
+	cfg.GRPCCompression = zstd.Name
+
+	factory := newStoreGatewayClientFactory(cfg, ClientConfig{TracingSampleRate: 1, GRPCCompressionSeriesOnly: true}, newInflightRequests(), nil, prometheus.NewPedanticRegistry())
+	client, err := factory(addr)
+	require.NoError(t, err)
+	defer client.Close() //nolint:errcheck
+
+	ctx := user.InjectOrgID(context.Background(), "test")
+	_, err = client.(*storeGatewayClient).LabelNames(ctx, &storepb.LabelNamesRequest{})
+	require.NoError(t, err)
+	assert.Empty(t, compression.compression.Load())
+
+	stream, err := client.(*storeGatewayClient).Series(ctx, &storepb.SeriesRequest{})
+	require.NoError(t, err)
+	for {
+		if _, err := stream.Recv(); err != nil {
+			require.Equal(t, io.EOF, err)
+			break
+		}
+	}
+	assert.Equal(t, zstd.Name, compression.compression.Load())
+}
+
+func Test_newStoreGatewayClientFactory_ShouldOnlyCompressRequestsAboveMinSize(t *testing.T) {
+	t.Parallel()
+
+	compression := &compressionStatsHandler{}
+	addr := startStoreGatewayServer(t, &seriesStoreGatewayServer{series: labels.FromStrings("__name__", "test")}, grpc.StatsHandler(compression))
+
+	cfg := grpcclient.ConfigWithHealthCheck{}
+	flagext.DefaultValues(&cfg)
+	cfg.GRPCCompression = zstd.Name
+
+	reg := prometheus.NewPedanticRegistry()
+	factory := newStoreGatewayClientFactory(cfg, ClientConfig{TracingSampleRate: 1, GRPCCompressionMinRequestSize: 100}, newInflightRequests(), nil, reg)
+	client, err := factory(addr)
+	require.NoError(t, err)
+	defer client.Close() //nolint:errcheck
+
+	largeMatchers := []storepb.LabelMatcher{{Type: storepb.LabelMatcher_RE, Name: "instance", Value: strings.Repeat("instance|", 20)}}
+	ctx := user.InjectOrgID(context.Background(), "test")
+
+	series := func(req *storepb.SeriesRequest) {
+		stream, err := client.(*storeGatewayClient).Series(ctx, req)
+		require.NoError(t, err)
+		for {
+			if _, err := stream.Recv(); err != nil {
+				require.Equal(t, io.EOF, err)
+				break
+			}
+		}
+	}
+
+	_, err = client.(*storeGatewayClient).LabelNames(ctx, &storepb.LabelNamesRequest{})
+	require.NoError(t, err)
+	assert.Empty(t, compression.compression.Load())
+
+	_, err = client.(*storeGatewayClient).LabelNames(ctx, &storepb.LabelNamesRequest{Matchers: largeMatchers})
+	require.NoError(t, err)
+	assert.Equal(t, zstd.Name, compression.compression.Load())
+
+	series(&storepb.SeriesRequest{})
+	assert.Empty(t, compression.compression.Load())
+
+	series(&storepb.SeriesRequest{Matchers: largeMatchers})
+	assert.Equal(t, zstd.Name, compression.compression.Load())
+
+	assert.NoError(t, testutil.GatherAndCompare(reg, strings.NewReader(`
+		# HELP cortex_storegateway_client_compression_decisions_total Total number of requests to store-gateways for which the compression has been decided based on the request size, by whether they've been compressed.
+		# TYPE cortex_storegateway_client_compression_decisions_total counter
+		cortex_storegateway_client_compression_decisions_total{client="querier",compressed="false"} 2
+		cortex_storegateway_client_compression_decisions_total{client="querier",compressed="true"} 2
+	`), "cortex_storegateway_client_compression_decisions_total"))
+}
+
+func Test_newStoreGatewayClientFactory_ShouldRetryOpeningSeriesStreamWithRequestSizeCompression(t *testing.T) {
+	t.Parallel()
+
+	// Reserve an address, and refuse the connections to it until the server is restarted.
+	listener, err := net.Listen("tcp", "localhost:0")
+	require.NoError(t, err)
+	addr := listener.Addr().String()
+	require.NoError(t, listener.Close())
+
+	cfg := grpcclient.ConfigWithHealthCheck{}
+	flagext.DefaultValues(&cfg)
+	cfg.GRPCCompression = zstd.Name
+
+	reg := prometheus.NewPedanticRegistry()
+	clientCfg := ClientConfig{
+		TracingSampleRate:             1,
+		GRPCCompressionMinRequestSize: 1,
+		Retry:                         testRetryConfig(50 * time.Millisecond),
+		ReconnectBackoff:              ReconnectBackoffConfig{BaseDelay: 50 * time.Millisecond, Multiplier: 1, MaxDelay: 50 * time.Millisecond},
+	}
+	clientCfg.Retry.MaxRetries = 50
+
+	factory := newStoreGatewayClientFactory(cfg, clientCfg, newInflightRequests(), nil, reg)
+	client, err := factory(addr)
+	require.NoError(t, err)
+	t.Cleanup(func() { require.NoError(t, client.Close()) })
+
+	// Bring the store-gateway back while the stream is being opened.
+	grpcServer := grpc.NewServer()
+	t.Cleanup(grpcServer.GracefulStop)
+	storegatewaypb.RegisterStoreGatewayServer(grpcServer, &seriesStoreGatewayServer{series: labels.FromStrings("series", "1")})
+	go func() {
+		time.Sleep(300 * time.Millisecond)
+		if listener, err := net.Listen("tcp", addr); err == nil {
+			_ = grpcServer.Serve(listener)
+		}
+	}()
+
+	req := &storepb.SeriesRequest{Matchers: []storepb.LabelMatcher{{Type: storepb.LabelMatcher_EQ, Name: "series", Value: "1"}}}
+	stream, err := client.(*storeGatewayClient).Series(user.InjectOrgID(context.Background(), "test"), req)
+	require.NoError(t, err)
+	resp, err := stream.Recv()
+	require.NoError(t, err)
+	assert.Equal(t, labels.FromStrings("series", "1"), resp.GetSeries().PromLabels())
+	assert.Greater(t, countRetries(t, reg), uint64(0))
+}
+
+func BenchmarkStoreGatewayClient_Compression(b *testing.B) {
+	const labelValuesPerSeries = 10
+
+	addr := startStoreGatewayServer(b, &compressionBenchmarkServer{})
+
+	for name, seriesOnly := range map[string]bool{"dial-wide": false, "series-only": true} {
+		b.Run(name, func(b *testing.B) {
+			cfg := grpcclient.ConfigWithHealthCheck{}
+			flagext.DefaultValues(&cfg)
+			cfg.GRPCCompression = zstd.Name
+
+			factory := newStoreGatewayClientFactory(cfg, ClientConfig{GRPCCompressionSeriesOnly: seriesOnly}, newInflightRequests(), nil, prometheus.NewPedanticRegistry())
+			client, err := factory(addr)
+			require.NoError(b, err)
+			defer client.Close() //nolint:errcheck
+
+			c := client.(*storeGatewayClient)
+			ctx := user.InjectOrgID(context.Background(), "test")
+
+			b.ReportAllocs()
+			b.ResetTimer()
+
+			// A mixed workload of a Series request for every few LabelValues requests.
+			for n := 0; n < b.N; n++ {
+				stream, err := c.Series(ctx, &storepb.SeriesRequest{})
+				require.NoError(b, err)
+				for {
+					if _, err := stream.Recv(); err != nil {
+						require.Equal(b, io.EOF, err)
+						break
+					}
+				}
+
+				for i := 0; i < labelValuesPerSeries; i++ {
+					_, err := c.LabelValues(ctx, &storepb.LabelValuesRequest{Label: "job"})
+					require.NoError(b, err)
+				}
+			}
+		})
+	}
+}
+
+// compressionBenchmarkServer returns large Series responses and small LabelValues ones.
+type compressionBenchmarkServer struct {
+	mockStoreGatewayServer
+}
+
+func (m *compressionBenchmarkServer) Series(_ *storepb.SeriesRequest, srv storegatewaypb.StoreGateway_SeriesServer) error {
+	for i := 0; i < 100; i++ {
+		series := &storepb.Series{
+			Labels: labelpb.ZLabelsFromPromLabels(labels.FromStrings("__name__", "series", "i", strconv.Itoa(i))),
+			Chunks: []storepb.AggrChunk{{Raw: &storepb.Chunk{Type: storepb.Chunk_XOR, Data: make([]byte, 1024)}}},
+		}
+		if err := srv.Send(storepb.NewSeriesResponse(series)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (m *compressionBenchmarkServer) LabelValues(context.Context, *storepb.LabelValuesRequest) (*storepb.LabelValuesResponse, error) {
+	return &storepb.LabelValuesResponse{Values: []string{"api", "db", "web"}}, nil
+}
+
+func startStoreGatewayServer(t testing.TB, srv storegatewaypb.StoreGatewayServer, opts ...grpc.ServerOption) string {
+	grpcServer := grpc.NewServer(opts...)
+	t.Cleanup(grpcServer.GracefulStop)
+	storegatewaypb.RegisterStoreGatewayServer(grpcServer, srv)
+
+	listener, err := net.Listen("tcp", "localhost:0")
+	require.NoError(t, err)
+
+	go func() {
+		_ = grpcServer.Serve(listener)
+	}()
+
+	return listener.Addr().String()
+}
@@ -0,0 +1,78 @@
+package querier
+
+import (
+	"context"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/thanos-io/thanos/pkg/discovery/dns"
+	"github.com/thanos-io/thanos/pkg/extprom"
+
+	"github.com/cortexproject/cortex/pkg/util/services"
+)
+
+var errInvalidDNSRefreshInterval = errors.New("store gateway DNS refresh interval must be greater than 0")
+
+// DNSStoreGatewayPool is a pool of store-gateway clients whose addresses are discovered by
+// periodically resolving a DNS name, e.g. the one of a headless Kubernetes service, instead
+// of the ring. The clients of the addresses which are no longer resolved are removed by the
+// pool on its periodic check.
+type DNSStoreGatewayPool struct {
+	services.Service
+	*storeGatewayClientPool
+
+	dnsName  string
+	provider *dns.Provider
+	logger   log.Logger
+}
+
+// NewDNSStoreGatewayPool returns a pool of store-gateway clients for the addresses the A records
+// of dnsName resolve to, refreshed every refreshInterval. dnsName is in the host:port form, and
+// can be prefixed with any of the lookup schemes supported by the store-gateway addresses, e.g.
+// "dnssrv+". Without a scheme, it's resolved with an A lookup.
+func NewDNSStoreGatewayPool(dnsName string, refreshInterval time.Duration, clientConfig ClientConfig, logger log.Logger, reg prometheus.Registerer) (*DNSStoreGatewayPool, error) {
+	if refreshInterval <= 0 {
+		return nil, errInvalidDNSRefreshInterval
+	}
+	if !dns.IsDynamicNode(dnsName) {
+		dnsName = "dns+" + dnsName
+	}
+
+	p := &DNSStoreGatewayPool{
+		dnsName:  dnsName,
+		provider: dns.NewProvider(logger, extprom.WrapRegistererWithPrefix("cortex_storegateway_client_", reg), dns.GolangResolverType),
+		logger:   logger,
+	}
+	p.storeGatewayClientPool = newStoreGatewayClientPool(func() ([]string, error) {
+		return p.Addresses(), nil
+	}, clientConfig, logger, reg)
+	p.Service = services.NewTimerService(refreshInterval, p.starting, p.resolve, p.stopping)
+	return p, nil
+}
+
+func (p *DNSStoreGatewayPool) starting(ctx context.Context) error {
+	// Initial DNS resolution, so that the addresses are known once the pool is running.
+	if err := p.resolve(ctx); err != nil {
+		return err
+	}
+	return services.StartAndAwaitRunning(ctx, p.storeGatewayClientPool)
+}
+
+func (p *DNSStoreGatewayPool) resolve(ctx context.Context) error {
+	if err := p.provider.Resolve(ctx, []string{p.dnsName}, true); err != nil {
+		level.Error(p.logger).Log("msg", "failed to resolve store-gateway addresses", "err", err, "dns_name", p.dnsName)
+	}
+	return nil
+}
+
+func (p *DNSStoreGatewayPool) stopping(_ error) error {
+	return services.StopAndAwaitTerminated(context.Background(), p.storeGatewayClientPool)
+}
+
+// Addresses returns the store-gateway addresses resolved by the last DNS resolution.
+func (p *DNSStoreGatewayPool) Addresses() []string {
+	return p.provider.Addresses()
+}
@@ -0,0 +1,43 @@
+package querier
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/cortexproject/cortex/pkg/util/services"
+)
+
+func TestDNSStoreGatewayPool(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	p, err := NewDNSStoreGatewayPool("localhost:9095", time.Minute, ClientConfig{}, log.NewNopLogger(), prometheus.NewPedanticRegistry())
+	require.NoError(t, err)
+	require.NoError(t, services.StartAndAwaitRunning(ctx, p))
+	defer services.StopAndAwaitTerminated(ctx, p) //nolint:errcheck
+
+	// The DNS name has been resolved on startup.
+	assert.Contains(t, p.Addresses(), "127.0.0.1:9095")
+
+	c, err := p.GetClientFor("127.0.0.1:9095")
+	require.NoError(t, err)
+	assert.Equal(t, "127.0.0.1:9095", c.(BlocksStoreClient).RemoteAddress())
+	assert.Equal(t, []string{"127.0.0.1:9095"}, p.RegisteredAddresses())
+
+	// The clients are closed when the pool is stopped.
+	require.NoError(t, services.StopAndAwaitTerminated(ctx, p))
+	assert.Empty(t, p.RegisteredAddresses())
+}
+
+func TestNewDNSStoreGatewayPool_ShouldRejectInvalidRefreshInterval(t *testing.T) {
+	t.Parallel()
+
+	_, err := NewDNSStoreGatewayPool("localhost:9095", 0, ClientConfig{}, log.NewNopLogger(), nil)
+	require.ErrorIs(t, err, errInvalidDNSRefreshInterval)
+}
@@ -0,0 +1,164 @@
+package querier
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"go.uber.org/atomic"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/cortexproject/cortex/pkg/ring/client"
+	"github.com/cortexproject/cortex/pkg/util/services"
+)
+
+const drainPollInterval = 10 * time.Millisecond
+
+// inflightRequests tracks the number of in-flight requests to store-gateways.
+type inflightRequests struct {
+	count atomic.Int64
+
+	// closed is set once the new requests are rejected.
+	closed atomic.Bool
+}
+
+func newInflightRequests() *inflightRequests {
+	return &inflightRequests{}
+}
+
+// start tracks a new request, failing if the new requests are rejected.
+func (r *inflightRequests) start(cc *grpc.ClientConn) error {
+	// The request is tracked before checking whether it's rejected, so that close() followed
+	// by wait() can't miss it.
+	r.count.Inc()
+	if r.closed.Load() {
+		r.count.Dec()
+		return status.Errorf(codes.Unavailable, "connection to store-gateway %s is closing", cc.Target())
+	}
+	return nil
+}
+
+// close rejects the new requests.
+func (r *inflightRequests) close() {
+	r.closed.Store(true)
+}
+
+func (r *inflightRequests) UnaryClientInterceptor(ctx context.Context, method string, req, reply any, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+	if err := r.start(cc); err != nil {
+		return err
+	}
+	defer r.count.Dec()
+
+	return invoker(ctx, method, req, reply, cc, opts...)
+}
+
+func (r *inflightRequests) StreamClientInterceptor(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, streamer grpc.Streamer, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+	if err := r.start(cc); err != nil {
+		return nil, err
+	}
+
+	stream, err := streamer(ctx, desc, cc, method, opts...)
+	if err != nil {
+		r.count.Dec()
+		return nil, err
+	}
+	return newInflightClientStream(stream, func() { r.count.Dec() }), nil
+}
+
+// wait blocks until there are no more in-flight requests or the context is done.
+func (r *inflightRequests) wait(ctx context.Context) error {
+	ticker := time.NewTicker(drainPollInterval)
+	defer ticker.Stop()
+
+	for r.count.Load() > 0 {
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	return nil
+}
+
+// inflightClientStream marks the request as completed once the stream has been fully consumed
+// or, if the caller stops reading it early, once the context of the stream is done.
+type inflightClientStream struct {
+	grpc.ClientStream
+
+	once sync.Once
+	done func()
+}
+
+func newInflightClientStream(stream grpc.ClientStream, done func()) *inflightClientStream {
+	s := &inflightClientStream{ClientStream: stream, done: done}
+	context.AfterFunc(stream.Context(), s.finish)
+	return s
+}
+
+func (s *inflightClientStream) RecvMsg(m any) error {
+	err := s.ClientStream.RecvMsg(m)
+	if err != nil {
+		s.finish()
+	}
+	return err
+}
+
+func (s *inflightClientStream) finish() {
+	s.once.Do(s.done)
+}
+
+// storeGatewayClientPool is a pool of store-gateway clients which, when stopped, waits for
+// the in-flight requests to complete, up to the drain timeout, before closing the clients.
+type storeGatewayClientPool struct {
+	services.Service
+	*client.Pool
+
+	inflight     *inflightRequests
+	health       *clientsHealth
+	shadow       *shadowRequests
+	hedging      *requestHedging
+	drainTimeout time.Duration
+	logger       log.Logger
+}
+
+func (p *storeGatewayClientPool) starting(ctx context.Context) error {
+	if p.shadow != nil {
+		if err := p.shadow.starting(ctx); err != nil {
+			return err
+		}
+	}
+	return services.StartAndAwaitRunning(ctx, p.Pool)
+}
+
+func (p *storeGatewayClientPool) stopping(_ error) error {
+	p.drain()
+
+	err := services.StopAndAwaitTerminated(context.Background(), p.Pool)
+	for _, addr := range p.RegisteredAddresses() {
+		p.RemoveClientFor(addr)
+	}
+
+	if p.shadow != nil {
+		if shadowErr := p.shadow.stop(); err == nil {
+			err = shadowErr
+		}
+	}
+	return err
+}
+
+func (p *storeGatewayClientPool) drain() {
+	if p.drainTimeout <= 0 {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), p.drainTimeout)
+	defer cancel()
+
+	if err := p.inflight.wait(ctx); err != nil {
+		level.Warn(p.logger).Log("msg", "timed out waiting for in-flight store-gateway requests to complete", "inflight", p.inflight.count.Load())
+	}
+}
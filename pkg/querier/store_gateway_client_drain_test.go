@@ -0,0 +1,273 @@
+package querier
+
+import (
+	"context"
+	"flag"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/thanos-io/thanos/pkg/store/storepb"
+	"github.com/weaveworks/common/user"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/cortexproject/cortex/pkg/ring/client"
+	"github.com/cortexproject/cortex/pkg/storegateway/storegatewaypb"
+	"github.com/cortexproject/cortex/pkg/util/services"
+)
+
+func TestStoreGatewayClientPool_ShouldDrainInflightRequestsOnStop(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string]struct {
+		drainTimeout        time.Duration
+		releaseRequest      bool
+		expectRequestFailed bool
+	}{
+		"should wait for in-flight requests to complete before closing the connections": {
+			drainTimeout:   time.Minute,
+			releaseRequest: true,
+		},
+		"should close the connections once the drain timeout has elapsed": {
+			drainTimeout:        200 * time.Millisecond,
+			expectRequestFailed: true,
+		},
+	}
+
+	for testName, testData := range tests {
+		t.Run(testName, func(t *testing.T) {
+			t.Parallel()
+
+			srv := &blockingStoreGatewayServer{started: make(chan struct{}), release: make(chan struct{})}
+			t.Cleanup(func() { close(srv.release) })
+
+			grpcServer := grpc.NewServer()
+			t.Cleanup(grpcServer.Stop)
+			storegatewaypb.RegisterStoreGatewayServer(grpcServer, srv)
+
+			listener, err := net.Listen("tcp", "localhost:0")
+			require.NoError(t, err)
+
+			go func() {
+				require.NoError(t, grpcServer.Serve(listener))
+			}()
+
+			cfg := ClientConfig{}
+			cfg.RegisterFlagsWithPrefix("test", flag.NewFlagSet("test", flag.PanicOnError))
+			cfg.DrainTimeout = testData.drainTimeout
+
+			pool := newStoreGatewayClientPool(nil, cfg, log.NewNopLogger(), prometheus.NewPedanticRegistry())
+			require.NoError(t, services.StartAndAwaitRunning(context.Background(), pool))
+
+			c, err := pool.GetClientFor(listener.Addr().String())
+			require.NoError(t, err)
+
+			// Issue a request which blocks until released.
+			requestErr := make(chan error, 1)
+			go func() {
+				_, err := c.(BlocksStoreClient).LabelNames(user.InjectOrgID(context.Background(), "test"), &storepb.LabelNamesRequest{})
+				requestErr <- err
+			}()
+			<-srv.started
+
+			// Simulate the shutdown.
+			stopped := make(chan error, 1)
+			go func() {
+				stopped <- services.StopAndAwaitTerminated(context.Background(), pool)
+			}()
+
+			if testData.releaseRequest {
+				select {
+				case <-stopped:
+					require.Fail(t, "the pool stopped while a request was in-flight")
+				case <-time.After(200 * time.Millisecond):
+				}
+
+				srv.release <- struct{}{}
+				require.NoError(t, <-requestErr)
+			}
+
+			require.NoError(t, <-stopped)
+			assert.Empty(t, pool.RegisteredAddresses())
+
+			if testData.expectRequestFailed {
+				require.Error(t, <-requestErr)
+			}
+		})
+	}
+}
+
+func TestStoreGatewayClientPool_ShouldNotWaitForAbandonedSeriesStreamsOnStop(t *testing.T) {
+	t.Parallel()
+
+	srv := &seriesStoreGatewayServer{series: labels.FromStrings("__name__", "test")}
+	addr := startStoreGatewayServer(t, srv)
+
+	cfg := ClientConfig{}
+	cfg.RegisterFlagsWithPrefix("test", flag.NewFlagSet("test", flag.PanicOnError))
+	cfg.DrainTimeout = time.Minute
+
+	pool := newStoreGatewayClientPool(nil, cfg, log.NewNopLogger(), prometheus.NewPedanticRegistry())
+	require.NoError(t, services.StartAndAwaitRunning(context.Background(), pool))
+
+	c, err := pool.GetClientFor(addr)
+	require.NoError(t, err)
+
+	// The caller stops reading the stream before its end, and cancels the request.
+	ctx, cancel := context.WithCancel(user.InjectOrgID(context.Background(), "test"))
+	stream, err := c.(BlocksStoreClient).Series(ctx, &storepb.SeriesRequest{})
+	require.NoError(t, err)
+	_, err = stream.Recv()
+	require.NoError(t, err)
+	cancel()
+
+	require.Eventually(t, func() bool { return pool.inflight.count.Load() == 0 }, time.Second, 10*time.Millisecond)
+
+	// The pool doesn't wait for the abandoned stream until the drain timeout.
+	stopCtx, stopCancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer stopCancel()
+	require.NoError(t, services.StopAndAwaitTerminated(stopCtx, pool))
+}
+
+func TestStoreGatewayClient_CloseGracefully(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string]struct {
+		timeout        time.Duration
+		releaseRequest bool
+		expectedErr    string
+	}{
+		"should wait for in-flight requests to complete before closing the connection": {
+			timeout:        time.Minute,
+			releaseRequest: true,
+		},
+		"should close the connection once the context is done": {
+			timeout:     200 * time.Millisecond,
+			expectedErr: "waiting for the in-flight requests to store-gateway",
+		},
+	}
+
+	for testName, testData := range tests {
+		t.Run(testName, func(t *testing.T) {
+			t.Parallel()
+
+			srv := &blockingStoreGatewayServer{started: make(chan struct{}), release: make(chan struct{})}
+			t.Cleanup(func() { close(srv.release) })
+
+			grpcServer := grpc.NewServer()
+			t.Cleanup(grpcServer.Stop)
+			storegatewaypb.RegisterStoreGatewayServer(grpcServer, srv)
+
+			listener, err := net.Listen("tcp", "localhost:0")
+			require.NoError(t, err)
+
+			go func() {
+				require.NoError(t, grpcServer.Serve(listener))
+			}()
+
+			cfg := ClientConfig{}
+			cfg.RegisterFlagsWithPrefix("test", flag.NewFlagSet("test", flag.PanicOnError))
+
+			pool := newStoreGatewayClientPool(nil, cfg, log.NewNopLogger(), prometheus.NewPedanticRegistry())
+			c, err := pool.GetClientFor(listener.Addr().String())
+			require.NoError(t, err)
+
+			// Issue a request which blocks until released.
+			ctx := user.InjectOrgID(context.Background(), "test")
+			requestErr := make(chan error, 1)
+			go func() {
+				_, err := c.(BlocksStoreClient).LabelNames(ctx, &storepb.LabelNamesRequest{})
+				requestErr <- err
+			}()
+			<-srv.started
+
+			closed := make(chan error, 1)
+			go func() {
+				closeCtx, cancel := context.WithTimeout(context.Background(), testData.timeout)
+				defer cancel()
+				closed <- c.(client.GracefulCloser).CloseGracefully(closeCtx)
+			}()
+
+			// The new requests are rejected while closing.
+			requests := c.(*healthTrackingClient).storeGatewayPoolClient.(*storeGatewayClient).requests
+			require.Eventually(t, requests.closed.Load, time.Second, 10*time.Millisecond)
+			_, err = c.(BlocksStoreClient).LabelNames(ctx, &storepb.LabelNamesRequest{})
+			require.Equal(t, codes.Unavailable, status.Code(err))
+
+			if testData.releaseRequest {
+				select {
+				case <-closed:
+					require.Fail(t, "the client closed while a request was in-flight")
+				case <-time.After(200 * time.Millisecond):
+				}
+
+				srv.release <- struct{}{}
+				require.NoError(t, <-requestErr)
+			}
+
+			err = <-closed
+			if testData.expectedErr == "" {
+				require.NoError(t, err)
+				return
+			}
+			require.ErrorContains(t, err, testData.expectedErr)
+			require.Error(t, <-requestErr)
+		})
+	}
+}
+
+func TestStoreGatewayClient_CloseGracefully_ShouldNotWaitForAbandonedSeriesStreams(t *testing.T) {
+	t.Parallel()
+
+	srv := &seriesStoreGatewayServer{series: labels.FromStrings("__name__", "test")}
+	addr := startStoreGatewayServer(t, srv)
+
+	cfg := ClientConfig{}
+	cfg.RegisterFlagsWithPrefix("test", flag.NewFlagSet("test", flag.PanicOnError))
+
+	pool := newStoreGatewayClientPool(nil, cfg, log.NewNopLogger(), prometheus.NewPedanticRegistry())
+	c, err := pool.GetClientFor(addr)
+	require.NoError(t, err)
+
+	// The caller stops reading the stream before its end, and cancels the request.
+	ctx, cancel := context.WithCancel(user.InjectOrgID(context.Background(), "test"))
+	stream, err := c.(BlocksStoreClient).Series(ctx, &storepb.SeriesRequest{})
+	require.NoError(t, err)
+	_, err = stream.Recv()
+	require.NoError(t, err)
+	cancel()
+
+	// The client doesn't wait for the abandoned stream until the deadline.
+	closeCtx, closeCancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer closeCancel()
+	require.NoError(t, c.(client.GracefulCloser).CloseGracefully(closeCtx))
+}
+
+// blockingStoreGatewayServer blocks the LabelNames requests until released. The started
+// channel is closed once the first request has been received.
+type blockingStoreGatewayServer struct {
+	mockStoreGatewayServer
+
+	started     chan struct{}
+	startedOnce sync.Once
+	release     chan struct{}
+}
+
+func (m *blockingStoreGatewayServer) LabelNames(ctx context.Context, _ *storepb.LabelNamesRequest) (*storepb.LabelNamesResponse, error) {
+	m.startedOnce.Do(func() { close(m.started) })
+
+	select {
+	case <-m.release:
+		return &storepb.LabelNamesResponse{}, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
@@ -0,0 +1,169 @@
+package querier
+
+import (
+	"context"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/health/grpc_health_v1"
+
+	"github.com/cortexproject/cortex/pkg/ring/client"
+	"github.com/cortexproject/cortex/pkg/util"
+)
+
+// removedClientHealthRetention is how long the last health check of a store-gateway client
+// removed from the pool because of a failing health check is kept.
+const removedClientHealthRetention = 15 * time.Minute
+
+// StoreGatewayClientHealth is the last health check result of a store-gateway client.
+type StoreGatewayClientHealth struct {
+	Address string `json:"address"`
+	// InPool is false if the client has been removed from the pool because of a failing
+	// health check.
+	InPool bool `json:"in_pool"`
+	// Status is the status returned by the store-gateway, or UNKNOWN if the client hasn't
+	// been checked yet or the health check failed.
+	Status    string     `json:"status"`
+	Error     string     `json:"error,omitempty"`
+	CheckedAt *time.Time `json:"checked_at,omitempty"`
+}
+
+type healthCheckResult struct {
+	status    grpc_health_v1.HealthCheckResponse_ServingStatus
+	err       string
+	checkedAt time.Time
+}
+
+func (r healthCheckResult) healthy() bool {
+	return r.err == "" && r.status == grpc_health_v1.HealthCheckResponse_SERVING
+}
+
+// clientsHealth tracks the results of the health checks run by the pool on the store-gateway clients.
+type clientsHealth struct {
+	mtx     sync.Mutex
+	results map[string]healthCheckResult
+}
+
+func newClientsHealth() *clientsHealth {
+	return &clientsHealth{results: map[string]healthCheckResult{}}
+}
+
+// wrapFactory returns a factory tracking the health checks of the clients created by factory.
+func (h *clientsHealth) wrapFactory(factory client.PoolFactory) client.PoolFactory {
+	return func(addr string) (client.PoolClient, error) {
+		c, err := factory(addr)
+		if err != nil {
+			return nil, err
+		}
+
+		// Forget the result of the previous client to the same address.
+		h.mtx.Lock()
+		delete(h.results, addr)
+		h.mtx.Unlock()
+
+		return &healthTrackingClient{storeGatewayPoolClient: c.(storeGatewayPoolClient), addr: addr, health: h}, nil
+	}
+}
+
+func (h *clientsHealth) record(addr string, resp *grpc_health_v1.HealthCheckResponse, err error) {
+	result := healthCheckResult{checkedAt: time.Now()}
+	if err != nil {
+		result.err = err.Error()
+	} else {
+		result.status = resp.GetStatus()
+	}
+
+	h.mtx.Lock()
+	defer h.mtx.Unlock()
+	h.results[addr] = result
+}
+
+// healthy returns whether the last health check of the client at the given address succeeded.
+func (h *clientsHealth) healthy(addr string) bool {
+	h.mtx.Lock()
+	defer h.mtx.Unlock()
+
+	result, ok := h.results[addr]
+	return ok && result.healthy()
+}
+
+// snapshot returns the last health check result of the clients at the given addresses, and
+// of the clients recently removed because of a failing health check, sorted by address.
+func (h *clientsHealth) snapshot(addrs []string) []StoreGatewayClientHealth {
+	h.mtx.Lock()
+	defer h.mtx.Unlock()
+
+	res := make([]StoreGatewayClientHealth, 0, len(addrs))
+	for _, addr := range addrs {
+		res = append(res, newStoreGatewayClientHealth(addr, true, h.results[addr]))
+	}
+	for addr, result := range h.results {
+		if slices.Contains(addrs, addr) {
+			continue
+		}
+		if result.healthy() || time.Since(result.checkedAt) > removedClientHealthRetention {
+			delete(h.results, addr)
+			continue
+		}
+		res = append(res, newStoreGatewayClientHealth(addr, false, result))
+	}
+
+	slices.SortFunc(res, func(a, b StoreGatewayClientHealth) int {
+		return strings.Compare(a.Address, b.Address)
+	})
+	return res
+}
+
+func newStoreGatewayClientHealth(addr string, inPool bool, result healthCheckResult) StoreGatewayClientHealth {
+	health := StoreGatewayClientHealth{
+		Address: addr,
+		InPool:  inPool,
+		Status:  result.status.String(),
+		Error:   result.err,
+	}
+	if !result.checkedAt.IsZero() {
+		health.CheckedAt = &result.checkedAt
+	}
+	return health
+}
+
+// healthTrackingClient is a store-gateway client recording the result of its health checks.
+type healthTrackingClient struct {
+	storeGatewayPoolClient
+
+	addr   string
+	health *clientsHealth
+}
+
+func (c *healthTrackingClient) Check(ctx context.Context, req *grpc_health_v1.HealthCheckRequest, opts ...grpc.CallOption) (*grpc_health_v1.HealthCheckResponse, error) {
+	resp, err := c.storeGatewayPoolClient.Check(ctx, req, opts...)
+	c.health.record(c.addr, resp, err)
+	return resp, err
+}
+
+// clientsHealth returns the last health check result of each store-gateway client.
+func (p *storeGatewayClientPool) clientsHealth() []StoreGatewayClientHealth {
+	return p.health.snapshot(p.RegisteredAddresses())
+}
+
+// storeGatewayClientsHealthProvider is implemented by the BlocksStoreSet tracking the health
+// of their store-gateway clients.
+type storeGatewayClientsHealthProvider interface {
+	clientsHealth() []StoreGatewayClientHealth
+}
+
+// StoreGatewayClientsHandler returns, in JSON format, the last health check result of each
+// store-gateway client in the pool.
+func (q *BlocksStoreQueryable) StoreGatewayClientsHandler(w http.ResponseWriter, _ *http.Request) {
+	provider, ok := q.stores.(storeGatewayClientsHealthProvider)
+	if !ok {
+		http.Error(w, "the store-gateway clients health is not available", http.StatusNotFound)
+		return
+	}
+
+	util.WriteJSONResponse(w, provider.clientsHealth())
+}
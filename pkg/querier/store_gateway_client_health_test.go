@@ -0,0 +1,149 @@
+package querier
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/weaveworks/common/user"
+	"go.uber.org/atomic"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/health/grpc_health_v1"
+
+	"github.com/cortexproject/cortex/pkg/ring/client"
+	"github.com/cortexproject/cortex/pkg/storegateway/storegatewaypb"
+	"github.com/cortexproject/cortex/pkg/util/services"
+)
+
+func TestStoreGatewayClientPool_ShouldTrackClientsHealth(t *testing.T) {
+	t.Parallel()
+
+	healthSrv := &mockHealthServer{}
+	healthSrv.status.Store(int32(grpc_health_v1.HealthCheckResponse_SERVING))
+	grpcServer := grpc.NewServer()
+	t.Cleanup(grpcServer.GracefulStop)
+	storegatewaypb.RegisterStoreGatewayServer(grpcServer, &mockStoreGatewayServer{})
+	grpc_health_v1.RegisterHealthServer(grpcServer, healthSrv)
+
+	listener, err := net.Listen("tcp", "localhost:0")
+	require.NoError(t, err)
+	addr := listener.Addr().String()
+
+	go func() {
+		require.NoError(t, grpcServer.Serve(listener))
+	}()
+
+	cfg := ClientConfig{}
+	cfg.RegisterFlagsWithPrefix("test", flag.NewFlagSet("test", flag.PanicOnError))
+
+	ctx := context.Background()
+	pool := newStoreGatewayClientPool(nil, cfg, log.NewNopLogger(), prometheus.NewPedanticRegistry())
+	require.NoError(t, services.StartAndAwaitRunning(ctx, pool))
+	t.Cleanup(func() {
+		require.NoError(t, services.StopAndAwaitTerminated(ctx, pool))
+	})
+
+	c, err := pool.GetClientFor(addr)
+	require.NoError(t, err)
+
+	// The client hasn't been checked yet.
+	assert.Equal(t, []StoreGatewayClientHealth{{Address: addr, InPool: true, Status: "UNKNOWN"}}, pool.clientsHealth())
+
+	check := func() {
+		_, _ = c.(client.PoolClient).Check(user.InjectOrgID(ctx, "0"), &grpc_health_v1.HealthCheckRequest{})
+	}
+
+	check()
+	health := pool.clientsHealth()
+	require.Len(t, health, 1)
+	assert.True(t, health[0].InPool)
+	assert.Equal(t, "SERVING", health[0].Status)
+	assert.Empty(t, health[0].Error)
+	require.NotNil(t, health[0].CheckedAt)
+
+	// A client removed after a failing health check is still reported.
+	healthSrv.status.Store(int32(grpc_health_v1.HealthCheckResponse_NOT_SERVING))
+	check()
+	pool.RemoveClientFor(addr)
+
+	health = pool.clientsHealth()
+	require.Len(t, health, 1)
+	assert.False(t, health[0].InPool)
+	assert.Equal(t, "NOT_SERVING", health[0].Status)
+
+	// The removed client is replaced once the address is used again.
+	_, err = pool.GetClientFor(addr)
+	require.NoError(t, err)
+	assert.Equal(t, []StoreGatewayClientHealth{{Address: addr, InPool: true, Status: "UNKNOWN"}}, pool.clientsHealth())
+}
+
+func TestClientsHealth_ShouldOnlyReportRecentlyRemovedUnhealthyClients(t *testing.T) {
+	t.Parallel()
+
+	h := newClientsHealth()
+	h.record("1.1.1.1:9095", &grpc_health_v1.HealthCheckResponse{Status: grpc_health_v1.HealthCheckResponse_SERVING}, nil)
+	h.record("2.2.2.2:9095", nil, context.DeadlineExceeded)
+	h.results["3.3.3.3:9095"] = healthCheckResult{err: "unavailable", checkedAt: time.Now().Add(-2 * removedClientHealthRetention)}
+
+	health := h.snapshot(nil)
+	require.Len(t, health, 1)
+	assert.Equal(t, "2.2.2.2:9095", health[0].Address)
+	assert.False(t, health[0].InPool)
+	assert.Equal(t, "UNKNOWN", health[0].Status)
+	assert.Equal(t, context.DeadlineExceeded.Error(), health[0].Error)
+
+	// The results not reported are forgotten.
+	assert.Len(t, h.results, 1)
+}
+
+func TestBlocksStoreQueryable_StoreGatewayClientsHandler(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	stores := newBlocksStoreBalancedSet([]string{"127.0.0.2:9095", "127.0.0.1:9095"}, ClientConfig{}, log.NewNopLogger(), nil)
+	require.NoError(t, services.StartAndAwaitRunning(ctx, stores))
+	defer services.StopAndAwaitTerminated(ctx, stores) //nolint:errcheck
+
+	q := &BlocksStoreQueryable{stores: stores}
+	for _, addr := range stores.serviceAddresses {
+		_, err := stores.clientsPool.GetClientFor(addr)
+		require.NoError(t, err)
+	}
+
+	rec := httptest.NewRecorder()
+	q.StoreGatewayClientsHandler(rec, httptest.NewRequest(http.MethodGet, "/querier/store-gateway-clients", nil))
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "application/json", rec.Header().Get("Content-Type"))
+
+	var health []StoreGatewayClientHealth
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &health))
+	assert.Equal(t, []StoreGatewayClientHealth{
+		{Address: "127.0.0.1:9095", InPool: true, Status: "UNKNOWN"},
+		{Address: "127.0.0.2:9095", InPool: true, Status: "UNKNOWN"},
+	}, health)
+
+	// The handler fails if the stores don't track the clients health.
+	q = &BlocksStoreQueryable{stores: &blocksStoreSetMock{}}
+	rec = httptest.NewRecorder()
+	q.StoreGatewayClientsHandler(rec, httptest.NewRequest(http.MethodGet, "/querier/store-gateway-clients", nil))
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+}
+
+type mockHealthServer struct {
+	grpc_health_v1.UnimplementedHealthServer
+
+	status atomic.Int32
+}
+
+func (m *mockHealthServer) Check(context.Context, *grpc_health_v1.HealthCheckRequest) (*grpc_health_v1.HealthCheckResponse, error) {
+	return &grpc_health_v1.HealthCheckResponse{Status: grpc_health_v1.HealthCheckResponse_ServingStatus(m.status.Load())}, nil
+}
@@ -0,0 +1,277 @@
+package querier
+
+import (
+	"context"
+	"flag"
+	"io"
+	"math/rand"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/thanos-io/thanos/pkg/store/storepb"
+	"google.golang.org/grpc"
+
+	"github.com/cortexproject/cortex/pkg/ring/client"
+	"github.com/cortexproject/cortex/pkg/storegateway/storegatewaypb"
+)
+
+var (
+	errNegativeHedgingDelay     = errors.New("store gateway client hedging delay must not be negative")
+	errNegativeHedgingMaxHedges = errors.New("store gateway client hedging max hedges must not be negative")
+)
+
+// HedgingConfig configures the hedging of the requests to store-gateways.
+type HedgingConfig struct {
+	Delay     time.Duration `yaml:"delay"`
+	MaxHedges int           `yaml:"max_hedges"`
+}
+
+func (cfg *HedgingConfig) RegisterFlagsWithPrefix(prefix string, f *flag.FlagSet) {
+	f.DurationVar(&cfg.Delay, prefix+".hedging.delay", 0, "If a Series, LabelNames or LabelValues request to a store-gateway hasn't received a response within this delay, the same request is sent to another healthy store-gateway holding the same blocks, and the first response is used. Only useful when the blocks are replicated across store-gateways. 0 to disable hedging.")
+	f.IntVar(&cfg.MaxHedges, prefix+".hedging.max-hedges", 1, "The maximum number of hedged requests sent for each request to a store-gateway, one every hedging delay.")
+}
+
+func (cfg *HedgingConfig) Validate() error {
+	if cfg.Delay < 0 {
+		return errNegativeHedgingDelay
+	}
+	if cfg.MaxHedges < 0 {
+		return errNegativeHedgingMaxHedges
+	}
+	return nil
+}
+
+func (cfg *HedgingConfig) enabled() bool {
+	return cfg.Delay > 0 && cfg.MaxHedges > 0
+}
+
+// requestHedging sends hedged requests to the healthy store-gateways of the pool.
+type requestHedging struct {
+	cfg HedgingConfig
+
+	hedgedRequests *prometheus.CounterVec
+	wonRequests    *prometheus.CounterVec
+}
+
+func newRequestHedging(cfg HedgingConfig, reg prometheus.Registerer) *requestHedging {
+	return &requestHedging{
+		cfg: cfg,
+		hedgedRequests: promauto.With(reg).NewCounterVec(prometheus.CounterOpts{
+			Namespace:   "cortex",
+			Name:        "storegateway_client_hedged_requests_total",
+			Help:        "Total number of hedged requests sent to store-gateways.",
+			ConstLabels: prometheus.Labels{"client": "querier"},
+		}, []string{"operation"}),
+		wonRequests: promauto.With(reg).NewCounterVec(prometheus.CounterOpts{
+			Namespace:   "cortex",
+			Name:        "storegateway_client_hedged_requests_won_total",
+			Help:        "Total number of hedged requests to store-gateways whose response has been used instead of the one of the original request.",
+			ConstLabels: prometheus.Labels{"client": "querier"},
+		}, []string{"operation"}),
+	}
+}
+
+// hedgeCandidate returns the client of a random healthy store-gateway among the targets,
+// other than the attempted ones, or nil if there's none. If targets is nil, any store-gateway
+// of the pool is a candidate.
+func (p *storeGatewayClientPool) hedgeCandidate(targets []string, attempted map[string]struct{}) BlocksStoreClient {
+	if targets == nil {
+		targets = p.RegisteredAddresses()
+	}
+
+	var candidates []string
+	for _, addr := range targets {
+		if _, ok := attempted[addr]; ok {
+			continue
+		}
+		if p.health.healthy(addr) {
+			candidates = append(candidates, addr)
+		}
+	}
+
+	for len(candidates) > 0 {
+		i := rand.Intn(len(candidates))
+		c, err := p.Pool.GetClientFor(candidates[i])
+		if err == nil {
+			return c.(BlocksStoreClient)
+		}
+		candidates = append(candidates[:i], candidates[i+1:]...)
+	}
+	return nil
+}
+
+type hedgedAttempt[T any] struct {
+	idx int
+	res T
+	err error
+}
+
+// hedge runs call against the primary client and, every hedging delay until a response is
+// received, against another healthy store-gateway among the targets, up to the max hedges. It
+// returns the first successful response, along with the function canceling its attempt
+// once done with it, and cancels the other attempts. If all the attempts fail, it returns
+// the error of the first one.
+func hedge[T any](ctx context.Context, p *storeGatewayClientPool, operation string, primary BlocksStoreClient, targets []string, call func(context.Context, BlocksStoreClient) (T, error)) (T, context.CancelFunc, error) {
+	h := p.hedging
+	results := make(chan hedgedAttempt[T], h.cfg.MaxHedges+1)
+	attempted := map[string]struct{}{primary.RemoteAddress(): {}}
+
+	var cancels []context.CancelFunc
+	launch := func(c BlocksStoreClient) {
+		attemptCtx, cancel := context.WithCancel(ctx)
+		idx := len(cancels)
+		cancels = append(cancels, cancel)
+		go func() {
+			res, err := call(attemptCtx, c)
+			results <- hedgedAttempt[T]{idx: idx, res: res, err: err}
+		}()
+	}
+	cancelOthers := func(winner int) {
+		for i, cancel := range cancels {
+			if i != winner {
+				cancel()
+			}
+		}
+	}
+
+	launch(primary)
+	pending := 1
+
+	timer := time.NewTimer(h.cfg.Delay)
+	defer timer.Stop()
+
+	var firstErr error
+	for {
+		select {
+		case r := <-results:
+			pending--
+			if r.err == nil {
+				cancelOthers(r.idx)
+				if r.idx > 0 {
+					h.wonRequests.WithLabelValues(operation).Inc()
+				}
+				return r.res, cancels[r.idx], nil
+			}
+			if firstErr == nil {
+				firstErr = r.err
+			}
+			if pending == 0 {
+				cancelOthers(-1)
+				var zero T
+				return zero, func() {}, firstErr
+			}
+
+		case <-timer.C:
+			c := p.hedgeCandidate(targets, attempted)
+			if c == nil {
+				continue
+			}
+			attempted[c.RemoteAddress()] = struct{}{}
+			h.hedgedRequests.WithLabelValues(operation).Inc()
+			launch(c)
+			pending++
+
+			if len(cancels) <= h.cfg.MaxHedges {
+				timer.Reset(h.cfg.Delay)
+			}
+		}
+	}
+}
+
+// hedgingClient is a store-gateway client hedging its requests across the healthy
+// store-gateways among its targets. Its RemoteAddress is the one of the original
+// store-gateway, even if the response comes from another one, so the targets must hold
+// the same blocks.
+type hedgingClient struct {
+	storeGatewayPoolClient
+
+	pool *storeGatewayClientPool
+	// targets are the store-gateways the requests are hedged to, or nil for any store-gateway
+	// of the pool.
+	targets []string
+}
+
+func (c *hedgingClient) Series(ctx context.Context, req *storepb.SeriesRequest, opts ...grpc.CallOption) (storegatewaypb.StoreGateway_SeriesClient, error) {
+	// The response of an attempt is its first message, so that the attempt whose stream
+	// starts first wins.
+	stream, cancel, err := hedge(ctx, c.pool, "Series", c.storeGatewayPoolClient, c.targets, func(ctx context.Context, client BlocksStoreClient) (*hedgedSeriesClient, error) {
+		stream, err := client.Series(ctx, req, opts...)
+		if err != nil {
+			return nil, err
+		}
+		first, err := stream.Recv()
+		if err != nil && !errors.Is(err, io.EOF) {
+			return nil, err
+		}
+		return &hedgedSeriesClient{StoreGateway_SeriesClient: stream, first: first, firstErr: err}, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	stream.cancel = cancel
+	return stream, nil
+}
+
+func (c *hedgingClient) LabelNames(ctx context.Context, req *storepb.LabelNamesRequest, opts ...grpc.CallOption) (*storepb.LabelNamesResponse, error) {
+	resp, cancel, err := hedge(ctx, c.pool, "LabelNames", c.storeGatewayPoolClient, c.targets, func(ctx context.Context, client BlocksStoreClient) (*storepb.LabelNamesResponse, error) {
+		return client.LabelNames(ctx, req, opts...)
+	})
+	cancel()
+	return resp, err
+}
+
+func (c *hedgingClient) LabelValues(ctx context.Context, req *storepb.LabelValuesRequest, opts ...grpc.CallOption) (*storepb.LabelValuesResponse, error) {
+	resp, cancel, err := hedge(ctx, c.pool, "LabelValues", c.storeGatewayPoolClient, c.targets, func(ctx context.Context, client BlocksStoreClient) (*storepb.LabelValuesResponse, error) {
+		return client.LabelValues(ctx, req, opts...)
+	})
+	cancel()
+	return resp, err
+}
+
+// hedgedSeriesClient is the stream of the winning Series attempt, whose first message has
+// already been received.
+type hedgedSeriesClient struct {
+	storegatewaypb.StoreGateway_SeriesClient
+
+	first    *storepb.SeriesResponse
+	firstErr error
+	consumed bool
+	cancel   context.CancelFunc
+}
+
+func (s *hedgedSeriesClient) Recv() (*storepb.SeriesResponse, error) {
+	resp, err := s.first, s.firstErr
+	if s.consumed {
+		resp, err = s.StoreGateway_SeriesClient.Recv()
+	}
+	s.consumed = true
+
+	if err != nil {
+		s.cancel()
+	}
+	return resp, err
+}
+
+// GetClientFor returns the client of the given store-gateway address, hedging its requests
+// to any store-gateway of the pool if enabled. It's only suitable when all the
+// store-gateways hold the same blocks.
+func (p *storeGatewayClientPool) GetClientFor(addr string) (client.PoolClient, error) {
+	c, err := p.Pool.GetClientFor(addr)
+	if err != nil || p.hedging == nil {
+		return c, err
+	}
+	return &hedgingClient{storeGatewayPoolClient: c.(storeGatewayPoolClient), pool: p}, nil
+}
+
+// getHedgedClientFor returns the client of the given store-gateway address, hedging its
+// requests to the given targets only, if enabled. The requests aren't hedged if there's no
+// target.
+func (p *storeGatewayClientPool) getHedgedClientFor(addr string, targets []string) (client.PoolClient, error) {
+	c, err := p.Pool.GetClientFor(addr)
+	if err != nil || p.hedging == nil || len(targets) == 0 {
+		return c, err
+	}
+	return &hedgingClient{storeGatewayPoolClient: c.(storeGatewayPoolClient), pool: p, targets: targets}, nil
+}
@@ -0,0 +1,155 @@
+package querier
+
+import (
+	"context"
+	"flag"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/thanos-io/thanos/pkg/store/labelpb"
+	"github.com/thanos-io/thanos/pkg/store/storepb"
+	"github.com/weaveworks/common/user"
+	"google.golang.org/grpc/health/grpc_health_v1"
+
+	"github.com/cortexproject/cortex/pkg/storegateway/storegatewaypb"
+	"github.com/cortexproject/cortex/pkg/util/services"
+)
+
+// slowStoreGatewayServer returns its name as series and label name after the given delay,
+// and records whether its requests have been canceled.
+type slowStoreGatewayServer struct {
+	mockStoreGatewayServer
+
+	name     string
+	delay    time.Duration
+	canceled chan struct{}
+}
+
+func newSlowStoreGatewayServer(name string, delay time.Duration) *slowStoreGatewayServer {
+	return &slowStoreGatewayServer{name: name, delay: delay, canceled: make(chan struct{}, 10)}
+}
+
+func (m *slowStoreGatewayServer) wait(ctx context.Context) error {
+	select {
+	case <-time.After(m.delay):
+		return nil
+	case <-ctx.Done():
+		m.canceled <- struct{}{}
+		return ctx.Err()
+	}
+}
+
+func (m *slowStoreGatewayServer) Series(_ *storepb.SeriesRequest, srv storegatewaypb.StoreGateway_SeriesServer) error {
+	if err := m.wait(srv.Context()); err != nil {
+		return err
+	}
+	return srv.Send(storepb.NewSeriesResponse(&storepb.Series{Labels: labelpb.ZLabelsFromPromLabels(labels.FromStrings("server", m.name))}))
+}
+
+func (m *slowStoreGatewayServer) LabelNames(ctx context.Context, _ *storepb.LabelNamesRequest) (*storepb.LabelNamesResponse, error) {
+	if err := m.wait(ctx); err != nil {
+		return nil, err
+	}
+	return &storepb.LabelNamesResponse{Names: []string{m.name}}, nil
+}
+
+func TestStoreGatewayClientPool_ShouldHedgeSlowRequests(t *testing.T) {
+	t.Parallel()
+
+	slow := newSlowStoreGatewayServer("slow", time.Minute)
+	fast := newSlowStoreGatewayServer("fast", 0)
+	slowAddr := startStoreGatewayServer(t, slow)
+	fastAddr := startStoreGatewayServer(t, fast)
+
+	cfg := ClientConfig{}
+	cfg.RegisterFlagsWithPrefix("test", flag.NewFlagSet("test", flag.PanicOnError))
+	cfg.Hedging.Delay = 50 * time.Millisecond
+	require.NoError(t, cfg.Validate())
+
+	pool := newStoreGatewayClientPool(nil, cfg, log.NewNopLogger(), prometheus.NewPedanticRegistry())
+	require.NoError(t, services.StartAndAwaitRunning(context.Background(), pool))
+	t.Cleanup(func() {
+		require.NoError(t, services.StopAndAwaitTerminated(context.Background(), pool))
+	})
+
+	c, err := pool.GetClientFor(slowAddr)
+	require.NoError(t, err)
+	ctx := user.InjectOrgID(context.Background(), "test")
+
+	// No hedged request is sent while the other store-gateway isn't known to be healthy.
+	shortCtx, cancel := context.WithTimeout(ctx, 200*time.Millisecond)
+	_, err = c.(BlocksStoreClient).LabelNames(shortCtx, &storepb.LabelNamesRequest{})
+	cancel()
+	require.Error(t, err)
+	<-slow.canceled
+
+	_, err = pool.GetClientFor(fastAddr)
+	require.NoError(t, err)
+	pool.health.record(fastAddr, &grpc_health_v1.HealthCheckResponse{Status: grpc_health_v1.HealthCheckResponse_SERVING}, nil)
+
+	t.Run("Series", func(t *testing.T) {
+		stream, err := c.(BlocksStoreClient).Series(ctx, &storepb.SeriesRequest{})
+		require.NoError(t, err)
+
+		resp, err := stream.Recv()
+		require.NoError(t, err)
+		assert.Equal(t, labels.FromStrings("server", "fast"), resp.GetSeries().PromLabels())
+		_, err = stream.Recv()
+		assert.Equal(t, io.EOF, err)
+
+		// The request to the slow store-gateway has been canceled.
+		<-slow.canceled
+	})
+
+	t.Run("LabelNames", func(t *testing.T) {
+		resp, err := c.(BlocksStoreClient).LabelNames(ctx, &storepb.LabelNamesRequest{})
+		require.NoError(t, err)
+		assert.Equal(t, []string{"fast"}, resp.Names)
+
+		<-slow.canceled
+	})
+
+	// The requests to the fast store-gateway aren't hedged.
+	c, err = pool.GetClientFor(fastAddr)
+	require.NoError(t, err)
+	resp, err := c.(BlocksStoreClient).LabelNames(ctx, &storepb.LabelNamesRequest{})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"fast"}, resp.Names)
+}
+
+func TestHedgingConfig_Validate(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string]struct {
+		cfg         HedgingConfig
+		expectedErr error
+	}{
+		"should pass when disabled": {
+			cfg: HedgingConfig{},
+		},
+		"should pass with delay and max hedges": {
+			cfg: HedgingConfig{Delay: time.Second, MaxHedges: 2},
+		},
+		"should fail with negative delay": {
+			cfg:         HedgingConfig{Delay: -time.Second, MaxHedges: 1},
+			expectedErr: errNegativeHedgingDelay,
+		},
+		"should fail with negative max hedges": {
+			cfg:         HedgingConfig{Delay: time.Second, MaxHedges: -1},
+			expectedErr: errNegativeHedgingMaxHedges,
+		},
+	}
+
+	for testName, testData := range tests {
+		t.Run(testName, func(t *testing.T) {
+			t.Parallel()
+			assert.Equal(t, testData.expectedErr, testData.cfg.Validate())
+		})
+	}
+}
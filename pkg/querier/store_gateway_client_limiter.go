@@ -0,0 +1,101 @@
+package querier
+
+import (
+	"context"
+
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+var errNegativeMaxConcurrentRequests = errors.New("store gateway client max concurrent requests must not be negative")
+
+func newInflightRequestsMetric(reg prometheus.Registerer) *prometheus.GaugeVec {
+	return promauto.With(reg).NewGaugeVec(prometheus.GaugeOpts{
+		Namespace:   "cortex",
+		Name:        "storegateway_client_inflight_requests",
+		Help:        "The current number of in-flight requests to each store-gateway.",
+		ConstLabels: prometheus.Labels{"client": "querier"},
+	}, []string{"address"})
+}
+
+// concurrencyLimiter tracks the in-flight requests to a single store-gateway and, if a limit
+// is configured, limits them. The requests over the limit wait for a slot up to their
+// deadline, and then fail with ResourceExhausted.
+type concurrencyLimiter struct {
+	addr     string
+	limit    int
+	slots    chan struct{}
+	metric   *prometheus.GaugeVec
+	inflight prometheus.Gauge
+}
+
+// newConcurrencyLimiter returns a limiter for the store-gateway at the given address. A
+// limit of 0 means unlimited.
+func newConcurrencyLimiter(addr string, limit int, metric *prometheus.GaugeVec) *concurrencyLimiter {
+	l := &concurrencyLimiter{
+		addr:     addr,
+		limit:    limit,
+		metric:   metric,
+		inflight: metric.WithLabelValues(addr),
+	}
+	if limit > 0 {
+		l.slots = make(chan struct{}, limit)
+	}
+	return l
+}
+
+func (l *concurrencyLimiter) acquire(ctx context.Context) error {
+	if l.slots != nil {
+		select {
+		case l.slots <- struct{}{}:
+		case <-ctx.Done():
+			if errors.Is(ctx.Err(), context.Canceled) {
+				return ctx.Err()
+			}
+			return status.Errorf(codes.ResourceExhausted, "too many concurrent requests to store-gateway %s (limit: %d)", l.addr, l.limit)
+		}
+	}
+
+	l.inflight.Inc()
+	return nil
+}
+
+func (l *concurrencyLimiter) release() {
+	l.inflight.Dec()
+	if l.slots != nil {
+		<-l.slots
+	}
+}
+
+// close removes the metric of the store-gateway.
+func (l *concurrencyLimiter) close() {
+	l.metric.DeleteLabelValues(l.addr)
+}
+
+func (l *concurrencyLimiter) UnaryClientInterceptor(ctx context.Context, method string, req, reply any, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+	if err := l.acquire(ctx); err != nil {
+		return err
+	}
+	defer l.release()
+
+	return invoker(ctx, method, req, reply, cc, opts...)
+}
+
+// StreamClientInterceptor holds the slot until the stream has been fully consumed or, if the
+// caller stops reading it early, until the context of the stream is done.
+func (l *concurrencyLimiter) StreamClientInterceptor(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, streamer grpc.Streamer, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+	if err := l.acquire(ctx); err != nil {
+		return nil, err
+	}
+
+	stream, err := streamer(ctx, desc, cc, method, opts...)
+	if err != nil {
+		l.release()
+		return nil, err
+	}
+	return newInflightClientStream(stream, l.release), nil
+}
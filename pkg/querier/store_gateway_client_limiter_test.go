@@ -0,0 +1,125 @@
+package querier
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/thanos-io/thanos/pkg/store/storepb"
+	"github.com/weaveworks/common/user"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/cortexproject/cortex/pkg/storegateway/storegatewaypb"
+	"github.com/cortexproject/cortex/pkg/util/flagext"
+	"github.com/cortexproject/cortex/pkg/util/grpcclient"
+)
+
+func Test_newStoreGatewayClientFactory_ShouldLimitConcurrentRequests(t *testing.T) {
+	t.Parallel()
+
+	srv := &blockingStoreGatewayServer{started: make(chan struct{}), release: make(chan struct{})}
+	grpcServer := grpc.NewServer()
+	t.Cleanup(grpcServer.Stop)
+	storegatewaypb.RegisterStoreGatewayServer(grpcServer, srv)
+
+	listener, err := net.Listen("tcp", "localhost:0")
+	require.NoError(t, err)
+
+	go func() {
+		require.NoError(t, grpcServer.Serve(listener))
+	}()
+
+	clientConfig := ClientConfig{}
+	clientConfig.RegisterFlagsWithPrefix("test", flag.NewFlagSet("test", flag.PanicOnError))
+	clientConfig.MaxConcurrentRequests = 1
+
+	cfg := grpcclient.ConfigWithHealthCheck{}
+	flagext.DefaultValues(&cfg)
+
+	reg := prometheus.NewPedanticRegistry()
+	factory := newStoreGatewayClientFactory(cfg, clientConfig, newInflightRequests(), nil, reg)
+	addr := listener.Addr().String()
+	client, err := factory(addr)
+	require.NoError(t, err)
+
+	ctx := user.InjectOrgID(context.Background(), "test")
+	assertInflightRequests := func(expected int) {
+		require.NoError(t, testutil.GatherAndCompare(reg, strings.NewReader(fmt.Sprintf(`
+			# HELP cortex_storegateway_client_inflight_requests The current number of in-flight requests to each store-gateway.
+			# TYPE cortex_storegateway_client_inflight_requests gauge
+			cortex_storegateway_client_inflight_requests{address="%s",client="querier"} %d
+		`, addr, expected)), "cortex_storegateway_client_inflight_requests"))
+	}
+
+	// Issue a request which blocks until released.
+	requestErr := make(chan error, 1)
+	go func() {
+		_, err := client.(BlocksStoreClient).LabelNames(ctx, &storepb.LabelNamesRequest{})
+		requestErr <- err
+	}()
+	<-srv.started
+	assertInflightRequests(1)
+
+	// The request over the limit waits for a slot until its deadline.
+	limitedCtx, cancel := context.WithTimeout(ctx, 100*time.Millisecond)
+	defer cancel()
+	_, err = client.(BlocksStoreClient).LabelNames(limitedCtx, &storepb.LabelNamesRequest{})
+	require.Error(t, err)
+	assert.Equal(t, codes.ResourceExhausted, status.Code(err))
+	assert.Contains(t, err.Error(), "too many concurrent requests to store-gateway")
+
+	close(srv.release)
+	require.NoError(t, <-requestErr)
+	assertInflightRequests(0)
+
+	// The metric of the store-gateway is removed once its client is closed.
+	require.NoError(t, client.Close())
+	require.NoError(t, testutil.GatherAndCompare(reg, strings.NewReader(""), "cortex_storegateway_client_inflight_requests"))
+}
+
+func Test_newStoreGatewayClientFactory_ShouldReleaseTheSlotOfAbandonedSeriesStreams(t *testing.T) {
+	t.Parallel()
+
+	srv := &seriesStoreGatewayServer{series: labels.FromStrings("__name__", "test")}
+	addr := startStoreGatewayServer(t, srv)
+
+	clientConfig := ClientConfig{}
+	clientConfig.RegisterFlagsWithPrefix("test", flag.NewFlagSet("test", flag.PanicOnError))
+	clientConfig.MaxConcurrentRequests = 1
+
+	cfg := grpcclient.ConfigWithHealthCheck{}
+	flagext.DefaultValues(&cfg)
+
+	factory := newStoreGatewayClientFactory(cfg, clientConfig, newInflightRequests(), nil, prometheus.NewPedanticRegistry())
+	client, err := factory(addr)
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = client.Close() })
+
+	// More queries than the limit stop reading the stream before its end, e.g. because they
+	// hit a query limit, and cancel their requests.
+	for i := 0; i < 3; i++ {
+		ctx, cancel := context.WithCancel(user.InjectOrgID(context.Background(), "test"))
+		stream, err := client.(BlocksStoreClient).Series(ctx, &storepb.SeriesRequest{})
+		require.NoError(t, err)
+		_, err = stream.Recv()
+		require.NoError(t, err)
+		cancel()
+
+		// The slot is released, so that the next request doesn't wait for it.
+		nextCtx, nextCancel := context.WithTimeout(user.InjectOrgID(context.Background(), "test"), time.Second)
+		_, err = client.(BlocksStoreClient).LabelNames(nextCtx, &storepb.LabelNamesRequest{})
+		nextCancel()
+		require.NoError(t, err)
+	}
+}
@@ -0,0 +1,153 @@
+package querier
+
+import (
+	"context"
+	"net"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc/resolver"
+)
+
+// dnsLookupTimeout is the timeout of each DNS lookup of the caching resolver.
+const dnsLookupTimeout = 10 * time.Second
+
+// cachingDNSResolverBuilder builds the "dns" resolvers of the store-gateway connections,
+// sharing the DNS lookups of the same host across all the connections for a TTL, so that
+// creating a new connection doesn't re-resolve a host which has been recently resolved.
+type cachingDNSResolverBuilder struct {
+	ttl        time.Duration
+	lookupHost func(ctx context.Context, host string) ([]string, error)
+
+	mtx   sync.Mutex
+	cache map[string]cachedDNSLookup
+}
+
+type cachedDNSLookup struct {
+	addrs     []string
+	expiresAt time.Time
+}
+
+func newCachingDNSResolverBuilder(ttl time.Duration) *cachingDNSResolverBuilder {
+	return &cachingDNSResolverBuilder{
+		ttl:        ttl,
+		lookupHost: net.DefaultResolver.LookupHost,
+		cache:      map[string]cachedDNSLookup{},
+	}
+}
+
+func (b *cachingDNSResolverBuilder) Scheme() string {
+	return "dns"
+}
+
+func (b *cachingDNSResolverBuilder) Build(target resolver.Target, cc resolver.ClientConn, _ resolver.BuildOptions) (resolver.Resolver, error) {
+	host, port, err := net.SplitHostPort(target.Endpoint())
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	r := &cachingDNSResolver{
+		builder:    b,
+		host:       host,
+		port:       port,
+		cc:         cc,
+		ctx:        ctx,
+		cancel:     cancel,
+		resolveNow: make(chan struct{}, 1),
+	}
+	r.wg.Add(1)
+	go r.watcher()
+	return r, nil
+}
+
+// lookup returns the addresses of the given host, from the cache if they've been resolved
+// less than the TTL ago. The failed lookups aren't cached.
+func (b *cachingDNSResolverBuilder) lookup(ctx context.Context, host string) ([]string, error) {
+	b.mtx.Lock()
+	cached, ok := b.cache[host]
+	b.mtx.Unlock()
+	if ok && time.Now().Before(cached.expiresAt) {
+		return cached.addrs, nil
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, dnsLookupTimeout)
+	defer cancel()
+
+	addrs, err := b.lookupHost(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+
+	b.mtx.Lock()
+	b.cache[host] = cachedDNSLookup{addrs: addrs, expiresAt: time.Now().Add(b.ttl)}
+	b.mtx.Unlock()
+	return addrs, nil
+}
+
+// cachingDNSResolver resolves the target of a store-gateway connection with the lookups
+// cached by its builder. It resolves the target once built, and then whenever gRPC asks
+// for a new resolution, e.g. after a connection failure, but no more than once per TTL
+// after a failed lookup.
+type cachingDNSResolver struct {
+	builder    *cachingDNSResolverBuilder
+	host, port string
+	cc         resolver.ClientConn
+
+	ctx        context.Context
+	cancel     context.CancelFunc
+	resolveNow chan struct{}
+	wg         sync.WaitGroup
+}
+
+func (r *cachingDNSResolver) watcher() {
+	defer r.wg.Done()
+
+	for {
+		if err := r.resolve(); err != nil {
+			// The failed lookups aren't cached, so we wait for the TTL before resolving
+			// again, not to overload the DNS servers.
+			select {
+			case <-time.After(r.builder.ttl):
+			case <-r.ctx.Done():
+				return
+			}
+		}
+
+		select {
+		case <-r.resolveNow:
+		case <-r.ctx.Done():
+			return
+		}
+	}
+}
+
+func (r *cachingDNSResolver) resolve() error {
+	hosts := []string{r.host}
+	if net.ParseIP(r.host) == nil {
+		var err error
+		if hosts, err = r.builder.lookup(r.ctx, r.host); err != nil {
+			r.cc.ReportError(err)
+			return err
+		}
+	}
+
+	addrs := make([]resolver.Address, 0, len(hosts))
+	for _, host := range hosts {
+		addrs = append(addrs, resolver.Address{Addr: net.JoinHostPort(host, r.port)})
+	}
+	_ = r.cc.UpdateState(resolver.State{Addresses: addrs})
+	return nil
+}
+
+func (r *cachingDNSResolver) ResolveNow(resolver.ResolveNowOptions) {
+	select {
+	case r.resolveNow <- struct{}{}:
+	default:
+	}
+}
+
+func (r *cachingDNSResolver) Close() {
+	r.cancel()
+	r.wg.Wait()
+}
@@ -0,0 +1,76 @@
+package querier
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/thanos-io/thanos/pkg/store/storepb"
+	"go.uber.org/atomic"
+	"google.golang.org/grpc"
+
+	"github.com/cortexproject/cortex/pkg/util/flagext"
+	"github.com/cortexproject/cortex/pkg/util/grpcclient"
+)
+
+func TestCachingDNSResolverBuilder_ShouldShareTheLookupsAcrossConnections(t *testing.T) {
+	t.Parallel()
+
+	_, port, err := net.SplitHostPort(startStoreGatewayServer(t, &mockStoreGatewayServer{}))
+	require.NoError(t, err)
+
+	lookups := atomic.NewInt32(0)
+	builder := newCachingDNSResolverBuilder(time.Hour)
+	builder.lookupHost = func(_ context.Context, host string) ([]string, error) {
+		lookups.Inc()
+		if host != "store-gateway.test" {
+			return nil, &net.DNSError{Err: "no such host", Name: host, IsNotFound: true}
+		}
+		return []string{"127.0.0.1"}, nil
+	}
+
+	cfg := grpcclient.ConfigWithHealthCheck{}
+	flagext.DefaultValues(&cfg)
+	cfg.ConnectTimeout = 5 * time.Second
+	addr := net.JoinHostPort("store-gateway.test", port)
+
+	for range 3 {
+		client, err := dialStoreGatewayClient(cfg, addr, []grpc.DialOption{grpc.WithResolvers(builder)}, true, nil, nil)
+		require.NoError(t, err)
+
+		_, err = client.LabelNames(context.Background(), &storepb.LabelNamesRequest{})
+		require.NoError(t, err)
+		require.NoError(t, client.Close())
+	}
+
+	// The host has been resolved only once.
+	assert.Equal(t, int32(1), lookups.Load())
+
+	// The lookups are done again once expired.
+	builder.mtx.Lock()
+	builder.cache["store-gateway.test"] = cachedDNSLookup{addrs: []string{"127.0.0.1"}, expiresAt: time.Now()}
+	builder.mtx.Unlock()
+
+	client, err := dialStoreGatewayClient(cfg, addr, []grpc.DialOption{grpc.WithResolvers(builder)}, true, nil, nil)
+	require.NoError(t, err)
+	require.NoError(t, client.Close())
+	assert.Equal(t, int32(2), lookups.Load())
+
+	// The failed lookups fail the connection.
+	cfg.ConnectTimeout = 500 * time.Millisecond
+	_, err = dialStoreGatewayClient(cfg, net.JoinHostPort("unknown.test", port), []grpc.DialOption{grpc.WithResolvers(builder)}, true, nil, nil)
+	require.Error(t, err)
+}
+
+func TestClientConfig_Validate_DNSCacheTTL(t *testing.T) {
+	t.Parallel()
+
+	cfg := ClientConfig{DNSCacheTTL: -time.Second}
+	assert.Equal(t, errNegativeDNSCacheTTL, cfg.Validate())
+
+	cfg.DNSCacheTTL = time.Minute
+	assert.NoError(t, cfg.Validate())
+}
@@ -0,0 +1,277 @@
+package querier
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/cortexproject/cortex/pkg/util/backoff"
+	"github.com/cortexproject/cortex/pkg/util/flagext"
+)
+
+const (
+	// retryStatusCode is the status_code label value of the request duration metric
+	// observed for each attempt which failed and has been retried.
+	retryStatusCode = "retry"
+)
+
+// retryableStoreGatewayMethods are the idempotent store-gateway methods which can be retried.
+var retryableStoreGatewayMethods = map[string]struct{}{
+	"/gatewaypb.StoreGateway/Series":      {},
+	"/gatewaypb.StoreGateway/LabelNames":  {},
+	"/gatewaypb.StoreGateway/LabelValues": {},
+}
+
+// RetryConfig configures the retries of the failed requests to store-gateways.
+type RetryConfig struct {
+	MaxRetries     int                    `yaml:"max_retries"`
+	PerTryTimeout  time.Duration          `yaml:"per_try_timeout"`
+	RetryableCodes flagext.StringSliceCSV `yaml:"retryable_codes"`
+	MinBackoff     time.Duration          `yaml:"min_backoff"`
+	MaxBackoff     time.Duration          `yaml:"max_backoff"`
+}
+
+func (cfg *RetryConfig) RegisterFlagsWithPrefix(prefix string, f *flag.FlagSet) {
+	cfg.RetryableCodes = []string{codes.Unavailable.String()}
+
+	f.IntVar(&cfg.MaxRetries, prefix+".retry.max-retries", 0, "The maximum number of times a failed Series, LabelNames or LabelValues request to a store-gateway is retried. Series requests are only retried if no response has been received yet. 0 to disable retries.")
+	f.DurationVar(&cfg.PerTryTimeout, prefix+".retry.per-try-timeout", 0, "The timeout of each attempt, including the whole stream for Series requests. Attempts are anyway bound to the request deadline. 0 to disable.")
+	f.Var(&cfg.RetryableCodes, prefix+".retry.retryable-codes", "Comma-separated list of gRPC status codes for which a request is retried.")
+	f.DurationVar(&cfg.MinBackoff, prefix+".retry.min-backoff", 100*time.Millisecond, "Minimum delay before retrying a request.")
+	f.DurationVar(&cfg.MaxBackoff, prefix+".retry.max-backoff", time.Second, "Maximum delay before retrying a request.")
+}
+
+func (cfg *RetryConfig) Validate() error {
+	if cfg.MaxRetries <= 0 {
+		return nil
+	}
+	if _, err := parseGRPCCodes(cfg.RetryableCodes); err != nil {
+		return err
+	}
+	return nil
+}
+
+func parseGRPCCodes(names []string) (map[codes.Code]struct{}, error) {
+	known := map[string]codes.Code{}
+	for c := codes.OK; c <= codes.Unauthenticated; c++ {
+		known[c.String()] = c
+	}
+
+	parsed := make(map[codes.Code]struct{}, len(names))
+	for _, name := range names {
+		c, ok := known[name]
+		if !ok {
+			return nil, fmt.Errorf("store gateway client retry: unknown gRPC status code %q", name)
+		}
+		parsed[c] = struct{}{}
+	}
+	return parsed, nil
+}
+
+// storeGatewayRetry retries the idempotent requests to store-gateways failing
+// with a retryable status code.
+type storeGatewayRetry struct {
+	cfg             RetryConfig
+	retryableCodes  map[codes.Code]struct{}
+	requestDuration *prometheus.HistogramVec
+}
+
+func newStoreGatewayRetry(cfg RetryConfig, requestDuration *prometheus.HistogramVec) *storeGatewayRetry {
+	// The codes have already been validated.
+	retryableCodes, _ := parseGRPCCodes(cfg.RetryableCodes)
+
+	return &storeGatewayRetry{
+		cfg:             cfg,
+		retryableCodes:  retryableCodes,
+		requestDuration: requestDuration,
+	}
+}
+
+func (r *storeGatewayRetry) newBackoff(ctx context.Context) *backoff.Backoff {
+	return backoff.New(ctx, backoff.Config{
+		MinBackoff: r.cfg.MinBackoff,
+		MaxBackoff: r.cfg.MaxBackoff,
+		MaxRetries: r.cfg.MaxRetries,
+	})
+}
+
+func (r *storeGatewayRetry) isRetryable(err error) bool {
+	_, ok := r.retryableCodes[status.Code(err)]
+	return ok
+}
+
+// attemptContext returns the context of a single attempt, bound to the per-try timeout.
+func (r *storeGatewayRetry) attemptContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	if r.cfg.PerTryTimeout > 0 {
+		return context.WithTimeout(ctx, r.cfg.PerTryTimeout)
+	}
+	return context.WithCancel(ctx)
+}
+
+func (r *storeGatewayRetry) observeRetry(method string, elapsed time.Duration) {
+	r.requestDuration.WithLabelValues(method, retryStatusCode).Observe(elapsed.Seconds())
+}
+
+func (r *storeGatewayRetry) UnaryClientInterceptor(ctx context.Context, method string, req, reply any, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+	if _, ok := retryableStoreGatewayMethods[method]; !ok {
+		return invoker(ctx, method, req, reply, cc, opts...)
+	}
+
+	b := r.newBackoff(ctx)
+	for {
+		start := time.Now()
+		attemptCtx, cancel := r.attemptContext(ctx)
+		err := invoker(attemptCtx, method, req, reply, cc, opts...)
+		cancel()
+		elapsed := time.Since(start)
+
+		if err == nil || !r.isRetryable(err) || b.NumRetries() >= r.cfg.MaxRetries {
+			return err
+		}
+
+		// Do not retry once the request deadline has been reached.
+		b.Wait()
+		if ctx.Err() != nil {
+			return err
+		}
+		r.observeRetry(method, elapsed)
+	}
+}
+
+func (r *storeGatewayRetry) StreamClientInterceptor(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, streamer grpc.Streamer, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+	if _, ok := retryableStoreGatewayMethods[method]; !ok {
+		return streamer(ctx, desc, cc, method, opts...)
+	}
+
+	s := &retryingClientStream{
+		ctx:      ctx,
+		retry:    r,
+		backoff:  r.newBackoff(ctx),
+		desc:     desc,
+		cc:       cc,
+		method:   method,
+		streamer: streamer,
+		opts:     opts,
+	}
+	if err := s.open(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// retryingClientStream re-opens the stream, replaying the sent messages, if it fails with
+// a retryable error before any response has been received. Opening the stream is retried
+// too, since it fails immediately if there's no ready connection to the store-gateway,
+// e.g. while it's restarting. Once a response has been
+// received, errors are returned as is, because retrying would duplicate the responses.
+type retryingClientStream struct {
+	grpc.ClientStream
+
+	ctx      context.Context
+	retry    *storeGatewayRetry
+	backoff  *backoff.Backoff
+	desc     *grpc.StreamDesc
+	cc       *grpc.ClientConn
+	method   string
+	streamer grpc.Streamer
+	opts     []grpc.CallOption
+
+	start     time.Time
+	cancel    context.CancelFunc
+	sent      []any
+	closeSent bool
+	received  bool
+}
+
+// open opens a new attempt, retrying with backoff if it fails with a retryable error.
+func (s *retryingClientStream) open() error {
+	for {
+		err := s.openAttempt()
+		if err == nil || !s.shouldRetry(err) {
+			return err
+		}
+	}
+}
+
+// shouldRetry returns whether the attempt which failed with the given error is retried,
+// after waiting for the backoff.
+func (s *retryingClientStream) shouldRetry(err error) bool {
+	elapsed := time.Since(s.start)
+
+	if s.received || !s.retry.isRetryable(err) || s.backoff.NumRetries() >= s.retry.cfg.MaxRetries {
+		return false
+	}
+
+	// Do not retry once the request deadline has been reached.
+	s.backoff.Wait()
+	if s.ctx.Err() != nil {
+		return false
+	}
+	s.retry.observeRetry(s.method, elapsed)
+	return true
+}
+
+func (s *retryingClientStream) openAttempt() error {
+	s.start = time.Now()
+
+	attemptCtx, cancel := s.retry.attemptContext(s.ctx)
+	stream, err := s.streamer(attemptCtx, s.desc, s.cc, s.method, s.opts...)
+	if err != nil {
+		cancel()
+		return err
+	}
+
+	s.ClientStream, s.cancel = stream, cancel
+	return nil
+}
+
+func (s *retryingClientStream) SendMsg(m any) error {
+	s.sent = append(s.sent, m)
+	return s.ClientStream.SendMsg(m)
+}
+
+func (s *retryingClientStream) CloseSend() error {
+	s.closeSent = true
+	return s.ClientStream.CloseSend()
+}
+
+func (s *retryingClientStream) RecvMsg(m any) error {
+	for {
+		err := s.ClientStream.RecvMsg(m)
+		if err == nil {
+			s.received = true
+			return nil
+		}
+
+		s.cancel()
+		if !s.shouldRetry(err) {
+			return err
+		}
+
+		if err := s.replay(); err != nil {
+			return err
+		}
+	}
+}
+
+// replay opens a new attempt and sends it the messages sent to the previous one.
+func (s *retryingClientStream) replay() error {
+	if err := s.open(); err != nil {
+		return err
+	}
+	for _, m := range s.sent {
+		if err := s.ClientStream.SendMsg(m); err != nil {
+			return err
+		}
+	}
+	if s.closeSent {
+		return s.ClientStream.CloseSend()
+	}
+	return nil
+}
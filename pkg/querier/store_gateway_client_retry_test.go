@@ -0,0 +1,267 @@
+package querier
+
+import (
+	"context"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/thanos-io/thanos/pkg/store/storepb"
+	"github.com/weaveworks/common/user"
+	"go.uber.org/atomic"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/cortexproject/cortex/pkg/storegateway/storegatewaypb"
+	"github.com/cortexproject/cortex/pkg/util/flagext"
+	"github.com/cortexproject/cortex/pkg/util/grpcclient"
+)
+
+func TestStoreGatewayRetry(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string]struct {
+		failures         int32
+		failureCode      codes.Code
+		expectedErr      codes.Code
+		expectedRequests int32
+		expectedRetries  uint64
+	}{
+		"should not retry successful requests": {
+			expectedErr:      codes.OK,
+			expectedRequests: 1,
+		},
+		"should retry requests failing with a retryable code": {
+			failures:         2,
+			failureCode:      codes.Unavailable,
+			expectedErr:      codes.OK,
+			expectedRequests: 3,
+			expectedRetries:  2,
+		},
+		"should not retry requests failing with a non retryable code": {
+			failures:         2,
+			failureCode:      codes.Internal,
+			expectedErr:      codes.Internal,
+			expectedRequests: 1,
+		},
+		"should give up after max retries": {
+			failures:         10,
+			failureCode:      codes.Unavailable,
+			expectedErr:      codes.Unavailable,
+			expectedRequests: 4,
+			expectedRetries:  3,
+		},
+	}
+
+	for testName, testData := range tests {
+		t.Run(testName, func(t *testing.T) {
+			t.Parallel()
+
+			for _, operation := range []string{"Series", "LabelNames"} {
+				t.Run(operation, func(t *testing.T) {
+					t.Parallel()
+
+					srv := &flakyStoreGatewayServer{failures: testData.failures, failureCode: testData.failureCode}
+					reg := prometheus.NewPedanticRegistry()
+					client := newRetryingStoreGatewayClient(t, srv, reg, testRetryConfig(time.Millisecond))
+
+					ctx := user.InjectOrgID(context.Background(), "test")
+					var err error
+					switch operation {
+					case "Series":
+						err = readAllSeries(ctx, client)
+					case "LabelNames":
+						_, err = client.LabelNames(ctx, &storepb.LabelNamesRequest{})
+					}
+
+					assert.Equal(t, testData.expectedErr, status.Code(err))
+					assert.Equal(t, testData.expectedRequests, srv.requests.Load())
+					assert.Equal(t, testData.expectedRetries, countRetries(t, reg))
+				})
+			}
+		})
+	}
+}
+
+func TestStoreGatewayRetry_ShouldNotRetrySeriesOnceResponsesHaveBeenReceived(t *testing.T) {
+	t.Parallel()
+
+	srv := &flakyStoreGatewayServer{failures: 1, failureCode: codes.Unavailable, failAfterSeries: true}
+	reg := prometheus.NewPedanticRegistry()
+	client := newRetryingStoreGatewayClient(t, srv, reg, testRetryConfig(time.Millisecond))
+
+	err := readAllSeries(user.InjectOrgID(context.Background(), "test"), client)
+	assert.Equal(t, codes.Unavailable, status.Code(err))
+	assert.Equal(t, int32(1), srv.requests.Load())
+	assert.Equal(t, uint64(0), countRetries(t, reg))
+}
+
+func TestStoreGatewayRetry_ShouldRespectRequestDeadline(t *testing.T) {
+	t.Parallel()
+
+	srv := &flakyStoreGatewayServer{failures: 10, failureCode: codes.Unavailable}
+	reg := prometheus.NewPedanticRegistry()
+	client := newRetryingStoreGatewayClient(t, srv, reg, testRetryConfig(time.Minute))
+
+	ctx, cancel := context.WithTimeout(user.InjectOrgID(context.Background(), "test"), 50*time.Millisecond)
+	defer cancel()
+
+	_, err := client.LabelNames(ctx, &storepb.LabelNamesRequest{})
+	assert.Equal(t, codes.Unavailable, status.Code(err))
+
+	// The backoff is longer than the deadline, so the request is never retried.
+	assert.Equal(t, int32(1), srv.requests.Load())
+}
+
+func TestStoreGatewayRetry_ShouldRetryOpeningSeriesStreamWhileTheStoreGatewayIsDown(t *testing.T) {
+	t.Parallel()
+
+	// Reserve an address, and refuse the connections to it until the server is restarted.
+	listener, err := net.Listen("tcp", "localhost:0")
+	require.NoError(t, err)
+	addr := listener.Addr().String()
+	require.NoError(t, listener.Close())
+
+	cfg := grpcclient.ConfigWithHealthCheck{}
+	flagext.DefaultValues(&cfg)
+
+	reg := prometheus.NewPedanticRegistry()
+	clientCfg := ClientConfig{
+		TracingSampleRate: 1,
+		Retry:             testRetryConfig(50 * time.Millisecond),
+		ReconnectBackoff:  ReconnectBackoffConfig{BaseDelay: 50 * time.Millisecond, Multiplier: 1, MaxDelay: 50 * time.Millisecond},
+	}
+	clientCfg.Retry.MaxRetries = 50
+
+	factory := newStoreGatewayClientFactory(cfg, clientCfg, newInflightRequests(), nil, reg)
+	client, err := factory(addr)
+	require.NoError(t, err)
+	t.Cleanup(func() { require.NoError(t, client.Close()) })
+
+	// Bring the store-gateway back while the stream is being opened.
+	grpcServer := grpc.NewServer()
+	t.Cleanup(grpcServer.GracefulStop)
+	storegatewaypb.RegisterStoreGatewayServer(grpcServer, &seriesStoreGatewayServer{series: labels.FromStrings("series", "1")})
+	go func() {
+		time.Sleep(300 * time.Millisecond)
+		if listener, err := net.Listen("tcp", addr); err == nil {
+			_ = grpcServer.Serve(listener)
+		}
+	}()
+
+	stream, err := client.(*storeGatewayClient).Series(user.InjectOrgID(context.Background(), "test"), &storepb.SeriesRequest{})
+	require.NoError(t, err)
+	resp, err := stream.Recv()
+	require.NoError(t, err)
+	assert.Equal(t, labels.FromStrings("series", "1"), resp.GetSeries().PromLabels())
+	assert.Greater(t, countRetries(t, reg), uint64(0))
+}
+
+func newRetryingStoreGatewayClient(t *testing.T, srv storegatewaypb.StoreGatewayServer, reg prometheus.Registerer, retryCfg RetryConfig) *storeGatewayClient {
+	grpcServer := grpc.NewServer()
+	t.Cleanup(grpcServer.GracefulStop)
+	storegatewaypb.RegisterStoreGatewayServer(grpcServer, srv)
+
+	listener, err := net.Listen("tcp", "localhost:0")
+	require.NoError(t, err)
+
+	go func() {
+		require.NoError(t, grpcServer.Serve(listener))
+	}()
+
+	cfg := grpcclient.ConfigWithHealthCheck{}
+	flagext.DefaultValues(&cfg)
+
+	factory := newStoreGatewayClientFactory(cfg, ClientConfig{TracingSampleRate: 1, Retry: retryCfg}, newInflightRequests(), nil, reg)
+	client, err := factory(listener.Addr().String())
+	require.NoError(t, err)
+	t.Cleanup(func() { require.NoError(t, client.Close()) })
+
+	return client.(*storeGatewayClient)
+}
+
+func testRetryConfig(minBackoff time.Duration) RetryConfig {
+	return RetryConfig{
+		MaxRetries:     3,
+		RetryableCodes: []string{codes.Unavailable.String()},
+		MinBackoff:     minBackoff,
+		MaxBackoff:     2 * minBackoff,
+	}
+}
+
+func readAllSeries(ctx context.Context, client *storeGatewayClient) error {
+	stream, err := client.Series(ctx, &storepb.SeriesRequest{})
+	if err != nil {
+		return err
+	}
+
+	for {
+		if _, err := stream.Recv(); err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		}
+	}
+}
+
+// countRetries returns the number of retried attempts tracked by the request duration metric.
+func countRetries(t *testing.T, reg *prometheus.Registry) uint64 {
+	metrics, err := reg.Gather()
+	require.NoError(t, err)
+
+	count := uint64(0)
+	for _, family := range metrics {
+		if family.GetName() != "cortex_storegateway_client_request_duration_seconds" {
+			continue
+		}
+		for _, m := range family.GetMetric() {
+			for _, l := range m.GetLabel() {
+				if l.GetName() == "status_code" && l.GetValue() == retryStatusCode {
+					count += m.GetHistogram().GetSampleCount()
+				}
+			}
+		}
+	}
+	return count
+}
+
+// flakyStoreGatewayServer fails the first requests with the configured code.
+type flakyStoreGatewayServer struct {
+	failures    int32
+	failureCode codes.Code
+	// failAfterSeries makes the failing Series requests fail after a series has been sent.
+	failAfterSeries bool
+
+	requests atomic.Int32
+}
+
+func (m *flakyStoreGatewayServer) Series(_ *storepb.SeriesRequest, srv storegatewaypb.StoreGateway_SeriesServer) error {
+	if m.requests.Inc() > m.failures {
+		return nil
+	}
+
+	if m.failAfterSeries {
+		if err := srv.Send(storepb.NewSeriesResponse(&storepb.Series{})); err != nil {
+			return err
+		}
+	}
+	return status.Error(m.failureCode, "failure")
+}
+
+func (m *flakyStoreGatewayServer) LabelNames(context.Context, *storepb.LabelNamesRequest) (*storepb.LabelNamesResponse, error) {
+	if m.requests.Inc() > m.failures {
+		return &storepb.LabelNamesResponse{}, nil
+	}
+	return nil, status.Error(m.failureCode, "failure")
+}
+
+func (m *flakyStoreGatewayServer) LabelValues(context.Context, *storepb.LabelValuesRequest) (*storepb.LabelValuesResponse, error) {
+	return &storepb.LabelValuesResponse{}, nil
+}
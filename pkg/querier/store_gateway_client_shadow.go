@@ -0,0 +1,215 @@
+package querier
+
+import (
+	"context"
+	"flag"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/thanos-io/thanos/pkg/store/storepb"
+	"google.golang.org/grpc"
+
+	"github.com/cortexproject/cortex/pkg/ring/client"
+	"github.com/cortexproject/cortex/pkg/storegateway/storegatewaypb"
+	"github.com/cortexproject/cortex/pkg/util/flagext"
+	"github.com/cortexproject/cortex/pkg/util/grpcclient"
+	"github.com/cortexproject/cortex/pkg/util/services"
+)
+
+var (
+	errInvalidShadowFraction  = errors.New("store gateway client shadow fraction should be in the range [0, 1]")
+	errMissingShadowAddresses = errors.New("store gateway client shadow addresses are required when the shadow fraction is greater than 0")
+)
+
+// ShadowConfig configures the mirroring of a fraction of the requests to store-gateways
+// to a canary fleet.
+type ShadowConfig struct {
+	Fraction  float64                `yaml:"fraction"`
+	Addresses flagext.StringSliceCSV `yaml:"addresses"`
+}
+
+func (cfg *ShadowConfig) RegisterFlagsWithPrefix(prefix string, f *flag.FlagSet) {
+	f.Float64Var(&cfg.Fraction, prefix+".shadow.fraction", 0, "The fraction of requests to store-gateways which are also sent to the shadow store-gateways, in the range [0, 1]. The responses of the shadow requests are discarded. 0 to disable.")
+	f.Var(&cfg.Addresses, prefix+".shadow.addresses", "Comma-separated list of the addresses of the shadow store-gateways, in the host:port format. Each shadow request is sent to a random one.")
+}
+
+func (cfg *ShadowConfig) Validate() error {
+	if cfg.Fraction < 0 || cfg.Fraction > 1 {
+		return errInvalidShadowFraction
+	}
+	if cfg.Fraction == 0 {
+		return nil
+	}
+	if len(cfg.Addresses) == 0 {
+		return errMissingShadowAddresses
+	}
+	for _, addr := range cfg.Addresses {
+		if err := validateStoreGatewayAddress(addr); err != nil {
+			return errors.Wrapf(err, "invalid store gateway client shadow address %q", addr)
+		}
+	}
+	return nil
+}
+
+// shadowRequests asynchronously mirrors a fraction of the requests to the shadow store-gateways.
+type shadowRequests struct {
+	cfg  ShadowConfig
+	pool *client.Pool
+
+	// ctx is canceled on stop to abort the in-flight shadow requests.
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	mtx     sync.Mutex
+	stopped bool
+	wg      sync.WaitGroup
+}
+
+func newShadowRequests(cfg ShadowConfig, clientCfg grpcclient.ConfigWithHealthCheck, dialOpts []grpc.DialOption, logger log.Logger, reg prometheus.Registerer) *shadowRequests {
+	requestDuration := promauto.With(reg).NewHistogramVec(prometheus.HistogramOpts{
+		Namespace:   "cortex",
+		Name:        "storegateway_client_shadow_request_duration_seconds",
+		Help:        "Time spent executing shadow requests to the shadow store-gateways.",
+		Buckets:     prometheus.ExponentialBuckets(0.008, 4, 7),
+		ConstLabels: prometheus.Labels{"client": "querier"},
+	}, []string{"operation", "status_code"})
+
+	unaryInterceptors, streamInterceptors := grpcclient.Instrument(requestDuration)
+	factory := func(addr string) (client.PoolClient, error) {
+		return dialStoreGatewayClient(clientCfg, addr, dialOpts, false, unaryInterceptors, streamInterceptors)
+	}
+
+	poolCfg := client.PoolConfig{
+		CheckInterval:      time.Minute,
+		HealthCheckEnabled: true,
+		HealthCheckTimeout: 10 * time.Second,
+		HealthCheckService: clientCfg.HealthCheckConfig.ServiceName,
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	return &shadowRequests{
+		cfg:    cfg,
+		pool:   client.NewPool("store-gateway-shadow", poolCfg, nil, factory, nil, logger),
+		ctx:    ctx,
+		cancel: cancel,
+	}
+}
+
+// sample returns whether the request should be mirrored.
+func (s *shadowRequests) sample() bool {
+	return rand.Float64() < s.cfg.Fraction
+}
+
+// send asynchronously runs the shadow request against a random shadow store-gateway. The
+// shadow request is not canceled with the original request, but it keeps its deadline.
+func (s *shadowRequests) send(ctx context.Context, request func(context.Context, BlocksStoreClient)) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	if s.stopped {
+		return
+	}
+
+	var (
+		shadowCtx context.Context
+		cancel    context.CancelFunc
+	)
+	if deadline, ok := ctx.Deadline(); ok {
+		shadowCtx, cancel = context.WithDeadline(context.WithoutCancel(ctx), deadline)
+	} else {
+		shadowCtx, cancel = context.WithCancel(context.WithoutCancel(ctx))
+	}
+	addr := s.cfg.Addresses[rand.Intn(len(s.cfg.Addresses))]
+
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		defer cancel()
+		defer context.AfterFunc(s.ctx, cancel)()
+
+		c, err := s.pool.GetClientFor(addr)
+		if err != nil {
+			return
+		}
+		request(shadowCtx, c.(BlocksStoreClient))
+	}()
+}
+
+func (s *shadowRequests) starting(ctx context.Context) error {
+	return services.StartAndAwaitRunning(ctx, s.pool)
+}
+
+// stop aborts the in-flight shadow requests and closes the clients.
+func (s *shadowRequests) stop() error {
+	s.mtx.Lock()
+	s.stopped = true
+	s.mtx.Unlock()
+
+	s.cancel()
+	s.wg.Wait()
+
+	err := services.StopAndAwaitTerminated(context.Background(), s.pool)
+	for _, addr := range s.pool.RegisteredAddresses() {
+		s.pool.RemoveClientFor(addr)
+	}
+	return err
+}
+
+// storeGatewayPoolClient is a store-gateway client managed by the clients pool.
+type storeGatewayPoolClient interface {
+	BlocksStoreClient
+	client.PoolClient
+	client.GracefulCloser
+}
+
+// shadowingClient is a store-gateway client mirroring a fraction of its requests to the
+// shadow store-gateways.
+type shadowingClient struct {
+	storeGatewayPoolClient
+
+	shadow *shadowRequests
+}
+
+func (c *shadowingClient) Series(ctx context.Context, req *storepb.SeriesRequest, opts ...grpc.CallOption) (storegatewaypb.StoreGateway_SeriesClient, error) {
+	if c.shadow.sample() {
+		c.shadow.send(ctx, func(ctx context.Context, shadowClient BlocksStoreClient) {
+			stream, err := shadowClient.Series(ctx, req)
+			if err != nil {
+				return
+			}
+
+			// Consume the whole response, so that the request is fully tracked.
+			for {
+				if _, err := stream.Recv(); err != nil {
+					return
+				}
+			}
+		})
+	}
+
+	return c.storeGatewayPoolClient.Series(ctx, req, opts...)
+}
+
+func (c *shadowingClient) LabelNames(ctx context.Context, req *storepb.LabelNamesRequest, opts ...grpc.CallOption) (*storepb.LabelNamesResponse, error) {
+	if c.shadow.sample() {
+		c.shadow.send(ctx, func(ctx context.Context, shadowClient BlocksStoreClient) {
+			_, _ = shadowClient.LabelNames(ctx, req)
+		})
+	}
+
+	return c.storeGatewayPoolClient.LabelNames(ctx, req, opts...)
+}
+
+func (c *shadowingClient) LabelValues(ctx context.Context, req *storepb.LabelValuesRequest, opts ...grpc.CallOption) (*storepb.LabelValuesResponse, error) {
+	if c.shadow.sample() {
+		c.shadow.send(ctx, func(ctx context.Context, shadowClient BlocksStoreClient) {
+			_, _ = shadowClient.LabelValues(ctx, req)
+		})
+	}
+
+	return c.storeGatewayPoolClient.LabelValues(ctx, req, opts...)
+}
@@ -0,0 +1,160 @@
+package querier
+
+import (
+	"context"
+	"flag"
+	"net"
+	"testing"
+
+	"github.com/go-kit/log"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/thanos-io/thanos/pkg/store/storepb"
+	"github.com/weaveworks/common/user"
+	"google.golang.org/grpc"
+
+	"github.com/cortexproject/cortex/pkg/storegateway/storegatewaypb"
+	"github.com/cortexproject/cortex/pkg/util/services"
+)
+
+func TestStoreGatewayClientPool_ShouldShadowRequestsAtConfiguredRate(t *testing.T) {
+	t.Parallel()
+
+	const numRequests = 1000
+
+	tests := map[string]struct {
+		fraction            float64
+		expectedShadowed    int
+		expectedShadowDelta float64
+	}{
+		"should not shadow requests with 0 fraction": {
+			fraction:         0,
+			expectedShadowed: 0,
+		},
+		"should shadow a fraction of the requests": {
+			fraction:            0.25,
+			expectedShadowed:    numRequests / 4,
+			expectedShadowDelta: 75,
+		},
+		"should shadow all requests with 1 fraction": {
+			fraction:         1,
+			expectedShadowed: numRequests,
+		},
+	}
+
+	for testName, testData := range tests {
+		t.Run(testName, func(t *testing.T) {
+			t.Parallel()
+
+			primary, primaryAddr := startFlakyStoreGatewayServer(t)
+			canary, canaryAddr := startFlakyStoreGatewayServer(t)
+
+			cfg := ClientConfig{}
+			cfg.RegisterFlagsWithPrefix("test", flag.NewFlagSet("test", flag.PanicOnError))
+			cfg.Shadow.Fraction = testData.fraction
+			cfg.Shadow.Addresses = []string{canaryAddr}
+			require.NoError(t, cfg.Validate())
+
+			reg := prometheus.NewPedanticRegistry()
+			pool := newStoreGatewayClientPool(nil, cfg, log.NewNopLogger(), reg)
+			require.NoError(t, services.StartAndAwaitRunning(context.Background(), pool))
+
+			c, err := pool.GetClientFor(primaryAddr)
+			require.NoError(t, err)
+
+			ctx := user.InjectOrgID(context.Background(), "test")
+			for range numRequests {
+				_, err := c.(BlocksStoreClient).LabelNames(ctx, &storepb.LabelNamesRequest{})
+				require.NoError(t, err)
+			}
+
+			// Wait until all shadow requests have completed.
+			if pool.shadow != nil {
+				pool.shadow.wg.Wait()
+			}
+			require.NoError(t, services.StopAndAwaitTerminated(context.Background(), pool))
+
+			assert.Equal(t, int32(numRequests), primary.requests.Load())
+			assert.InDelta(t, testData.expectedShadowed, canary.requests.Load(), testData.expectedShadowDelta)
+
+			// The shadow requests are tracked separately from the real ones.
+			assert.Equal(t, uint64(canary.requests.Load()), histogramSampleCount(t, reg, "cortex_storegateway_client_shadow_request_duration_seconds"))
+			assert.Equal(t, uint64(numRequests), histogramSampleCount(t, reg, "cortex_storegateway_client_request_duration_seconds"))
+		})
+	}
+}
+
+func TestShadowConfig_Validate(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string]struct {
+		cfg         ShadowConfig
+		expectedErr string
+	}{
+		"should pass when disabled": {
+			cfg: ShadowConfig{},
+		},
+		"should pass with fraction and addresses": {
+			cfg: ShadowConfig{Fraction: 0.1, Addresses: []string{"canary:9095"}},
+		},
+		"should fail with out of range fraction": {
+			cfg:         ShadowConfig{Fraction: 1.1, Addresses: []string{"canary:9095"}},
+			expectedErr: errInvalidShadowFraction.Error(),
+		},
+		"should fail without addresses": {
+			cfg:         ShadowConfig{Fraction: 0.1},
+			expectedErr: errMissingShadowAddresses.Error(),
+		},
+		"should fail with invalid address": {
+			cfg:         ShadowConfig{Fraction: 0.1, Addresses: []string{"canary"}},
+			expectedErr: `invalid store gateway client shadow address "canary"`,
+		},
+	}
+
+	for testName, testData := range tests {
+		t.Run(testName, func(t *testing.T) {
+			t.Parallel()
+
+			err := testData.cfg.Validate()
+			if testData.expectedErr == "" {
+				require.NoError(t, err)
+				return
+			}
+			require.ErrorContains(t, err, testData.expectedErr)
+		})
+	}
+}
+
+func startFlakyStoreGatewayServer(t *testing.T) (*flakyStoreGatewayServer, string) {
+	srv := &flakyStoreGatewayServer{}
+
+	grpcServer := grpc.NewServer()
+	t.Cleanup(grpcServer.GracefulStop)
+	storegatewaypb.RegisterStoreGatewayServer(grpcServer, srv)
+
+	listener, err := net.Listen("tcp", "localhost:0")
+	require.NoError(t, err)
+
+	go func() {
+		require.NoError(t, grpcServer.Serve(listener))
+	}()
+
+	return srv, listener.Addr().String()
+}
+
+func histogramSampleCount(t *testing.T, reg *prometheus.Registry, name string) uint64 {
+	metrics, err := reg.Gather()
+	require.NoError(t, err)
+
+	count := uint64(0)
+	for _, m := range metrics {
+		if m.GetName() != name {
+			continue
+		}
+		for _, metric := range m.GetMetric() {
+			count += metric.GetHistogram().GetSampleCount()
+		}
+	}
+	return count
+}
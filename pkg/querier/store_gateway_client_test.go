@@ -2,20 +2,46 @@ package querier
 
 import (
 	"context"
+	"flag"
+	"fmt"
+	"io"
+	"math"
 	"net"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 
+	"github.com/go-kit/log"
+	"github.com/gogo/protobuf/types"
+	"github.com/oklog/ulid/v2"
+	"github.com/opentracing/opentracing-go"
+	"github.com/opentracing/opentracing-go/mocktracer"
 	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
 	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/prometheus/model/labels"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	"github.com/thanos-io/thanos/pkg/block"
+	"github.com/thanos-io/thanos/pkg/store/hintspb"
+	"github.com/thanos-io/thanos/pkg/store/labelpb"
 	"github.com/thanos-io/thanos/pkg/store/storepb"
 	"github.com/weaveworks/common/user"
+	"go.uber.org/atomic"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/backoff"
+	"google.golang.org/grpc/connectivity"
+	"google.golang.org/grpc/keepalive"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/stats"
 
+	"github.com/cortexproject/cortex/pkg/storage/tsdb/bucketindex"
 	"github.com/cortexproject/cortex/pkg/storegateway/storegatewaypb"
+	"github.com/cortexproject/cortex/pkg/util/concurrency"
 	"github.com/cortexproject/cortex/pkg/util/flagext"
 	"github.com/cortexproject/cortex/pkg/util/grpcclient"
+	"github.com/cortexproject/cortex/pkg/util/grpcencoding/zstd"
 )
 
 func Test_newStoreGatewayClientFactory(t *testing.T) {
@@ -40,7 +66,7 @@ func Test_newStoreGatewayClientFactory(t *testing.T) {
 	flagext.DefaultValues(&cfg)
 
 	reg := prometheus.NewPedanticRegistry()
-	factory := newStoreGatewayClientFactory(cfg, reg)
+	factory := newStoreGatewayClientFactory(cfg, ClientConfig{TracingSampleRate: 1}, newInflightRequests(), nil, reg)
 
 	for range 2 {
 		client, err := factory(listener.Addr().String())
@@ -61,11 +87,415 @@ func Test_newStoreGatewayClientFactory(t *testing.T) {
 	metrics, err := reg.Gather()
 	require.NoError(t, err)
 
-	assert.Len(t, metrics, 1)
-	assert.Equal(t, "cortex_storegateway_client_request_duration_seconds", metrics[0].GetName())
-	assert.Equal(t, dto.MetricType_HISTOGRAM, metrics[0].GetType())
-	assert.Len(t, metrics[0].GetMetric(), 1)
-	assert.Equal(t, uint64(2), metrics[0].GetMetric()[0].GetHistogram().GetSampleCount())
+	var requestDuration *dto.MetricFamily
+	for _, m := range metrics {
+		if m.GetName() == "cortex_storegateway_client_request_duration_seconds" {
+			requestDuration = m
+		}
+	}
+	require.NotNil(t, requestDuration)
+	assert.Equal(t, dto.MetricType_HISTOGRAM, requestDuration.GetType())
+	assert.Len(t, requestDuration.GetMetric(), 1)
+	assert.Equal(t, uint64(2), requestDuration.GetMetric()[0].GetHistogram().GetSampleCount())
+}
+
+func Test_newStoreGatewayClientFactory_ShouldTrackConnectionsChurn(t *testing.T) {
+	t.Parallel()
+
+	cfg := grpcclient.ConfigWithHealthCheck{}
+	flagext.DefaultValues(&cfg)
+
+	reg := prometheus.NewPedanticRegistry()
+	factory := newStoreGatewayClientFactory(cfg, ClientConfig{TracingSampleRate: 1}, newInflightRequests(), nil, reg)
+
+	first, err := factory("127.0.0.1:9095")
+	require.NoError(t, err)
+	second, err := factory("127.0.0.1:9096")
+	require.NoError(t, err)
+	defer second.Close() //nolint:errcheck
+
+	// Failing to create a client doesn't count as a created connection.
+	_, err = factory("127.0.0.1")
+	require.Error(t, err)
+
+	// Closing a client multiple times counts as a single closed connection.
+	require.NoError(t, first.Close())
+	require.Error(t, first.Close())
+
+	require.NoError(t, testutil.GatherAndCompare(reg, strings.NewReader(`
+		# HELP cortex_storegateway_client_connections_closed_total Total number of connections to store-gateways closed.
+		# TYPE cortex_storegateway_client_connections_closed_total counter
+		cortex_storegateway_client_connections_closed_total{client="querier"} 1
+		# HELP cortex_storegateway_client_connections_created_total Total number of connections created to store-gateways.
+		# TYPE cortex_storegateway_client_connections_created_total counter
+		cortex_storegateway_client_connections_created_total{client="querier"} 2
+	`), "cortex_storegateway_client_connections_created_total", "cortex_storegateway_client_connections_closed_total"))
+}
+
+func Test_newStoreGatewayClientFactory_TraceSampling(t *testing.T) {
+	tracer := mocktracer.New()
+	prevTracer := opentracing.GlobalTracer()
+	opentracing.SetGlobalTracer(tracer)
+	t.Cleanup(func() { opentracing.SetGlobalTracer(prevTracer) })
+
+	grpcServer := grpc.NewServer()
+	defer grpcServer.GracefulStop()
+
+	storegatewaypb.RegisterStoreGatewayServer(grpcServer, &mockStoreGatewayServer{})
+
+	listener, err := net.Listen("tcp", "localhost:0")
+	require.NoError(t, err)
+
+	go func() {
+		require.NoError(t, grpcServer.Serve(listener))
+	}()
+
+	cfg := grpcclient.ConfigWithHealthCheck{}
+	flagext.DefaultValues(&cfg)
+
+	// Disable sampling, so that only the force sampled requests get traced.
+	factory := newStoreGatewayClientFactory(cfg, ClientConfig{TracingSampleRate: 0}, newInflightRequests(), nil, prometheus.NewPedanticRegistry())
+	client, err := factory(listener.Addr().String())
+	require.NoError(t, err)
+	defer client.Close() //nolint:errcheck
+
+	ctx := user.InjectOrgID(context.Background(), "test")
+	_, err = client.(*storeGatewayClient).LabelNames(ctx, &storepb.LabelNamesRequest{})
+	require.NoError(t, err)
+	assert.Empty(t, tracer.FinishedSpans())
+
+	_, err = client.(*storeGatewayClient).LabelNames(InjectForceTraceSamplingIntoContext(ctx), &storepb.LabelNamesRequest{})
+	require.NoError(t, err)
+	require.Len(t, tracer.FinishedSpans(), 1)
+	assert.Equal(t, "/gatewaypb.StoreGateway/LabelNames", tracer.FinishedSpans()[0].OperationName)
+}
+
+func Test_newStoreGatewayClientFactory_ShouldTraceEachOperation(t *testing.T) {
+	tracer := mocktracer.New()
+	prevTracer := opentracing.GlobalTracer()
+	opentracing.SetGlobalTracer(tracer)
+	t.Cleanup(func() { opentracing.SetGlobalTracer(prevTracer) })
+
+	// Record the methods whose requests carried the trace context in the gRPC metadata.
+	var tracedMtx sync.Mutex
+	var traced []string
+	recordTraced := func(ctx context.Context, method string) {
+		if md, ok := metadata.FromIncomingContext(ctx); ok && len(md.Get("mockpfx-ids-traceid")) > 0 {
+			tracedMtx.Lock()
+			traced = append(traced, method)
+			tracedMtx.Unlock()
+		}
+	}
+	addr := startStoreGatewayServer(t, &labelValuesStoreGatewayServer{},
+		grpc.UnaryInterceptor(func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+			recordTraced(ctx, info.FullMethod)
+			return handler(ctx, req)
+		}),
+		grpc.StreamInterceptor(func(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+			recordTraced(ss.Context(), info.FullMethod)
+			return handler(srv, ss)
+		}),
+	)
+
+	cfg := grpcclient.ConfigWithHealthCheck{}
+	flagext.DefaultValues(&cfg)
+
+	factory := newStoreGatewayClientFactory(cfg, ClientConfig{TracingSampleRate: 1}, newInflightRequests(), nil, prometheus.NewPedanticRegistry())
+	client, err := factory(addr)
+	require.NoError(t, err)
+	defer client.Close() //nolint:errcheck
+
+	ctx := user.InjectOrgID(context.Background(), "test")
+	stream, err := client.(*storeGatewayClient).Series(ctx, &storepb.SeriesRequest{})
+	require.NoError(t, err)
+	_, err = stream.Recv()
+	require.Equal(t, io.EOF, err)
+
+	_, err = client.(*storeGatewayClient).LabelNames(ctx, &storepb.LabelNamesRequest{})
+	require.NoError(t, err)
+	_, err = client.(*storeGatewayClient).LabelValues(ctx, &storepb.LabelValuesRequest{})
+	require.NoError(t, err)
+
+	expected := []string{
+		"/gatewaypb.StoreGateway/Series",
+		"/gatewaypb.StoreGateway/LabelNames",
+		"/gatewaypb.StoreGateway/LabelValues",
+	}
+
+	var operations []string
+	for _, span := range tracer.FinishedSpans() {
+		operations = append(operations, span.OperationName)
+	}
+	assert.ElementsMatch(t, expected, operations)
+
+	tracedMtx.Lock()
+	defer tracedMtx.Unlock()
+	assert.ElementsMatch(t, expected, traced)
+}
+
+func Test_newStoreGatewayClientFactory_ShouldSupportZstdCompression(t *testing.T) {
+	t.Parallel()
+
+	expected := labels.FromStrings("__name__", "test", "instance", "store-gateway")
+	compression := &compressionStatsHandler{}
+
+	grpcServer := grpc.NewServer(grpc.StatsHandler(compression))
+	defer grpcServer.GracefulStop()
+
+	storegatewaypb.RegisterStoreGatewayServer(grpcServer, &seriesStoreGatewayServer{series: expected})
+
+	listener, err := net.Listen("tcp", "localhost:0")
+	require.NoError(t, err)
+
+	go func() {
+		require.NoError(t, grpcServer.Serve(listener))
+	}()
+
+	cfg := grpcclient.ConfigWithHealthCheck{}
+	flagext.DefaultValues(&cfg)
+	cfg.GRPCCompression = zstd.Name
+
+	factory := newStoreGatewayClientFactory(cfg, ClientConfig{TracingSampleRate: 1}, newInflightRequests(), nil, prometheus.NewPedanticRegistry())
+	client, err := factory(listener.Addr().String())
+	require.NoError(t, err)
+	defer client.Close() //nolint:errcheck
+
+	stream, err := client.(*storeGatewayClient).Series(user.InjectOrgID(context.Background(), "test"), &storepb.SeriesRequest{})
+	require.NoError(t, err)
+
+	res, err := stream.Recv()
+	require.NoError(t, err)
+	assert.Equal(t, expected, labelpb.ZLabelsToPromLabels(res.GetSeries().Labels))
+
+	_, err = stream.Recv()
+	require.Equal(t, io.EOF, err)
+	assert.Equal(t, zstd.Name, compression.compression.Load())
+}
+
+func TestClientConfig_Validate_GRPCCompression(t *testing.T) {
+	t.Parallel()
+
+	for _, compression := range []string{"", "gzip", "snappy", "snappy-block", "zstd"} {
+		cfg := ClientConfig{}
+		cfg.RegisterFlagsWithPrefix("test", flag.NewFlagSet("test", flag.PanicOnError))
+		cfg.GRPCCompression = compression
+		assert.NoError(t, cfg.Validate(), compression)
+	}
+
+	cfg := ClientConfig{}
+	cfg.RegisterFlagsWithPrefix("test", flag.NewFlagSet("test", flag.PanicOnError))
+	cfg.GRPCCompression = "lz4"
+	assert.ErrorContains(t, cfg.Validate(), "unsupported store gateway client compression type: lz4")
+}
+
+func TestClientConfig_Validate_LoadBalancingPolicy(t *testing.T) {
+	t.Parallel()
+
+	for _, policy := range []string{"", "pick_first", "round_robin"} {
+		cfg := ClientConfig{}
+		cfg.RegisterFlagsWithPrefix("test", flag.NewFlagSet("test", flag.PanicOnError))
+		cfg.LoadBalancingPolicy = policy
+		assert.NoError(t, cfg.Validate(), policy)
+	}
+
+	cfg := ClientConfig{}
+	cfg.RegisterFlagsWithPrefix("test", flag.NewFlagSet("test", flag.PanicOnError))
+	cfg.LoadBalancingPolicy = "random"
+	assert.ErrorContains(t, cfg.Validate(), "unsupported store gateway client load balancing policy: random")
+}
+
+func TestClientConfig_Validate_PoolUnhealthyThreshold(t *testing.T) {
+	t.Parallel()
+
+	for _, threshold := range []int{0, 1, 3} {
+		cfg := ClientConfig{}
+		cfg.RegisterFlagsWithPrefix("test", flag.NewFlagSet("test", flag.PanicOnError))
+		cfg.PoolUnhealthyThreshold = threshold
+		assert.NoError(t, cfg.Validate(), threshold)
+	}
+
+	cfg := ClientConfig{}
+	cfg.RegisterFlagsWithPrefix("test", flag.NewFlagSet("test", flag.PanicOnError))
+	cfg.PoolUnhealthyThreshold = -1
+	assert.Equal(t, errNegativePoolUnhealthyThreshold, cfg.Validate())
+}
+
+func TestClientConfig_Validate_RequestDurationBuckets(t *testing.T) {
+	t.Parallel()
+
+	for _, buckets := range [][]float64{nil, {1}, {0.1, 0.5, 1}} {
+		cfg := ClientConfig{}
+		cfg.RegisterFlagsWithPrefix("test", flag.NewFlagSet("test", flag.PanicOnError))
+		cfg.RequestDurationBuckets = buckets
+		assert.NoError(t, cfg.Validate(), buckets)
+	}
+
+	for _, buckets := range [][]float64{{1, 1}, {0.1, 1, 0.5}} {
+		cfg := ClientConfig{}
+		cfg.RegisterFlagsWithPrefix("test", flag.NewFlagSet("test", flag.PanicOnError))
+		cfg.RequestDurationBuckets = buckets
+		assert.Equal(t, errNonIncreasingRequestDurationBuckets, cfg.Validate(), buckets)
+	}
+}
+
+func Test_newStoreGatewayClientFactory_ShouldUseRequestDurationBuckets(t *testing.T) {
+	t.Parallel()
+
+	addr := startStoreGatewayServer(t, &mockStoreGatewayServer{})
+
+	cfg := grpcclient.ConfigWithHealthCheck{}
+	flagext.DefaultValues(&cfg)
+
+	reg := prometheus.NewPedanticRegistry()
+	factory := newStoreGatewayClientFactory(cfg, ClientConfig{TracingSampleRate: 1, RequestDurationBuckets: []float64{0.1, 0.5, 1}}, newInflightRequests(), nil, reg)
+	client, err := factory(addr)
+	require.NoError(t, err)
+	defer client.Close() //nolint:errcheck
+
+	_, err = client.(*storeGatewayClient).LabelNames(user.InjectOrgID(context.Background(), "test"), &storepb.LabelNamesRequest{})
+	require.NoError(t, err)
+
+	metrics, err := reg.Gather()
+	require.NoError(t, err)
+
+	var upperBounds []float64
+	for _, m := range metrics {
+		if m.GetName() != "cortex_storegateway_client_request_duration_seconds" {
+			continue
+		}
+		for _, b := range m.GetMetric()[0].GetHistogram().GetBucket() {
+			upperBounds = append(upperBounds, b.GetUpperBound())
+		}
+	}
+	assert.Equal(t, []float64{0.1, 0.5, 1}, upperBounds)
+}
+
+func Test_newStoreGatewayClientFactory_ShouldApplyLoadBalancingPolicy(t *testing.T) {
+	t.Parallel()
+
+	expected := labels.FromStrings("__name__", "test", "instance", "store-gateway")
+	addr := startStoreGatewayServer(t, &seriesStoreGatewayServer{series: expected})
+
+	cfg := grpcclient.ConfigWithHealthCheck{}
+	flagext.DefaultValues(&cfg)
+
+	factory := newStoreGatewayClientFactory(cfg, ClientConfig{TracingSampleRate: 1, LoadBalancingPolicy: "round_robin"}, newInflightRequests(), nil, prometheus.NewPedanticRegistry())
+	client, err := factory(addr)
+	require.NoError(t, err)
+	defer client.Close() //nolint:errcheck
+
+	stream, err := client.(*storeGatewayClient).Series(user.InjectOrgID(context.Background(), "test"), &storepb.SeriesRequest{})
+	require.NoError(t, err)
+
+	res, err := stream.Recv()
+	require.NoError(t, err)
+	assert.Equal(t, expected, labelpb.ZLabelsToPromLabels(res.GetSeries().Labels))
+}
+
+func TestClientConfig_Validate_GRPCAuthority(t *testing.T) {
+	t.Parallel()
+
+	for _, authority := range []string{"", "store-gateway", "store-gateway.cortex.svc:9095", "127.0.0.1:9095", "[::1]:9095"} {
+		cfg := ClientConfig{}
+		cfg.RegisterFlagsWithPrefix("test", flag.NewFlagSet("test", flag.PanicOnError))
+		cfg.GRPCAuthority = authority
+		assert.NoError(t, cfg.Validate(), authority)
+	}
+
+	for _, authority := range []string{"http://store-gateway", "store-gateway/path", "user@store-gateway", "store-gateway:", "store-gateway:port", "store-gateway:70000", ":9095"} {
+		cfg := ClientConfig{}
+		cfg.RegisterFlagsWithPrefix("test", flag.NewFlagSet("test", flag.PanicOnError))
+		cfg.GRPCAuthority = authority
+		assert.ErrorContains(t, cfg.Validate(), fmt.Sprintf("invalid store gateway client gRPC authority %q", authority))
+	}
+}
+
+func Test_newStoreGatewayClientFactory_ShouldApplyGRPCAuthority(t *testing.T) {
+	t.Parallel()
+
+	srv := &authorityStoreGatewayServer{}
+	addr := startStoreGatewayServer(t, srv)
+
+	cfg := grpcclient.ConfigWithHealthCheck{}
+	flagext.DefaultValues(&cfg)
+
+	factory := newStoreGatewayClientFactory(cfg, ClientConfig{TracingSampleRate: 1, GRPCAuthority: "store-gateway.cortex.svc:9095"}, newInflightRequests(), nil, prometheus.NewPedanticRegistry())
+	client, err := factory(addr)
+	require.NoError(t, err)
+	defer client.Close() //nolint:errcheck
+
+	_, err = client.(*storeGatewayClient).LabelNames(user.InjectOrgID(context.Background(), "test"), &storepb.LabelNamesRequest{})
+	require.NoError(t, err)
+	assert.Equal(t, "store-gateway.cortex.svc:9095", srv.authority.Load())
+
+	// The client is still identified by the dialed address.
+	assert.Equal(t, addr, client.(*storeGatewayClient).RemoteAddress())
+}
+
+// authorityStoreGatewayServer records the :authority header of the last request.
+type authorityStoreGatewayServer struct {
+	mockStoreGatewayServer
+
+	authority atomic.String
+}
+
+func (m *authorityStoreGatewayServer) LabelNames(ctx context.Context, _ *storepb.LabelNamesRequest) (*storepb.LabelNamesResponse, error) {
+	if md, ok := metadata.FromIncomingContext(ctx); ok && len(md.Get(":authority")) > 0 {
+		m.authority.Store(md.Get(":authority")[0])
+	}
+	return &storepb.LabelNamesResponse{}, nil
+}
+
+func TestLoadBalancingServiceConfig(t *testing.T) {
+	t.Parallel()
+
+	assert.JSONEq(t, `{"loadBalancingConfig": [{"round_robin": {}}]}`, loadBalancingServiceConfig("round_robin"))
+}
+
+func Test_dialStoreGatewayClient_ShouldValidateAddress(t *testing.T) {
+	t.Parallel()
+
+	cfg := grpcclient.ConfigWithHealthCheck{}
+	flagext.DefaultValues(&cfg)
+
+	tests := map[string]struct {
+		addr        string
+		expectedErr string
+	}{
+		"empty address": {
+			addr:        "",
+			expectedErr: "invalid store-gateway address \"\": empty address",
+		},
+		"missing port": {
+			addr:        "127.0.0.1",
+			expectedErr: "invalid store-gateway address \"127.0.0.1\"",
+		},
+		"valid address": {
+			addr: "127.0.0.1:9095",
+		},
+		"valid hostname address": {
+			addr: "localhost:9095",
+		},
+		"valid target with a resolver scheme": {
+			addr: "dns:///localhost:9095",
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			client, err := dialStoreGatewayClient(cfg, tc.addr, nil, false, nil, nil)
+			if tc.expectedErr != "" {
+				require.ErrorContains(t, err, tc.expectedErr)
+				return
+			}
+
+			require.NoError(t, err)
+			assert.Equal(t, tc.addr, client.RemoteAddress())
+			require.NoError(t, client.Close())
+		})
+	}
 }
 
 type mockStoreGatewayServer struct{}
@@ -75,9 +505,257 @@ func (m *mockStoreGatewayServer) Series(_ *storepb.SeriesRequest, srv storegatew
 }
 
 func (m *mockStoreGatewayServer) LabelNames(context.Context, *storepb.LabelNamesRequest) (*storepb.LabelNamesResponse, error) {
-	return nil, nil
+	return &storepb.LabelNamesResponse{}, nil
 }
 
 func (m *mockStoreGatewayServer) LabelValues(context.Context, *storepb.LabelValuesRequest) (*storepb.LabelValuesResponse, error) {
 	return nil, nil
 }
+
+// seriesStoreGatewayServer returns a single series from the Series requests.
+type seriesStoreGatewayServer struct {
+	mockStoreGatewayServer
+
+	series labels.Labels
+}
+
+func (m *seriesStoreGatewayServer) Series(_ *storepb.SeriesRequest, srv storegatewaypb.StoreGateway_SeriesServer) error {
+	return srv.Send(storepb.NewSeriesResponse(&storepb.Series{Labels: labelpb.ZLabelsFromPromLabels(m.series)}))
+}
+
+// labelValuesStoreGatewayServer returns an empty response from the LabelValues requests.
+type labelValuesStoreGatewayServer struct {
+	mockStoreGatewayServer
+}
+
+func (m *labelValuesStoreGatewayServer) LabelValues(context.Context, *storepb.LabelValuesRequest) (*storepb.LabelValuesResponse, error) {
+	return &storepb.LabelValuesResponse{}, nil
+}
+
+// compressionStatsHandler records the compression of the requests received by the server.
+type compressionStatsHandler struct {
+	compression atomic.String
+}
+
+func (h *compressionStatsHandler) TagRPC(ctx context.Context, _ *stats.RPCTagInfo) context.Context {
+	return ctx
+}
+
+func (h *compressionStatsHandler) HandleRPC(_ context.Context, s stats.RPCStats) {
+	if header, ok := s.(*stats.InHeader); ok {
+		h.compression.Store(header.Compression)
+	}
+}
+
+func (h *compressionStatsHandler) TagConn(ctx context.Context, _ *stats.ConnTagInfo) context.Context {
+	return ctx
+}
+
+func (h *compressionStatsHandler) HandleConn(context.Context, stats.ConnStats) {}
+
+func TestClientConfig_connectParams(t *testing.T) {
+	t.Parallel()
+
+	cfg := ClientConfig{}
+	cfg.RegisterFlagsWithPrefix("test", flag.NewFlagSet("test", flag.PanicOnError))
+	assert.Equal(t, grpc.ConnectParams{
+		Backoff:           backoff.DefaultConfig,
+		MinConnectTimeout: 5 * time.Second,
+	}, cfg.connectParams())
+
+	cfg.ReconnectBackoff = ReconnectBackoffConfig{BaseDelay: 2 * time.Second, Multiplier: 2, Jitter: 0.5, MaxDelay: time.Minute}
+	cfg.ConnectTimeout = 0
+	assert.Equal(t, grpc.ConnectParams{
+		Backoff:           backoff.Config{BaseDelay: 2 * time.Second, Multiplier: 2, Jitter: 0.5, MaxDelay: time.Minute},
+		MinConnectTimeout: defaultGRPCConnectTimeout,
+	}, cfg.connectParams())
+
+	// The zero config uses the gRPC defaults.
+	assert.Equal(t, backoff.DefaultConfig, (&ClientConfig{}).connectParams().Backoff)
+}
+
+func TestReconnectBackoffConfig_Validate(t *testing.T) {
+	t.Parallel()
+
+	valid := ReconnectBackoffConfig{BaseDelay: time.Second, Multiplier: 1.6, Jitter: 0.2, MaxDelay: time.Minute}
+
+	tests := map[string]struct {
+		cfg         func(cfg *ReconnectBackoffConfig)
+		expectedErr error
+	}{
+		"valid": {
+			cfg: func(*ReconnectBackoffConfig) {},
+		},
+		"zero config": {
+			cfg: func(cfg *ReconnectBackoffConfig) { *cfg = ReconnectBackoffConfig{} },
+		},
+		"zero base delay": {
+			cfg:         func(cfg *ReconnectBackoffConfig) { cfg.BaseDelay = 0 },
+			expectedErr: errInvalidReconnectBackoffBaseDelay,
+		},
+		"multiplier lower than 1": {
+			cfg:         func(cfg *ReconnectBackoffConfig) { cfg.Multiplier = 0.5 },
+			expectedErr: errInvalidReconnectBackoffMultiplier,
+		},
+		"jitter out of range": {
+			cfg:         func(cfg *ReconnectBackoffConfig) { cfg.Jitter = 1.5 },
+			expectedErr: errInvalidReconnectBackoffJitter,
+		},
+		"max delay lower than base delay": {
+			cfg:         func(cfg *ReconnectBackoffConfig) { cfg.MaxDelay = time.Millisecond },
+			expectedErr: errInvalidReconnectBackoffMaxDelay,
+		},
+	}
+
+	for testName, testData := range tests {
+		t.Run(testName, func(t *testing.T) {
+			cfg := valid
+			testData.cfg(&cfg)
+			assert.Equal(t, testData.expectedErr, cfg.Validate())
+		})
+	}
+}
+
+func TestClientConfig_keepaliveParams(t *testing.T) {
+	t.Parallel()
+
+	cfg := ClientConfig{}
+	cfg.RegisterFlagsWithPrefix("test", flag.NewFlagSet("test", flag.PanicOnError))
+	assert.Equal(t, keepalive.ClientParameters{
+		Time:                20 * time.Second,
+		Timeout:             10 * time.Second,
+		PermitWithoutStream: true,
+	}, cfg.keepaliveParams())
+
+	// Keepalive pings are disabled with a 0 time.
+	cfg.KeepaliveTime = 0
+	assert.Equal(t, time.Duration(math.MaxInt64), cfg.keepaliveParams().Time)
+}
+
+func Test_dialStoreGatewayClient_EagerConnect(t *testing.T) {
+	t.Parallel()
+
+	cfg := grpcclient.ConfigWithHealthCheck{}
+	flagext.DefaultValues(&cfg)
+	cfg.ConnectTimeout = 500 * time.Millisecond
+
+	t.Run("should connect to a reachable store-gateway", func(t *testing.T) {
+		t.Parallel()
+
+		_, addr := startFlakyStoreGatewayServer(t)
+
+		client, err := dialStoreGatewayClient(cfg, addr, nil, true, nil, nil)
+		require.NoError(t, err)
+		assert.Equal(t, connectivity.Ready, client.conn.GetState())
+		assert.Equal(t, addr+" [READY]", client.String())
+		assert.Equal(t, addr, client.RemoteAddress())
+		require.NoError(t, client.Close())
+	})
+
+	t.Run("should fail within the connect timeout if the store-gateway is unreachable", func(t *testing.T) {
+		t.Parallel()
+
+		// Get a free address nothing listens on.
+		listener, err := net.Listen("tcp", "localhost:0")
+		require.NoError(t, err)
+		addr := listener.Addr().String()
+		require.NoError(t, listener.Close())
+
+		start := time.Now()
+		_, err = dialStoreGatewayClient(cfg, addr, nil, true, nil, nil)
+		require.ErrorContains(t, err, "failed to connect to store-gateway "+addr)
+		assert.Less(t, time.Since(start), 5*time.Second)
+	})
+}
+
+func TestStoreGatewayClientPool_RemoveClients(t *testing.T) {
+	t.Parallel()
+
+	cfg := ClientConfig{}
+	cfg.RegisterFlagsWithPrefix("test", flag.NewFlagSet("test", flag.PanicOnError))
+
+	logs := &concurrency.SyncBuffer{}
+	pool := newStoreGatewayClientPool(nil, cfg, log.NewLogfmtLogger(logs), prometheus.NewPedanticRegistry())
+
+	first, err := pool.GetClientFor("127.0.0.1:1")
+	require.NoError(t, err)
+	_, err = pool.GetClientFor("127.0.0.1:2")
+	require.NoError(t, err)
+
+	pool.RemoveClients([]string{"127.0.0.1:1", "127.0.0.1:3"})
+	assert.Equal(t, []string{"127.0.0.1:2"}, pool.RegisteredAddresses())
+	assert.Equal(t, "level=info msg=\"closed store-gateway client\" addr=127.0.0.1:1\n", logs.String())
+
+	// The removed client is created anew.
+	recreated, err := pool.GetClientFor("127.0.0.1:1")
+	require.NoError(t, err)
+	assert.NotSame(t, first, recreated)
+
+	pool.RemoveClients(pool.RegisteredAddresses())
+	assert.Empty(t, pool.RegisteredAddresses())
+}
+
+func TestWithBlocksFromContext(t *testing.T) {
+	block1 := ulid.MustNew(1, nil)
+	block2 := ulid.MustNew(2, nil)
+	block3 := ulid.MustNew(3, nil)
+
+	blockMatchers := func(ids ...ulid.ULID) []storepb.LabelMatcher {
+		return []storepb.LabelMatcher{{
+			Type:  storepb.LabelMatcher_RE,
+			Name:  block.BlockIDLabel,
+			Value: strings.Join(convertULIDsToString(ids), "|"),
+		}}
+	}
+	marshalHints := func(hints *hintspb.SeriesRequestHints) *types.Any {
+		anyHints, err := types.MarshalAny(hints)
+		require.NoError(t, err)
+		return anyHints
+	}
+
+	tests := map[string]struct {
+		ctx           context.Context
+		hints         *types.Any
+		expectedHints *hintspb.SeriesRequestHints
+	}{
+		"no blocks in the context": {
+			ctx: context.Background(),
+		},
+		"blocks in the context and no hints": {
+			ctx:           InjectBlocksIntoContext(context.Background(), &bucketindex.Block{ID: block1}, &bucketindex.Block{ID: block2}),
+			expectedHints: &hintspb.SeriesRequestHints{BlockMatchers: blockMatchers(block1, block2)},
+		},
+		"blocks in the context and hints without block matchers": {
+			ctx:           InjectBlocksIntoContext(context.Background(), &bucketindex.Block{ID: block1}),
+			hints:         marshalHints(&hintspb.SeriesRequestHints{EnableQueryStats: true}),
+			expectedHints: &hintspb.SeriesRequestHints{BlockMatchers: blockMatchers(block1), EnableQueryStats: true},
+		},
+		"blocks in the context and hints with block matchers": {
+			ctx:           InjectBlocksIntoContext(context.Background(), &bucketindex.Block{ID: block1}),
+			hints:         marshalHints(&hintspb.SeriesRequestHints{BlockMatchers: blockMatchers(block3)}),
+			expectedHints: &hintspb.SeriesRequestHints{BlockMatchers: blockMatchers(block3)},
+		},
+	}
+
+	for testName, testData := range tests {
+		t.Run(testName, func(t *testing.T) {
+			req := &storepb.SeriesRequest{MinTime: 10, MaxTime: 20, Hints: testData.hints}
+			actual, err := withBlocksFromContext(testData.ctx, req)
+			require.NoError(t, err)
+
+			// The original request is never modified.
+			require.Equal(t, testData.hints, req.Hints)
+			require.Equal(t, int64(10), actual.MinTime)
+			require.Equal(t, int64(20), actual.MaxTime)
+
+			if testData.expectedHints == nil {
+				require.Nil(t, actual.Hints)
+				return
+			}
+
+			hints := &hintspb.SeriesRequestHints{}
+			require.NoError(t, types.UnmarshalAny(actual.Hints, hints))
+			require.Equal(t, testData.expectedHints, hints)
+		})
+	}
+}
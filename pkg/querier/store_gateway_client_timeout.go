@@ -0,0 +1,170 @@
+package querier
+
+import (
+	"context"
+	"flag"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"google.golang.org/grpc"
+)
+
+var (
+	errInvalidAdaptiveTimeoutRange      = errors.New("store gateway client adaptive timeout min timeout should be greater than 0 and lower or equal than max timeout")
+	errInvalidAdaptiveTimeoutPercentile = errors.New("store gateway client adaptive timeout percentile should be in the range (0, 1]")
+	errInvalidAdaptiveTimeoutMultiplier = errors.New("store gateway client adaptive timeout multiplier should be greater or equal than 1")
+	errInvalidAdaptiveTimeoutWindowSize = errors.New("store gateway client adaptive timeout window size should be greater than 0")
+)
+
+// AdaptiveTimeoutConfig configures per-operation timeouts of the requests to store-gateways,
+// computed from the latency of the recent requests.
+type AdaptiveTimeoutConfig struct {
+	Enabled    bool          `yaml:"enabled"`
+	MinTimeout time.Duration `yaml:"min_timeout"`
+	MaxTimeout time.Duration `yaml:"max_timeout"`
+	Percentile float64       `yaml:"percentile"`
+	Multiplier float64       `yaml:"multiplier"`
+	WindowSize int           `yaml:"window_size"`
+}
+
+func (cfg *AdaptiveTimeoutConfig) RegisterFlagsWithPrefix(prefix string, f *flag.FlagSet) {
+	f.BoolVar(&cfg.Enabled, prefix+".adaptive-timeout.enabled", false, "True to enable adaptive timeouts for the requests to store-gateways, computed for each operation from the latency of the recent requests.")
+	f.DurationVar(&cfg.MinTimeout, prefix+".adaptive-timeout.min-timeout", time.Second, "The minimum adaptive timeout.")
+	f.DurationVar(&cfg.MaxTimeout, prefix+".adaptive-timeout.max-timeout", 2*time.Minute, "The maximum adaptive timeout. This timeout is also used until the latency of some requests has been observed.")
+	f.Float64Var(&cfg.Percentile, prefix+".adaptive-timeout.percentile", 0.99, "The percentile of the recent requests latency the adaptive timeout is computed from, in the range (0, 1].")
+	f.Float64Var(&cfg.Multiplier, prefix+".adaptive-timeout.multiplier", 2, "The multiplier applied to the latency percentile to compute the adaptive timeout.")
+	f.IntVar(&cfg.WindowSize, prefix+".adaptive-timeout.window-size", 100, "The number of recent requests, for each operation, the latency percentile is computed on.")
+}
+
+func (cfg *AdaptiveTimeoutConfig) Validate() error {
+	if !cfg.Enabled {
+		return nil
+	}
+	if cfg.MinTimeout <= 0 || cfg.MinTimeout > cfg.MaxTimeout {
+		return errInvalidAdaptiveTimeoutRange
+	}
+	if cfg.Percentile <= 0 || cfg.Percentile > 1 {
+		return errInvalidAdaptiveTimeoutPercentile
+	}
+	if cfg.Multiplier < 1 {
+		return errInvalidAdaptiveTimeoutMultiplier
+	}
+	if cfg.WindowSize <= 0 {
+		return errInvalidAdaptiveTimeoutWindowSize
+	}
+	return nil
+}
+
+// adaptiveTimeouts tracks the latency of the recent requests for each operation and
+// computes the timeout to apply to the next ones.
+type adaptiveTimeouts struct {
+	cfg AdaptiveTimeoutConfig
+
+	mtx        sync.Mutex
+	latencies  map[string][]time.Duration
+	nextSample map[string]int
+	timeouts   map[string]time.Duration
+
+	effectiveTimeout *prometheus.GaugeVec
+}
+
+func newAdaptiveTimeouts(cfg AdaptiveTimeoutConfig, reg prometheus.Registerer) *adaptiveTimeouts {
+	return &adaptiveTimeouts{
+		cfg:        cfg,
+		latencies:  map[string][]time.Duration{},
+		nextSample: map[string]int{},
+		timeouts:   map[string]time.Duration{},
+		effectiveTimeout: promauto.With(reg).NewGaugeVec(prometheus.GaugeOpts{
+			Namespace:   "cortex",
+			Name:        "storegateway_client_adaptive_timeout_seconds",
+			Help:        "The timeout currently applied to the requests to the store-gateway.",
+			ConstLabels: prometheus.Labels{"client": "querier"},
+		}, []string{"operation"}),
+	}
+}
+
+// timeout returns the timeout to apply to the next request of the given operation.
+func (t *adaptiveTimeouts) timeout(operation string) time.Duration {
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+
+	if timeout, ok := t.timeouts[operation]; ok {
+		return timeout
+	}
+	return t.cfg.MaxTimeout
+}
+
+// observe records the latency of a request and updates the timeout of its operation.
+func (t *adaptiveTimeouts) observe(operation string, latency time.Duration) {
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+
+	// Keep the latencies of the last requests in a ring buffer.
+	latencies := t.latencies[operation]
+	if len(latencies) < t.cfg.WindowSize {
+		latencies = append(latencies, latency)
+	} else {
+		latencies[t.nextSample[operation]] = latency
+	}
+	t.latencies[operation] = latencies
+	t.nextSample[operation] = (t.nextSample[operation] + 1) % t.cfg.WindowSize
+
+	sorted := slices.Clone(latencies)
+	slices.Sort(sorted)
+	idx := int(float64(len(sorted)-1) * t.cfg.Percentile)
+
+	timeout := min(max(time.Duration(float64(sorted[idx])*t.cfg.Multiplier), t.cfg.MinTimeout), t.cfg.MaxTimeout)
+	t.timeouts[operation] = timeout
+	t.effectiveTimeout.WithLabelValues(operation).Set(timeout.Seconds())
+}
+
+func (t *adaptiveTimeouts) UnaryClientInterceptor(ctx context.Context, method string, req, reply any, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+	ctx, cancel := context.WithTimeout(ctx, t.timeout(method))
+	defer cancel()
+
+	start := time.Now()
+	err := invoker(ctx, method, req, reply, cc, opts...)
+	t.observe(method, time.Since(start))
+	return err
+}
+
+func (t *adaptiveTimeouts) StreamClientInterceptor(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, streamer grpc.Streamer, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+	ctx, cancel := context.WithTimeout(ctx, t.timeout(method))
+
+	start := time.Now()
+	stream, err := streamer(ctx, desc, cc, method, opts...)
+	if err != nil {
+		cancel()
+		t.observe(method, time.Since(start))
+		return nil, err
+	}
+
+	return &adaptiveTimeoutClientStream{
+		ClientStream: stream,
+		done: func() {
+			cancel()
+			t.observe(method, time.Since(start))
+		},
+	}, nil
+}
+
+// adaptiveTimeoutClientStream releases the stream timeout and records its latency
+// once the stream has been fully consumed.
+type adaptiveTimeoutClientStream struct {
+	grpc.ClientStream
+
+	once sync.Once
+	done func()
+}
+
+func (s *adaptiveTimeoutClientStream) RecvMsg(m any) error {
+	err := s.ClientStream.RecvMsg(m)
+	if err != nil {
+		s.once.Do(s.done)
+	}
+	return err
+}
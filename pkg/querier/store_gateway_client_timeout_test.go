@@ -0,0 +1,113 @@
+package querier
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAdaptiveTimeouts_ShouldRaiseTimeoutWithLatenciesUpToMax(t *testing.T) {
+	t.Parallel()
+
+	const operation = "/gatewaypb.StoreGateway/Series"
+
+	reg := prometheus.NewPedanticRegistry()
+	timeouts := newAdaptiveTimeouts(AdaptiveTimeoutConfig{
+		Enabled:    true,
+		MinTimeout: time.Second,
+		MaxTimeout: 10 * time.Second,
+		Percentile: 1,
+		Multiplier: 2,
+		WindowSize: 3,
+	}, reg)
+
+	// The max timeout is used until some latencies have been observed.
+	assert.Equal(t, 10*time.Second, timeouts.timeout(operation))
+
+	// The timeout never goes below the min.
+	timeouts.observe(operation, 100*time.Millisecond)
+	assert.Equal(t, time.Second, timeouts.timeout(operation))
+
+	// Rising latencies raise the timeout.
+	timeouts.observe(operation, 2*time.Second)
+	assert.Equal(t, 4*time.Second, timeouts.timeout(operation))
+
+	timeouts.observe(operation, 4*time.Second)
+	assert.Equal(t, 8*time.Second, timeouts.timeout(operation))
+
+	// The timeout is capped to the max.
+	timeouts.observe(operation, 8*time.Second)
+	assert.Equal(t, 10*time.Second, timeouts.timeout(operation))
+
+	assert.NoError(t, testutil.GatherAndCompare(reg, strings.NewReader(`
+		# HELP cortex_storegateway_client_adaptive_timeout_seconds The timeout currently applied to the requests to the store-gateway.
+		# TYPE cortex_storegateway_client_adaptive_timeout_seconds gauge
+		cortex_storegateway_client_adaptive_timeout_seconds{client="querier",operation="/gatewaypb.StoreGateway/Series"} 10
+	`)))
+
+	// Once the slow requests are out of the window, the timeout gets lower again.
+	for range 3 {
+		timeouts.observe(operation, time.Second)
+	}
+	assert.Equal(t, 2*time.Second, timeouts.timeout(operation))
+
+	// Each operation has its own timeout.
+	assert.Equal(t, 10*time.Second, timeouts.timeout("/gatewaypb.StoreGateway/LabelNames"))
+}
+
+func TestAdaptiveTimeoutConfig_Validate(t *testing.T) {
+	t.Parallel()
+
+	valid := AdaptiveTimeoutConfig{Enabled: true, MinTimeout: time.Second, MaxTimeout: time.Minute, Percentile: 0.99, Multiplier: 2, WindowSize: 100}
+
+	tests := map[string]struct {
+		setup    func(cfg *AdaptiveTimeoutConfig)
+		expected error
+	}{
+		"valid config": {
+			setup: func(*AdaptiveTimeoutConfig) {},
+		},
+		"disabled config is not validated": {
+			setup: func(cfg *AdaptiveTimeoutConfig) {
+				*cfg = AdaptiveTimeoutConfig{}
+			},
+		},
+		"min timeout greater than max timeout": {
+			setup: func(cfg *AdaptiveTimeoutConfig) {
+				cfg.MinTimeout = 2 * time.Minute
+			},
+			expected: errInvalidAdaptiveTimeoutRange,
+		},
+		"percentile out of range": {
+			setup: func(cfg *AdaptiveTimeoutConfig) {
+				cfg.Percentile = 1.5
+			},
+			expected: errInvalidAdaptiveTimeoutPercentile,
+		},
+		"multiplier lower than 1": {
+			setup: func(cfg *AdaptiveTimeoutConfig) {
+				cfg.Multiplier = 0.5
+			},
+			expected: errInvalidAdaptiveTimeoutMultiplier,
+		},
+		"empty window": {
+			setup: func(cfg *AdaptiveTimeoutConfig) {
+				cfg.WindowSize = 0
+			},
+			expected: errInvalidAdaptiveTimeoutWindowSize,
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			cfg := valid
+			tc.setup(&cfg)
+			require.Equal(t, tc.expected, cfg.Validate())
+		})
+	}
+}
@@ -0,0 +1,86 @@
+package querier
+
+import (
+	"crypto/tls"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+
+	cortextls "github.com/cortexproject/cortex/pkg/util/tls"
+)
+
+// clientCertificateReloader provides the client certificate to the TLS handshakes,
+// reloading it from disk once the reload interval has elapsed since the last load.
+// Since the certificate is only presented during the handshake, a reloaded certificate
+// is used by the connections created after the reload.
+type clientCertificateReloader struct {
+	cfg            cortextls.ClientConfig
+	certPath       string
+	keyPath        string
+	reloadInterval time.Duration
+	reloadFailures prometheus.Counter
+
+	mtx      sync.Mutex
+	cert     *tls.Certificate
+	loadedAt time.Time
+}
+
+func newClientCertificateReloader(cfg cortextls.ClientConfig, reloadInterval time.Duration, reg prometheus.Registerer) *clientCertificateReloader {
+	r := &clientCertificateReloader{
+		cfg:            cfg,
+		certPath:       cfg.CertPath,
+		keyPath:        cfg.KeyPath,
+		reloadInterval: reloadInterval,
+		reloadFailures: promauto.With(reg).NewCounter(prometheus.CounterOpts{
+			Namespace:   "cortex",
+			Name:        "storegateway_client_tls_certificate_reload_failures_total",
+			Help:        "Total number of failures reloading the client TLS certificate used to connect to store-gateways.",
+			ConstLabels: prometheus.Labels{"client": "querier"},
+		}),
+	}
+
+	// The certificate is loaded by the reloader, not when building the TLS config.
+	r.cfg.CertPath, r.cfg.KeyPath = "", ""
+	return r
+}
+
+// dialOption returns the transport credentials presenting the reloaded client certificate.
+func (r *clientCertificateReloader) dialOption() (grpc.DialOption, error) {
+	tlsConfig, err := r.cfg.GetTLSConfig()
+	if err != nil {
+		return nil, errors.Wrap(err, "error creating grpc dial options")
+	}
+
+	tlsConfig.GetClientCertificate = r.getClientCertificate
+
+	return grpc.WithTransportCredentials(credentials.NewTLS(tlsConfig)), nil
+}
+
+func (r *clientCertificateReloader) getClientCertificate(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+
+	if r.cert != nil && time.Since(r.loadedAt) < r.reloadInterval {
+		return r.cert, nil
+	}
+
+	cert, err := tls.LoadX509KeyPair(r.certPath, r.keyPath)
+	r.loadedAt = time.Now()
+	if err != nil {
+		r.reloadFailures.Inc()
+
+		// Keep using the previous certificate, if any, while the files are being rotated.
+		if r.cert != nil {
+			return r.cert, nil
+		}
+		return nil, errors.Wrapf(err, "failed to load TLS certificate %s,%s", r.certPath, r.keyPath)
+	}
+
+	r.cert = &cert
+	return r.cert, nil
+}
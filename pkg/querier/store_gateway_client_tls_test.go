@@ -0,0 +1,200 @@
+package querier
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"flag"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/thanos-io/thanos/pkg/store/storepb"
+	"github.com/weaveworks/common/user"
+	"go.uber.org/atomic"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/peer"
+
+	"github.com/cortexproject/cortex/integration/ca"
+	"github.com/cortexproject/cortex/pkg/storegateway/storegatewaypb"
+	"github.com/cortexproject/cortex/pkg/util/flagext"
+	"github.com/cortexproject/cortex/pkg/util/grpcclient"
+)
+
+func Test_newStoreGatewayClientFactory_ShouldReloadClientCertificate(t *testing.T) {
+	t.Parallel()
+
+	const reloadInterval = 100 * time.Millisecond
+
+	dir := t.TempDir()
+	testCA := ca.New("Cortex Test")
+	caCertFile := filepath.Join(dir, "ca.crt")
+	require.NoError(t, testCA.WriteCACertificate(caCertFile))
+
+	serverCertFile := filepath.Join(dir, "server.crt")
+	serverKeyFile := filepath.Join(dir, "server.key")
+	require.NoError(t, testCA.WriteCertificate(&x509.Certificate{
+		Subject:     pkix.Name{CommonName: "server"},
+		DNSNames:    []string{"localhost"},
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}, serverCertFile, serverKeyFile))
+
+	clientCertFile := filepath.Join(dir, "client.crt")
+	clientKeyFile := filepath.Join(dir, "client.key")
+	writeClientCert := func(commonName string) {
+		require.NoError(t, os.RemoveAll(clientCertFile))
+		require.NoError(t, os.RemoveAll(clientKeyFile))
+		require.NoError(t, testCA.WriteCertificate(&x509.Certificate{
+			Subject:     pkix.Name{CommonName: commonName},
+			ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+		}, clientCertFile, clientKeyFile))
+	}
+	writeClientCert("client-1")
+
+	// Start a store-gateway requiring a client certificate.
+	serverCert, err := tls.LoadX509KeyPair(serverCertFile, serverKeyFile)
+	require.NoError(t, err)
+	caCert, err := os.ReadFile(caCertFile)
+	require.NoError(t, err)
+	clientCAs := x509.NewCertPool()
+	require.True(t, clientCAs.AppendCertsFromPEM(caCert))
+
+	srv := &peerStoreGatewayServer{}
+	grpcServer := grpc.NewServer(grpc.Creds(credentials.NewTLS(&tls.Config{
+		Certificates: []tls.Certificate{serverCert},
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+		ClientCAs:    clientCAs,
+	})))
+	t.Cleanup(grpcServer.GracefulStop)
+	storegatewaypb.RegisterStoreGatewayServer(grpcServer, srv)
+
+	listener, err := net.Listen("tcp", "localhost:0")
+	require.NoError(t, err)
+
+	go func() {
+		require.NoError(t, grpcServer.Serve(listener))
+	}()
+
+	clientConfig := ClientConfig{}
+	clientConfig.RegisterFlagsWithPrefix("test", flag.NewFlagSet("test", flag.PanicOnError))
+	clientConfig.TLSEnabled = true
+	clientConfig.TLS.CertPath = clientCertFile
+	clientConfig.TLS.KeyPath = clientKeyFile
+	clientConfig.TLS.CAPath = caCertFile
+	clientConfig.TLS.ServerName = "localhost"
+	clientConfig.TLSReloadInterval = reloadInterval
+
+	cfg := grpcclient.ConfigWithHealthCheck{}
+	flagext.DefaultValues(&cfg)
+	cfg.TLSEnabled = true
+	cfg.TLS = clientConfig.TLS
+
+	reg := prometheus.NewPedanticRegistry()
+	factory := newStoreGatewayClientFactory(cfg, clientConfig, newInflightRequests(), nil, reg)
+
+	requestWithNewClient := func() string {
+		client, err := factory(listener.Addr().String())
+		require.NoError(t, err)
+		defer client.Close() //nolint:errcheck
+
+		_, err = client.(*storeGatewayClient).LabelNames(user.InjectOrgID(context.Background(), "test"), &storepb.LabelNamesRequest{})
+		require.NoError(t, err)
+		return srv.commonName.Load()
+	}
+
+	assert.Equal(t, "client-1", requestWithNewClient())
+
+	// The rotated certificate is used by the new connections once the reload interval has elapsed.
+	writeClientCert("client-2")
+	time.Sleep(reloadInterval)
+	assert.Equal(t, "client-2", requestWithNewClient())
+
+	// The previous certificate is kept if the files can't be loaded.
+	require.NoError(t, os.WriteFile(clientCertFile, []byte("invalid"), 0644))
+	time.Sleep(reloadInterval)
+	assert.Equal(t, "client-2", requestWithNewClient())
+	require.NoError(t, testutil.GatherAndCompare(reg, strings.NewReader(`
+		# HELP cortex_storegateway_client_tls_certificate_reload_failures_total Total number of failures reloading the client TLS certificate used to connect to store-gateways.
+		# TYPE cortex_storegateway_client_tls_certificate_reload_failures_total counter
+		cortex_storegateway_client_tls_certificate_reload_failures_total{client="querier"} 1
+	`), "cortex_storegateway_client_tls_certificate_reload_failures_total"))
+}
+
+// peerStoreGatewayServer records the common name of the client certificate of the last request.
+type peerStoreGatewayServer struct {
+	mockStoreGatewayServer
+
+	commonName atomic.String
+}
+
+func (m *peerStoreGatewayServer) LabelNames(ctx context.Context, _ *storepb.LabelNamesRequest) (*storepb.LabelNamesResponse, error) {
+	if p, ok := peer.FromContext(ctx); ok {
+		if info, ok := p.AuthInfo.(credentials.TLSInfo); ok && len(info.State.PeerCertificates) > 0 {
+			m.commonName.Store(info.State.PeerCertificates[0].Subject.CommonName)
+		}
+	}
+	return &storepb.LabelNamesResponse{}, nil
+}
+
+func Test_newStoreGatewayClientFactory_ShouldOverrideTLSServerName(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	testCA := ca.New("Cortex Test")
+	caCertFile := filepath.Join(dir, "ca.crt")
+	require.NoError(t, testCA.WriteCACertificate(caCertFile))
+
+	// The server certificate doesn't match the IP address the store-gateway is dialed by.
+	serverCertFile := filepath.Join(dir, "server.crt")
+	serverKeyFile := filepath.Join(dir, "server.key")
+	require.NoError(t, testCA.WriteCertificate(&x509.Certificate{
+		Subject:     pkix.Name{CommonName: "server"},
+		DNSNames:    []string{"store-gateway.cortex.svc"},
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}, serverCertFile, serverKeyFile))
+
+	serverCert, err := tls.LoadX509KeyPair(serverCertFile, serverKeyFile)
+	require.NoError(t, err)
+	grpcServer := grpc.NewServer(grpc.Creds(credentials.NewTLS(&tls.Config{Certificates: []tls.Certificate{serverCert}})))
+	t.Cleanup(grpcServer.GracefulStop)
+	storegatewaypb.RegisterStoreGatewayServer(grpcServer, &mockStoreGatewayServer{})
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	go func() {
+		require.NoError(t, grpcServer.Serve(listener))
+	}()
+
+	request := func(serverName string) error {
+		clientConfig := ClientConfig{}
+		clientConfig.RegisterFlagsWithPrefix("test", flag.NewFlagSet("test", flag.PanicOnError))
+		clientConfig.TLSEnabled = true
+		clientConfig.TLS.CAPath = caCertFile
+		clientConfig.TLS.ServerName = serverName
+
+		cfg := grpcclient.ConfigWithHealthCheck{}
+		flagext.DefaultValues(&cfg)
+		cfg.TLSEnabled = true
+		cfg.TLS = clientConfig.TLS
+
+		client, err := newStoreGatewayClientFactory(cfg, clientConfig, newInflightRequests(), nil, nil)(listener.Addr().String())
+		require.NoError(t, err)
+		defer client.Close() //nolint:errcheck
+
+		_, err = client.(*storeGatewayClient).LabelNames(user.InjectOrgID(context.Background(), "test"), &storepb.LabelNamesRequest{})
+		return err
+	}
+
+	assert.ErrorContains(t, request(""), "cannot validate certificate for 127.0.0.1")
+	assert.NoError(t, request("store-gateway.cortex.svc"))
+}
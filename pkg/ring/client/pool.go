@@ -24,6 +24,14 @@ type PoolClient interface {
 	io.Closer
 }
 
+// GracefulCloser is optionally implemented by the PoolClient which can wait for their
+// in-flight requests to complete before closing.
+type GracefulCloser interface {
+	// CloseGracefully stops accepting new requests and waits for the in-flight ones to
+	// complete, until the context is done, before closing the client.
+	CloseGracefully(ctx context.Context) error
+}
+
 // PoolFactory defines the signature for a client factory.
 type PoolFactory func(addr string) (PoolClient, error)
 
@@ -38,6 +46,15 @@ type PoolConfig struct {
 	CheckInterval      time.Duration
 	HealthCheckEnabled bool
 	HealthCheckTimeout time.Duration
+	// HealthCheckService is the name of the gRPC service whose health is checked. Empty to
+	// check the overall health of the server.
+	HealthCheckService string
+	// UnhealthyThreshold is the number of consecutive failed health checks required before
+	// removing a client. 0 and 1 remove it on the first failed health check.
+	UnhealthyThreshold int
+	// GracefulCloseTimeout is the max time to wait for the in-flight requests of the stale
+	// clients implementing GracefulCloser to complete. 0 to close them immediately.
+	GracefulCloseTimeout time.Duration
 }
 
 // Pool holds a cache of grpc_health_v1 clients.
@@ -53,6 +70,10 @@ type Pool struct {
 	sync.RWMutex
 	clients map[string]PoolClient
 
+	// failedHealthChecks is the number of consecutive failed health checks of each client.
+	// It's only accessed by the health check loop.
+	failedHealthChecks map[string]int
+
 	clientsMetric prometheus.Gauge
 }
 
@@ -117,6 +138,17 @@ func (p *Pool) GetClientFor(addr string) (PoolClient, error) {
 
 // RemoveClientFor removes the client with the specified address
 func (p *Pool) RemoveClientFor(addr string) {
+	p.removeClientFor(addr, false)
+}
+
+// RemoveClientGracefullyFor removes the client with the specified address, waiting for its
+// in-flight requests to complete up to the configured GracefulCloseTimeout, if the client
+// implements GracefulCloser.
+func (p *Pool) RemoveClientGracefullyFor(addr string) {
+	p.removeClientFor(addr, true)
+}
+
+func (p *Pool) removeClientFor(addr string, graceful bool) {
 	p.Lock()
 	defer p.Unlock()
 	client, ok := p.clients[addr]
@@ -127,13 +159,24 @@ func (p *Pool) RemoveClientFor(addr string) {
 		}
 		// Close in the background since this operation may take awhile and we have a mutex
 		go func(addr string, closer PoolClient) {
-			if err := closer.Close(); err != nil {
+			if err := p.closeClient(closer, graceful); err != nil {
 				level.Error(p.logger).Log("msg", fmt.Sprintf("error closing connection to %s", p.clientName), "addr", addr, "err", err)
 			}
 		}(addr, client)
 	}
 }
 
+func (p *Pool) closeClient(client PoolClient, graceful bool) error {
+	gc, ok := client.(GracefulCloser)
+	if !graceful || !ok || p.cfg.GracefulCloseTimeout <= 0 {
+		return client.Close()
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), p.cfg.GracefulCloseTimeout)
+	defer cancel()
+	return gc.CloseGracefully(ctx)
+}
+
 // RegisteredAddresses returns all the service addresses for which there's an active client.
 func (p *Pool) RegisteredAddresses() []string {
 	result := []string{}
@@ -169,32 +212,56 @@ func (p *Pool) removeStaleClients() {
 			continue
 		}
 		level.Info(p.logger).Log("msg", "removing stale client", "addr", addr)
-		p.RemoveClientFor(addr)
+		p.RemoveClientGracefullyFor(addr)
 	}
 }
 
-// cleanUnhealthy loops through all servers and deletes any that fails a healthcheck.
+// cleanUnhealthy loops through all servers and deletes any that fails enough consecutive
+// healthchecks.
 func (p *Pool) cleanUnhealthy() {
-	for _, addr := range p.RegisteredAddresses() {
+	addrs := p.RegisteredAddresses()
+	if p.failedHealthChecks == nil {
+		p.failedHealthChecks = map[string]int{}
+	}
+
+	// Forget the failed health checks of the clients removed in the meanwhile.
+	for addr := range p.failedHealthChecks {
+		if !slices.Contains(addrs, addr) {
+			delete(p.failedHealthChecks, addr)
+		}
+	}
+
+	for _, addr := range addrs {
 		client, ok := p.fromCache(addr)
 		// not ok means someone removed a client between the start of this loop and now
 		if ok {
-			err := healthCheck(client, p.cfg.HealthCheckTimeout)
-			if err != nil {
-				level.Warn(p.logger).Log("msg", fmt.Sprintf("removing %s failing healthcheck", p.clientName), "addr", addr, "reason", err)
-				p.RemoveClientFor(addr)
+			err := healthCheck(client, p.cfg.HealthCheckTimeout, p.cfg.HealthCheckService)
+			if err == nil {
+				delete(p.failedHealthChecks, addr)
+				continue
 			}
+
+			p.failedHealthChecks[addr]++
+			if failed := p.failedHealthChecks[addr]; failed < p.cfg.UnhealthyThreshold {
+				level.Warn(p.logger).Log("msg", fmt.Sprintf("%s failing healthcheck", p.clientName), "addr", addr, "consecutive_failures", failed, "reason", err)
+				continue
+			}
+
+			level.Warn(p.logger).Log("msg", fmt.Sprintf("removing %s failing healthcheck", p.clientName), "addr", addr, "reason", err)
+			delete(p.failedHealthChecks, addr)
+			p.RemoveClientFor(addr)
 		}
 	}
 }
 
-// healthCheck will check if the client is still healthy, returning an error if it is not
-func healthCheck(client PoolClient, timeout time.Duration) error {
+// healthCheck will check if the given service of the client is still healthy, returning an
+// error if it is not
+func healthCheck(client PoolClient, timeout time.Duration, service string) error {
 	ctx, cancel := context.WithTimeout(context.Background(), timeout)
 	defer cancel()
 	ctx = user.InjectOrgID(ctx, "0")
 
-	resp, err := client.Check(ctx, &grpc_health_v1.HealthCheckRequest{})
+	resp, err := client.Check(ctx, &grpc_health_v1.HealthCheckRequest{Service: service})
 	if err != nil {
 		return err
 	}
@@ -49,7 +49,7 @@ func TestHealthCheck(t *testing.T) {
 		{mockClient{happy: false, status: grpc_health_v1.HealthCheckResponse_NOT_SERVING}, true},
 	}
 	for _, tc := range tcs {
-		err := healthCheck(tc.client, 50*time.Millisecond)
+		err := healthCheck(tc.client, 50*time.Millisecond, "")
 		hasError := err != nil
 		if hasError != tc.hasError {
 			t.Errorf("Expected error: %t, error: %v", tc.hasError, err)
@@ -57,6 +57,26 @@ func TestHealthCheck(t *testing.T) {
 	}
 }
 
+// serviceHealthClient is serving only the given service.
+type serviceHealthClient struct {
+	mockClient
+	service string
+}
+
+func (i serviceHealthClient) Check(_ context.Context, in *grpc_health_v1.HealthCheckRequest, _ ...grpc.CallOption) (*grpc_health_v1.HealthCheckResponse, error) {
+	if in.Service != i.service {
+		return &grpc_health_v1.HealthCheckResponse{Status: grpc_health_v1.HealthCheckResponse_SERVICE_UNKNOWN}, nil
+	}
+	return &grpc_health_v1.HealthCheckResponse{Status: grpc_health_v1.HealthCheckResponse_SERVING}, nil
+}
+
+func TestHealthCheck_ShouldCheckTheGivenService(t *testing.T) {
+	client := serviceHealthClient{service: "store-gateway"}
+
+	require.NoError(t, healthCheck(client, 50*time.Millisecond, "store-gateway"))
+	require.Error(t, healthCheck(client, 50*time.Millisecond, ""))
+}
+
 func TestPoolCache(t *testing.T) {
 	buildCount := 0
 	factory := func(addr string) (PoolClient, error) {
@@ -145,3 +165,102 @@ func TestCleanUnhealthy(t *testing.T) {
 		}
 	}
 }
+
+func TestCleanUnhealthy_ShouldRemoveClientsAfterConsecutiveFailures(t *testing.T) {
+	flapping := &flappingMockClient{}
+	pool := &Pool{
+		cfg:     PoolConfig{UnhealthyThreshold: 2},
+		clients: map[string]PoolClient{"flapping": flapping},
+		logger:  log.NewNopLogger(),
+	}
+
+	// A successful health check resets the failures.
+	flapping.happy = false
+	pool.cleanUnhealthy()
+	flapping.happy = true
+	pool.cleanUnhealthy()
+	flapping.happy = false
+	pool.cleanUnhealthy()
+	require.Contains(t, pool.clients, "flapping")
+
+	pool.cleanUnhealthy()
+	require.NotContains(t, pool.clients, "flapping")
+	require.Empty(t, pool.failedHealthChecks)
+}
+
+// flappingMockClient is a mockClient whose health can be changed between health checks.
+type flappingMockClient struct {
+	happy bool
+}
+
+func (i *flappingMockClient) Check(ctx context.Context, in *grpc_health_v1.HealthCheckRequest, opts ...grpc.CallOption) (*grpc_health_v1.HealthCheckResponse, error) {
+	return mockClient{happy: i.happy, status: grpc_health_v1.HealthCheckResponse_SERVING}.Check(ctx, in, opts...)
+}
+
+func (i *flappingMockClient) Watch(ctx context.Context, in *grpc_health_v1.HealthCheckRequest, opts ...grpc.CallOption) (grpc_health_v1.Health_WatchClient, error) {
+	return mockClient{}.Watch(ctx, in, opts...)
+}
+
+func (i *flappingMockClient) Close() error {
+	return nil
+}
+
+type gracefulMockClient struct {
+	mockClient
+
+	closed chan string
+}
+
+func (i gracefulMockClient) Close() error {
+	i.closed <- "close"
+	return nil
+}
+
+func (i gracefulMockClient) CloseGracefully(ctx context.Context) error {
+	if _, ok := ctx.Deadline(); !ok {
+		return fmt.Errorf("missing deadline")
+	}
+	i.closed <- "graceful"
+	return nil
+}
+
+func TestRemoveStaleClients_ShouldCloseGracefully(t *testing.T) {
+	tcs := map[string]struct {
+		timeout  time.Duration
+		expected string
+	}{
+		"should close gracefully with timeout": {
+			timeout:  time.Second,
+			expected: "graceful",
+		},
+		"should close immediately without timeout": {
+			expected: "close",
+		},
+	}
+
+	for name, tc := range tcs {
+		t.Run(name, func(t *testing.T) {
+			closed := make(chan string, 2)
+			factory := func(addr string) (PoolClient, error) {
+				return gracefulMockClient{mockClient: mockClient{happy: true, status: grpc_health_v1.HealthCheckResponse_SERVING}, closed: closed}, nil
+			}
+			discovery := func() ([]string, error) {
+				return []string{"2"}, nil
+			}
+
+			pool := NewPool("test", PoolConfig{GracefulCloseTimeout: tc.timeout}, discovery, factory, nil, log.NewNopLogger())
+			for _, addr := range []string{"1", "2"} {
+				_, err := pool.GetClientFor(addr)
+				require.NoError(t, err)
+			}
+
+			pool.removeStaleClients()
+			require.Equal(t, []string{"2"}, pool.RegisteredAddresses())
+			require.Equal(t, tc.expected, <-closed)
+
+			// RemoveClientFor() always closes the client immediately.
+			pool.RemoveClientFor("2")
+			require.Equal(t, "close", <-closed)
+		})
+	}
+}
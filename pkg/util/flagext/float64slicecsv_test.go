@@ -0,0 +1,40 @@
+package flagext
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/yaml.v2"
+)
+
+func Test_Float64SliceCSV(t *testing.T) {
+	type TestStruct struct {
+		CSV Float64SliceCSV `yaml:"csv"`
+	}
+
+	var testStruct TestStruct
+	s := "0.005,0.25,1,10"
+	assert.Nil(t, testStruct.CSV.Set(s))
+
+	assert.Equal(t, []float64{0.005, 0.25, 1, 10}, []float64(testStruct.CSV))
+	assert.Equal(t, s, testStruct.CSV.String())
+
+	expected := []byte(`csv: 0.005,0.25,1,10
+`)
+
+	actual, err := yaml.Marshal(testStruct)
+	assert.Nil(t, err)
+	assert.Equal(t, expected, actual)
+
+	var testStruct2 TestStruct
+
+	err = yaml.Unmarshal(expected, &testStruct2)
+	assert.Nil(t, err)
+	assert.Equal(t, testStruct, testStruct2)
+
+	// An empty string is an empty slice, while invalid numbers are rejected.
+	require.NoError(t, testStruct.CSV.Set(""))
+	assert.Empty(t, testStruct.CSV)
+	assert.Error(t, testStruct.CSV.Set("1,a"))
+}
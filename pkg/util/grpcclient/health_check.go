@@ -28,15 +28,19 @@ type HealthCheckConfig struct {
 	*HealthCheckInterceptors `yaml:"-"`
 
 	UnhealthyThreshold int64         `yaml:"unhealthy_threshold"`
+	HealthyThreshold   int64         `yaml:"healthy_threshold"`
 	Interval           time.Duration `yaml:"interval"`
 	Timeout            time.Duration `yaml:"timeout"`
+	ServiceName        string        `yaml:"service_name"`
 }
 
 // RegisterFlagsWithPrefix for Config.
 func (cfg *HealthCheckConfig) RegisterFlagsWithPrefix(prefix string, f *flag.FlagSet) {
 	f.Int64Var(&cfg.UnhealthyThreshold, prefix+".healthcheck.unhealthy-threshold", 0, "The number of consecutive failed health checks required before considering a target unhealthy. 0 means disabled.")
+	f.Int64Var(&cfg.HealthyThreshold, prefix+".healthcheck.healthy-threshold", 1, "The number of consecutive successful health checks required before considering an unhealthy target healthy again.")
 	f.DurationVar(&cfg.Timeout, prefix+".healthcheck.timeout", 1*time.Second, "The amount of time during which no response from a target means a failed health check.")
 	f.DurationVar(&cfg.Interval, prefix+".healthcheck.interval", 5*time.Second, "The approximate amount of time between health checks of an individual target.")
+	f.StringVar(&cfg.ServiceName, prefix+".healthcheck.service-name", "", "The name of the gRPC service whose health is checked. Empty to check the overall health of the target.")
 }
 
 type healthCheckClient struct {
@@ -50,6 +54,7 @@ type healthCheckEntry struct {
 	lastCheckTime  atomic.Time
 	lastTickTime   atomic.Time
 	unhealthyCount atomic.Int64
+	healthyCount   atomic.Int64
 
 	healthCheckClientMutex sync.RWMutex
 	healthCheckClient      *healthCheckClient
@@ -87,12 +92,18 @@ func (e *healthCheckEntry) isHealthy() bool {
 
 func (e *healthCheckEntry) recordHealth(err error) error {
 	if err != nil {
+		e.healthyCount.Store(0)
 		e.unhealthyCount.Inc()
-	} else {
-		e.unhealthyCount.Store(0)
+		return err
 	}
 
-	return err
+	// An unhealthy target is only considered healthy again after enough consecutive
+	// successful health checks.
+	if e.isHealthy() || e.healthyCount.Inc() >= e.clientConfig.HealthCheckConfig.HealthyThreshold {
+		e.healthyCount.Store(0)
+		e.unhealthyCount.Store(0)
+	}
+	return nil
 }
 
 func (e *healthCheckEntry) tick() {
@@ -182,7 +193,7 @@ func (h *HealthCheckInterceptors) iteration(ctx context.Context) error {
 				return
 			}
 
-			if err := i.recordHealth(healthCheck(client, i.clientConfig.HealthCheckConfig.Timeout)); !i.isHealthy() {
+			if err := i.recordHealth(healthCheck(client, i.clientConfig.HealthCheckConfig.Timeout, i.clientConfig.HealthCheckConfig.ServiceName)); !i.isHealthy() {
 				level.Warn(h.logger).Log("msg", "instance marked as unhealthy", "address", i.address, "err", err)
 			}
 		}(instance)
@@ -235,12 +246,12 @@ func (h *HealthCheckInterceptors) UnaryHealthCheckInterceptor(clientConfig *Conf
 	}
 }
 
-func healthCheck(client grpc_health_v1.HealthClient, timeout time.Duration) error {
+func healthCheck(client grpc_health_v1.HealthClient, timeout time.Duration, service string) error {
 	ctx, cancel := context.WithTimeout(context.Background(), timeout)
 	defer cancel()
 	ctx = user.InjectOrgID(ctx, "0")
 
-	resp, err := client.Check(ctx, &grpc_health_v1.HealthCheckRequest{})
+	resp, err := client.Check(ctx, &grpc_health_v1.HealthCheckRequest{Service: service})
 	if err != nil {
 		return err
 	}
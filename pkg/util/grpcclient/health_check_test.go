@@ -152,3 +152,31 @@ func TestNewHealthCheckInterceptors(t *testing.T) {
 		return ui(context.Background(), "", struct{}{}, struct{}{}, ccUnhealthy, invoker) == nil
 	})
 }
+
+func TestHealthCheckEntry_ShouldRequireConsecutiveSuccessesToBeHealthyAgain(t *testing.T) {
+	e := &healthCheckEntry{clientConfig: &ConfigWithHealthCheck{
+		HealthCheckConfig: HealthCheckConfig{UnhealthyThreshold: 2, HealthyThreshold: 3},
+	}}
+	failure := fmt.Errorf("some error")
+
+	// A successful health check resets the failures of a healthy target.
+	_ = e.recordHealth(failure)
+	_ = e.recordHealth(nil)
+	_ = e.recordHealth(failure)
+	require.True(t, e.isHealthy())
+
+	_ = e.recordHealth(failure)
+	require.False(t, e.isHealthy())
+
+	// A failed health check resets the successes of an unhealthy target.
+	_ = e.recordHealth(nil)
+	_ = e.recordHealth(nil)
+	_ = e.recordHealth(failure)
+	require.False(t, e.isHealthy())
+
+	_ = e.recordHealth(nil)
+	_ = e.recordHealth(nil)
+	require.False(t, e.isHealthy())
+	_ = e.recordHealth(nil)
+	require.True(t, e.isHealthy())
+}
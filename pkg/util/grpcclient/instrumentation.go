@@ -1,6 +1,8 @@
 package grpcclient
 
 import (
+	"context"
+
 	otgrpc "github.com/opentracing-contrib/go-grpc"
 	"github.com/opentracing/opentracing-go"
 	"github.com/prometheus/client_golang/prometheus"
@@ -11,6 +13,10 @@ import (
 	cortexmiddleware "github.com/cortexproject/cortex/pkg/util/middleware"
 )
 
+// TraceSampler decides whether a client span should be created for the request
+// associated with the given context.
+type TraceSampler func(ctx context.Context) bool
+
 func Instrument(requestDuration *prometheus.HistogramVec) ([]grpc.UnaryClientInterceptor, []grpc.StreamClientInterceptor) {
 	return []grpc.UnaryClientInterceptor{
 			grpcutil.HTTPHeaderPropagationClientInterceptor,
@@ -26,6 +32,23 @@ func Instrument(requestDuration *prometheus.HistogramVec) ([]grpc.UnaryClientInt
 		}
 }
 
+// InstrumentWithTraceSampler is like Instrument, but a client span is only created
+// for the requests for which the sampler returns true.
+func InstrumentWithTraceSampler(requestDuration *prometheus.HistogramVec, sampler TraceSampler) ([]grpc.UnaryClientInterceptor, []grpc.StreamClientInterceptor) {
+	return []grpc.UnaryClientInterceptor{
+			grpcutil.HTTPHeaderPropagationClientInterceptor,
+			sampledUnaryClientInterceptor(sampler, otgrpc.OpenTracingClientInterceptor(opentracing.GlobalTracer())),
+			middleware.ClientUserHeaderInterceptor,
+			cortexmiddleware.PrometheusGRPCUnaryInstrumentation(requestDuration),
+		}, []grpc.StreamClientInterceptor{
+			grpcutil.HTTPHeaderPropagationStreamClientInterceptor,
+			unwrapErrorStreamClientInterceptor(),
+			sampledStreamClientInterceptor(sampler, otgrpc.OpenTracingStreamClientInterceptor(opentracing.GlobalTracer())),
+			middleware.StreamClientUserHeaderInterceptor,
+			cortexmiddleware.PrometheusGRPCStreamInstrumentation(requestDuration),
+		}
+}
+
 func InstrumentReusableStream(requestDuration *prometheus.HistogramVec) ([]grpc.UnaryClientInterceptor, []grpc.StreamClientInterceptor) {
 	return []grpc.UnaryClientInterceptor{
 			grpcutil.HTTPHeaderPropagationClientInterceptor,
@@ -40,3 +63,25 @@ func InstrumentReusableStream(requestDuration *prometheus.HistogramVec) ([]grpc.
 			cortexmiddleware.PrometheusGRPCReusableStreamInstrumentation(requestDuration),
 		}
 }
+
+// sampledUnaryClientInterceptor runs the next interceptor only if the request is sampled,
+// otherwise it directly invokes the RPC.
+func sampledUnaryClientInterceptor(sampler TraceSampler, next grpc.UnaryClientInterceptor) grpc.UnaryClientInterceptor {
+	return func(ctx context.Context, method string, req, reply any, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		if !sampler(ctx) {
+			return invoker(ctx, method, req, reply, cc, opts...)
+		}
+		return next(ctx, method, req, reply, cc, invoker, opts...)
+	}
+}
+
+// sampledStreamClientInterceptor runs the next interceptor only if the request is sampled,
+// otherwise it directly opens the stream.
+func sampledStreamClientInterceptor(sampler TraceSampler, next grpc.StreamClientInterceptor) grpc.StreamClientInterceptor {
+	return func(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, streamer grpc.Streamer, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+		if !sampler(ctx) {
+			return streamer(ctx, desc, cc, method, opts...)
+		}
+		return next(ctx, desc, cc, method, streamer, opts...)
+	}
+}
@@ -0,0 +1,73 @@
+package runtimeconfig
+
+import (
+	"reflect"
+	"slices"
+)
+
+// KeyedConfig is implemented by loaded configs made of top-level keys, e.g. the tenants, so
+// that the listeners created with CreateListenerChannelWithChangedKeys are told which keys
+// changed on each update. Configs of type map[string]any are supported as well.
+type KeyedConfig interface {
+	ConfigKeys() map[string]any
+}
+
+// ChangedKeysUpdate is sent to the listeners created with CreateListenerChannelWithChangedKeys
+// each time a new config is loaded.
+type ChangedKeysUpdate struct {
+	// New is the newly loaded config value.
+	New any
+	// ChangedKeys are the sorted top-level keys added, removed or whose value changed since
+	// the previous config. It's nil if AllChanged is true.
+	ChangedKeys []string
+	// AllChanged is true if the changed keys can't be computed, e.g. on the first load or if
+	// the configs aren't made of keys, in which case all the keys must be considered changed.
+	AllChanged bool
+}
+
+// newChangedKeysUpdate returns the update with the top-level keys changed between the two
+// configs.
+func newChangedKeysUpdate(oldValue, newValue any) ChangedKeysUpdate {
+	changed, ok := changedKeys(oldValue, newValue)
+	return ChangedKeysUpdate{New: newValue, ChangedKeys: changed, AllChanged: !ok}
+}
+
+// changedKeys returns the sorted top-level keys added, removed or whose value changed between
+// the two configs, and false if any of the configs isn't made of keys.
+func changedKeys(oldValue, newValue any) ([]string, bool) {
+	oldKeys, ok := configKeys(oldValue)
+	if !ok {
+		return nil, false
+	}
+	newKeys, ok := configKeys(newValue)
+	if !ok {
+		return nil, false
+	}
+
+	changed := []string{}
+	for key, value := range newKeys {
+		if prev, ok := oldKeys[key]; !ok || !reflect.DeepEqual(prev, value) {
+			changed = append(changed, key)
+		}
+	}
+	for key := range oldKeys {
+		if _, ok := newKeys[key]; !ok {
+			changed = append(changed, key)
+		}
+	}
+	slices.Sort(changed)
+	return changed, true
+}
+
+// configKeys returns the top-level keys of the config, along with their values, and whether
+// the config is made of keys.
+func configKeys(cfg any) (map[string]any, bool) {
+	switch c := cfg.(type) {
+	case KeyedConfig:
+		return c.ConfigKeys(), true
+	case map[string]any:
+		return c, true
+	default:
+		return nil, false
+	}
+}
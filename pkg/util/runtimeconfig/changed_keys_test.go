@@ -0,0 +1,93 @@
+package runtimeconfig
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/cortexproject/cortex/pkg/util/services"
+)
+
+type keyedTestConfig map[string]int
+
+func (c keyedTestConfig) ConfigKeys() map[string]any {
+	keys := make(map[string]any, len(c))
+	for k, v := range c {
+		keys[k] = v
+	}
+	return keys
+}
+
+func TestChangedKeys(t *testing.T) {
+	tests := map[string]struct {
+		oldValue, newValue any
+		expectedKeys       []string
+		expectedOK         bool
+	}{
+		"first load": {
+			oldValue: nil,
+			newValue: map[string]any{"user1": 1},
+		},
+		"not made of keys": {
+			oldValue: 1,
+			newValue: 2,
+		},
+		"unchanged maps": {
+			oldValue:     map[string]any{"user1": map[string]any{"limit": 1}},
+			newValue:     map[string]any{"user1": map[string]any{"limit": 1}},
+			expectedKeys: []string{},
+			expectedOK:   true,
+		},
+		"added, removed and changed keys": {
+			oldValue:     map[string]any{"user1": 1, "user2": 2, "user3": 3},
+			newValue:     map[string]any{"user1": 1, "user2": 20, "user4": 4},
+			expectedKeys: []string{"user2", "user3", "user4"},
+			expectedOK:   true,
+		},
+		"keyed configs": {
+			oldValue:     keyedTestConfig{"user1": 1, "user2": 2},
+			newValue:     keyedTestConfig{"user1": 10, "user2": 2},
+			expectedKeys: []string{"user1"},
+			expectedOK:   true,
+		},
+	}
+
+	for testName, testData := range tests {
+		t.Run(testName, func(t *testing.T) {
+			keys, ok := changedKeys(testData.oldValue, testData.newValue)
+			assert.Equal(t, testData.expectedOK, ok)
+			assert.Equal(t, testData.expectedKeys, keys)
+		})
+	}
+}
+
+func TestManager_ListenerChannelWithChangedKeys(t *testing.T) {
+	manager := NewStatic(nil, nil)
+	require.NoError(t, services.StartAndAwaitRunning(context.Background(), manager))
+
+	ch := manager.CreateListenerChannelWithChangedKeys(1)
+	receive := func() ChangedKeysUpdate {
+		select {
+		case update := <-ch:
+			return update
+		case <-time.After(time.Second):
+			t.Fatal("listener didn't receive the update")
+			return ChangedKeysUpdate{}
+		}
+	}
+
+	first := keyedTestConfig{"user1": 1, "user2": 2}
+	manager.SetConfig(first)
+	assert.Equal(t, ChangedKeysUpdate{New: first, AllChanged: true}, receive())
+
+	second := keyedTestConfig{"user1": 1, "user2": 3}
+	manager.SetConfig(second)
+	assert.Equal(t, ChangedKeysUpdate{New: second, ChangedKeys: []string{"user2"}}, receive())
+
+	require.NoError(t, services.StopAndAwaitTerminated(context.Background(), manager))
+	_, ok := <-ch
+	require.False(t, ok)
+}
@@ -0,0 +1,91 @@
+package runtimeconfig
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v2"
+)
+
+var (
+	yamlLineRegexp         = regexp.MustCompile(`^(?:yaml: )?line (\d+): `)
+	yamlUnknownFieldRegexp = regexp.MustCompile(`^field (\S+) not found`)
+	yamlValueRegexp        = regexp.MustCompile("`([^`]+)`")
+)
+
+// DecodeError is a YAML decode error, locating the offending content in the decoded file.
+type DecodeError struct {
+	// Line is the 1-based line of the offending content.
+	Line int
+	// Column is the 1-based column of the offending content.
+	Column int
+	// Snippet is the offending line, without the indentation.
+	Snippet string
+
+	msg string
+	err error
+}
+
+// NewYAMLDecodeError returns a DecodeError locating, in buf, the error returned by the
+// YAML decoder. If the error can't be located, it's only wrapped as a parse error.
+func NewYAMLDecodeError(buf []byte, err error) error {
+	msg := err.Error()
+	moreErrors := 0
+	if typeErr, ok := err.(*yaml.TypeError); ok && len(typeErr.Errors) > 0 {
+		msg = typeErr.Errors[0]
+		moreErrors = len(typeErr.Errors) - 1
+	}
+
+	match := yamlLineRegexp.FindStringSubmatch(msg)
+	if match == nil {
+		return errors.Wrap(err, "parse")
+	}
+
+	lineNum, _ := strconv.Atoi(match[1])
+	lines := bytes.Split(buf, []byte("\n"))
+	if lineNum < 1 || lineNum > len(lines) {
+		return errors.Wrap(err, "parse")
+	}
+
+	msg = strings.TrimPrefix(msg, match[0])
+	if moreErrors > 0 {
+		msg = fmt.Sprintf("%s (and %d more errors)", msg, moreErrors)
+	}
+
+	line := string(lines[lineNum-1])
+	return &DecodeError{
+		Line:    lineNum,
+		Column:  yamlErrorColumn(line, msg),
+		Snippet: strings.TrimSpace(line),
+		msg:     msg,
+		err:     err,
+	}
+}
+
+// yamlErrorColumn returns the column of the field or value the error is about, if found
+// in the line, otherwise the column of the first non-blank character of the line.
+func yamlErrorColumn(line, msg string) int {
+	match := yamlUnknownFieldRegexp.FindStringSubmatch(msg)
+	if match == nil {
+		match = yamlValueRegexp.FindStringSubmatch(msg)
+	}
+	if match != nil {
+		if idx := strings.Index(line, match[1]); idx >= 0 {
+			return idx + 1
+		}
+	}
+
+	return len(line) - len(strings.TrimLeft(line, " \t")) + 1
+}
+
+func (e *DecodeError) Error() string {
+	return fmt.Sprintf("parse: line %d, column %d, near %q: %s", e.Line, e.Column, e.Snippet, e.msg)
+}
+
+func (e *DecodeError) Unwrap() error {
+	return e.err
+}
@@ -0,0 +1,59 @@
+package runtimeconfig
+
+import (
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/yaml.v2"
+)
+
+func TestNewYAMLDecodeError(t *testing.T) {
+	tests := map[string]struct {
+		content        string
+		expectedLine   int
+		expectedColumn int
+		expectedErr    string
+	}{
+		"syntax error": {
+			content:        "overrides:\n  user1: limit: 100\n",
+			expectedLine:   2,
+			expectedColumn: 3,
+			expectedErr:    `parse: line 2, column 3, near "user1: limit: 100": mapping values are not allowed in this context`,
+		},
+		"unknown field": {
+			content:        "overrides:\n  user1:\n    unknown: 100\n",
+			expectedLine:   3,
+			expectedColumn: 5,
+			expectedErr:    `parse: line 3, column 5, near "unknown: 100": field unknown not found in type struct { Limit int "yaml:\"limit\"" }`,
+		},
+		"invalid value": {
+			content:        "overrides:\n  user1:\n    limit: abc\n    other: 1\n",
+			expectedLine:   3,
+			expectedColumn: 12,
+			expectedErr:    "parse: line 3, column 12, near \"limit: abc\": cannot unmarshal !!str `abc` into int (and 1 more errors)",
+		},
+	}
+
+	for testName, testData := range tests {
+		t.Run(testName, func(t *testing.T) {
+			var cfg struct {
+				Overrides map[string]struct {
+					Limit int `yaml:"limit"`
+				} `yaml:"overrides"`
+			}
+			decodeErr := yaml.UnmarshalStrict([]byte(testData.content), &cfg)
+			require.Error(t, decodeErr)
+
+			err := NewYAMLDecodeError([]byte(testData.content), decodeErr)
+			assert.EqualError(t, err, testData.expectedErr)
+
+			var located *DecodeError
+			require.True(t, errors.As(err, &located))
+			assert.Equal(t, testData.expectedLine, located.Line)
+			assert.Equal(t, testData.expectedColumn, located.Column)
+			assert.ErrorIs(t, err, decodeErr)
+		})
+	}
+}
@@ -0,0 +1,84 @@
+package runtimeconfig
+
+import (
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/go-kit/log/level"
+	"github.com/pkg/errors"
+)
+
+// ErrConfigNotInHistory is returned by RevertTo when no config with the given hash is kept in
+// the history.
+var ErrConfigNotInHistory = errors.New("runtime config not found in the history")
+
+// ConfigSnapshot is a successfully loaded config kept in the history of the Manager.
+type ConfigSnapshot struct {
+	// Config is the loaded config value.
+	Config any
+	// Hash is the hex-encoded SHA256 hash of the content the config has been loaded from.
+	Hash string
+	// LoadedAt is when the config has been loaded.
+	LoadedAt time.Time
+}
+
+// recordHistory adds the given snapshot to the history, unless the most recent one has the
+// same hash, evicting the oldest snapshots beyond the configured HistorySize.
+func (om *Manager) recordHistory(snapshot ConfigSnapshot) {
+	if om.cfg.HistorySize <= 0 {
+		return
+	}
+
+	om.historyMtx.Lock()
+	defer om.historyMtx.Unlock()
+
+	if len(om.history) > 0 && om.history[0].Hash == snapshot.Hash {
+		return
+	}
+	om.history = slices.Insert(om.history, 0, snapshot)
+	if len(om.history) > om.cfg.HistorySize {
+		om.history = om.history[:om.cfg.HistorySize]
+	}
+}
+
+// History returns the last distinct successfully loaded configs, up to the configured
+// HistorySize, from the most recent.
+func (om *Manager) History() []ConfigSnapshot {
+	om.historyMtx.Lock()
+	defer om.historyMtx.Unlock()
+
+	return slices.Clone(om.history)
+}
+
+// RevertTo re-applies the config with the given hash from the history and notifies the
+// listeners, as done on each successful reload. The reverted config is kept until the runtime
+// config files change, after which the next reload applies them again.
+func (om *Manager) RevertTo(hash string) error {
+	om.historyMtx.Lock()
+	i := slices.IndexFunc(om.history, func(s ConfigSnapshot) bool { return s.Hash == hash })
+	var snapshot ConfigSnapshot
+	if i >= 0 {
+		snapshot = om.history[i]
+	}
+	om.historyMtx.Unlock()
+
+	if i < 0 {
+		return fmt.Errorf("%w: %s", ErrConfigNotInHistory, hash)
+	}
+
+	// Keep track of the hash of the loaded files, which differs from the hash of the current
+	// config if it has already been reverted.
+	filesHash := om.revertedHash.Load()
+	if filesHash == "" {
+		_, filesHash = om.GetConfigWithHash()
+	}
+	if hash == filesHash {
+		filesHash = ""
+	}
+	om.revertedHash.Store(filesHash)
+	om.applyConfig(ConfigEvent{Config: snapshot.Config, Hash: snapshot.Hash, LoadedAt: snapshot.LoadedAt, Source: noConfigSource})
+
+	level.Info(om.logger).Log("msg", "reverted runtime config", "sha256", hash, "loaded_at", snapshot.LoadedAt)
+	return nil
+}
@@ -0,0 +1,86 @@
+package runtimeconfig
+
+import (
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/cortexproject/cortex/pkg/storage/bucket"
+)
+
+func TestManager_History(t *testing.T) {
+	defaultTestLimits = nil
+
+	configs := make([][]byte, 5)
+	hashes := make([]string, 5)
+	for i := range configs {
+		configs[i] = fmt.Appendf(nil, "overrides:\n  user1:\n    limit2: %d", i)
+		hashes[i] = fmt.Sprintf("%x", sha256.Sum256(configs[i]))
+	}
+	limit := func(cfg any) int {
+		return cfg.(*testOverrides).Overrides["user1"].Limit2
+	}
+
+	manager, err := New(Config{
+		ReloadPeriod:  time.Hour,
+		LoadPath:      "runtime-config.yaml",
+		Loader:        testLoadOverrides,
+		HistorySize:   2,
+		StorageConfig: bucket.Config{Backend: bucket.Filesystem},
+	}, nil, log.NewNopLogger(), mockBucketClientFactory())
+	require.NoError(t, err)
+	manager.bucketClient = createMockBucketClient(configs[0], configs[1], configs[1], configs[2], configs[2], configs[3])
+
+	for range 4 {
+		require.NoError(t, manager.loadConfig(context.Background()))
+	}
+
+	// The unchanged config is only kept once, and the oldest one is evicted.
+	history := manager.History()
+	require.Len(t, history, 2)
+	assert.Equal(t, []string{hashes[2], hashes[1]}, []string{history[0].Hash, history[1].Hash})
+	assert.Equal(t, 2, limit(history[0].Config))
+	assert.Equal(t, 1, limit(history[1].Config))
+	assert.False(t, history[0].LoadedAt.Before(history[1].LoadedAt))
+
+	require.ErrorIs(t, manager.RevertTo(hashes[0]), ErrConfigNotInHistory)
+	assert.Equal(t, 2, limit(manager.GetConfig()))
+
+	// Reverting notifies the listeners.
+	ch := manager.CreateListenerChannel(1)
+	require.NoError(t, manager.RevertTo(hashes[1]))
+	assert.Equal(t, 1, limit(<-ch))
+	cfg, hash := manager.GetConfigWithHash()
+	assert.Equal(t, 1, limit(cfg))
+	assert.Equal(t, hashes[1], hash)
+
+	// The reverted config is kept while the files are unchanged.
+	require.NoError(t, manager.loadConfig(context.Background()))
+	assert.Equal(t, 1, limit(manager.GetConfig()))
+	assert.Len(t, ch, 0)
+
+	// The files are applied again once they change.
+	require.NoError(t, manager.loadConfig(context.Background()))
+	assert.Equal(t, 3, limit(manager.GetConfig()))
+	history = manager.History()
+	assert.Equal(t, []string{hashes[3], hashes[2]}, []string{history[0].Hash, history[1].Hash})
+}
+
+func TestManager_HistoryDisabled(t *testing.T) {
+	_, cfg := newTestOverridesManagerConfig(t, 1)
+
+	manager, err := New(cfg, nil, log.NewNopLogger(), mockBucketClientFactory())
+	require.NoError(t, err)
+	manager.bucketClient = createMockBucketClient([]byte("1"))
+	require.NoError(t, manager.loadConfig(context.Background()))
+
+	assert.Empty(t, manager.History())
+	_, hash := manager.GetConfigWithHash()
+	require.ErrorIs(t, manager.RevertTo(hash), ErrConfigNotInHistory)
+}
@@ -0,0 +1,89 @@
+package runtimeconfig
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// newHTTPClient returns the client the runtime config files are read over HTTP with.
+func newHTTPClient(cfg Config) *http.Client {
+	return &http.Client{Timeout: cfg.HTTPTimeout}
+}
+
+// validateHTTPURL returns an error if the given URL isn't an absolute HTTP or HTTPS URL.
+func validateHTTPURL(rawURL string) error {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidHTTPURL, err)
+	}
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("%w: %s", ErrInvalidHTTPURL, rawURL)
+	}
+	return nil
+}
+
+// loadConfigFromHTTP reads the given file, resolved against baseURL, with an HTTP GET. If the
+// file was read from the same source in the last successful load and its response had an
+// ETag, the request is conditional and the previously loaded content is returned when the
+// server replies that the file is unchanged.
+func (om *Manager) loadConfigFromHTTP(ctx context.Context, source int, baseURL, path string) (loadedFile, error) {
+	if om.cfg.ReadTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, om.cfg.ReadTimeout)
+		defer cancel()
+	}
+
+	fileURL, err := url.JoinPath(baseURL, path)
+	if err != nil {
+		return loadedFile{}, errors.Wrap(err, "resolve file URL")
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, fileURL, nil)
+	if err != nil {
+		return loadedFile{}, errors.Wrap(err, "create request")
+	}
+	if token := om.cfg.HTTPBearerToken.String(); token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	prev, ok := om.getLoadedFile(source, path)
+	if ok && prev.etag != "" {
+		req.Header.Set("If-None-Match", prev.etag)
+	}
+
+	downloadedAt := time.Now()
+	resp, err := om.httpClient.Do(req)
+	if err != nil {
+		return loadedFile{}, errors.Wrap(err, "get file")
+	}
+	defer func() {
+		_, _ = io.Copy(io.Discard, resp.Body)
+		_ = resp.Body.Close()
+	}()
+
+	switch {
+	case resp.StatusCode == http.StatusNotModified && ok && prev.etag != "":
+		return prev, nil
+	case resp.StatusCode != http.StatusOK:
+		return loadedFile{}, fmt.Errorf("get file: unexpected status code %d", resp.StatusCode)
+	}
+
+	buf, err := readAllWithLimit(resp.Body, om.cfg.MaxFileSize)
+	if err != nil {
+		return loadedFile{}, errors.Wrap(err, "read entire file")
+	}
+
+	if om.cfg.Compression == CompressionGzip || strings.HasSuffix(path, ".gz") {
+		if buf, err = gunzip(buf, om.cfg.MaxFileSize); err != nil {
+			return loadedFile{}, errors.Wrap(err, "decompress gzip file")
+		}
+	}
+	return loadedFile{content: buf, etag: resp.Header.Get("ETag"), downloadedAt: downloadedAt}, nil
+}
@@ -0,0 +1,95 @@
+package runtimeconfig
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/stretchr/testify/require"
+	"github.com/thanos-io/objstore"
+	"go.uber.org/atomic"
+
+	"github.com/cortexproject/cortex/pkg/storage/bucket"
+	"github.com/cortexproject/cortex/pkg/util/flagext"
+	"github.com/cortexproject/cortex/pkg/util/services"
+)
+
+func TestManager_ShouldReadConfigFromHTTP(t *testing.T) {
+	config := atomic.NewString(`overrides:
+  user1:
+    limit2: 150`)
+	etag := atomic.NewString(`"v1"`)
+	okResponses := atomic.NewInt32(0)
+	notModifiedResponses := atomic.NewInt32(0)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/runtime/overrides.yaml" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		if r.Header.Get("Authorization") != "Bearer secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		if r.Header.Get("If-None-Match") == etag.Load() {
+			notModifiedResponses.Inc()
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		okResponses.Inc()
+		w.Header().Set("ETag", etag.Load())
+		_, _ = w.Write([]byte(config.Load()))
+	}))
+	defer server.Close()
+
+	overridesManager, err := New(Config{
+		ReloadPeriod:    time.Hour,
+		LoadPath:        "overrides.yaml",
+		Loader:          testLoadOverrides,
+		HTTPURL:         server.URL + "/runtime",
+		HTTPTimeout:     time.Second,
+		HTTPBearerToken: flagext.Secret{Value: "secret"},
+		StorageConfig:   bucket.Config{Backend: bucket.Filesystem},
+	}, nil, log.NewNopLogger(), func(context.Context) (objstore.Bucket, error) {
+		t.Fatal("the bucket client should not be created when reading from HTTP")
+		return nil, nil
+	})
+	require.NoError(t, err)
+	require.NoError(t, services.StartAndAwaitRunning(context.Background(), overridesManager))
+	defer services.StopAndAwaitTerminated(context.Background(), overridesManager) //nolint:errcheck
+	require.Equal(t, 150, overridesManager.GetConfig().(*testOverrides).Overrides["user1"].Limit2)
+	require.Equal(t, int32(1), okResponses.Load())
+
+	// The ETag is unchanged, so the previously loaded content is kept.
+	require.NoError(t, overridesManager.loadConfig(context.Background()))
+	require.Equal(t, 150, overridesManager.GetConfig().(*testOverrides).Overrides["user1"].Limit2)
+	require.Equal(t, int32(1), okResponses.Load())
+	require.Equal(t, int32(1), notModifiedResponses.Load())
+
+	// The config has changed.
+	config.Store(`overrides:
+  user1:
+    limit2: 200`)
+	etag.Store(`"v2"`)
+	require.NoError(t, overridesManager.loadConfig(context.Background()))
+	require.Equal(t, 200, overridesManager.GetConfig().(*testOverrides).Overrides["user1"].Limit2)
+	require.Equal(t, int32(2), okResponses.Load())
+}
+
+func TestManager_ShouldFailOnUnexpectedHTTPStatusCode(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer server.Close()
+
+	_, err := LoadAndValidate(context.Background(), Config{
+		LoadPath:      "overrides.yaml",
+		Loader:        testLoadOverrides,
+		HTTPURL:       server.URL,
+		StorageConfig: bucket.Config{Backend: bucket.Filesystem},
+	}, nil)
+	require.ErrorContains(t, err, "read file overrides.yaml: get file: unexpected status code 500")
+}
@@ -0,0 +1,83 @@
+package runtimeconfig
+
+import (
+	"net/http"
+
+	"github.com/cortexproject/cortex/pkg/util"
+)
+
+// The kinds of listener channels, by the function they have been created with.
+const (
+	listenerKindConfig       = "config"
+	listenerKindWithPrevious = "config_with_previous"
+	listenerKindEvent        = "config_event"
+	listenerKindChangedKeys  = "changed_keys"
+)
+
+// ListenerStats are the stats of a listener channel of the Manager.
+type ListenerStats struct {
+	// Name is the name the listener has been created with by CreateNamedListenerChannel, or
+	// "unnamed".
+	Name string `json:"name"`
+	// Kind is the kind of updates the listener receives: config, config_with_previous,
+	// config_event or changed_keys.
+	Kind string `json:"kind"`
+	// BufferSize is the capacity of the listener channel.
+	BufferSize int `json:"buffer_size"`
+	// Pending is the number of updates buffered in the channel, not received yet.
+	Pending int `json:"pending"`
+	// DroppedUpdates is the number of updates dropped because the channel buffer was full.
+	DroppedUpdates int64 `json:"dropped_updates"`
+}
+
+// ListenerCount returns the number of listener channels currently registered, of any kind.
+func (om *Manager) ListenerCount() int {
+	om.listenersMtx.Lock()
+	defer om.listenersMtx.Unlock()
+
+	return len(om.listeners) + len(om.updateListeners) + len(om.eventListeners) + len(om.keysListeners)
+}
+
+// ListenerStats returns the stats of the listener channels currently registered, by kind and
+// then in registration order, so that the listeners not receiving their updates can be found.
+func (om *Manager) ListenerStats() []ListenerStats {
+	om.listenersMtx.Lock()
+	defer om.listenersMtx.Unlock()
+
+	stats := make([]ListenerStats, 0, len(om.listeners)+len(om.updateListeners)+len(om.eventListeners)+len(om.keysListeners))
+	for _, ch := range om.listeners {
+		stats = append(stats, om.listenerStats(ch, om.listenerName(ch), listenerKindConfig, cap(ch), len(ch)))
+	}
+	for _, ch := range om.updateListeners {
+		stats = append(stats, om.listenerStats(ch, unnamedListener, listenerKindWithPrevious, cap(ch), len(ch)))
+	}
+	for _, ch := range om.eventListeners {
+		stats = append(stats, om.listenerStats(ch, unnamedListener, listenerKindEvent, cap(ch), len(ch)))
+	}
+	for _, ch := range om.keysListeners {
+		stats = append(stats, om.listenerStats(ch, unnamedListener, listenerKindChangedKeys, cap(ch), len(ch)))
+	}
+	return stats
+}
+
+func (om *Manager) listenerStats(ch any, name, kind string, bufferSize, pending int) ListenerStats {
+	return ListenerStats{
+		Name:           name,
+		Kind:           kind,
+		BufferSize:     bufferSize,
+		Pending:        pending,
+		DroppedUpdates: om.listenerDrops[ch],
+	}
+}
+
+// ListenersHandler renders, in JSON format, the stats of the registered listener channels.
+func (om *Manager) ListenersHandler(w http.ResponseWriter, _ *http.Request) {
+	util.WriteJSONResponse(w, om.ListenerStats())
+}
+
+// recordDroppedUpdate tracks an update dropped because the buffer of the given listener
+// channel was full. It must be called with listenersMtx held.
+func (om *Manager) recordDroppedUpdate(ch any, name string) {
+	om.listenerDroppedUpdates.WithLabelValues(name).Inc()
+	om.listenerDrops[ch]++
+}
@@ -0,0 +1,59 @@
+package runtimeconfig
+
+import (
+	"context"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/cortexproject/cortex/pkg/storage/bucket"
+)
+
+func TestManager_ListenerStats(t *testing.T) {
+	defaultTestLimits = nil
+
+	manager, err := New(Config{
+		ReloadPeriod:  time.Hour,
+		LoadPath:      "runtime-config.yaml",
+		Loader:        testLoadOverrides,
+		StorageConfig: bucket.Config{Backend: bucket.Filesystem},
+	}, nil, log.NewNopLogger(), mockBucketClientFactory())
+	require.NoError(t, err)
+	assert.Equal(t, 0, manager.ListenerCount())
+	assert.Empty(t, manager.ListenerStats())
+
+	named := manager.CreateNamedListenerChannel("limits", 1)
+	unnamed := manager.CreateListenerChannel(2)
+	events := manager.CreateListenerChannelV2(0)
+	assert.Equal(t, 3, manager.ListenerCount())
+
+	manager.bucketClient = createMockBucketClient([]byte(`overrides:
+  user1:
+    limit2: 150`), []byte(`overrides:
+  user1:
+    limit2: 200`))
+	require.NoError(t, manager.loadConfig(context.Background()))
+	require.NoError(t, manager.loadConfig(context.Background()))
+
+	assert.Equal(t, []ListenerStats{
+		{Name: "limits", Kind: listenerKindConfig, BufferSize: 1, Pending: 1, DroppedUpdates: 1},
+		{Name: unnamedListener, Kind: listenerKindConfig, BufferSize: 2, Pending: 2},
+		{Name: unnamedListener, Kind: listenerKindEvent, DroppedUpdates: 2},
+	}, manager.ListenerStats())
+
+	// The stats of the closed listeners are forgotten.
+	manager.CloseListenerChannel(named)
+	manager.CloseListenerChannelV2(events)
+	assert.Equal(t, 1, manager.ListenerCount())
+
+	rec := httptest.NewRecorder()
+	manager.ListenersHandler(rec, httptest.NewRequest("GET", "/runtime_config/listeners", nil))
+	assert.JSONEq(t, `[{"name": "unnamed", "kind": "config", "buffer_size": 2, "pending": 2, "dropped_updates": 0}]`, rec.Body.String())
+
+	<-unnamed
+	assert.Equal(t, 1, manager.ListenerStats()[0].Pending)
+}
@@ -0,0 +1,50 @@
+package runtimeconfig
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+// LoaderRegistry maps the extensions of the runtime config files, e.g. ".yaml", to the
+// Loader of their format. It's used to pick the Loader when Config.Loader is not set.
+type LoaderRegistry map[string]Loader
+
+// Register sets the Loader of the files with the given extension, including the leading
+// dot. Extensions are case-insensitive.
+func (r LoaderRegistry) Register(ext string, loader Loader) {
+	r[strings.ToLower(ext)] = loader
+}
+
+// RegisterDefaultExtensions sets the Loader of the YAML and JSON files. Since JSON is a
+// subset of YAML, a YAML loader can be used for both.
+func (r LoaderRegistry) RegisterDefaultExtensions(yamlLoader, jsonLoader Loader) {
+	r.Register(".yaml", yamlLoader)
+	r.Register(".yml", yamlLoader)
+	r.Register(".json", jsonLoader)
+}
+
+// ForPath returns the Loader of the file at the given path, based on its extension. The
+// .gz suffix of compressed files is ignored.
+func (r LoaderRegistry) ForPath(path string) (Loader, error) {
+	ext := strings.ToLower(filepath.Ext(strings.TrimSuffix(path, ".gz")))
+	if loader, ok := r[ext]; ok && loader != nil {
+		return loader, nil
+	}
+	return nil, fmt.Errorf("no runtime config loader registered for the extension %q of file %s", ext, path)
+}
+
+// loader returns the Loader of the runtime config files at the given paths: Config.Loader if
+// set, otherwise the one registered for the extension of the first file, since the files are
+// merged before being loaded.
+func (mc *Config) loader(paths []string) (Loader, error) {
+	if mc.Loader != nil {
+		return mc.Loader, nil
+	}
+
+	if len(paths) == 0 {
+		return nil, errors.New("no runtime config loader configured")
+	}
+	return mc.Loaders.ForPath(paths[0])
+}
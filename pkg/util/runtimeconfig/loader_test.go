@@ -0,0 +1,113 @@
+package runtimeconfig
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/thanos-io/objstore"
+	"gopkg.in/yaml.v2"
+
+	"github.com/cortexproject/cortex/pkg/storage/bucket"
+	"github.com/cortexproject/cortex/pkg/util/services"
+)
+
+func TestLoaderRegistry_ForPath(t *testing.T) {
+	yamlLoader := func(io.Reader) (any, error) { return "yaml", nil }
+	jsonLoader := func(io.Reader) (any, error) { return "json", nil }
+
+	registry := LoaderRegistry{}
+	registry.RegisterDefaultExtensions(yamlLoader, jsonLoader)
+
+	tests := map[string]struct {
+		path        string
+		expected    string
+		expectedErr string
+	}{
+		"yaml":                   {path: "runtime.yaml", expected: "yaml"},
+		"yml":                    {path: "dir/runtime.yml", expected: "yaml"},
+		"json":                   {path: "runtime.json", expected: "json"},
+		"uppercase extension":    {path: "runtime.JSON", expected: "json"},
+		"gzip-compressed":        {path: "runtime.json.gz", expected: "json"},
+		"unregistered extension": {path: "runtime.toml", expectedErr: `no runtime config loader registered for the extension ".toml" of file runtime.toml`},
+		"no extension":           {path: "runtime", expectedErr: `no runtime config loader registered for the extension "" of file runtime`},
+	}
+
+	for testName, testData := range tests {
+		t.Run(testName, func(t *testing.T) {
+			loader, err := registry.ForPath(testData.path)
+			if testData.expectedErr != "" {
+				require.EqualError(t, err, testData.expectedErr)
+				return
+			}
+			require.NoError(t, err)
+
+			loaded, err := loader(nil)
+			require.NoError(t, err)
+			assert.Equal(t, testData.expected, loaded)
+		})
+	}
+}
+
+func TestManager_ShouldPickLoaderByExtension(t *testing.T) {
+	registry := LoaderRegistry{}
+	registry.RegisterDefaultExtensions(
+		func(r io.Reader) (any, error) {
+			var out testOverrides
+			return &out, yaml.NewDecoder(r).Decode(&out)
+		},
+		func(r io.Reader) (any, error) {
+			var out testOverrides
+			return &out, json.NewDecoder(r).Decode(&out)
+		},
+	)
+
+	tests := map[string]struct {
+		path        string
+		content     string
+		expectedErr string
+	}{
+		"yaml": {
+			path:    "runtime.yaml",
+			content: "overrides:\n  user1:\n    limit2: 150\n",
+		},
+		"json": {
+			path:    "runtime.json",
+			content: `{"overrides": {"user1": {"limit2": 150}}}`,
+		},
+		"unregistered extension": {
+			path:        "runtime.toml",
+			expectedErr: `no runtime config loader registered for the extension ".toml" of file runtime.toml`,
+		},
+	}
+
+	for testName, testData := range tests {
+		t.Run(testName, func(t *testing.T) {
+			bucketClient := &bucket.ClientMock{}
+			bucketClient.MockGet(testData.path, testData.content, nil)
+
+			manager, err := New(Config{
+				ReloadPeriod:  time.Hour,
+				LoadPath:      testData.path,
+				Loaders:       registry,
+				StorageConfig: bucket.Config{Backend: bucket.Filesystem},
+			}, nil, log.NewNopLogger(), func(context.Context) (objstore.Bucket, error) {
+				return bucketClient, nil
+			})
+			if testData.expectedErr != "" {
+				require.EqualError(t, err, testData.expectedErr)
+				return
+			}
+			require.NoError(t, err)
+			require.NoError(t, services.StartAndAwaitRunning(context.Background(), manager))
+			defer services.StopAndAwaitTerminated(context.Background(), manager) //nolint:errcheck
+
+			assert.Equal(t, 150, manager.GetConfig().(*testOverrides).Overrides["user1"].Limit2)
+		})
+	}
+}
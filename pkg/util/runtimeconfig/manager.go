@@ -2,50 +2,418 @@ package runtimeconfig
 
 import (
 	"bytes"
+	"compress/gzip"
 	"context"
 	"crypto/sha256"
 	"flag"
 	"fmt"
 	"io"
+	"net/http"
+	"strconv"
+	"strings"
 	"sync"
 	"time"
 
+	"github.com/fsnotify/fsnotify"
 	"github.com/go-kit/log"
 	"github.com/go-kit/log/level"
 	"github.com/pkg/errors"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promauto"
 	"github.com/thanos-io/objstore"
+	"go.uber.org/atomic"
+	"golang.org/x/sync/singleflight"
+	"gopkg.in/yaml.v2"
 
 	"github.com/cortexproject/cortex/pkg/storage/bucket"
+	"github.com/cortexproject/cortex/pkg/util"
+	"github.com/cortexproject/cortex/pkg/util/backoff"
+	"github.com/cortexproject/cortex/pkg/util/concurrency"
+	"github.com/cortexproject/cortex/pkg/util/flagext"
+	"github.com/cortexproject/cortex/pkg/util/multierror"
 	"github.com/cortexproject/cortex/pkg/util/services"
 )
 
 type BucketClientFactory func(ctx context.Context) (objstore.Bucket, error)
 
+// FallbackBucketClientFactory returns the bucket client of the fallback source at the given
+// index of Config.Fallbacks.
+type FallbackBucketClientFactory func(ctx context.Context, index int, source Source) (objstore.Bucket, error)
+
+// The errors returned by New when the Config is invalid.
+var (
+	ErrEmptyLoadPath            = errors.New("LoadPath is empty")
+	ErrEmptyBackend             = errors.New("Backend should not be explicitly empty")
+	ErrUnsupportedCompression   = errors.New("unsupported compression")
+	ErrInvalidReloadPeriod      = errors.New("reload period must be greater than 0")
+	ErrInvalidReloadSchedule    = errors.New("invalid reload schedule")
+	ErrNegativeMaxStaleness     = errors.New("max staleness must not be negative")
+	ErrNegativeReadTimeout      = errors.New("read timeout must not be negative")
+	ErrNegativeMaxFileSize      = errors.New("max file size must not be negative")
+	ErrNegativeLoadConcurrency  = errors.New("load concurrency must not be negative")
+	ErrNegativeInitialLoad      = errors.New("initial load max attempts and backoff must not be negative")
+	ErrNegativeStopFlushTimeout = errors.New("stop flush timeout must not be negative")
+	ErrNegativeHistorySize      = errors.New("history size must not be negative")
+	ErrNegativeLoaderTimeout    = errors.New("loader timeout must not be negative")
+	ErrInvalidHTTPURL           = errors.New("invalid HTTP URL")
+	ErrNegativeHTTPTimeout      = errors.New("HTTP timeout must not be negative")
+
+	ErrMissingFallbackBucketClientFactory = errors.New("FallbackBucketClientFactory is required when fallback sources are configured")
+)
+
+// ErrEmptyConfig is returned when the runtime config files are all empty, unless AllowEmpty
+// is set, so that an empty file doesn't wipe out the config.
+var ErrEmptyConfig = errors.New("runtime config is empty")
+
+// ErrConfigNotFound is returned at startup if a runtime config file doesn't exist in the bucket.
+var ErrConfigNotFound = errors.New("runtime config file not found")
+
+// ErrLoaderTimeout is returned when the loader doesn't return within the configured
+// LoaderTimeout.
+var ErrLoaderTimeout = errors.New("runtime config loader timed out")
+
+// errReloadDisabled is returned by Reload when there's no file to reload the config from.
+var errReloadDisabled = errors.New("runtime config reload disabled: file not specified")
+
+const (
+	// CompressionNone means the runtime config files are stored uncompressed,
+	// unless their name has the .gz suffix.
+	CompressionNone = "none"
+	// CompressionGzip means the runtime config files are stored gzip-compressed.
+	CompressionGzip = "gzip"
+
+	// unnamedListener is the name the dropped updates of the listeners created without
+	// a name are tracked by.
+	unnamedListener = "unnamed"
+
+	// The reasons the runtime config load failures are tracked by.
+	loadFailureRead       = "read"
+	loadFailureDecompress = "decompress"
+	loadFailureParse      = "parse"
+	loadFailureValidate   = "validate"
+)
+
 // Loader loads the configuration from file.
 type Loader func(r io.Reader) (any, error)
 
+// LoaderWithMeta is like Loader, but also receives the key of the object the configuration
+// is read from, along with its attributes.
+type LoaderWithMeta func(key string, attrs objstore.ObjectAttributes, r io.Reader) (any, error)
+
+// Merger merges the content of multiple runtime config files, in the order they
+// are listed in LoadPath, into the content passed to the Loader.
+type Merger func(parts [][]byte) ([]byte, error)
+
+// ClusterStateProvider returns a snapshot of the cluster state the runtime config is
+// validated against, e.g. the known tenants or ring instances.
+type ClusterStateProvider func(ctx context.Context) (any, error)
+
+// ClusterStateValidator returns an error if the loaded config is inconsistent with the
+// given cluster state.
+type ClusterStateValidator func(cfg any, clusterState any) error
+
+// TenantSizer is implemented by loaded configs able to report the size, in bytes,
+// of each tenant's section of the runtime config file.
+type TenantSizer interface {
+	TenantSizes() map[string]int
+}
+
 // Config holds the config for an Manager instance.
 // It holds config related to loading per-tenant config.
 type Config struct {
 	ReloadPeriod time.Duration `yaml:"period"`
+	// ReloadSchedule, if set, is the cron expression of the times the runtime config is
+	// reloaded at, in UTC, instead of every ReloadPeriod.
+	ReloadSchedule string `yaml:"reload_schedule"`
 	// LoadPath contains the path to the runtime config file, requires an
-	// non-empty value
+	// non-empty value. Multiple comma-separated paths can be provided.
 	LoadPath string `yaml:"file"`
-	Loader   Loader `yaml:"-"`
+	// Prefix is the bucket prefix the files listed in LoadPath are read from.
+	Prefix string `yaml:"prefix"`
+	// Preload, if set, is the content of the runtime config served as soon as the Manager
+	// starts, loaded and validated as the files listed in LoadPath. If LoadPath is empty, it's
+	// served without creating any bucket client nor reloading it. Otherwise, it's replaced by
+	// the config read from LoadPath on the first successful load, and failing to read LoadPath
+	// at startup doesn't fail the Manager.
+	Preload []byte `yaml:"-"`
+	Loader  Loader `yaml:"-"`
+	// LoaderWithMeta, if set, is used instead of Loader. Since the files listed in LoadPath
+	// are merged before being loaded, it receives the key and attributes of the first file.
+	// The attributes are empty if not provided by the storage backend.
+	LoaderWithMeta LoaderWithMeta `yaml:"-"`
+	// Loaders picks the Loader by the extension of the files listed in LoadPath, if Loader
+	// and LoaderWithMeta are nil.
+	Loaders LoaderRegistry `yaml:"-"`
+	// Merger merges the files listed in LoadPath. If nil, their content is concatenated.
+	Merger Merger `yaml:"-"`
+	// MergeStrategies is the merge strategy of each section, used by NewSectionMerger.
+	MergeStrategies map[string]string `yaml:"merge_strategies" doc:"nocli|description=The strategy used to merge each top-level section of the runtime config files when multiple files are provided. Supported values are: deep-merge, replace and append. Sections without a strategy are deep-merged.|default={}"`
+
+	// LogFullOnChange enables logging the whole applied config each time it changes.
+	LogFullOnChange bool `yaml:"log_full_on_change"`
+	// Redact, if set, returns a copy of the loaded config with secrets removed, which
+	// is logged instead of the loaded config.
+	Redact func(cfg any) any `yaml:"-"`
+	// TenantExtractor, if set, returns the section of the loaded config scoped to the
+	// tenant, which is returned by GetConfigFor.
+	TenantExtractor func(cfg any, tenantID string) any `yaml:"-"`
+
+	// Compression is the compression of the runtime config files stored in the bucket.
+	Compression string `yaml:"compression"`
+
+	// MaxTenantConfigSize is the max size, in bytes, of a single tenant's section. It is
+	// only enforced when the loaded config implements TenantSizer.
+	MaxTenantConfigSize int `yaml:"max_tenant_config_size"`
+
+	// MaxStaleness is the max time since the last successful load after which the Manager
+	// fails. 0 means the Manager never fails because of a stale config.
+	MaxStaleness time.Duration `yaml:"max_staleness"`
+
+	// ReadTimeout is the timeout of the read of each runtime config file. 0 means no timeout.
+	ReadTimeout time.Duration `yaml:"read_timeout"`
+
+	// MaxFileSize is the max size, in bytes, of each runtime config file, after
+	// decompression. 0 means unlimited.
+	MaxFileSize int `yaml:"max_file_size"`
+
+	// LoadConcurrency is the max number of runtime config files read concurrently. 0 means
+	// the files are read sequentially.
+	LoadConcurrency int `yaml:"load_concurrency"`
+
+	// InitialLoadMaxAttempts is the max number of attempts of the initial load, at startup,
+	// retried with a backoff between InitialLoadMinBackoff and InitialLoadMaxBackoff. 0 and 1
+	// mean a single attempt.
+	InitialLoadMaxAttempts int           `yaml:"initial_load_max_attempts"`
+	InitialLoadMinBackoff  time.Duration `yaml:"initial_load_min_backoff"`
+	InitialLoadMaxBackoff  time.Duration `yaml:"initial_load_max_backoff"`
+
+	// StopFlushTimeout, if greater than 0, is the max time the Manager waits, when stopping,
+	// for the listeners created with CreateListenerChannel to receive the current config
+	// before closing them. 0 means the listeners are closed immediately.
+	StopFlushTimeout time.Duration `yaml:"stop_flush_timeout"`
+
+	// LoaderTimeout, if greater than 0, is the max time the loader is given to load the runtime
+	// config files. The loader runs in its own goroutine, which is abandoned on timeout and
+	// leaks until the loader returns. 0 means the loader runs with no timeout.
+	LoaderTimeout time.Duration `yaml:"loader_timeout"`
+
+	// HistorySize is the number of the last distinct successfully loaded configs kept in
+	// memory, which can be reverted to with RevertTo. 0 means no history is kept.
+	HistorySize int `yaml:"history_size"`
+
+	// AllowEmpty allows loading the runtime config files when they're all empty, clearing the
+	// config. Otherwise, the load fails with ErrEmptyConfig and the previous config is kept.
+	AllowEmpty bool `yaml:"allow_empty"`
+
+	// WatchFilesystem enables reloading the runtime config files as soon as they change,
+	// in addition to the periodic reload. It's only supported by the filesystem backend.
+	WatchFilesystem bool `yaml:"watch_filesystem"`
+
+	// ClusterStateValidator, if set, validates each loaded config against the cluster
+	// state returned by ClusterStateProvider. Configs failing validation are rejected.
+	ClusterStateValidator ClusterStateValidator `yaml:"-"`
+	ClusterStateProvider  ClusterStateProvider  `yaml:"-"`
 
+	// HTTPURL, if set, is the base URL the runtime config files listed in LoadPath are read
+	// from with an HTTP GET, instead of StorageConfig.
+	HTTPURL         string         `yaml:"http_url"`
+	HTTPTimeout     time.Duration  `yaml:"http_timeout"`
+	HTTPBearerToken flagext.Secret `yaml:"http_bearer_token"`
+
+	StorageConfig bucket.Config `yaml:",inline"`
+
+	// Fallbacks are the sources the runtime config is read from, in order, when it can't be
+	// read from LoadPath in StorageConfig. Their bucket clients are created with
+	// FallbackBucketClientFactory.
+	Fallbacks                   []Source                    `yaml:"fallbacks" doc:"hidden"`
+	FallbackBucketClientFactory FallbackBucketClientFactory `yaml:"-"`
+}
+
+// Source is a storage backend and the runtime config files to read from it.
+type Source struct {
+	// LoadPath contains the path to the runtime config file. Multiple comma-separated
+	// paths can be provided.
+	LoadPath      string        `yaml:"file"`
+	Prefix        string        `yaml:"prefix"`
 	StorageConfig bucket.Config `yaml:",inline"`
 }
 
+// loadPaths returns the list of files to load the runtime config from.
+func (s *Source) loadPaths() []string {
+	return splitLoadPaths(s.LoadPath)
+}
+
 // RegisterFlags registers flags.
 func (mc *Config) RegisterFlags(f *flag.FlagSet) {
-	f.StringVar(&mc.LoadPath, "runtime-config.file", "", "File with the configuration that can be updated in runtime.")
+	f.StringVar(&mc.LoadPath, "runtime-config.file", "", "File with the configuration that can be updated in runtime. Multiple comma-separated files can be provided, in which case they're read in order and merged.")
+	f.StringVar(&mc.Prefix, "runtime-config.prefix", "", "Prefix, in the bucket of the storage backend, of the runtime config files. It's prepended to the path of each file, so that the same file can be configured across clusters storing the runtime config under different prefixes.")
 	f.DurationVar(&mc.ReloadPeriod, "runtime-config.reload-period", 10*time.Second, "How often to check runtime config file.")
+	f.StringVar(&mc.ReloadSchedule, "runtime-config.reload-schedule", "", "If set, the runtime config file is reloaded at the times matching this cron expression, in UTC, instead of every reload period. The expression is made of 5 fields: minute, hour, day of month, month and day of week, e.g. '30 2 * * *' to reload every day at 02:30.")
+	f.BoolVar(&mc.LogFullOnChange, "runtime-config.log-full-on-change", false, "If true, the whole applied runtime config is logged at debug level each time it changes.")
+	f.StringVar(&mc.Compression, "runtime-config.compression", CompressionNone, "Compression of the runtime config files. Supported values are: 'none' and 'gzip'. Files with the .gz suffix are always decompressed.")
+	f.IntVar(&mc.MaxTenantConfigSize, "runtime-config.max-tenant-config-size", 0, "Maximum size in bytes of a single tenant's section in the runtime config file. If any tenant exceeds it, the whole reload is rejected and the previous config is kept. 0 to disable.")
+
+	f.DurationVar(&mc.MaxStaleness, "runtime-config.max-staleness", 0, "Maximum time since the last successful runtime config load, after which the runtime config manager fails, making the service not ready. Until then, the last successfully loaded config keeps being used. 0 to never fail.")
+
+	f.DurationVar(&mc.ReadTimeout, "runtime-config.read-timeout", 0, "Timeout of the read of each runtime config file from the storage backend. A failed read fails the whole reload, and the previous config is kept. 0 to disable.")
+	f.IntVar(&mc.MaxFileSize, "runtime-config.max-file-size", 0, "Maximum size in bytes of each runtime config file, after decompression. If any file exceeds it, the whole reload is rejected and the previous config is kept. 0 to disable.")
+	f.IntVar(&mc.InitialLoadMaxAttempts, "runtime-config.initial-load-max-attempts", 1, "Maximum number of attempts of the initial runtime config load, at startup, before failing. The failed attempts are retried with an exponential backoff.")
+	f.DurationVar(&mc.InitialLoadMinBackoff, "runtime-config.initial-load-min-backoff", time.Second, "Minimum delay before retrying the initial runtime config load.")
+	f.DurationVar(&mc.InitialLoadMaxBackoff, "runtime-config.initial-load-max-backoff", 10*time.Second, "Maximum delay before retrying the initial runtime config load.")
+	f.DurationVar(&mc.StopFlushTimeout, "runtime-config.stop-flush-timeout", 0, "If greater than 0, the runtime config manager, when stopping, sends the current runtime config to each listener one last time, waiting up to this timeout overall for them to receive it, before closing them. 0 to close them immediately.")
+	f.DurationVar(&mc.LoaderTimeout, "runtime-config.loader-timeout", 0, "If greater than 0, the maximum time to parse and validate the runtime config files. On timeout, the reload is rejected and the previous config is kept, while the parsing keeps running in the background until it completes. 0 to disable.")
+	f.IntVar(&mc.HistorySize, "runtime-config.history-size", 0, "Number of the last distinct successfully loaded runtime configs kept in memory, which the runtime config can be reverted to. 0 to disable.")
+	f.BoolVar(&mc.AllowEmpty, "runtime-config.allow-empty", false, "If true, empty runtime config files are loaded, clearing the runtime config. If false, a reload reading only empty files is rejected and the previous config is kept.")
+	f.IntVar(&mc.LoadConcurrency, "runtime-config.load-concurrency", 4, "Maximum number of runtime config files read concurrently, when multiple files are provided. If any file fails to be read, the whole reload is rejected and the previous config is kept. 0 to read them sequentially.")
+
+	f.BoolVar(&mc.WatchFilesystem, "runtime-config.watch-filesystem", false, "If true, the runtime config files are reloaded as soon as they change, in addition to the periodic reload. Only supported by the filesystem backend.")
+
+	f.StringVar(&mc.HTTPURL, "runtime-config.http-url", "", "If set, the runtime config files are read with an HTTP GET from this base URL, joined with the path of each file, instead of the storage backend. Requests are conditional on the ETag of the last successfully loaded files.")
+	f.DurationVar(&mc.HTTPTimeout, "runtime-config.http-timeout", 10*time.Second, "Timeout of the HTTP requests the runtime config files are read with, when reading them from the HTTP URL. 0 to disable.")
+	f.Var(&mc.HTTPBearerToken, "runtime-config.http-bearer-token", "Bearer token sent with the HTTP requests the runtime config files are read with, when reading them from the HTTP URL.")
 
 	mc.StorageConfig.RegisterFlagsWithPrefixAndBackend("runtime-config.", f, bucket.Filesystem)
 }
 
+func (mc *Config) validate() error {
+	if mc.LoadPath == "" && mc.Preload == nil {
+		return ErrEmptyLoadPath
+	}
+
+	if mc.LoadPath != "" && mc.StorageConfig.Backend == "" {
+		return ErrEmptyBackend
+	}
+
+	switch mc.Compression {
+	case "", CompressionNone, CompressionGzip:
+	default:
+		return fmt.Errorf("%w: %s", ErrUnsupportedCompression, mc.Compression)
+	}
+
+	if err := validateMergeStrategies(mc.MergeStrategies); err != nil {
+		return err
+	}
+
+	if mc.ReloadSchedule != "" {
+		if _, err := parseCronSchedule(mc.ReloadSchedule); err != nil {
+			return fmt.Errorf("%w: %v", ErrInvalidReloadSchedule, err)
+		}
+	}
+
+	if mc.MaxStaleness < 0 {
+		return ErrNegativeMaxStaleness
+	}
+
+	if mc.ReadTimeout < 0 {
+		return ErrNegativeReadTimeout
+	}
+
+	if mc.MaxFileSize < 0 {
+		return ErrNegativeMaxFileSize
+	}
+
+	if mc.LoadConcurrency < 0 {
+		return ErrNegativeLoadConcurrency
+	}
+
+	if mc.InitialLoadMaxAttempts < 0 || mc.InitialLoadMinBackoff < 0 || mc.InitialLoadMaxBackoff < 0 {
+		return ErrNegativeInitialLoad
+	}
+
+	if mc.StopFlushTimeout < 0 {
+		return ErrNegativeStopFlushTimeout
+	}
+
+	if mc.HistorySize < 0 {
+		return ErrNegativeHistorySize
+	}
+
+	if mc.LoaderTimeout < 0 {
+		return ErrNegativeLoaderTimeout
+	}
+
+	if mc.HTTPURL != "" {
+		if err := validateHTTPURL(mc.HTTPURL); err != nil {
+			return err
+		}
+	}
+
+	if mc.HTTPTimeout < 0 {
+		return ErrNegativeHTTPTimeout
+	}
+
+	if mc.Loader == nil && mc.LoaderWithMeta == nil && mc.Loaders != nil {
+		if _, err := mc.loader(mc.loadPaths()); err != nil {
+			return err
+		}
+	}
+
+	if len(mc.Fallbacks) > 0 && mc.FallbackBucketClientFactory == nil {
+		return ErrMissingFallbackBucketClientFactory
+	}
+
+	for i, fallback := range mc.Fallbacks {
+		if fallback.LoadPath == "" {
+			return fmt.Errorf("fallback %d: %w", i, ErrEmptyLoadPath)
+		}
+		if fallback.StorageConfig.Backend == "" {
+			return fmt.Errorf("fallback %d: %w", i, ErrEmptyBackend)
+		}
+		if mc.Loader == nil && mc.LoaderWithMeta == nil && mc.Loaders != nil {
+			if _, err := mc.loader(fallback.loadPaths()); err != nil {
+				return errors.Wrapf(err, "fallback %d", i)
+			}
+		}
+	}
+	return nil
+}
+
+// loadPaths returns the list of files to load the runtime config from.
+func (mc *Config) loadPaths() []string {
+	return splitLoadPaths(mc.LoadPath)
+}
+
+// splitLoadPaths returns the list of files in the comma-separated loadPath.
+func splitLoadPaths(loadPath string) []string {
+	var paths []string
+	for _, p := range strings.Split(loadPath, ",") {
+		if p = strings.TrimSpace(p); p != "" {
+			paths = append(paths, p)
+		}
+	}
+	return paths
+}
+
+// ConfigUpdate is sent to the listeners created with CreateListenerChannelWithPrevious
+// each time a new config is loaded.
+type ConfigUpdate struct {
+	// Old is the previously loaded config value, nil on the first load.
+	Old any
+	// New is the newly loaded config value.
+	New any
+}
+
+// noConfigSource is the source index of the configs not read from any source.
+const noConfigSource = -1
+
+// ConfigEvent is sent to the listeners created with CreateListenerChannelV2 each time a new
+// config is loaded, with the metadata of the load.
+type ConfigEvent struct {
+	// Config is the newly loaded config value.
+	Config any
+	// Hash is the hex-encoded SHA256 hash of the content the config has been loaded from,
+	// empty for the configs set with SetConfig.
+	Hash string
+	// LoadedAt is when the config has been loaded.
+	LoadedAt time.Time
+	// Source is the index of the source the config has been read from, where 0 is the primary
+	// source and the following are the fallbacks, in order. It's -1 for the preloaded config
+	// and the configs set with SetConfig.
+	Source int
+	// Generation is the generation of the config, see Manager.Generation.
+	Generation uint64
+}
+
 // Manager periodically reloads the configuration from a file, and keeps this
 // configuration available for clients.
 type Manager struct {
@@ -54,193 +422,1324 @@ type Manager struct {
 	cfg    Config
 	logger log.Logger
 
-	listenersMtx sync.Mutex
-	listeners    []chan any
+	listenersMtx    sync.Mutex
+	listeners       []chan any
+	listenerNames   map[chan any]string
+	updateListeners []chan ConfigUpdate
+	keysListeners   []chan ChangedKeysUpdate
+	eventListeners  []chan ConfigEvent
+	callbacks       []func(cfg any)
+	// listenerDrops is the number of updates dropped by each listener channel, of any kind.
+	listenerDrops map[any]int64
+
+	configMtx  sync.RWMutex
+	config     any
+	hash       string
+	generation uint64
+
+	// history are the last distinct successfully loaded configs, from the most recent.
+	historyMtx sync.Mutex
+	history    []ConfigSnapshot
+	// revertedHash, if set, is the hash of the files loaded when the config has been reverted
+	// with RevertTo. The reverted config is kept until the files change.
+	revertedHash atomic.String
+
+	// loaded is closed, once, when the first config is loaded.
+	loaded     chan struct{}
+	loadedOnce sync.Once
+
+	// reloadGroup coalesces concurrent reloads into a single in-flight load.
+	reloadGroup singleflight.Group
+	// reloadCtx is the context of the loads shared by the reloads, which isn't tied to any
+	// of their callers. It's canceled, by reloadCancel, when the Manager stops.
+	reloadCtx    context.Context
+	reloadCancel context.CancelFunc
 
-	configMtx sync.RWMutex
-	config    any
+	configLoadSuccess      prometheus.Gauge
+	configStaleness        prometheus.Gauge
+	configHash             *prometheus.GaugeVec
+	configFileSize         *prometheus.GaugeVec
+	configSource           *prometheus.GaugeVec
+	listenerDroppedUpdates *prometheus.CounterVec
+	loadFailures           *prometheus.CounterVec
+	loaderTimeouts         prometheus.Counter
 
-	configLoadSuccess prometheus.Gauge
-	configHash        *prometheus.GaugeVec
+	// lastLoadSuccess is the time of the last successful config load.
+	lastLoadSuccess atomic.Time
+	// lastLoadAttempt is the time of the last config load attempt, and lastLoadError the
+	// error it failed with, if any.
+	lastLoadAttempt atomic.Time
+	lastLoadError   atomic.String
+	// nextReload is the time of the next periodic reload.
+	nextReload atomic.Time
+	// schedule, if set, is the schedule of the periodic reloads, instead of ReloadPeriod.
+	schedule *cronSchedule
+
+	// stopped is whether the Manager has stopped, after which the config is no longer updated.
+	stopped atomic.Bool
+
+	// loadedFiles are the files of the last successful config load, by path, read from the
+	// source at index loadedSource. Their content is reused, instead of being downloaded
+	// again, while their attributes are unchanged.
+	loadedFilesMtx sync.Mutex
+	loadedFiles    map[string]loadedFile
+	loadedSource   int
 
 	bucketClient        objstore.Bucket
 	bucketClientFactory BucketClientFactory
+
+	// httpClient reads the runtime config files when HTTPURL is set.
+	httpClient *http.Client
+
+	// fallbackBucketClients are the bucket clients of the fallback sources, in order.
+	fallbackBucketClients []objstore.Bucket
+}
+
+// configSource is a source the runtime config files are read from.
+type configSource struct {
+	paths        []string
+	prefix       string
+	bucketClient objstore.Bucket
+	// httpURL, if set, is the base URL the files are read from, instead of bucketClient.
+	httpURL string
+}
+
+// lastModifiedResolution is the coarsest resolution of the last modified time of the objects
+// the Manager copes with. An object modified twice within it may keep the same attributes.
+const lastModifiedResolution = time.Second
+
+// loadedFile is the content of a runtime config file, along with the attributes of the
+// object it was read from.
+type loadedFile struct {
+	attrs   objstore.ObjectAttributes
+	content []byte
+
+	// downloadedAt is when the download of the object started.
+	downloadedAt time.Time
+
+	// etag is the ETag of the HTTP response the file was read from, if any.
+	etag string
+}
+
+// unchanged returns whether the object the file has been downloaded from is unchanged, given
+// its current attributes. Since the last modified time may have a coarse resolution, the
+// object is only known to be unchanged if it was last modified well before the download.
+func (f loadedFile) unchanged(attrs objstore.ObjectAttributes) bool {
+	return f.attrs.Size == attrs.Size &&
+		f.attrs.LastModified.Equal(attrs.LastModified) &&
+		f.attrs.LastModified.Before(f.downloadedAt.Add(-lastModifiedResolution))
 }
 
 // New creates an instance of Manager and starts reload config loop based on config
 func New(cfg Config, registerer prometheus.Registerer, logger log.Logger, factory BucketClientFactory) (*Manager, error) {
-	if cfg.LoadPath == "" {
-		return nil, errors.New("LoadPath is empty")
+	if err := cfg.validate(); err != nil {
+		return nil, err
+	}
+
+	if cfg.LoadPath != "" && cfg.ReloadSchedule == "" && cfg.ReloadPeriod <= 0 {
+		return nil, ErrInvalidReloadPeriod
 	}
 
-	if cfg.StorageConfig.Backend == "" {
-		return nil, errors.New("Backend should not be explicitly empty")
+	mgr := newManager(cfg, registerer, logger)
+	mgr.bucketClientFactory = factory
+	if cfg.ReloadSchedule != "" {
+		// The schedule has already been validated.
+		mgr.schedule, _ = parseCronSchedule(cfg.ReloadSchedule)
 	}
+	mgr.Service = services.NewBasicService(mgr.starting, mgr.loop, mgr.stopping)
+	return mgr, nil
+}
+
+// NewStatic creates a Manager serving the given config, which is never read from a bucket
+// nor reloaded. The config can be changed with SetConfig, notifying the listeners, so that
+// the consumers of the runtime config can be tested deterministically.
+func NewStatic(config any, registerer prometheus.Registerer) *Manager {
+	mgr := newManager(Config{}, registerer, log.NewNopLogger())
+	mgr.Service = services.NewIdleService(nil, mgr.stopping)
+	mgr.SetConfig(config)
+	return mgr
+}
 
-	mgr := Manager{
-		cfg: cfg,
+func newManager(cfg Config, registerer prometheus.Registerer, logger log.Logger) *Manager {
+	reloadCtx, reloadCancel := context.WithCancel(context.Background())
+	return &Manager{
+		cfg:          cfg,
+		reloadCtx:    reloadCtx,
+		reloadCancel: reloadCancel,
 		configLoadSuccess: promauto.With(registerer).NewGauge(prometheus.GaugeOpts{
 			Name: "runtime_config_last_reload_successful",
 			Help: "Whether the last runtime-config reload attempt was successful.",
 		}),
+		configStaleness: promauto.With(registerer).NewGauge(prometheus.GaugeOpts{
+			Name: "runtime_config_staleness_seconds",
+			Help: "Time since the last successful runtime-config load, updated on each reload attempt.",
+		}),
 		configHash: promauto.With(registerer).NewGaugeVec(prometheus.GaugeOpts{
 			Name: "runtime_config_hash",
 			Help: "Hash of the currently active runtime config file.",
 		}, []string{"sha256"}),
-		logger:              logger,
-		bucketClientFactory: factory,
+		configFileSize: promauto.With(registerer).NewGaugeVec(prometheus.GaugeOpts{
+			Name: "runtime_config_file_size_bytes",
+			Help: "Size, in bytes, of each runtime config file as of the last successful load, after decompression.",
+		}, []string{"file"}),
+		configSource: promauto.With(registerer).NewGaugeVec(prometheus.GaugeOpts{
+			Name: "runtime_config_source",
+			Help: "Whether the runtime config has been read from the source at the given index as of the last successful load, where 0 is the primary source and the following are the fallbacks, in order.",
+		}, []string{"index"}),
+		listenerDroppedUpdates: promauto.With(registerer).NewCounterVec(prometheus.CounterOpts{
+			Name: "runtime_config_listener_dropped_updates_total",
+			Help: "Total number of runtime config updates dropped because the listener's buffer was full.",
+		}, []string{"listener"}),
+		loadFailures: newLoadFailuresMetric(registerer),
+		loaderTimeouts: promauto.With(registerer).NewCounter(prometheus.CounterOpts{
+			Name: "runtime_config_loader_timeouts_total",
+			Help: "Total number of runtime config loads abandoned because the loader didn't return within the loader timeout.",
+		}),
+		listenerNames: map[chan any]string{},
+		listenerDrops: map[any]int64{},
+		loaded:        make(chan struct{}),
+		logger:        logger,
+		httpClient:    newHTTPClient(cfg),
 	}
+}
 
-	mgr.Service = services.NewBasicService(mgr.starting, mgr.loop, mgr.stopping)
-	return &mgr, nil
+// newLoadFailuresMetric returns the counter of the runtime config load failures, with all the
+// reasons initialized. The counter isn't registered if registerer is nil.
+func newLoadFailuresMetric(registerer prometheus.Registerer) *prometheus.CounterVec {
+	loadFailures := promauto.With(registerer).NewCounterVec(prometheus.CounterOpts{
+		Name: "runtime_config_load_failures_total",
+		Help: "Total number of runtime config load failures, by the reason: read, decompress, parse or validate.",
+	}, []string{"reason"})
+
+	for _, reason := range []string{loadFailureRead, loadFailureDecompress, loadFailureParse, loadFailureValidate} {
+		loadFailures.WithLabelValues(reason)
+	}
+	return loadFailures
 }
 
 func (om *Manager) starting(ctx context.Context) error {
+	if om.cfg.Preload != nil {
+		if err := om.loadPreload(ctx); err != nil {
+			return errors.Wrap(err, "failed to load preloaded runtime config")
+		}
+	}
+
 	if om.cfg.LoadPath == "" {
 		return nil
 	}
 
 	var err error
-	om.bucketClient, err = om.bucketClientFactory(ctx)
-	if err != nil {
-		return err
+	if om.cfg.HTTPURL == "" {
+		if om.bucketClient, err = om.bucketClientFactory(ctx); err != nil {
+			return err
+		}
 	}
 
-	return errors.Wrap(om.loadConfig(ctx), "failed to load runtime config")
-}
-
-// CreateListenerChannel creates new channel that can be used to receive new config values.
-// If there is no receiver waiting for value when config manager tries to send the update,
-// or channel buffer is full, update is discarded.
-//
-// When config manager is stopped, it closes all channels to notify receivers that they will
-// not receive any more updates.
-func (om *Manager) CreateListenerChannel(buffer int) <-chan any {
-	ch := make(chan any, buffer)
-
-	om.listenersMtx.Lock()
-	defer om.listenersMtx.Unlock()
+	if om.fallbackBucketClients, err = newFallbackBucketClients(ctx, om.cfg); err != nil {
+		return err
+	}
 
-	om.listeners = append(om.listeners, ch)
-	return ch
+	err = om.loadInitialConfig(ctx)
+	if err != nil && om.cfg.Preload != nil {
+		level.Warn(om.logger).Log("msg", "failed to load runtime config, serving the preloaded config until the next successful reload", "err", err)
+		return nil
+	}
+	return errors.Wrap(err, "failed to load runtime config")
 }
 
-// CloseListenerChannel removes given channel from list of channels to send notifications to and closes channel.
-func (om *Manager) CloseListenerChannel(listener <-chan any) {
-	om.listenersMtx.Lock()
-	defer om.listenersMtx.Unlock()
+// checkConfigExists returns ErrConfigNotFound, naming the object key, if any of the runtime
+// config files doesn't exist in the bucket, so that a wrong path is reported as such instead
+// of a generic read error. It's skipped if the files are read over HTTP or if there are
+// fallback sources to read them from. The failures to check the existence are ignored, and
+// left to the read to report.
+func (om *Manager) checkConfigExists(ctx context.Context) error {
+	if om.cfg.HTTPURL != "" || len(om.cfg.Fallbacks) > 0 {
+		return nil
+	}
 
-	for ix, ch := range om.listeners {
-		if ch == listener {
-			om.listeners = append(om.listeners[:ix], om.listeners[ix+1:]...)
-			close(ch)
-			break
+	for _, path := range om.cfg.loadPaths() {
+		key := objectKey(om.cfg.Prefix, path)
+		if exists, err := om.bucketClient.Exists(ctx, key); err == nil && !exists {
+			return fmt.Errorf("%w: %s", ErrConfigNotFound, key)
 		}
 	}
+	return nil
 }
 
-func (om *Manager) loop(ctx context.Context) error {
-	if om.cfg.LoadPath == "" {
-		level.Info(om.logger).Log("msg", "runtime config disabled: file not specified")
-		<-ctx.Done()
-		return nil
-	}
-
-	ticker := time.NewTicker(om.cfg.ReloadPeriod)
-	defer ticker.Stop()
+// loadInitialConfig loads the config at startup, retrying up to the configured max attempts.
+// Each attempt checks that the files exist first, so that the files uploaded while retrying
+// are loaded.
+func (om *Manager) loadInitialConfig(ctx context.Context) error {
+	boff := backoff.New(ctx, backoff.Config{
+		MinBackoff: om.cfg.InitialLoadMinBackoff,
+		MaxBackoff: om.cfg.InitialLoadMaxBackoff,
+	})
 
-	for {
-		select {
-		case <-ticker.C:
-			err := om.loadConfig(ctx)
-			if err != nil {
-				// Log but don't stop on error - we don't want to halt all ingesters because of a typo
-				level.Error(om.logger).Log("msg", "failed to load config", "err", err)
-			}
-		case <-ctx.Done():
-			return nil
+	for attempt := 1; ; attempt++ {
+		err := om.checkConfigExists(ctx)
+		if err == nil {
+			err = om.loadConfig(ctx)
+		}
+		if err == nil || attempt >= om.cfg.InitialLoadMaxAttempts || ctx.Err() != nil {
+			return err
 		}
+
+		level.Warn(om.logger).Log("msg", "failed to load runtime config, retrying", "attempt", attempt, "max_attempts", om.cfg.InitialLoadMaxAttempts, "err", err)
+		boff.Wait()
 	}
 }
 
-// loadConfig loads configuration using the loader function, and if successful,
-// stores it as current configuration and notifies listeners.
-func (om *Manager) loadConfig(ctx context.Context) error {
-	buf, err := om.loadConfigFromBucket(ctx)
-
+// loadPreload loads and validates the preloaded config, and stores it as current configuration.
+func (om *Manager) loadPreload(ctx context.Context) error {
+	cfg, err := om.load(om.cfg.loadPaths(), nil, om.cfg.Preload)
 	if err != nil {
-		om.configLoadSuccess.Set(0)
-		return errors.Wrap(err, "read file")
+		return err
 	}
-	hash := sha256.Sum256(buf)
 
-	cfg, err := om.cfg.Loader(bytes.NewReader(buf))
-	if err != nil {
-		om.configLoadSuccess.Set(0)
-		return errors.Wrap(err, "load file")
+	if err := om.validateTenantSizes(cfg); err != nil {
+		return err
 	}
-	om.configLoadSuccess.Set(1)
 
-	om.setConfig(cfg)
-	om.callListeners(cfg)
+	if err := om.validateClusterState(ctx, cfg); err != nil {
+		return err
+	}
 
-	// expose hash of runtime config
+	hash := fmt.Sprintf("%x", sha256.Sum256(om.cfg.Preload))
+	loadedAt := time.Now()
+	om.configLoadSuccess.Set(1)
+	om.lastLoadSuccess.Store(loadedAt)
+	om.setConfigAndCallListeners(ConfigEvent{Config: cfg, Hash: hash, LoadedAt: loadedAt, Source: noConfigSource})
 	om.configHash.Reset()
-	om.configHash.WithLabelValues(fmt.Sprintf("%x", hash[:])).Set(1)
+	om.configHash.WithLabelValues(hash).Set(1)
 	return nil
 }
 
-func (om *Manager) loadConfigFromBucket(ctx context.Context) ([]byte, error) {
-	readCloser, err := om.bucketClient.Get(ctx, om.cfg.LoadPath)
-	if err != nil {
-		return nil, errors.Wrap(err, "open file")
+// LoadAndValidate reads the runtime config files, loads them with the Loader and validates
+// the loaded config, as done by the Manager on each reload, and returns the loaded config.
+// Only the files of LoadPath are checked: the fallbacks are never read, and the Loader runs
+// synchronously regardless of LoaderTimeout. Unlike the Manager, it doesn't update any metric,
+// notify any listener or run any goroutine, so it can be used to check the runtime config
+// files, e.g. in CI.
+func LoadAndValidate(ctx context.Context, cfg Config, factory BucketClientFactory) (any, error) {
+	if err := cfg.validate(); err != nil {
+		return nil, err
 	}
 
-	buf, err := io.ReadAll(readCloser)
-	if err != nil {
-		return nil, errors.Wrap(err, "read entire file")
+	var bucketClient objstore.Bucket
+	if cfg.HTTPURL == "" {
+		var err error
+		if bucketClient, err = factory(ctx); err != nil {
+			return nil, err
+		}
+		defer bucketClient.Close() //nolint:errcheck
 	}
 
-	err = readCloser.Close()
-	return buf, err
+	// The loader timeout is disabled, so that the loader is called synchronously.
+	cfg.LoaderTimeout = 0
+	om := &Manager{
+		cfg:          cfg,
+		logger:       log.NewNopLogger(),
+		bucketClient: bucketClient,
+		httpClient:   newHTTPClient(cfg),
+		// Not registered, so that no metric is exposed.
+		loadFailures: newLoadFailuresMetric(nil),
+	}
+	loaded, _, _, err := om.readConfigFromSource(ctx, 0, om.primarySource())
+	return loaded, err
 }
 
-func (om *Manager) setConfig(config any) {
-	om.configMtx.Lock()
-	defer om.configMtx.Unlock()
-	om.config = config
+// newFallbackBucketClients returns the bucket clients of the fallback sources, in order.
+func newFallbackBucketClients(ctx context.Context, cfg Config) ([]objstore.Bucket, error) {
+	clients := make([]objstore.Bucket, 0, len(cfg.Fallbacks))
+	for i, fallback := range cfg.Fallbacks {
+		c, err := cfg.FallbackBucketClientFactory(ctx, i, fallback)
+		if err != nil {
+			for _, prev := range clients {
+				_ = prev.Close()
+			}
+			return nil, errors.Wrapf(err, "create bucket client of fallback %d", i)
+		}
+		clients = append(clients, c)
+	}
+	return clients, nil
 }
 
-func (om *Manager) callListeners(newValue any) {
+// RegisterCallback registers a function called with the new config value on each successful
+// load, in registration order. Unlike the listener channels, no update is dropped: the
+// callbacks are called synchronously, before the load returns and the listener channels are
+// notified. Since they block the reloads, they must be fast, and must not call back into the
+// Manager other than to get the config.
+func (om *Manager) RegisterCallback(callback func(cfg any)) {
 	om.listenersMtx.Lock()
 	defer om.listenersMtx.Unlock()
 
-	for _, ch := range om.listeners {
-		select {
-		case ch <- newValue:
-			// ok
-		default:
-			// nobody is listening or buffer full.
-		}
-	}
+	om.callbacks = append(om.callbacks, callback)
 }
 
-// Stop stops the Manager
-func (om *Manager) stopping(_ error) error {
+// CreateListenerChannel creates new channel that can be used to receive new config values.
+// If there is no receiver waiting for value when config manager tries to send the update,
+// or channel buffer is full, update is discarded.
+//
+// When config manager is stopped, it closes all channels to notify receivers that they will
+// not receive any more updates.
+func (om *Manager) CreateListenerChannel(buffer int) <-chan any {
+	ch := make(chan any, buffer)
+
 	om.listenersMtx.Lock()
 	defer om.listenersMtx.Unlock()
 
-	for _, ch := range om.listeners {
-		close(ch)
-	}
-	om.listeners = nil
-	return nil
+	om.listeners = append(om.listeners, ch)
+	return ch
 }
 
-// GetConfig returns last loaded config value, possibly nil.
-func (om *Manager) GetConfig() any {
+// CreateNamedListenerChannel is like CreateListenerChannel, but the updates dropped because
+// the channel buffer is full are tracked by the name of the listener.
+func (om *Manager) CreateNamedListenerChannel(name string, buffer int) <-chan any {
+	ch := make(chan any, buffer)
+
+	om.listenersMtx.Lock()
+	defer om.listenersMtx.Unlock()
+
+	om.listeners = append(om.listeners, ch)
+	om.listenerNames[ch] = name
+	return ch
+}
+
+// SubscribeWithCurrent is like CreateListenerChannel, but also returns the current config
+// value, possibly nil. The current value and the channel registration are atomic with
+// respect to reloads, so the caller sees the current value followed by all the subsequent
+// updates, without missing or receiving twice any of them.
+func (om *Manager) SubscribeWithCurrent(buffer int) (any, <-chan any) {
+	ch := make(chan any, buffer)
+
+	// Lock ordering: listenersMtx is always acquired before configMtx, as done while
+	// setting the config and calling the listeners on reload, to avoid deadlocks.
+	om.listenersMtx.Lock()
+	defer om.listenersMtx.Unlock()
 	om.configMtx.RLock()
 	defer om.configMtx.RUnlock()
 
-	return om.config
+	om.listeners = append(om.listeners, ch)
+	return om.config, ch
+}
+
+// CreateListenerChannelWithPrevious is like CreateListenerChannel, but the channel receives
+// both the previous and the new config values on each update.
+func (om *Manager) CreateListenerChannelWithPrevious(buffer int) <-chan ConfigUpdate {
+	ch := make(chan ConfigUpdate, buffer)
+
+	om.listenersMtx.Lock()
+	defer om.listenersMtx.Unlock()
+
+	om.updateListeners = append(om.updateListeners, ch)
+	return ch
+}
+
+// CloseListenerChannelWithPrevious removes given channel, created with CreateListenerChannelWithPrevious,
+// from list of channels to send notifications to and closes channel.
+func (om *Manager) CloseListenerChannelWithPrevious(listener <-chan ConfigUpdate) {
+	om.listenersMtx.Lock()
+	defer om.listenersMtx.Unlock()
+
+	for ix, ch := range om.updateListeners {
+		if ch == listener {
+			om.updateListeners = append(om.updateListeners[:ix], om.updateListeners[ix+1:]...)
+			delete(om.listenerDrops, ch)
+			close(ch)
+			break
+		}
+	}
+}
+
+// CreateListenerChannelV2 is like CreateListenerChannel, but the channel receives the new config
+// value along with the hash, time and source of its load on each update.
+func (om *Manager) CreateListenerChannelV2(buffer int) <-chan ConfigEvent {
+	ch := make(chan ConfigEvent, buffer)
+
+	om.listenersMtx.Lock()
+	defer om.listenersMtx.Unlock()
+
+	om.eventListeners = append(om.eventListeners, ch)
+	return ch
+}
+
+// CloseListenerChannelV2 removes given channel, created with CreateListenerChannelV2, from list
+// of channels to send notifications to and closes channel.
+func (om *Manager) CloseListenerChannelV2(listener <-chan ConfigEvent) {
+	om.listenersMtx.Lock()
+	defer om.listenersMtx.Unlock()
+
+	for ix, ch := range om.eventListeners {
+		if ch == listener {
+			om.eventListeners = append(om.eventListeners[:ix], om.eventListeners[ix+1:]...)
+			delete(om.listenerDrops, ch)
+			close(ch)
+			break
+		}
+	}
+}
+
+// CreateListenerChannelWithChangedKeys is like CreateListenerChannel, but the channel receives
+// the top-level keys changed since the previous config along with the new config value on each
+// update, so that the consumers can only rebuild the state of the changed keys. The changed keys
+// are only computed for the configs implementing KeyedConfig or of type map[string]any.
+func (om *Manager) CreateListenerChannelWithChangedKeys(buffer int) <-chan ChangedKeysUpdate {
+	ch := make(chan ChangedKeysUpdate, buffer)
+
+	om.listenersMtx.Lock()
+	defer om.listenersMtx.Unlock()
+
+	om.keysListeners = append(om.keysListeners, ch)
+	return ch
+}
+
+// CloseListenerChannelWithChangedKeys removes given channel, created with CreateListenerChannelWithChangedKeys,
+// from list of channels to send notifications to and closes channel.
+func (om *Manager) CloseListenerChannelWithChangedKeys(listener <-chan ChangedKeysUpdate) {
+	om.listenersMtx.Lock()
+	defer om.listenersMtx.Unlock()
+
+	for ix, ch := range om.keysListeners {
+		if ch == listener {
+			om.keysListeners = append(om.keysListeners[:ix], om.keysListeners[ix+1:]...)
+			delete(om.listenerDrops, ch)
+			close(ch)
+			break
+		}
+	}
+}
+
+// CloseListenerChannel removes given channel from list of channels to send notifications to and closes channel.
+func (om *Manager) CloseListenerChannel(listener <-chan any) {
+	om.listenersMtx.Lock()
+	defer om.listenersMtx.Unlock()
+
+	for ix, ch := range om.listeners {
+		if ch == listener {
+			om.listeners = append(om.listeners[:ix], om.listeners[ix+1:]...)
+			delete(om.listenerNames, ch)
+			delete(om.listenerDrops, ch)
+			close(ch)
+			break
+		}
+	}
+}
+
+// DrainAndCloseListenerChannel is like CloseListenerChannel, but returns the values buffered in
+// the channel, in the order they were sent, instead of leaving them to the receiver. Since the
+// channel is removed from the list of channels to send notifications to before being drained,
+// no value is sent to it afterwards, so the caller can process any pending update on shutdown.
+// It returns nil if the channel isn't a listener of the Manager.
+func (om *Manager) DrainAndCloseListenerChannel(listener <-chan any) []any {
+	om.listenersMtx.Lock()
+	defer om.listenersMtx.Unlock()
+
+	for ix, ch := range om.listeners {
+		if ch != listener {
+			continue
+		}
+
+		om.listeners = append(om.listeners[:ix], om.listeners[ix+1:]...)
+		delete(om.listenerNames, ch)
+		delete(om.listenerDrops, ch)
+
+		// The receiver may concurrently consume the buffered values, so they're read
+		// without blocking.
+		var pending []any
+		for {
+			select {
+			case value := <-ch:
+				pending = append(pending, value)
+				continue
+			default:
+			}
+			break
+		}
+		close(ch)
+		return pending
+	}
+	return nil
+}
+
+func (om *Manager) loop(ctx context.Context) error {
+	if om.cfg.LoadPath == "" {
+		if om.cfg.Preload != nil {
+			level.Info(om.logger).Log("msg", "runtime config reload disabled: serving the preloaded config")
+		} else {
+			level.Info(om.logger).Log("msg", "runtime config disabled: file not specified")
+		}
+		<-ctx.Done()
+		return nil
+	}
+
+	next := om.nextReloadTime(time.Now())
+	timer := time.NewTimer(time.Until(next))
+	defer timer.Stop()
+	om.nextReload.Store(next)
+
+	// The changes of the watched files trigger a reload, once they stop changing.
+	var (
+		watchedFiles = om.watchedFiles()
+		watchEvents  <-chan fsnotify.Event
+		watchErrors  <-chan error
+		debounce     <-chan time.Time
+	)
+	if len(watchedFiles) > 0 {
+		watcher, err := newFilesWatcher(watchedFiles)
+		if err != nil {
+			level.Warn(om.logger).Log("msg", "failed to watch runtime config files, falling back to the periodic reload only", "err", err)
+		} else {
+			defer watcher.Close() //nolint:errcheck
+			watchEvents, watchErrors = watcher.Events, watcher.Errors
+		}
+	}
+
+	for {
+		select {
+		case t := <-timer.C:
+			next = om.nextReloadTime(t)
+			timer.Reset(time.Until(next))
+			om.nextReload.Store(next)
+		case <-debounce:
+			debounce = nil
+		case event := <-watchEvents:
+			if isWatchedFileChange(event, watchedFiles) {
+				debounce = time.After(watchDebounce)
+			}
+			continue
+		case err := <-watchErrors:
+			level.Warn(om.logger).Log("msg", "error watching runtime config files", "err", err)
+			continue
+		case <-ctx.Done():
+			return nil
+		}
+
+		err := om.Reload(ctx)
+		if err != nil {
+			// Log but don't stop on error - we don't want to halt all ingesters because of a typo
+			level.Error(om.logger).Log("msg", "failed to load config", "err", err)
+		}
+
+		// Unless the config has been stale for too long.
+		if staleness := time.Since(om.lastLoadSuccess.Load()); om.cfg.MaxStaleness > 0 && staleness > om.cfg.MaxStaleness {
+			return fmt.Errorf("runtime config has not been successfully loaded for %s, exceeding the max staleness of %s", staleness.Truncate(time.Millisecond), om.cfg.MaxStaleness)
+		}
+	}
+}
+
+// nextReloadTime returns the time of the periodic reload following the given time, according to
+// the reload schedule, if set, otherwise to the reload period.
+func (om *Manager) nextReloadTime(now time.Time) time.Time {
+	if om.schedule != nil {
+		return om.schedule.next(now)
+	}
+	return now.Add(om.cfg.ReloadPeriod)
+}
+
+// Reload immediately reloads the runtime config. Overlapping reloads, including the
+// periodic one, share a single in-flight load and all get its result. The shared load
+// isn't tied to the context of any caller: canceling ctx only stops waiting for it.
+func (om *Manager) Reload(ctx context.Context) error {
+	if om.cfg.LoadPath == "" {
+		return errReloadDisabled
+	}
+
+	result := om.reloadGroup.DoChan("reload", func() (any, error) {
+		return nil, om.loadConfig(om.reloadCtx)
+	})
+
+	select {
+	case res := <-result:
+		return res.Err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// loadConfig loads configuration using the loader function, and if successful,
+// stores it as current configuration and notifies listeners.
+func (om *Manager) loadConfig(ctx context.Context) error {
+	defer om.updateStaleness()
+
+	om.lastLoadAttempt.Store(time.Now())
+	cfg, hash, files, source, err := om.readConfig(ctx)
+	if err != nil {
+		om.configLoadSuccess.Set(0)
+		om.lastLoadError.Store(err.Error())
+		return err
+	}
+	loadedAt := time.Now()
+	om.configLoadSuccess.Set(1)
+	om.lastLoadError.Store("")
+	om.lastLoadSuccess.Store(loadedAt)
+	om.setLoadedFiles(source, files)
+
+	// The reverted config is kept until the files change.
+	if om.revertedHash.Load() != hash {
+		om.revertedHash.Store("")
+		om.applyConfig(ConfigEvent{Config: cfg, Hash: hash, LoadedAt: loadedAt, Source: source})
+		om.recordHistory(ConfigSnapshot{Config: cfg, Hash: hash, LoadedAt: loadedAt})
+	}
+
+	for path, file := range files {
+		om.configFileSize.WithLabelValues(path).Set(float64(len(file.content)))
+	}
+
+	for i := range om.configSources() {
+		value := 0.0
+		if i == source {
+			value = 1
+		}
+		om.configSource.WithLabelValues(strconv.Itoa(i)).Set(value)
+	}
+	return nil
+}
+
+// applyConfig stores the config of the given event as current configuration, notifies the
+// listeners and exposes its hash.
+func (om *Manager) applyConfig(event ConfigEvent) {
+	prevHash := om.setConfigAndCallListeners(event)
+
+	if om.cfg.LogFullOnChange && event.Hash != prevHash {
+		om.logAppliedConfig(event.Config, event.Hash)
+	}
+
+	// expose hash of runtime config
+	om.configHash.Reset()
+	om.configHash.WithLabelValues(event.Hash).Set(1)
+}
+
+// configSources returns the sources the runtime config is read from, in order: the primary
+// source followed by the fallbacks.
+func (om *Manager) configSources() []configSource {
+	sources := []configSource{om.primarySource()}
+	for i, fallback := range om.cfg.Fallbacks {
+		sources = append(sources, configSource{paths: fallback.loadPaths(), prefix: fallback.Prefix, bucketClient: om.fallbackBucketClients[i]})
+	}
+	return sources
+}
+
+// primarySource returns the source the runtime config is read from when it's available.
+func (om *Manager) primarySource() configSource {
+	return configSource{paths: om.cfg.loadPaths(), prefix: om.cfg.Prefix, bucketClient: om.bucketClient, httpURL: om.cfg.HTTPURL}
+}
+
+// readConfig reads the runtime config from the first source it can be successfully read
+// from and loads it using the loader function. It returns the loaded and validated config,
+// along with the hash and the content of the files, and the index of the source.
+func (om *Manager) readConfig(ctx context.Context) (any, string, map[string]loadedFile, int, error) {
+	sources := om.configSources()
+	if len(sources) == 1 {
+		cfg, hash, files, err := om.readConfigFromSource(ctx, 0, sources[0])
+		return cfg, hash, files, 0, err
+	}
+
+	errs := multierror.New()
+	for i, source := range sources {
+		cfg, hash, files, err := om.readConfigFromSource(ctx, i, source)
+		if err == nil {
+			return cfg, hash, files, i, nil
+		}
+
+		level.Warn(om.logger).Log("msg", "failed to read runtime config from source", "index", i, "err", err)
+		errs.Add(errors.Wrapf(err, "source %d", i))
+	}
+	return nil, "", nil, 0, errs.Err()
+}
+
+// readConfigFromSource reads the runtime config files from the source at the given index and
+// loads them using the loader function. It returns the loaded and validated config, along
+// with the hash and the content of the files.
+func (om *Manager) readConfigFromSource(ctx context.Context, index int, source configSource) (any, string, map[string]loadedFile, error) {
+	loaded, err := om.readFiles(ctx, index, source)
+	if err != nil {
+		var decompressionErr *decompressionError
+		if errors.As(err, &decompressionErr) {
+			om.loadFailures.WithLabelValues(loadFailureDecompress).Inc()
+		} else {
+			om.loadFailures.WithLabelValues(loadFailureRead).Inc()
+		}
+		return nil, "", nil, err
+	}
+
+	// The files are merged and hashed in the order they're listed, regardless of the
+	// order they've been read in.
+	parts := make([][]byte, 0, len(loaded))
+	files := make(map[string]loadedFile, len(loaded))
+	hasher := sha256.New()
+	size := 0
+	for i, path := range source.paths {
+		files[path] = loaded[i]
+		parts = append(parts, loaded[i].content)
+		hasher.Write(loaded[i].content)
+		size += len(loaded[i].content)
+	}
+	hash := fmt.Sprintf("%x", hasher.Sum(nil))
+
+	if size == 0 && !om.cfg.AllowEmpty {
+		om.loadFailures.WithLabelValues(loadFailureValidate).Inc()
+		return nil, "", nil, ErrEmptyConfig
+	}
+
+	buf, err := om.mergeParts(parts)
+	if err != nil {
+		om.loadFailures.WithLabelValues(loadFailureParse).Inc()
+		return nil, "", nil, errors.Wrap(err, "merge files")
+	}
+
+	cfg, err := om.load(source.paths, files, buf)
+	if err != nil {
+		om.loadFailures.WithLabelValues(loadFailureParse).Inc()
+		return nil, "", nil, err
+	}
+
+	if err := om.validateTenantSizes(cfg); err != nil {
+		om.loadFailures.WithLabelValues(loadFailureValidate).Inc()
+		return nil, "", nil, err
+	}
+
+	if err := om.validateClusterState(ctx, cfg); err != nil {
+		om.loadFailures.WithLabelValues(loadFailureValidate).Inc()
+		return nil, "", nil, err
+	}
+	return cfg, hash, files, nil
+}
+
+// readFiles reads the runtime config files of the source at the given index, up to the
+// configured LoadConcurrency at a time. The returned files are in the same order as the
+// source paths. Failing to read any file fails the whole read.
+func (om *Manager) readFiles(ctx context.Context, index int, source configSource) ([]loadedFile, error) {
+	files := make([]loadedFile, len(source.paths))
+	jobs := make([]any, len(source.paths))
+	for i := range source.paths {
+		jobs[i] = i
+	}
+
+	err := concurrency.ForEach(ctx, jobs, max(om.cfg.LoadConcurrency, 1), func(ctx context.Context, job any) error {
+		i := job.(int)
+		path := source.paths[i]
+
+		var file loadedFile
+		var err error
+		if source.httpURL != "" {
+			file, err = om.loadConfigFromHTTP(ctx, index, source.httpURL, path)
+		} else {
+			file, err = om.loadConfigFromBucket(ctx, index, source.bucketClient, source.prefix, path)
+		}
+		if err != nil {
+			return errors.Wrapf(err, "read file %s", path)
+		}
+
+		// Each worker writes a distinct index, so no locking is required.
+		files[i] = file
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return files, nil
+}
+
+// load loads the merged content of the given runtime config files, as runLoader does, failing
+// with ErrLoaderTimeout if it takes longer than the configured LoaderTimeout.
+func (om *Manager) load(paths []string, files map[string]loadedFile, buf []byte) (any, error) {
+	if om.cfg.LoaderTimeout <= 0 {
+		return om.runLoader(paths, files, buf)
+	}
+
+	type result struct {
+		cfg any
+		err error
+	}
+
+	// The channel is buffered, so that the goroutine returns even once abandoned.
+	done := make(chan result, 1)
+	go func() {
+		cfg, err := om.runLoader(paths, files, buf)
+		done <- result{cfg: cfg, err: err}
+	}()
+
+	timer := time.NewTimer(om.cfg.LoaderTimeout)
+	defer timer.Stop()
+
+	select {
+	case res := <-done:
+		return res.cfg, res.err
+	case <-timer.C:
+		om.loaderTimeouts.Inc()
+		return nil, fmt.Errorf("%w after %s", ErrLoaderTimeout, om.cfg.LoaderTimeout)
+	}
+}
+
+// runLoader loads the merged content of the given runtime config files with the configured
+// LoaderWithMeta, if any, otherwise with the Loader of the files.
+func (om *Manager) runLoader(paths []string, files map[string]loadedFile, buf []byte) (any, error) {
+	if om.cfg.LoaderWithMeta != nil {
+		var key string
+		if len(paths) > 0 {
+			key = paths[0]
+		}
+
+		cfg, err := om.cfg.LoaderWithMeta(key, files[key].attrs, bytes.NewReader(buf))
+		return cfg, errors.Wrap(err, "load file")
+	}
+
+	loader, err := om.cfg.loader(paths)
+	if err != nil {
+		return nil, err
+	}
+
+	cfg, err := loader(bytes.NewReader(buf))
+	return cfg, errors.Wrap(err, "load file")
+}
+
+// updateStaleness updates the metric tracking the time since the last successful load.
+func (om *Manager) updateStaleness() {
+	if last := om.lastLoadSuccess.Load(); !last.IsZero() {
+		om.configStaleness.Set(time.Since(last).Seconds())
+	}
+}
+
+// validateTenantSizes returns an error if any tenant's section of the loaded config
+// exceeds the configured max size.
+func (om *Manager) validateTenantSizes(cfg any) error {
+	if om.cfg.MaxTenantConfigSize <= 0 {
+		return nil
+	}
+
+	sizer, ok := cfg.(TenantSizer)
+	if !ok {
+		return nil
+	}
+
+	for tenant, size := range sizer.TenantSizes() {
+		if size > om.cfg.MaxTenantConfigSize {
+			level.Warn(om.logger).Log("msg", "runtime config rejected because tenant section exceeds max size", "tenant", tenant, "size", size, "limit", om.cfg.MaxTenantConfigSize)
+			return fmt.Errorf("runtime config for tenant %s is %d bytes, exceeding the max allowed size of %d bytes", tenant, size, om.cfg.MaxTenantConfigSize)
+		}
+	}
+	return nil
+}
+
+// validateClusterState returns an error if the loaded config is rejected by the configured
+// ClusterStateValidator.
+func (om *Manager) validateClusterState(ctx context.Context, cfg any) error {
+	if om.cfg.ClusterStateValidator == nil {
+		return nil
+	}
+
+	var state any
+	if om.cfg.ClusterStateProvider != nil {
+		var err error
+		if state, err = om.cfg.ClusterStateProvider(ctx); err != nil {
+			return errors.Wrap(err, "get cluster state")
+		}
+	}
+
+	if err := om.cfg.ClusterStateValidator(cfg, state); err != nil {
+		level.Warn(om.logger).Log("msg", "runtime config rejected because inconsistent with the cluster state", "err", err)
+		return errors.Wrap(err, "validate against cluster state")
+	}
+	return nil
+}
+
+// mergeParts merges the content of the runtime config files using the configured
+// Merger, defaulting to concatenation.
+func (om *Manager) mergeParts(parts [][]byte) ([]byte, error) {
+	if om.cfg.Merger != nil {
+		return om.cfg.Merger(parts)
+	}
+	return bytes.Join(parts, nil), nil
+}
+
+// loadConfigFromBucket reads the given file, under the given prefix, from the bucket of the
+// source at the given index. The file isn't downloaded if the attributes of the object are unchanged since the last
+// successful load from the same source, in which case the previously loaded content is
+// returned. If the backend doesn't provide the attributes, the file is always downloaded.
+func (om *Manager) loadConfigFromBucket(ctx context.Context, source int, bucketClient objstore.Bucket, prefix, path string) (loadedFile, error) {
+	if om.cfg.ReadTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, om.cfg.ReadTimeout)
+		defer cancel()
+	}
+
+	key := objectKey(prefix, path)
+	attrs, err := bucketClient.Attributes(ctx, key)
+	if err != nil || attrs.LastModified.IsZero() {
+		attrs = objstore.ObjectAttributes{}
+	} else if prev, ok := om.getLoadedFile(source, path); ok && prev.unchanged(attrs) {
+		return prev, nil
+	}
+
+	downloadedAt := time.Now()
+	readCloser, err := bucketClient.Get(ctx, key)
+	if err != nil {
+		return loadedFile{}, errors.Wrap(err, "open file")
+	}
+
+	buf, err := readAllWithLimit(readCloser, om.cfg.MaxFileSize)
+	if err != nil {
+		_ = readCloser.Close()
+		return loadedFile{}, errors.Wrap(err, "read entire file")
+	}
+
+	if err = readCloser.Close(); err != nil {
+		return loadedFile{}, err
+	}
+
+	if om.cfg.Compression == CompressionGzip || strings.HasSuffix(path, ".gz") {
+		if buf, err = gunzip(buf, om.cfg.MaxFileSize); err != nil {
+			return loadedFile{}, errors.Wrap(err, "decompress gzip file")
+		}
+	}
+	return loadedFile{attrs: attrs, content: buf, downloadedAt: downloadedAt}, nil
+}
+
+// objectKey returns the key of the object the given runtime config file is stored at, under
+// the given bucket prefix. The leading and trailing slashes of the prefix are trimmed.
+func objectKey(prefix, path string) string {
+	if prefix = strings.Trim(prefix, "/"); prefix == "" {
+		return path
+	}
+	return prefix + "/" + strings.TrimLeft(path, "/")
+}
+
+// getLoadedFile returns the given file of the last successful load, if it was read from the
+// source at the given index.
+func (om *Manager) getLoadedFile(source int, path string) (loadedFile, bool) {
+	om.loadedFilesMtx.Lock()
+	defer om.loadedFilesMtx.Unlock()
+
+	if source != om.loadedSource {
+		return loadedFile{}, false
+	}
+
+	file, ok := om.loadedFiles[path]
+	return file, ok
+}
+
+func (om *Manager) setLoadedFiles(source int, files map[string]loadedFile) {
+	om.loadedFilesMtx.Lock()
+	defer om.loadedFilesMtx.Unlock()
+
+	om.loadedFiles, om.loadedSource = files, source
+}
+
+// decompressionError is the error failing to decompress a runtime config file, told apart
+// from the other read errors in the load failures metric.
+type decompressionError struct {
+	err error
+}
+
+func (e *decompressionError) Error() string { return e.err.Error() }
+func (e *decompressionError) Unwrap() error { return e.err }
+
+func gunzip(buf []byte, maxSize int) ([]byte, error) {
+	r, err := gzip.NewReader(bytes.NewReader(buf))
+	if err != nil {
+		return nil, &decompressionError{err: err}
+	}
+	defer r.Close()
+
+	buf, err = readAllWithLimit(r, maxSize)
+	if err != nil {
+		return nil, &decompressionError{err: err}
+	}
+	return buf, nil
+}
+
+// readAllWithLimit reads r until EOF, failing if more than maxSize bytes are read. A
+// maxSize of 0 means unlimited.
+func readAllWithLimit(r io.Reader, maxSize int) ([]byte, error) {
+	if maxSize <= 0 {
+		return io.ReadAll(r)
+	}
+
+	buf, err := io.ReadAll(io.LimitReader(r, int64(maxSize)+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(buf) > maxSize {
+		return nil, fmt.Errorf("the file exceeds the max size of %d bytes", maxSize)
+	}
+	return buf, nil
+}
+
+// SetConfig stores the given config as current configuration and notifies the listeners, as
+// done on each successful reload. It's meant to be used with the Managers created with
+// NewStatic, since the config of the other ones is replaced on the next reload.
+func (om *Manager) SetConfig(config any) {
+	loadedAt := time.Now()
+	om.configLoadSuccess.Set(1)
+	om.lastLoadSuccess.Store(loadedAt)
+	om.setConfigAndCallListeners(ConfigEvent{Config: config, LoadedAt: loadedAt, Source: noConfigSource})
+}
+
+// setConfigAndCallListeners stores the config of the given event as current configuration and
+// notifies the listeners, atomically with respect to SubscribeWithCurrent. It returns the hash
+// of the previous config.
+func (om *Manager) setConfigAndCallListeners(event ConfigEvent) string {
+	// Lock ordering: listenersMtx is always acquired before configMtx.
+	om.listenersMtx.Lock()
+	defer om.listenersMtx.Unlock()
+
+	prev, prevHash, generation := om.setConfig(event.Config, event.Hash)
+	event.Generation = generation
+	om.loadedOnce.Do(func() { close(om.loaded) })
+	for _, callback := range om.callbacks {
+		callback(event.Config)
+	}
+	om.callListeners(prev, event)
+	return prevHash
+}
+
+// setConfig stores the given config as current configuration and returns the previous one,
+// along with its hash, and the generation of the new one.
+func (om *Manager) setConfig(config any, hash string) (any, string, uint64) {
+	om.configMtx.Lock()
+	defer om.configMtx.Unlock()
+	prev, prevHash := om.config, om.hash
+	om.config, om.hash = config, hash
+
+	// The configs set with SetConfig have no hash, so they're always considered changed.
+	if hash == "" || hash != prevHash {
+		om.generation++
+	}
+	return prev, prevHash, om.generation
+}
+
+// Generation returns the generation of the current config, 0 if none has been loaded. It's
+// incremented each time the config changes, but not on the reloads of an unchanged config,
+// so that the consumers can cheaply tell whether they've processed the current config.
+func (om *Manager) Generation() uint64 {
+	om.configMtx.RLock()
+	defer om.configMtx.RUnlock()
+
+	return om.generation
+}
+
+// logAppliedConfig logs the serialized config at debug level, after redacting it.
+func (om *Manager) logAppliedConfig(cfg any, hash string) {
+	if om.cfg.Redact != nil {
+		cfg = om.cfg.Redact(cfg)
+	}
+
+	out, err := yaml.Marshal(cfg)
+	if err != nil {
+		level.Warn(om.logger).Log("msg", "failed to serialize applied runtime config", "err", err)
+		return
+	}
+	level.Debug(om.logger).Log("msg", "applied runtime config", "sha256", hash, "config", string(out))
+}
+
+// callListeners sends the config update to the listeners. It must be called with
+// listenersMtx held.
+func (om *Manager) callListeners(oldValue any, event ConfigEvent) {
+	newValue := event.Config
+	for _, ch := range om.listeners {
+		select {
+		case ch <- newValue:
+			// ok
+		default:
+			// nobody is listening or buffer full.
+			om.recordDroppedUpdate(ch, om.listenerName(ch))
+		}
+	}
+
+	for _, ch := range om.updateListeners {
+		select {
+		case ch <- ConfigUpdate{Old: oldValue, New: newValue}:
+			// ok
+		default:
+			// nobody is listening or buffer full.
+			om.recordDroppedUpdate(ch, unnamedListener)
+		}
+	}
+
+	for _, ch := range om.eventListeners {
+		select {
+		case ch <- event:
+			// ok
+		default:
+			// nobody is listening or buffer full.
+			om.recordDroppedUpdate(ch, unnamedListener)
+		}
+	}
+
+	// The changed keys are only computed if anyone is interested in them.
+	if len(om.keysListeners) == 0 {
+		return
+	}
+
+	update := newChangedKeysUpdate(oldValue, newValue)
+	for _, ch := range om.keysListeners {
+		select {
+		case ch <- update:
+			// ok
+		default:
+			// nobody is listening or buffer full.
+			om.recordDroppedUpdate(ch, unnamedListener)
+		}
+	}
+}
+
+// listenerName returns the name the listener was created with, if any. It must be called
+// with listenersMtx held.
+func (om *Manager) listenerName(ch chan any) string {
+	if name, ok := om.listenerNames[ch]; ok {
+		return name
+	}
+	return unnamedListener
+}
+
+// Stop stops the Manager
+func (om *Manager) stopping(_ error) error {
+	// Abort the in-flight reload, if any.
+	om.reloadCancel()
+
+	om.listenersMtx.Lock()
+	defer om.listenersMtx.Unlock()
+
+	if om.cfg.StopFlushTimeout > 0 {
+		om.flushListeners()
+	}
+
+	for _, ch := range om.listeners {
+		close(ch)
+	}
+	om.listeners = nil
+	om.listenerNames = map[chan any]string{}
+	om.listenerDrops = map[any]int64{}
+
+	for _, ch := range om.updateListeners {
+		close(ch)
+	}
+	om.updateListeners = nil
+
+	for _, ch := range om.keysListeners {
+		close(ch)
+	}
+	om.keysListeners = nil
+
+	for _, ch := range om.eventListeners {
+		close(ch)
+	}
+	om.eventListeners = nil
+
+	om.stopped.Store(true)
+	return nil
+}
+
+// flushListeners sends the current config, if any, to the listeners created with
+// CreateListenerChannel, waiting up to StopFlushTimeout overall for them to receive it, so
+// that they get the last-known config before being closed. It must be called with
+// listenersMtx held.
+func (om *Manager) flushListeners() {
+	cfg := om.GetConfig()
+	if cfg == nil {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), om.cfg.StopFlushTimeout)
+	defer cancel()
+
+	for _, ch := range om.listeners {
+		// Once the timeout has expired, the config is still sent to the listeners with room
+		// in their buffer.
+		select {
+		case ch <- cfg:
+			continue
+		default:
+		}
+
+		select {
+		case ch <- cfg:
+		case <-ctx.Done():
+			om.recordDroppedUpdate(ch, om.listenerName(ch))
+		}
+	}
+}
+
+// Stopped returns whether the Manager has stopped. Once stopped, the config returned by
+// GetConfig is no longer updated, so long-lived consumers can detect it's frozen.
+func (om *Manager) Stopped() bool {
+	return om.stopped.Load()
+}
+
+// GetConfig returns last loaded config value, possibly nil. It keeps returning the last
+// loaded config after the Manager has stopped, see Stopped.
+func (om *Manager) GetConfig() any {
+	om.configMtx.RLock()
+	defer om.configMtx.RUnlock()
+
+	return om.config
+}
+
+// GetConfigFor returns the section of the last loaded config value scoped to the tenant,
+// extracted with the configured TenantExtractor. If no TenantExtractor is configured, the
+// whole config is returned. Returns nil if no config has been loaded.
+func (om *Manager) GetConfigFor(tenantID string) any {
+	cfg := om.GetConfig()
+	if cfg == nil || om.cfg.TenantExtractor == nil {
+		return cfg
+	}
+
+	return om.cfg.TenantExtractor(cfg, tenantID)
+}
+
+// ReloadStatus is the status of the runtime config reloads.
+type ReloadStatus struct {
+	// LastReloadAttempt is the time of the last load attempt, nil if none.
+	LastReloadAttempt *time.Time `json:"last_reload_attempt,omitempty"`
+	// LastReloadSuccess is the time of the last successful load, nil if none.
+	LastReloadSuccess *time.Time `json:"last_reload_success,omitempty"`
+	// LastError is the error the last load attempt failed with, empty if it succeeded.
+	LastError string `json:"last_error,omitempty"`
+	// NextReload is the time of the next periodic reload, nil if the Manager is not running.
+	NextReload *time.Time `json:"next_reload,omitempty"`
+}
+
+// WaitForConfig blocks until the first config is loaded, returning it, or until the context
+// is done, returning the context error.
+func (om *Manager) WaitForConfig(ctx context.Context) (any, error) {
+	select {
+	case <-om.loaded:
+		return om.GetConfig(), nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// Status returns the status of the runtime config reloads.
+func (om *Manager) Status() ReloadStatus {
+	status := ReloadStatus{
+		LastReloadAttempt: timeOrNil(om.lastLoadAttempt.Load()),
+		LastReloadSuccess: timeOrNil(om.lastLoadSuccess.Load()),
+		LastError:         om.lastLoadError.Load(),
+	}
+	if om.State() == services.Running {
+		status.NextReload = timeOrNil(om.nextReload.Load())
+	}
+	return status
+}
+
+// StatusHandler renders, in JSON format, the status of the runtime config reloads.
+func (om *Manager) StatusHandler(w http.ResponseWriter, _ *http.Request) {
+	util.WriteJSONResponse(w, om.Status())
+}
+
+func timeOrNil(t time.Time) *time.Time {
+	if t.IsZero() {
+		return nil
+	}
+	return &t
+}
+
+// GetConfigWithHash returns last loaded config value, possibly nil, along with the
+// hex-encoded sha256 hash of the runtime config files it was loaded from.
+func (om *Manager) GetConfigWithHash() (any, string) {
+	om.configMtx.RLock()
+	defer om.configMtx.RUnlock()
+
+	return om.config, om.hash
 }
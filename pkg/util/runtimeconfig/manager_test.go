@@ -2,16 +2,23 @@ package runtimeconfig
 
 import (
 	"bytes"
+	"compress/gzip"
 	"context"
 	"crypto/sha256"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"net/http"
+	"net/http/httptest"
 	"os"
 	"strings"
+	"sync"
 	"testing"
 	"time"
 
 	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promauto"
 	"github.com/prometheus/client_golang/prometheus/testutil"
@@ -23,7 +30,9 @@ import (
 	"gopkg.in/yaml.v2"
 
 	"github.com/cortexproject/cortex/pkg/storage/bucket"
+	"github.com/cortexproject/cortex/pkg/util/concurrency"
 	"github.com/cortexproject/cortex/pkg/util/services"
+	"github.com/cortexproject/cortex/pkg/util/test"
 )
 
 type TestLimits struct {
@@ -74,6 +83,8 @@ func newTestOverridesManagerConfig(t *testing.T, i int32) (*atomic.Int32, Config
 	return config, Config{
 		ReloadPeriod: 5 * time.Second,
 		LoadPath:     tempFile.Name(),
+		// The config is returned by the Loader, regardless of the empty file.
+		AllowEmpty: true,
 		Loader: func(_ io.Reader) (i any, err error) {
 			val := int(config.Load())
 			return val, nil
@@ -168,7 +179,10 @@ func TestManager_ListenerWithDefaultLimits(t *testing.T) {
 					# HELP runtime_config_last_reload_successful Whether the last runtime-config reload attempt was successful.
 					# TYPE runtime_config_last_reload_successful gauge
 					runtime_config_last_reload_successful 1
-				`, fmt.Sprintf("%x", sha256.Sum256(config1))))))
+					# HELP runtime_config_file_size_bytes Size, in bytes, of each runtime config file as of the last successful load, after decompression.
+					# TYPE runtime_config_file_size_bytes gauge
+					runtime_config_file_size_bytes{file="%s"} %d
+				`, fmt.Sprintf("%x", sha256.Sum256(config1)), tempFile.Name(), len(config1))), "runtime_config_hash", "runtime_config_last_reload_successful", "runtime_config_file_size_bytes"))
 
 	// need to use buffer, otherwise loadConfig will throw away update
 	ch := overridesManager.CreateListenerChannel(1)
@@ -193,6 +207,11 @@ func TestManager_ListenerWithDefaultLimits(t *testing.T) {
 	require.Equal(t, 200, to.Overrides["user2"].Limit2) // new overrides
 	require.Equal(t, 100, to.Overrides["user2"].Limit1) // from defaults
 
+	// the hash should match the one of the config currently advertised
+	actualCfg, actualHash := overridesManager.GetConfigWithHash()
+	require.Same(t, to, actualCfg)
+	require.Equal(t, fmt.Sprintf("%x", sha256.Sum256(config2)), actualHash)
+
 	// check if the metrics have been updated
 	assert.NoError(t, testutil.GatherAndCompare(reg, strings.NewReader(fmt.Sprintf(`
 					# HELP runtime_config_hash Hash of the currently active runtime config file.
@@ -201,7 +220,10 @@ func TestManager_ListenerWithDefaultLimits(t *testing.T) {
 					# HELP runtime_config_last_reload_successful Whether the last runtime-config reload attempt was successful.
 					# TYPE runtime_config_last_reload_successful gauge
 					runtime_config_last_reload_successful 1
-				`, fmt.Sprintf("%x", sha256.Sum256(config2))))))
+					# HELP runtime_config_file_size_bytes Size, in bytes, of each runtime config file as of the last successful load, after decompression.
+					# TYPE runtime_config_file_size_bytes gauge
+					runtime_config_file_size_bytes{file="%s"} %d
+				`, fmt.Sprintf("%x", sha256.Sum256(config2)), tempFile.Name(), len(config2))), "runtime_config_hash", "runtime_config_last_reload_successful", "runtime_config_file_size_bytes"))
 
 	// Cleaning up
 	require.NoError(t, services.StopAndAwaitTerminated(context.Background(), overridesManager))
@@ -250,114 +272,1911 @@ func TestManager_ListenerChannel(t *testing.T) {
 	}
 }
 
-func TestManager_StopClosesListenerChannels(t *testing.T) {
+func TestManager_ShouldFailWhenConfigIsStaleForTooLong(t *testing.T) {
 	_, overridesManagerConfig := newTestOverridesManagerConfig(t, 555)
+	overridesManagerConfig.ReloadPeriod = 10 * time.Millisecond
+	overridesManagerConfig.MaxStaleness = 200 * time.Millisecond
 
-	overridesManager, err := New(overridesManagerConfig, nil, log.NewNopLogger(), mockBucketClientFactory([]byte{}))
+	// Only the initial load succeeds.
+	bucketClient := createMockBucketClient([]byte{})
+	bucketClient.On("Get", mock.Anything, mock.Anything).Return(nil, errors.New("bucket unavailable"))
+
+	reg := prometheus.NewPedanticRegistry()
+	overridesManager, err := New(overridesManagerConfig, reg, log.NewNopLogger(), func(context.Context) (objstore.Bucket, error) {
+		return bucketClient, nil
+	})
 	require.NoError(t, err)
 	require.NoError(t, services.StartAndAwaitRunning(context.Background(), overridesManager))
 
-	// need to use buffer, otherwise loadConfig will throw away update
-	ch := overridesManager.CreateListenerChannel(0)
+	// The last good config keeps being served, while the staleness grows.
+	test.Poll(t, time.Second, true, func() any {
+		return testutil.ToFloat64(overridesManager.configStaleness) > 0
+	})
+	assert.Equal(t, services.Running, overridesManager.State())
+	assert.Equal(t, 555, overridesManager.GetConfig())
 
-	require.NoError(t, services.StopAndAwaitTerminated(context.Background(), overridesManager))
+	// The manager fails once the max staleness has been exceeded.
+	err = overridesManager.AwaitTerminated(context.Background())
+	require.ErrorContains(t, err, "runtime config has not been successfully loaded for")
+	assert.Equal(t, services.Failed, overridesManager.State())
+	assert.GreaterOrEqual(t, testutil.ToFloat64(overridesManager.configStaleness), overridesManagerConfig.MaxStaleness.Seconds())
+}
+
+func TestManager_ShouldFailoverToFallbackSources(t *testing.T) {
+	primaryConfig := []byte(`overrides:
+  user1:
+    limit1: 100`)
+	fallbackConfig := []byte(`overrides:
+  user1:
+    limit1: 200`)
+
+	// The primary source fails the second load only, the first fallback source always fails.
+	primary := &bucket.ClientMock{}
+	primary.On("Attributes", mock.Anything, "runtime-config").Return(objstore.ObjectAttributes{}, nil)
+	primary.On("Get", mock.Anything, "runtime-config").Return(io.NopCloser(bytes.NewReader(primaryConfig)), nil).Once()
+	primary.On("Get", mock.Anything, "runtime-config").Return(nil, errors.New("bucket unavailable")).Once()
+	primary.On("Get", mock.Anything, "runtime-config").Return(io.NopCloser(bytes.NewReader(primaryConfig)), nil).Once()
+
+	failing := &bucket.ClientMock{}
+	failing.On("Attributes", mock.Anything, "runtime-config-1").Return(objstore.ObjectAttributes{}, nil)
+	failing.On("Get", mock.Anything, "runtime-config-1").Return(nil, errors.New("bucket unavailable"))
+
+	fallback := &bucket.ClientMock{}
+	fallback.On("Attributes", mock.Anything, "runtime-config-2").Return(objstore.ObjectAttributes{}, nil)
+	fallback.On("Get", mock.Anything, "runtime-config-2").Return(io.NopCloser(bytes.NewReader(fallbackConfig)), nil).Once()
+
+	cfg := Config{
+		ReloadPeriod:  time.Hour,
+		LoadPath:      "runtime-config",
+		Loader:        testLoadOverrides,
+		StorageConfig: bucket.Config{Backend: bucket.Filesystem},
+		Fallbacks: []Source{
+			{LoadPath: "runtime-config-1", StorageConfig: bucket.Config{Backend: bucket.S3}},
+			{LoadPath: "runtime-config-2", StorageConfig: bucket.Config{Backend: bucket.Filesystem}},
+		},
+		FallbackBucketClientFactory: mockFallbackBucketClientFactory(failing, fallback),
+	}
+
+	reg := prometheus.NewPedanticRegistry()
+	manager, err := New(cfg, reg, log.NewNopLogger(), func(context.Context) (objstore.Bucket, error) {
+		return primary, nil
+	})
+	require.NoError(t, err)
+	require.NoError(t, services.StartAndAwaitRunning(context.Background(), manager))
+	t.Cleanup(func() {
+		require.NoError(t, services.StopAndAwaitTerminated(context.Background(), manager))
+	})
+
+	expectedSource := func(index int) string {
+		var out strings.Builder
+		out.WriteString(`
+			# HELP runtime_config_source Whether the runtime config has been read from the source at the given index as of the last successful load, where 0 is the primary source and the following are the fallbacks, in order.
+			# TYPE runtime_config_source gauge
+`)
+		for i := 0; i < 3; i++ {
+			value := 0
+			if i == index {
+				value = 1
+			}
+			fmt.Fprintf(&out, "\t\t\truntime_config_source{index=\"%d\"} %d\n", i, value)
+		}
+		return out.String()
+	}
+
+	// The primary source is used while available.
+	assert.Equal(t, 100, manager.GetConfig().(*testOverrides).Overrides["user1"].Limit1)
+	assert.NoError(t, testutil.GatherAndCompare(reg, strings.NewReader(expectedSource(0)), "runtime_config_source"))
+
+	// The first fallback source successfully read is used when the primary is unavailable.
+	require.NoError(t, manager.Reload(context.Background()))
+	assert.Equal(t, 200, manager.GetConfig().(*testOverrides).Overrides["user1"].Limit1)
+	assert.NoError(t, testutil.GatherAndCompare(reg, strings.NewReader(expectedSource(2)), "runtime_config_source"))
+
+	// The primary source is used again once available.
+	require.NoError(t, manager.Reload(context.Background()))
+	assert.Equal(t, 100, manager.GetConfig().(*testOverrides).Overrides["user1"].Limit1)
+	assert.NoError(t, testutil.GatherAndCompare(reg, strings.NewReader(expectedSource(0)), "runtime_config_source"))
+
+	// The reload fails if no source can be read.
+	primary.On("Get", mock.Anything, "runtime-config").Return(nil, errors.New("bucket unavailable"))
+	fallback.On("Get", mock.Anything, "runtime-config-2").Return(nil, errors.New("bucket unavailable"))
+	err = manager.Reload(context.Background())
+	require.ErrorContains(t, err, "source 0: read file runtime-config: open file: bucket unavailable")
+	require.ErrorContains(t, err, "source 2: read file runtime-config-2: open file: bucket unavailable")
+	assert.Equal(t, 100, manager.GetConfig().(*testOverrides).Overrides["user1"].Limit1)
+}
+
+func TestManager_ShouldSkipDownloadWhenObjectAttributesAreUnchanged(t *testing.T) {
+	config1 := []byte(`overrides:
+  user1:
+    limit2: 150`)
+	config2 := []byte(`overrides:
+  user1:
+    limit2: 200`)
+	attrs1 := objstore.ObjectAttributes{Size: int64(len(config1)), LastModified: time.Unix(1000, 0)}
+	attrs2 := objstore.ObjectAttributes{Size: int64(len(config2)), LastModified: time.Unix(2000, 0)}
+
+	bucketClient := &bucket.ClientMock{}
+	bucketClient.MockExists(mock.Anything, true, nil)
+	bucketClient.On("Attributes", mock.Anything, "runtime-config").Return(attrs1, nil).Twice()
+	bucketClient.On("Attributes", mock.Anything, "runtime-config").Return(attrs2, nil).Once()
+	bucketClient.On("Attributes", mock.Anything, "runtime-config").Return(objstore.ObjectAttributes{}, errors.New("not supported")).Once()
+	bucketClient.On("Get", mock.Anything, "runtime-config").Return(io.NopCloser(bytes.NewReader(config1)), nil).Once()
+	bucketClient.On("Get", mock.Anything, "runtime-config").Return(io.NopCloser(bytes.NewReader(config2)), nil).Once()
+	bucketClient.On("Get", mock.Anything, "runtime-config").Return(io.NopCloser(bytes.NewReader(config2)), nil).Once()
+
+	overridesManager, err := New(Config{
+		ReloadPeriod:  time.Hour,
+		LoadPath:      "runtime-config",
+		Loader:        testLoadOverrides,
+		StorageConfig: bucket.Config{Backend: bucket.Filesystem},
+	}, nil, log.NewNopLogger(), func(context.Context) (objstore.Bucket, error) {
+		return bucketClient, nil
+	})
+	require.NoError(t, err)
+	require.NoError(t, services.StartAndAwaitRunning(context.Background(), overridesManager))
+	defer services.StopAndAwaitTerminated(context.Background(), overridesManager) //nolint:errcheck
+	require.Equal(t, 150, overridesManager.GetConfig().(*testOverrides).Overrides["user1"].Limit2)
+
+	// The attributes are unchanged, so the file is not downloaded again.
+	require.NoError(t, overridesManager.loadConfig(context.Background()))
+	require.Equal(t, 150, overridesManager.GetConfig().(*testOverrides).Overrides["user1"].Limit2)
+	bucketClient.AssertNumberOfCalls(t, "Get", 1)
+
+	// The attributes have changed.
+	require.NoError(t, overridesManager.loadConfig(context.Background()))
+	require.Equal(t, 200, overridesManager.GetConfig().(*testOverrides).Overrides["user1"].Limit2)
+	bucketClient.AssertNumberOfCalls(t, "Get", 2)
+
+	// The attributes are not available, so the file is always downloaded.
+	require.NoError(t, overridesManager.loadConfig(context.Background()))
+	bucketClient.AssertNumberOfCalls(t, "Get", 3)
+	bucketClient.AssertExpectations(t)
+}
+
+func TestManager_ShouldReadFilesUnderPrefix(t *testing.T) {
+	bucketClient := &bucket.ClientMock{}
+	bucketClient.MockGet("cluster-a/runtime-config.yaml", `overrides:
+  user1:
+    limit2: 150`, nil)
+
+	overridesManager, err := New(Config{
+		ReloadPeriod:  time.Hour,
+		LoadPath:      "runtime-config.yaml",
+		Prefix:        "/cluster-a/",
+		Loader:        testLoadOverrides,
+		StorageConfig: bucket.Config{Backend: bucket.Filesystem},
+	}, nil, log.NewNopLogger(), func(context.Context) (objstore.Bucket, error) {
+		return bucketClient, nil
+	})
+	require.NoError(t, err)
+	require.NoError(t, services.StartAndAwaitRunning(context.Background(), overridesManager))
+	defer services.StopAndAwaitTerminated(context.Background(), overridesManager) //nolint:errcheck
+
+	require.Equal(t, 150, overridesManager.GetConfig().(*testOverrides).Overrides["user1"].Limit2)
+}
+
+func TestObjectKey(t *testing.T) {
+	tests := []struct {
+		prefix, path, expected string
+	}{
+		{prefix: "", path: "runtime-config.yaml", expected: "runtime-config.yaml"},
+		{prefix: "/", path: "runtime-config.yaml", expected: "runtime-config.yaml"},
+		{prefix: "cluster-a", path: "runtime-config.yaml", expected: "cluster-a/runtime-config.yaml"},
+		{prefix: "/cluster-a/", path: "/runtime-config.yaml", expected: "cluster-a/runtime-config.yaml"},
+		{prefix: "clusters/a", path: "overrides/runtime-config.yaml", expected: "clusters/a/overrides/runtime-config.yaml"},
+	}
+
+	for _, testData := range tests {
+		assert.Equal(t, testData.expected, objectKey(testData.prefix, testData.path), "prefix: %q, path: %q", testData.prefix, testData.path)
+	}
+}
+
+func TestManager_ShouldServePreloadedConfig(t *testing.T) {
+	preload := []byte(`overrides:
+  user1:
+    limit2: 150`)
+
+	t.Run("without LoadPath", func(t *testing.T) {
+		overridesManager, err := New(Config{
+			Preload: preload,
+			Loader:  testLoadOverrides,
+		}, nil, log.NewNopLogger(), func(context.Context) (objstore.Bucket, error) {
+			t.Fatal("the bucket client should not be created when only the preloaded config is served")
+			return nil, nil
+		})
+		require.NoError(t, err)
+		require.NoError(t, services.StartAndAwaitRunning(context.Background(), overridesManager))
+		defer services.StopAndAwaitTerminated(context.Background(), overridesManager) //nolint:errcheck
+
+		require.Equal(t, 150, overridesManager.GetConfig().(*testOverrides).Overrides["user1"].Limit2)
+		require.ErrorIs(t, overridesManager.Reload(context.Background()), errReloadDisabled)
+		require.Equal(t, 150, overridesManager.GetConfig().(*testOverrides).Overrides["user1"].Limit2)
+	})
+
+	t.Run("replaced by LoadPath on the first successful load", func(t *testing.T) {
+		bucketClient := &bucket.ClientMock{}
+		bucketClient.MockExists(mock.Anything, true, nil)
+		bucketClient.On("Attributes", mock.Anything, "runtime-config").Return(objstore.ObjectAttributes{}, nil)
+		bucketClient.On("Get", mock.Anything, "runtime-config").Return(nil, errors.New("bucket unavailable")).Once()
+		bucketClient.On("Get", mock.Anything, "runtime-config").Return(io.NopCloser(strings.NewReader(`overrides:
+  user1:
+    limit2: 200`)), nil).Once()
+
+		overridesManager, err := New(Config{
+			ReloadPeriod:  time.Hour,
+			LoadPath:      "runtime-config",
+			Preload:       preload,
+			Loader:        testLoadOverrides,
+			StorageConfig: bucket.Config{Backend: bucket.Filesystem},
+		}, nil, log.NewNopLogger(), func(context.Context) (objstore.Bucket, error) {
+			return bucketClient, nil
+		})
+		require.NoError(t, err)
+
+		// The bucket is unavailable at startup, so the preloaded config is served.
+		require.NoError(t, services.StartAndAwaitRunning(context.Background(), overridesManager))
+		defer services.StopAndAwaitTerminated(context.Background(), overridesManager) //nolint:errcheck
+		require.Equal(t, 150, overridesManager.GetConfig().(*testOverrides).Overrides["user1"].Limit2)
+
+		require.NoError(t, overridesManager.Reload(context.Background()))
+		require.Equal(t, 200, overridesManager.GetConfig().(*testOverrides).Overrides["user1"].Limit2)
+	})
+
+	t.Run("invalid preloaded config", func(t *testing.T) {
+		overridesManager, err := New(Config{
+			Preload: []byte("overrides: ["),
+			Loader:  testLoadOverrides,
+		}, nil, log.NewNopLogger(), nil)
+		require.NoError(t, err)
+		require.ErrorContains(t, services.StartAndAwaitRunning(context.Background(), overridesManager), "failed to load preloaded runtime config")
+	})
+}
+
+func TestManager_ShouldTrackListenerDroppedUpdates(t *testing.T) {
+	_, overridesManagerConfig := newTestOverridesManagerConfig(t, 555)
+
+	reg := prometheus.NewPedanticRegistry()
+	overridesManager, err := New(overridesManagerConfig, reg, log.NewNopLogger(), mockBucketClientFactory([]byte{}, []byte{}, []byte{}, []byte{}))
+	require.NoError(t, err)
+	require.NoError(t, services.StartAndAwaitRunning(context.Background(), overridesManager))
+	defer services.StopAndAwaitTerminated(context.Background(), overridesManager) //nolint:errcheck
+
+	named := overridesManager.CreateNamedListenerChannel("limits", 1)
+	unnamed := overridesManager.CreateListenerChannel(1)
+
+	// The first reload fills the buffers, the next ones are dropped.
+	for i := 0; i < 3; i++ {
+		require.NoError(t, overridesManager.loadConfig(context.Background()))
+	}
+	require.Equal(t, 555, <-named)
+	require.Equal(t, 555, <-unnamed)
+
+	assert.NoError(t, testutil.GatherAndCompare(reg, strings.NewReader(`
+		# HELP runtime_config_listener_dropped_updates_total Total number of runtime config updates dropped because the listener's buffer was full.
+		# TYPE runtime_config_listener_dropped_updates_total counter
+		runtime_config_listener_dropped_updates_total{listener="limits"} 2
+		runtime_config_listener_dropped_updates_total{listener="unnamed"} 2
+	`), "runtime_config_listener_dropped_updates_total"))
+}
+
+func TestManager_ListenerChannelWithPrevious(t *testing.T) {
+	config, overridesManagerConfig := newTestOverridesManagerConfig(t, 555)
+
+	overridesManager, err := New(overridesManagerConfig, nil, log.NewNopLogger(), mockBucketClientFactory([]byte{}, []byte{}, []byte{}))
+	require.NoError(t, err)
+
+	// Subscribe before starting, so that the listener receives the very first load.
+	ch := overridesManager.CreateListenerChannelWithPrevious(1)
+	require.NoError(t, services.StartAndAwaitRunning(context.Background(), overridesManager))
+
+	select {
+	case update := <-ch:
+		require.Equal(t, ConfigUpdate{Old: nil, New: 555}, update)
+	case <-time.After(time.Second):
+		t.Fatal("listener was not called")
+	}
+
+	config.Store(1111)
+	err = overridesManager.loadConfig(context.TODO())
+	require.NoError(t, err)
+
+	select {
+	case update := <-ch:
+		require.Equal(t, ConfigUpdate{Old: 555, New: 1111}, update)
+	case <-time.After(time.Second):
+		t.Fatal("listener was not called")
+	}
 
+	overridesManager.CloseListenerChannelWithPrevious(ch)
 	select {
 	case _, ok := <-ch:
 		require.False(t, ok)
 	case <-time.After(time.Second):
 		t.Fatal("channel not closed")
 	}
+
+	require.NoError(t, services.StopAndAwaitTerminated(context.Background(), overridesManager))
 }
 
-func TestManager_ShouldFastFailOnInvalidConfigAtStartup(t *testing.T) {
-	// Create an invalid runtime config file.
-	tempFile, err := os.CreateTemp("", "invalid-config")
-	require.NoError(t, err)
-	t.Cleanup(func() {
-		require.NoError(t, os.Remove(tempFile.Name()))
-	})
+func TestManager_ListenerChannelV2(t *testing.T) {
+	_, cfg := newTestOverridesManagerConfig(t, 555)
 
-	config := []byte("!invalid!")
-	_, err = tempFile.Write(config)
+	manager, err := New(cfg, nil, log.NewNopLogger(), mockBucketClientFactory([]byte{}, []byte{}))
 	require.NoError(t, err)
-	require.NoError(t, tempFile.Close())
 
-	// Create the config manager and start it.
-	cfg := Config{
-		ReloadPeriod:  time.Second,
-		LoadPath:      tempFile.Name(),
+	// Subscribe before starting, so that the listener receives the very first load.
+	ch := manager.CreateListenerChannelV2(1)
+	startedAt := time.Now()
+	require.NoError(t, services.StartAndAwaitRunning(context.Background(), manager))
+
+	select {
+	case event := <-ch:
+		_, hash := manager.GetConfigWithHash()
+		assert.Equal(t, 555, event.Config)
+		assert.Equal(t, fmt.Sprintf("%x", sha256.Sum256(nil)), event.Hash)
+		assert.Equal(t, hash, event.Hash)
+		assert.Equal(t, 0, event.Source)
+		assert.WithinRange(t, event.LoadedAt, startedAt, time.Now())
+		assert.Equal(t, event.LoadedAt, *manager.Status().LastReloadSuccess)
+	case <-time.After(time.Second):
+		t.Fatal("listener was not called")
+	}
+
+	// The configs set with SetConfig have no hash nor source.
+	manager.SetConfig(2222)
+	select {
+	case event := <-ch:
+		assert.Equal(t, 2222, event.Config)
+		assert.Empty(t, event.Hash)
+		assert.Equal(t, -1, event.Source)
+	case <-time.After(time.Second):
+		t.Fatal("listener was not called")
+	}
+
+	manager.CloseListenerChannelV2(ch)
+	select {
+	case _, ok := <-ch:
+		require.False(t, ok)
+	case <-time.After(time.Second):
+		t.Fatal("channel not closed")
+	}
+
+	// The channels are closed when the Manager stops.
+	other := manager.CreateListenerChannelV2(0)
+	require.NoError(t, services.StopAndAwaitTerminated(context.Background(), manager))
+	_, ok := <-other
+	require.False(t, ok)
+}
+
+func TestManager_Generation(t *testing.T) {
+	defaultTestLimits = nil
+
+	manager, err := New(Config{
+		ReloadPeriod:  time.Hour,
+		LoadPath:      "runtime-config.yaml",
 		Loader:        testLoadOverrides,
 		StorageConfig: bucket.Config{Backend: bucket.Filesystem},
+	}, nil, log.NewNopLogger(), mockBucketClientFactory())
+	require.NoError(t, err)
+	assert.Equal(t, uint64(0), manager.Generation())
+
+	ch := manager.CreateListenerChannelV2(10)
+	config := []byte(`overrides:
+  user1:
+    limit2: 150`)
+	manager.bucketClient = createMockBucketClient(config, config, []byte(`overrides:
+  user1:
+    limit2: 200`))
+
+	// The generation is only incremented when the config changes.
+	for _, expected := range []uint64{1, 1, 2} {
+		require.NoError(t, manager.loadConfig(context.Background()))
+		assert.Equal(t, expected, manager.Generation())
+		assert.Equal(t, expected, (<-ch).Generation)
 	}
 
-	m, err := New(cfg, nil, log.NewNopLogger(), mockBucketClientFactory(config))
-	require.NoError(t, err)
-	require.Error(t, services.StartAndAwaitRunning(context.Background(), m))
+	// The configs set with SetConfig are always considered changed.
+	manager.SetConfig(manager.GetConfig())
+	assert.Equal(t, uint64(3), manager.Generation())
+	assert.Equal(t, uint64(3), (<-ch).Generation)
 }
 
-func TestManger_ShouldFastFailOnMissingConfiguration(t *testing.T) {
-	tests := []struct {
-		name         string
-		cfg          Config
-		errorMessage string
+func TestManager_GetConfigFor(t *testing.T) {
+	tests := map[string]struct {
+		extractor func(cfg any, tenantID string) any
+		expected  any
 	}{
-		{
-			name:         "empty load path",
-			cfg:          Config{},
-			errorMessage: "LoadPath is empty",
+		"should return the whole config without extractor": {
+			expected: map[string]int{"tenant-a": 1, "tenant-b": 2},
 		},
-		{
-			name: "empty storage backend",
-			cfg: Config{
-				LoadPath: "fileLoadPath",
+		"should return the tenant section with extractor": {
+			extractor: func(cfg any, tenantID string) any {
+				return cfg.(map[string]int)[tenantID]
 			},
-			errorMessage: "Backend should not be explicitly empty",
+			expected: 2,
 		},
 	}
-	for _, tt := range tests {
-		t.Run(tt.name, func(t *testing.T) {
-			_, err := New(tt.cfg, nil, log.NewNopLogger(), mockBucketClientFactory([]byte{}))
-			require.ErrorContains(t, err, tt.errorMessage)
+
+	for testName, testData := range tests {
+		t.Run(testName, func(t *testing.T) {
+			_, cfg := newTestOverridesManagerConfig(t, 0)
+			cfg.Loader = func(_ io.Reader) (any, error) {
+				return map[string]int{"tenant-a": 1, "tenant-b": 2}, nil
+			}
+			cfg.TenantExtractor = testData.extractor
+
+			manager, err := New(cfg, nil, log.NewNopLogger(), mockBucketClientFactory([]byte{}))
+			require.NoError(t, err)
+
+			// No config has been loaded yet.
+			assert.Nil(t, manager.GetConfigFor("tenant-b"))
+
+			require.NoError(t, services.StartAndAwaitRunning(context.Background(), manager))
+			defer services.StopAndAwaitTerminated(context.Background(), manager) //nolint:errcheck
+
+			assert.Equal(t, testData.expected, manager.GetConfigFor("tenant-b"))
 		})
 	}
 }
 
-func TestManager_GetsRuntimeConfigFromBackendStore(t *testing.T) {
-	fileName := "runtime-config"
-	config := []byte(`overrides:
-  user2:
-    limit2: 200`)
-	bucketClient := createMockBucketClient(config)
-	manager := Manager{
-		cfg: Config{
-			LoadPath:      fileName,
-			Loader:        testLoadOverrides,
-			StorageConfig: bucket.Config{Backend: bucket.Filesystem},
+func TestManager_LoaderWithMeta(t *testing.T) {
+	lastModified := time.Now().Add(-time.Hour).Truncate(time.Second)
+
+	bucketClient := &bucket.ClientMock{}
+	bucketClient.MockExists(mock.Anything, true, nil)
+	bucketClient.On("Attributes", mock.Anything, "runtime-config-1.yaml").Return(objstore.ObjectAttributes{Size: 3, LastModified: lastModified}, nil)
+	bucketClient.On("Attributes", mock.Anything, "runtime-config-2.yaml").Return(objstore.ObjectAttributes{}, nil)
+	bucketClient.On("Get", mock.Anything, "runtime-config-1.yaml").Return(io.NopCloser(strings.NewReader("abc")), nil)
+	bucketClient.On("Get", mock.Anything, "runtime-config-2.yaml").Return(io.NopCloser(strings.NewReader("def")), nil)
+
+	var (
+		actualKey   string
+		actualAttrs objstore.ObjectAttributes
+	)
+	cfg := Config{
+		ReloadPeriod: time.Hour,
+		LoadPath:     "runtime-config-1.yaml,runtime-config-2.yaml",
+		Loader: func(_ io.Reader) (any, error) {
+			return nil, errors.New("unexpected call to the Loader")
 		},
-		configLoadSuccess: promauto.NewGauge(prometheus.GaugeOpts{Name: "mockLoadSuccess"}),
-		configHash: promauto.NewGaugeVec(prometheus.GaugeOpts{
-			Name: "mockHash",
-		}, []string{"sha256"}),
-		bucketClient: bucketClient,
+		// The meta of the first file is passed, along with the merged content.
+		LoaderWithMeta: func(key string, attrs objstore.ObjectAttributes, r io.Reader) (any, error) {
+			actualKey, actualAttrs = key, attrs
+			content, err := io.ReadAll(r)
+			return string(content), err
+		},
+		StorageConfig: bucket.Config{Backend: bucket.Filesystem},
 	}
 
-	err := manager.loadConfig(context.TODO())
-
+	manager, err := New(cfg, nil, log.NewNopLogger(), func(context.Context) (objstore.Bucket, error) {
+		return bucketClient, nil
+	})
 	require.NoError(t, err)
-	bucketClient.AssertExpectations(t)
+	require.NoError(t, services.StartAndAwaitRunning(context.Background(), manager))
+	defer services.StopAndAwaitTerminated(context.Background(), manager) //nolint:errcheck
+
+	assert.Equal(t, "abcdef", manager.GetConfig())
+	assert.Equal(t, "runtime-config-1.yaml", actualKey)
+	assert.Equal(t, objstore.ObjectAttributes{Size: 3, LastModified: lastModified}, actualAttrs)
 }
 
-func mockBucketClientFactory(configs ...[]byte) BucketClientFactory {
-	return func(ctx context.Context) (objstore.Bucket, error) {
-		return createMockBucketClient(configs...), nil
+func TestManager_ShouldRetryInitialLoad(t *testing.T) {
+	tests := map[string]struct {
+		maxAttempts   int
+		expectedErr   bool
+		expectedCalls int
+	}{
+		"should fail on the first error by default": {
+			expectedErr:   true,
+			expectedCalls: 1,
+		},
+		"should fail once the attempts are exhausted": {
+			maxAttempts:   2,
+			expectedErr:   true,
+			expectedCalls: 2,
+		},
+		"should succeed within the max attempts": {
+			maxAttempts:   3,
+			expectedCalls: 3,
+		},
 	}
-}
 
-func createMockBucketClient(configs ...[]byte) *bucket.ClientMock {
-	bucketClient := bucket.ClientMock{}
-	for _, config := range configs {
-		bucketClient.On("Get", mock.Anything, mock.Anything).Return(io.NopCloser(bytes.NewBuffer(config)), nil).Once()
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			_, cfg := newTestOverridesManagerConfig(t, 555)
+			cfg.InitialLoadMaxAttempts = tc.maxAttempts
+			cfg.InitialLoadMinBackoff = 10 * time.Millisecond
+			cfg.InitialLoadMaxBackoff = 20 * time.Millisecond
+
+			// The first two reads fail.
+			bucketClient := &bucket.ClientMock{}
+			bucketClient.MockExists(mock.Anything, true, nil)
+			bucketClient.On("Attributes", mock.Anything, mock.Anything).Return(objstore.ObjectAttributes{}, nil)
+			bucketClient.On("Get", mock.Anything, mock.Anything).Return(nil, errors.New("bucket unavailable")).Twice()
+			bucketClient.On("Get", mock.Anything, mock.Anything).Return(io.NopCloser(bytes.NewReader(nil)), nil).Once()
+
+			manager, err := New(cfg, nil, log.NewNopLogger(), func(context.Context) (objstore.Bucket, error) {
+				return bucketClient, nil
+			})
+			require.NoError(t, err)
+
+			err = services.StartAndAwaitRunning(context.Background(), manager)
+			bucketClient.AssertNumberOfCalls(t, "Get", tc.expectedCalls)
+			if tc.expectedErr {
+				require.ErrorContains(t, err, "bucket unavailable")
+				return
+			}
+			require.NoError(t, err)
+			require.NoError(t, services.StopAndAwaitTerminated(context.Background(), manager))
+			assert.Equal(t, 555, manager.GetConfig())
+		})
+	}
+}
+
+func TestManager_ShouldFailToStartIfTheConfigDoesNotExist(t *testing.T) {
+	_, cfg := newTestOverridesManagerConfig(t, 1)
+	cfg.Prefix = "runtime"
+
+	bucketClient := &bucket.ClientMock{}
+	bucketClient.MockExists(mock.Anything, false, nil)
+
+	manager, err := New(cfg, nil, log.NewNopLogger(), func(context.Context) (objstore.Bucket, error) {
+		return bucketClient, nil
+	})
+	require.NoError(t, err)
+
+	err = services.StartAndAwaitRunning(context.Background(), manager)
+	require.ErrorIs(t, err, ErrConfigNotFound)
+	assert.ErrorContains(t, err, objectKey(cfg.Prefix, cfg.loadPaths()[0]))
+	bucketClient.AssertNotCalled(t, "Get", mock.Anything, mock.Anything)
+}
+
+func TestManager_ShouldRetryTheInitialLoadUntilTheConfigExists(t *testing.T) {
+	_, cfg := newTestOverridesManagerConfig(t, 1)
+	cfg.InitialLoadMaxAttempts = 3
+	cfg.InitialLoadMinBackoff = 10 * time.Millisecond
+	cfg.InitialLoadMaxBackoff = 10 * time.Millisecond
+
+	// The config is uploaded after the first attempt.
+	bucketClient := &bucket.ClientMock{}
+	bucketClient.On("Exists", mock.Anything, mock.Anything).Return(false, nil).Once()
+	bucketClient.MockExists(mock.Anything, true, nil)
+	bucketClient.On("Attributes", mock.Anything, mock.Anything).Return(objstore.ObjectAttributes{}, nil)
+	bucketClient.On("Get", mock.Anything, mock.Anything).Return(io.NopCloser(bytes.NewReader(nil)), nil)
+
+	manager, err := New(cfg, nil, log.NewNopLogger(), func(context.Context) (objstore.Bucket, error) {
+		return bucketClient, nil
+	})
+	require.NoError(t, err)
+	require.NoError(t, services.StartAndAwaitRunning(context.Background(), manager))
+	t.Cleanup(func() {
+		require.NoError(t, services.StopAndAwaitTerminated(context.Background(), manager))
+	})
+
+	assert.Equal(t, 1, manager.GetConfig())
+	bucketClient.AssertNumberOfCalls(t, "Exists", 2)
+}
+
+func TestManager_ShouldReloadOnSchedule(t *testing.T) {
+	_, cfg := newTestOverridesManagerConfig(t, 1)
+	// The schedule wins over the reload period, which isn't required.
+	cfg.ReloadPeriod = 0
+	cfg.ReloadSchedule = "0 3 * * *"
+
+	manager, err := New(cfg, nil, log.NewNopLogger(), mockBucketClientFactory([]byte{}))
+	require.NoError(t, err)
+
+	now := time.Date(2024, time.January, 10, 10, 15, 0, 0, time.UTC)
+	assert.Equal(t, time.Date(2024, time.January, 11, 3, 0, 0, 0, time.UTC), manager.nextReloadTime(now))
+
+	startedAt := time.Now()
+	require.NoError(t, services.StartAndAwaitRunning(context.Background(), manager))
+	t.Cleanup(func() {
+		require.NoError(t, services.StopAndAwaitTerminated(context.Background(), manager))
+	})
+
+	test.Poll(t, time.Second, true, func() any {
+		return manager.Status().NextReload != nil
+	})
+	next := *manager.Status().NextReload
+	assert.Equal(t, 3, next.Hour())
+	assert.Equal(t, 0, next.Minute())
+	assert.WithinRange(t, next, startedAt, startedAt.Add(24*time.Hour))
+}
+
+func TestManager_Status(t *testing.T) {
+	_, cfg := newTestOverridesManagerConfig(t, 1)
+	cfg.ReloadPeriod = time.Hour
+
+	// Only the initial load succeeds.
+	bucketClient := createMockBucketClient([]byte{})
+	bucketClient.On("Get", mock.Anything, mock.Anything).Return(nil, errors.New("bucket unavailable"))
+
+	manager, err := New(cfg, nil, log.NewNopLogger(), func(context.Context) (objstore.Bucket, error) {
+		return bucketClient, nil
+	})
+	require.NoError(t, err)
+	assert.Equal(t, ReloadStatus{}, manager.Status())
+
+	startedAt := time.Now()
+	require.NoError(t, services.StartAndAwaitRunning(context.Background(), manager))
+
+	test.Poll(t, time.Second, true, func() any {
+		return manager.Status().NextReload != nil
+	})
+	status := manager.Status()
+	require.NotNil(t, status.LastReloadAttempt)
+	require.NotNil(t, status.LastReloadSuccess)
+	assert.False(t, status.LastReloadSuccess.Before(*status.LastReloadAttempt))
+	assert.Empty(t, status.LastError)
+	assert.WithinRange(t, *status.NextReload, startedAt.Add(cfg.ReloadPeriod), time.Now().Add(cfg.ReloadPeriod))
+
+	// The error of the last failed attempt is reported.
+	require.Error(t, manager.Reload(context.Background()))
+	status = manager.Status()
+	assert.True(t, status.LastReloadAttempt.After(*status.LastReloadSuccess))
+	assert.Contains(t, status.LastError, "bucket unavailable")
+
+	rec := httptest.NewRecorder()
+	manager.StatusHandler(rec, httptest.NewRequest(http.MethodGet, "/runtime_config/status", nil))
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "application/json", rec.Header().Get("Content-Type"))
+
+	var rendered ReloadStatus
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &rendered))
+	assert.Equal(t, status.LastError, rendered.LastError)
+	assert.True(t, status.NextReload.Equal(*rendered.NextReload))
+
+	// No reload is scheduled once stopped.
+	require.NoError(t, services.StopAndAwaitTerminated(context.Background(), manager))
+	assert.Nil(t, manager.Status().NextReload)
+}
+
+func TestManager_SubscribeWithCurrent(t *testing.T) {
+	const numReloads = 100
+
+	config, overridesManagerConfig := newTestOverridesManagerConfig(t, 0)
+
+	// The initial load and the reloads each get the config from the bucket once.
+	configs := make([][]byte, numReloads+1)
+	for i := range configs {
+		configs[i] = []byte{}
+	}
+	overridesManager, err := New(overridesManagerConfig, nil, log.NewNopLogger(), mockBucketClientFactory(configs...))
+	require.NoError(t, err)
+	require.NoError(t, services.StartAndAwaitRunning(context.Background(), overridesManager))
+	defer services.StopAndAwaitTerminated(context.Background(), overridesManager) //nolint:errcheck
+
+	// Subscribe while the config is being reloaded.
+	reloaded := make(chan struct{})
+	go func() {
+		defer close(reloaded)
+		for i := 1; i <= numReloads; i++ {
+			config.Store(int32(i))
+			assert.NoError(t, overridesManager.loadConfig(context.Background()))
+		}
+	}()
+
+	time.Sleep(time.Millisecond)
+	current, ch := overridesManager.SubscribeWithCurrent(numReloads)
+	<-reloaded
+
+	// The current value is followed by all the subsequent updates, without gaps or duplicates.
+	expected := current.(int) + 1
+	for expected <= numReloads {
+		select {
+		case value := <-ch:
+			require.Equal(t, expected, value)
+			expected++
+		case <-time.After(time.Second):
+			t.Fatalf("listener didn't receive the update %d", expected)
+		}
+	}
+
+	select {
+	case value := <-ch:
+		t.Fatalf("unexpected update %v", value)
+	default:
+	}
+}
+
+func TestManager_StopClosesListenerChannels(t *testing.T) {
+	_, overridesManagerConfig := newTestOverridesManagerConfig(t, 555)
+
+	overridesManager, err := New(overridesManagerConfig, nil, log.NewNopLogger(), mockBucketClientFactory([]byte{}))
+	require.NoError(t, err)
+	require.NoError(t, services.StartAndAwaitRunning(context.Background(), overridesManager))
+
+	// need to use buffer, otherwise loadConfig will throw away update
+	ch := overridesManager.CreateListenerChannel(0)
+
+	require.NoError(t, services.StopAndAwaitTerminated(context.Background(), overridesManager))
+
+	select {
+	case _, ok := <-ch:
+		require.False(t, ok)
+	case <-time.After(time.Second):
+		t.Fatal("channel not closed")
+	}
+}
+
+func TestManager_StopShouldFlushTheConfigToListeners(t *testing.T) {
+	_, overridesManagerConfig := newTestOverridesManagerConfig(t, 555)
+	overridesManagerConfig.StopFlushTimeout = 100 * time.Millisecond
+
+	reg := prometheus.NewPedanticRegistry()
+	overridesManager, err := New(overridesManagerConfig, reg, log.NewNopLogger(), mockBucketClientFactory([]byte{}))
+	require.NoError(t, err)
+	require.NoError(t, services.StartAndAwaitRunning(context.Background(), overridesManager))
+
+	// The config is received by the listener reading from its channel, and dropped for the
+	// one not reading from it.
+	ch := overridesManager.CreateListenerChannel(0)
+	ignored := overridesManager.CreateListenerChannel(0)
+
+	received := make(chan any, 2)
+	go func() {
+		for value := range ch {
+			received <- value
+		}
+		close(received)
+	}()
+
+	require.NoError(t, services.StopAndAwaitTerminated(context.Background(), overridesManager))
+
+	var values []any
+	for value := range received {
+		values = append(values, value)
+	}
+	assert.Equal(t, []any{555}, values)
+
+	_, ok := <-ignored
+	assert.False(t, ok)
+	assert.NoError(t, testutil.GatherAndCompare(reg, strings.NewReader(`
+		# HELP runtime_config_listener_dropped_updates_total Total number of runtime config updates dropped because the listener's buffer was full.
+		# TYPE runtime_config_listener_dropped_updates_total counter
+		runtime_config_listener_dropped_updates_total{listener="unnamed"} 1
+	`), "runtime_config_listener_dropped_updates_total"))
+}
+
+func TestManager_Stopped(t *testing.T) {
+	_, overridesManagerConfig := newTestOverridesManagerConfig(t, 555)
+
+	overridesManager, err := New(overridesManagerConfig, nil, log.NewNopLogger(), mockBucketClientFactory([]byte{}))
+	require.NoError(t, err)
+	require.False(t, overridesManager.Stopped())
+
+	require.NoError(t, services.StartAndAwaitRunning(context.Background(), overridesManager))
+	require.False(t, overridesManager.Stopped())
+
+	require.NoError(t, services.StopAndAwaitTerminated(context.Background(), overridesManager))
+	require.True(t, overridesManager.Stopped())
+
+	// The last loaded config is still returned.
+	require.Equal(t, 555, overridesManager.GetConfig())
+}
+
+func TestManager_WaitForConfig(t *testing.T) {
+	_, cfg := newTestOverridesManagerConfig(t, 555)
+
+	// Block the first load until the test releases it.
+	release := make(chan struct{})
+	bucketClient := &bucket.ClientMock{}
+	bucketClient.MockExists(mock.Anything, true, nil)
+	bucketClient.On("Attributes", mock.Anything, mock.Anything).Return(objstore.ObjectAttributes{}, nil)
+	bucketClient.On("Get", mock.Anything, mock.Anything).Return(func(_ context.Context, _ string) (io.ReadCloser, error) {
+		<-release
+		return io.NopCloser(bytes.NewReader(nil)), nil
+	})
+
+	manager, err := New(cfg, nil, log.NewNopLogger(), func(_ context.Context) (objstore.Bucket, error) {
+		return bucketClient, nil
+	})
+	require.NoError(t, err)
+	require.NoError(t, manager.StartAsync(context.Background()))
+	t.Cleanup(func() {
+		require.NoError(t, services.StopAndAwaitTerminated(context.Background(), manager))
+	})
+
+	// The context is done before the first load completes.
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	loaded, err := manager.WaitForConfig(ctx)
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+	require.Nil(t, loaded)
+
+	close(release)
+	loaded, err = manager.WaitForConfig(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, 555, loaded)
+
+	// The config is returned straight away once loaded.
+	loaded, err = manager.WaitForConfig(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, 555, loaded)
+}
+
+func TestManager_RegisterCallback(t *testing.T) {
+	config, cfg := newTestOverridesManagerConfig(t, 1)
+
+	manager, err := New(cfg, nil, log.NewNopLogger(), mockBucketClientFactory([]byte{}, []byte{}, []byte{}))
+	require.NoError(t, err)
+
+	var calls []string
+	manager.RegisterCallback(func(cfg any) {
+		// The new config is already returned by the Manager.
+		require.Equal(t, cfg, manager.GetConfig())
+		calls = append(calls, fmt.Sprintf("first:%v", cfg))
+	})
+	manager.RegisterCallback(func(cfg any) {
+		calls = append(calls, fmt.Sprintf("second:%v", cfg))
+	})
+
+	require.NoError(t, services.StartAndAwaitRunning(context.Background(), manager))
+	t.Cleanup(func() {
+		require.NoError(t, services.StopAndAwaitTerminated(context.Background(), manager))
+	})
+	require.Equal(t, []string{"first:1", "second:1"}, calls)
+
+	// The callbacks are called before the load returns.
+	config.Store(2)
+	require.NoError(t, manager.loadConfig(context.Background()))
+	require.Equal(t, []string{"first:1", "second:1", "first:2", "second:2"}, calls)
+}
+
+func TestManager_DrainAndCloseListenerChannel(t *testing.T) {
+	manager := NewStatic(0, nil)
+	require.NoError(t, services.StartAndAwaitRunning(context.Background(), manager))
+	defer services.StopAndAwaitTerminated(context.Background(), manager) //nolint:errcheck
+
+	ch := manager.CreateNamedListenerChannel("test", 3)
+	other := manager.CreateListenerChannel(3)
+	manager.SetConfig(1)
+	manager.SetConfig(2)
+
+	require.Equal(t, []any{1, 2}, manager.DrainAndCloseListenerChannel(ch))
+
+	// No value is sent to the drained channel afterwards.
+	manager.SetConfig(3)
+	_, ok := <-ch
+	require.False(t, ok)
+
+	// The other listeners are unaffected.
+	require.Equal(t, []any{1, 2, 3}, manager.DrainAndCloseListenerChannel(other))
+
+	// Unknown channels are ignored.
+	require.Nil(t, manager.DrainAndCloseListenerChannel(make(chan any)))
+}
+
+func TestNewStatic(t *testing.T) {
+	manager := NewStatic("initial", nil)
+	require.NoError(t, services.StartAndAwaitRunning(context.Background(), manager))
+
+	assert.Equal(t, "initial", manager.GetConfig())
+
+	ch := manager.CreateListenerChannel(1)
+	manager.SetConfig("updated")
+
+	select {
+	case value := <-ch:
+		assert.Equal(t, "updated", value)
+	case <-time.After(time.Second):
+		t.Fatal("listener didn't receive the update")
+	}
+	assert.Equal(t, "updated", manager.GetConfig())
+
+	require.NoError(t, services.StopAndAwaitTerminated(context.Background(), manager))
+
+	select {
+	case _, ok := <-ch:
+		require.False(t, ok)
+	case <-time.After(time.Second):
+		t.Fatal("channel not closed")
+	}
+}
+
+func TestManager_ShouldFastFailOnInvalidConfigAtStartup(t *testing.T) {
+	// Create an invalid runtime config file.
+	tempFile, err := os.CreateTemp("", "invalid-config")
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		require.NoError(t, os.Remove(tempFile.Name()))
+	})
+
+	config := []byte("!invalid!")
+	_, err = tempFile.Write(config)
+	require.NoError(t, err)
+	require.NoError(t, tempFile.Close())
+
+	// Create the config manager and start it.
+	cfg := Config{
+		ReloadPeriod:  time.Second,
+		LoadPath:      tempFile.Name(),
+		Loader:        testLoadOverrides,
+		StorageConfig: bucket.Config{Backend: bucket.Filesystem},
+	}
+
+	m, err := New(cfg, nil, log.NewNopLogger(), mockBucketClientFactory(config))
+	require.NoError(t, err)
+	require.Error(t, services.StartAndAwaitRunning(context.Background(), m))
+}
+
+func TestManger_ShouldFastFailOnMissingConfiguration(t *testing.T) {
+	tests := []struct {
+		name         string
+		cfg          Config
+		expectedErr  error
+		errorMessage string
+	}{
+		{
+			name:         "empty load path",
+			cfg:          Config{},
+			expectedErr:  ErrEmptyLoadPath,
+			errorMessage: "LoadPath is empty",
+		},
+		{
+			name: "empty storage backend",
+			cfg: Config{
+				LoadPath: "fileLoadPath",
+			},
+			expectedErr:  ErrEmptyBackend,
+			errorMessage: "Backend should not be explicitly empty",
+		},
+		{
+			name: "unsupported compression",
+			cfg: Config{
+				LoadPath:      "fileLoadPath",
+				Compression:   "lz4",
+				StorageConfig: bucket.Config{Backend: bucket.Filesystem},
+			},
+			expectedErr:  ErrUnsupportedCompression,
+			errorMessage: "unsupported compression: lz4",
+		},
+		{
+			name: "invalid reload schedule",
+			cfg: Config{
+				LoadPath:       "fileLoadPath",
+				ReloadSchedule: "0 0 * *",
+				StorageConfig:  bucket.Config{Backend: bucket.Filesystem},
+			},
+			expectedErr:  ErrInvalidReloadSchedule,
+			errorMessage: "invalid reload schedule: expected 5 fields, got 4",
+		},
+		{
+			name: "negative initial load max attempts",
+			cfg: Config{
+				LoadPath:               "fileLoadPath",
+				InitialLoadMaxAttempts: -1,
+				StorageConfig:          bucket.Config{Backend: bucket.Filesystem},
+			},
+			expectedErr:  ErrNegativeInitialLoad,
+			errorMessage: "initial load max attempts and backoff must not be negative",
+		},
+		{
+			name: "negative max staleness",
+			cfg: Config{
+				LoadPath:      "fileLoadPath",
+				MaxStaleness:  -time.Second,
+				StorageConfig: bucket.Config{Backend: bucket.Filesystem},
+			},
+			expectedErr:  ErrNegativeMaxStaleness,
+			errorMessage: "max staleness must not be negative",
+		},
+		{
+			name: "negative read timeout",
+			cfg: Config{
+				LoadPath:      "fileLoadPath",
+				ReadTimeout:   -time.Second,
+				StorageConfig: bucket.Config{Backend: bucket.Filesystem},
+			},
+			expectedErr:  ErrNegativeReadTimeout,
+			errorMessage: "read timeout must not be negative",
+		},
+		{
+			name: "negative max file size",
+			cfg: Config{
+				LoadPath:      "fileLoadPath",
+				MaxFileSize:   -1,
+				StorageConfig: bucket.Config{Backend: bucket.Filesystem},
+			},
+			expectedErr:  ErrNegativeMaxFileSize,
+			errorMessage: "max file size must not be negative",
+		},
+		{
+			name: "negative load concurrency",
+			cfg: Config{
+				LoadPath:        "fileLoadPath",
+				LoadConcurrency: -1,
+				StorageConfig:   bucket.Config{Backend: bucket.Filesystem},
+			},
+			expectedErr:  ErrNegativeLoadConcurrency,
+			errorMessage: "load concurrency must not be negative",
+		},
+		{
+			name: "invalid HTTP URL",
+			cfg: Config{
+				LoadPath:      "fileLoadPath",
+				HTTPURL:       "config-server/runtime",
+				StorageConfig: bucket.Config{Backend: bucket.Filesystem},
+			},
+			expectedErr:  ErrInvalidHTTPURL,
+			errorMessage: "invalid HTTP URL: config-server/runtime",
+		},
+		{
+			name: "negative HTTP timeout",
+			cfg: Config{
+				LoadPath:      "fileLoadPath",
+				HTTPURL:       "http://config-server/runtime",
+				HTTPTimeout:   -time.Second,
+				StorageConfig: bucket.Config{Backend: bucket.Filesystem},
+			},
+			expectedErr:  ErrNegativeHTTPTimeout,
+			errorMessage: "HTTP timeout must not be negative",
+		},
+		{
+			name: "zero reload period",
+			cfg: Config{
+				LoadPath:      "fileLoadPath",
+				StorageConfig: bucket.Config{Backend: bucket.Filesystem},
+			},
+			expectedErr:  ErrInvalidReloadPeriod,
+			errorMessage: "reload period must be greater than 0",
+		},
+		{
+			name: "fallback without bucket client factory",
+			cfg: Config{
+				LoadPath:      "fileLoadPath",
+				StorageConfig: bucket.Config{Backend: bucket.Filesystem},
+				Fallbacks:     []Source{{LoadPath: "fallbackLoadPath", StorageConfig: bucket.Config{Backend: bucket.Filesystem}}},
+			},
+			expectedErr:  ErrMissingFallbackBucketClientFactory,
+			errorMessage: "FallbackBucketClientFactory is required when fallback sources are configured",
+		},
+		{
+			name: "fallback with empty load path",
+			cfg: Config{
+				LoadPath:                    "fileLoadPath",
+				StorageConfig:               bucket.Config{Backend: bucket.Filesystem},
+				Fallbacks:                   []Source{{StorageConfig: bucket.Config{Backend: bucket.Filesystem}}},
+				FallbackBucketClientFactory: mockFallbackBucketClientFactory(),
+			},
+			expectedErr:  ErrEmptyLoadPath,
+			errorMessage: "fallback 0: LoadPath is empty",
+		},
+		{
+			name: "fallback with empty storage backend",
+			cfg: Config{
+				LoadPath:                    "fileLoadPath",
+				StorageConfig:               bucket.Config{Backend: bucket.Filesystem},
+				Fallbacks:                   []Source{{LoadPath: "fallbackLoadPath"}},
+				FallbackBucketClientFactory: mockFallbackBucketClientFactory(),
+			},
+			expectedErr:  ErrEmptyBackend,
+			errorMessage: "fallback 0: Backend should not be explicitly empty",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := New(tt.cfg, nil, log.NewNopLogger(), mockBucketClientFactory([]byte{}))
+			require.ErrorIs(t, err, tt.expectedErr)
+			require.ErrorContains(t, err, tt.errorMessage)
+		})
+	}
+}
+
+func TestManager_GetsRuntimeConfigFromBackendStore(t *testing.T) {
+	fileName := "runtime-config"
+	config := []byte(`overrides:
+  user2:
+    limit2: 200`)
+	bucketClient := createMockBucketClient(config)
+	manager := Manager{
+		cfg: Config{
+			LoadPath:      fileName,
+			Loader:        testLoadOverrides,
+			StorageConfig: bucket.Config{Backend: bucket.Filesystem},
+		},
+		configLoadSuccess: promauto.NewGauge(prometheus.GaugeOpts{Name: "mockLoadSuccess"}),
+		configStaleness:   promauto.NewGauge(prometheus.GaugeOpts{Name: "mockStaleness"}),
+		configHash: promauto.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "mockHash",
+		}, []string{"sha256"}),
+		configFileSize: promauto.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "mockFileSize",
+		}, []string{"file"}),
+		configSource: promauto.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "mockSource",
+		}, []string{"index"}),
+		loaded:       make(chan struct{}),
+		bucketClient: bucketClient,
+	}
+
+	err := manager.loadConfig(context.TODO())
+
+	require.NoError(t, err)
+	bucketClient.AssertExpectations(t)
+}
+
+type testSizedConfig struct {
+	sizes map[string]int
+}
+
+func (c *testSizedConfig) TenantSizes() map[string]int {
+	return c.sizes
+}
+
+func TestManager_ShouldRejectReloadOnOversizedTenantConfig(t *testing.T) {
+	loaded := &testSizedConfig{sizes: map[string]int{"user1": 10, "user2": 20}}
+
+	cfg := Config{
+		ReloadPeriod:        time.Second,
+		LoadPath:            "runtime-config",
+		MaxTenantConfigSize: 50,
+		AllowEmpty:          true,
+		Loader: func(_ io.Reader) (any, error) {
+			return loaded, nil
+		},
+		StorageConfig: bucket.Config{Backend: bucket.Filesystem},
+	}
+
+	reg := prometheus.NewPedanticRegistry()
+	manager, err := New(cfg, reg, log.NewNopLogger(), mockBucketClientFactory([]byte{}, []byte{}))
+	require.NoError(t, err)
+	require.NoError(t, services.StartAndAwaitRunning(context.Background(), manager))
+	t.Cleanup(func() {
+		require.NoError(t, services.StopAndAwaitTerminated(context.Background(), manager))
+	})
+
+	initial := manager.GetConfig()
+	require.Equal(t, loaded, initial)
+
+	// Reload a config where a single tenant exceeds the limit.
+	loaded = &testSizedConfig{sizes: map[string]int{"user1": 10, "user2": 100}}
+	err = manager.loadConfig(context.Background())
+	require.ErrorContains(t, err, "tenant user2")
+
+	// The previous config should be kept.
+	assert.Same(t, initial, manager.GetConfig())
+	assert.Equal(t, float64(0), testutil.ToFloat64(manager.configLoadSuccess))
+}
+
+func TestManager_ShouldRejectConfigInconsistentWithClusterState(t *testing.T) {
+	config := []byte("overrides:\n  user1:\n    limit1: 100\n")
+
+	cfg := Config{
+		ReloadPeriod: time.Second,
+		LoadPath:     "runtime-config",
+		Loader:       testLoadOverrides,
+		ClusterStateProvider: func(context.Context) (any, error) {
+			return map[string]bool{"user1": true}, nil
+		},
+		ClusterStateValidator: func(cfg any, clusterState any) error {
+			tenants := clusterState.(map[string]bool)
+			for tenant := range cfg.(*testOverrides).Overrides {
+				if !tenants[tenant] {
+					return fmt.Errorf("unknown tenant %s", tenant)
+				}
+			}
+			return nil
+		},
+		StorageConfig: bucket.Config{Backend: bucket.Filesystem},
+	}
+
+	bucketClient := &bucket.ClientMock{}
+	bucketClient.MockExists(mock.Anything, true, nil)
+	bucketClient.On("Attributes", mock.Anything, mock.Anything).Return(objstore.ObjectAttributes{}, nil)
+	bucketClient.On("Get", mock.Anything, "runtime-config").Return(func(context.Context, string) (io.ReadCloser, error) {
+		return io.NopCloser(bytes.NewReader(config)), nil
+	})
+
+	manager, err := New(cfg, prometheus.NewPedanticRegistry(), log.NewNopLogger(), func(context.Context) (objstore.Bucket, error) {
+		return bucketClient, nil
+	})
+	require.NoError(t, err)
+	require.NoError(t, services.StartAndAwaitRunning(context.Background(), manager))
+	t.Cleanup(func() {
+		require.NoError(t, services.StopAndAwaitTerminated(context.Background(), manager))
+	})
+
+	initial := manager.GetConfig()
+	require.Contains(t, initial.(*testOverrides).Overrides, "user1")
+
+	// Reload a config referencing a tenant not existing in the cluster.
+	config = []byte("overrides:\n  user1:\n    limit1: 100\n  user2:\n    limit1: 200\n")
+	err = manager.loadConfig(context.Background())
+	require.ErrorContains(t, err, "unknown tenant user2")
+
+	// The previous config should be kept.
+	assert.Same(t, initial, manager.GetConfig())
+	assert.Equal(t, float64(0), testutil.ToFloat64(manager.configLoadSuccess))
+}
+
+func TestManager_ShouldLoadMultipleFiles(t *testing.T) {
+	base := []byte("overrides:\n  user1:\n    limit1: 100\n")
+	env := []byte("  user2:\n    limit2: 200\n")
+
+	bucketClient := &bucket.ClientMock{}
+	bucketClient.MockGet("base.yaml", string(base), nil)
+	bucketClient.MockGet("env.yaml", string(env), nil)
+
+	tests := map[string]struct {
+		merger   Merger
+		expected *testOverrides
+	}{
+		"should concatenate files by default": {
+			expected: &testOverrides{Overrides: map[string]*TestLimits{
+				"user1": {Limit1: 100},
+				"user2": {Limit2: 200},
+			}},
+		},
+		"should use the configured merger": {
+			// Keep only the first file.
+			merger: func(parts [][]byte) ([]byte, error) {
+				return parts[0], nil
+			},
+			expected: &testOverrides{Overrides: map[string]*TestLimits{
+				"user1": {Limit1: 100},
+			}},
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			defaultTestLimits = nil
+
+			reg := prometheus.NewPedanticRegistry()
+			manager, err := New(Config{
+				ReloadPeriod:  time.Second,
+				LoadPath:      "base.yaml, env.yaml",
+				Loader:        testLoadOverrides,
+				Merger:        tc.merger,
+				StorageConfig: bucket.Config{Backend: bucket.Filesystem},
+			}, reg, log.NewNopLogger(), func(_ context.Context) (objstore.Bucket, error) {
+				return bucketClient, nil
+			})
+			require.NoError(t, err)
+			require.NoError(t, services.StartAndAwaitRunning(context.Background(), manager))
+			t.Cleanup(func() {
+				require.NoError(t, services.StopAndAwaitTerminated(context.Background(), manager))
+			})
+
+			assert.Equal(t, tc.expected, manager.GetConfig())
+
+			// The hash should cover all the files, regardless of the merger.
+			assert.NoError(t, testutil.GatherAndCompare(reg, strings.NewReader(fmt.Sprintf(`
+					# HELP runtime_config_hash Hash of the currently active runtime config file.
+					# TYPE runtime_config_hash gauge
+					runtime_config_hash{sha256="%x"} 1
+				`, sha256.Sum256(append(append([]byte{}, base...), env...)))), "runtime_config_hash"))
+		})
+	}
+}
+
+func TestManager_ShouldReadFilesConcurrently(t *testing.T) {
+	files := map[string]string{
+		"base.yaml":  "overrides:\n  user1:\n    limit1: 100\n",
+		"team.yaml":  "  user2:\n    limit2: 200\n",
+		"extra.yaml": "  user3:\n    limit1: 300\n",
+	}
+
+	t.Run("should merge the files in order once all of them have been read", func(t *testing.T) {
+		defaultTestLimits = nil
+
+		manager, err := New(Config{
+			ReloadPeriod:    time.Hour,
+			LoadPath:        "base.yaml,team.yaml,extra.yaml",
+			LoadConcurrency: len(files),
+			Loader:          testLoadOverrides,
+			StorageConfig:   bucket.Config{Backend: bucket.Filesystem},
+		}, nil, log.NewNopLogger(), mockBucketClientFactory())
+		require.NoError(t, err)
+
+		// Each read blocks until all the files are being read, and the first file is the
+		// last one to complete.
+		inflight := sync.WaitGroup{}
+		inflight.Add(len(files))
+		bucketClient := &bucket.ClientMock{}
+		bucketClient.On("Attributes", mock.Anything, mock.Anything).Return(objstore.ObjectAttributes{}, nil)
+		bucketClient.On("Get", mock.Anything, mock.Anything).Return(func(_ context.Context, name string) (io.ReadCloser, error) {
+			inflight.Done()
+			inflight.Wait()
+			if name == "base.yaml" {
+				time.Sleep(50 * time.Millisecond)
+			}
+			return io.NopCloser(strings.NewReader(files[name])), nil
+		})
+		manager.bucketClient = bucketClient
+
+		require.NoError(t, manager.loadConfig(context.Background()))
+		assert.Equal(t, &testOverrides{Overrides: map[string]*TestLimits{
+			"user1": {Limit1: 100},
+			"user2": {Limit2: 200},
+			"user3": {Limit1: 300},
+		}}, manager.GetConfig())
+	})
+
+	t.Run("should fail the reload if any file fails to be read", func(t *testing.T) {
+		defaultTestLimits = nil
+
+		manager, err := New(Config{
+			ReloadPeriod:    time.Hour,
+			LoadPath:        "base.yaml,team.yaml,extra.yaml",
+			LoadConcurrency: 2,
+			Loader:          testLoadOverrides,
+			StorageConfig:   bucket.Config{Backend: bucket.Filesystem},
+		}, nil, log.NewNopLogger(), mockBucketClientFactory())
+		require.NoError(t, err)
+
+		bucketClient := &bucket.ClientMock{}
+		bucketClient.MockGet("base.yaml", files["base.yaml"], nil)
+		bucketClient.On("Attributes", mock.Anything, "team.yaml").Return(objstore.ObjectAttributes{}, nil)
+		bucketClient.On("Get", mock.Anything, "team.yaml").Return(nil, errors.New("read failure"))
+		bucketClient.MockGet("extra.yaml", files["extra.yaml"], nil)
+		manager.bucketClient = bucketClient
+
+		require.ErrorContains(t, manager.loadConfig(context.Background()), "read file team.yaml")
+		assert.Nil(t, manager.GetConfig())
+	})
+}
+
+func TestManager_ConcurrentReloadsShouldShareTheSameLoad(t *testing.T) {
+	_, cfg := newTestOverridesManagerConfig(t, 555)
+
+	manager, err := New(cfg, nil, log.NewNopLogger(), mockBucketClientFactory())
+	require.NoError(t, err)
+
+	// Block the bucket read until the test releases it, so that the reloads overlap.
+	started := make(chan struct{})
+	release := make(chan struct{})
+	bucketClient := &bucket.ClientMock{}
+	bucketClient.On("Attributes", mock.Anything, mock.Anything).Return(objstore.ObjectAttributes{}, nil)
+	bucketClient.On("Get", mock.Anything, mock.Anything).Return(func(_ context.Context, _ string) (io.ReadCloser, error) {
+		close(started)
+		<-release
+		return io.NopCloser(bytes.NewReader(nil)), nil
+	})
+	manager.bucketClient = bucketClient
+
+	errs := make(chan error, 2)
+	go func() { errs <- manager.Reload(context.Background()) }()
+	<-started
+
+	go func() { errs <- manager.Reload(context.Background()) }()
+	// Give the second reload the time to join the in-flight one.
+	time.Sleep(100 * time.Millisecond)
+	close(release)
+
+	require.NoError(t, <-errs)
+	require.NoError(t, <-errs)
+	bucketClient.AssertNumberOfCalls(t, "Get", 1)
+	assert.Equal(t, 555, manager.GetConfig())
+}
+
+func TestManager_CanceledReloadShouldNotFailTheSharedLoad(t *testing.T) {
+	_, cfg := newTestOverridesManagerConfig(t, 556)
+
+	manager, err := New(cfg, nil, log.NewNopLogger(), mockBucketClientFactory())
+	require.NoError(t, err)
+
+	// Block the bucket read until the test releases it, so that the reloads overlap.
+	started := make(chan struct{})
+	release := make(chan struct{})
+	bucketClient := &bucket.ClientMock{}
+	bucketClient.On("Attributes", mock.Anything, mock.Anything).Return(objstore.ObjectAttributes{}, nil)
+	bucketClient.On("Get", mock.Anything, mock.Anything).Return(func(ctx context.Context, _ string) (io.ReadCloser, error) {
+		close(started)
+		<-release
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		return io.NopCloser(bytes.NewReader(nil)), nil
+	})
+	manager.bucketClient = bucketClient
+
+	ctx, cancel := context.WithCancel(context.Background())
+	canceled := make(chan error, 1)
+	go func() { canceled <- manager.Reload(ctx) }()
+	<-started
+
+	joined := make(chan error, 1)
+	go func() { joined <- manager.Reload(context.Background()) }()
+	// Give the second reload the time to join the in-flight one.
+	time.Sleep(100 * time.Millisecond)
+
+	// The canceled reload returns without waiting for the shared load.
+	cancel()
+	select {
+	case err := <-canceled:
+		require.ErrorIs(t, err, context.Canceled)
+	case <-time.After(5 * time.Second):
+		require.Fail(t, "the canceled reload is still waiting for the shared load")
+	}
+
+	close(release)
+	require.NoError(t, <-joined)
+	bucketClient.AssertNumberOfCalls(t, "Get", 1)
+	assert.Equal(t, 556, manager.GetConfig())
+}
+
+func TestManager_ShouldRejectEmptyConfig(t *testing.T) {
+	config := []byte(`overrides:
+  user1:
+    limit2: 150`)
+
+	t.Run("should keep the previous config on empty files", func(t *testing.T) {
+		defaultTestLimits = nil
+
+		reg := prometheus.NewPedanticRegistry()
+		manager, err := New(Config{
+			ReloadPeriod:  time.Hour,
+			LoadPath:      "runtime-config.yaml",
+			Loader:        testLoadOverrides,
+			StorageConfig: bucket.Config{Backend: bucket.Filesystem},
+		}, reg, log.NewNopLogger(), mockBucketClientFactory(config, []byte{}))
+		require.NoError(t, err)
+		require.NoError(t, services.StartAndAwaitRunning(context.Background(), manager))
+		t.Cleanup(func() {
+			require.NoError(t, services.StopAndAwaitTerminated(context.Background(), manager))
+		})
+
+		require.ErrorIs(t, manager.loadConfig(context.Background()), ErrEmptyConfig)
+		assert.Equal(t, 150, manager.GetConfig().(*testOverrides).Overrides["user1"].Limit2)
+		assert.NoError(t, testutil.GatherAndCompare(reg, strings.NewReader(`
+				# HELP runtime_config_last_reload_successful Whether the last runtime-config reload attempt was successful.
+				# TYPE runtime_config_last_reload_successful gauge
+				runtime_config_last_reload_successful 0
+			`), "runtime_config_last_reload_successful"))
+	})
+
+	t.Run("should clear the config on empty files if allowed", func(t *testing.T) {
+		defaultTestLimits = nil
+
+		manager, err := New(Config{
+			ReloadPeriod: time.Hour,
+			LoadPath:     "runtime-config.yaml",
+			// An empty file clears the overrides.
+			Loader: func(r io.Reader) (any, error) {
+				buf, err := io.ReadAll(r)
+				if err != nil || len(buf) == 0 {
+					return &testOverrides{}, err
+				}
+				return testLoadOverrides(bytes.NewReader(buf))
+			},
+			AllowEmpty:    true,
+			StorageConfig: bucket.Config{Backend: bucket.Filesystem},
+		}, nil, log.NewNopLogger(), mockBucketClientFactory(config, []byte{}))
+		require.NoError(t, err)
+		require.NoError(t, services.StartAndAwaitRunning(context.Background(), manager))
+		t.Cleanup(func() {
+			require.NoError(t, services.StopAndAwaitTerminated(context.Background(), manager))
+		})
+
+		require.NoError(t, manager.loadConfig(context.Background()))
+		assert.Empty(t, manager.GetConfig().(*testOverrides).Overrides)
+	})
+}
+
+func TestManager_ShouldLoadGzipCompressedConfig(t *testing.T) {
+	config := []byte(`overrides:
+  user1:
+    limit2: 150`)
+
+	var compressed bytes.Buffer
+	gz := gzip.NewWriter(&compressed)
+	_, err := gz.Write(config)
+	require.NoError(t, err)
+	require.NoError(t, gz.Close())
+
+	tests := map[string]struct {
+		loadPath    string
+		compression string
+		maxFileSize int
+		content     []byte
+		expectedErr string
+	}{
+		"should decompress a file with the .gz suffix": {
+			loadPath:    "runtime-config.yaml.gz",
+			compression: CompressionNone,
+			content:     compressed.Bytes(),
+		},
+		"should decompress a file when gzip compression is configured": {
+			loadPath:    "runtime-config.yaml",
+			compression: CompressionGzip,
+			content:     compressed.Bytes(),
+		},
+		"should fail on a file which is not gzip-compressed": {
+			loadPath:    "runtime-config.yaml",
+			compression: CompressionGzip,
+			content:     config,
+			expectedErr: "decompress gzip file",
+		},
+		"should fail on a file exceeding the max file size once decompressed": {
+			loadPath:    "runtime-config.yaml.gz",
+			compression: CompressionNone,
+			maxFileSize: len(config) - 1,
+			content:     compressed.Bytes(),
+			expectedErr: fmt.Sprintf("the file exceeds the max size of %d bytes", len(config)-1),
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			defaultTestLimits = nil
+
+			reg := prometheus.NewPedanticRegistry()
+			manager, err := New(Config{
+				ReloadPeriod:  time.Hour,
+				LoadPath:      tc.loadPath,
+				Compression:   tc.compression,
+				MaxFileSize:   tc.maxFileSize,
+				Loader:        testLoadOverrides,
+				StorageConfig: bucket.Config{Backend: bucket.Filesystem},
+			}, reg, log.NewNopLogger(), mockBucketClientFactory())
+			require.NoError(t, err)
+			manager.bucketClient = createMockBucketClient(tc.content)
+
+			err = manager.loadConfig(context.Background())
+			if tc.expectedErr != "" {
+				require.ErrorContains(t, err, tc.expectedErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, &testOverrides{Overrides: map[string]*TestLimits{"user1": {Limit2: 150}}}, manager.GetConfig())
+
+			// The hash should be computed over the decompressed content.
+			assert.NoError(t, testutil.GatherAndCompare(reg, strings.NewReader(fmt.Sprintf(`
+					# HELP runtime_config_hash Hash of the currently active runtime config file.
+					# TYPE runtime_config_hash gauge
+					runtime_config_hash{sha256="%x"} 1
+				`, sha256.Sum256(config))), "runtime_config_hash"))
+		})
+	}
+}
+
+func TestManager_ShouldTrackLoadFailuresByReason(t *testing.T) {
+	config := []byte(`overrides:
+  user1:
+    limit2: 150`)
+
+	tests := map[string]struct {
+		cfg            Config
+		bucketClient   func() *bucket.ClientMock
+		expectedReason string
+	}{
+		"should track the failures to read a file": {
+			bucketClient: func() *bucket.ClientMock {
+				bucketClient := createMockBucketClient()
+				bucketClient.On("Get", mock.Anything, mock.Anything).Return(nil, errors.New("bucket is down"))
+				return bucketClient
+			},
+			expectedReason: loadFailureRead,
+		},
+		"should track the failures to decompress a file": {
+			cfg:            Config{Compression: CompressionGzip},
+			bucketClient:   func() *bucket.ClientMock { return createMockBucketClient(config) },
+			expectedReason: loadFailureDecompress,
+		},
+		"should track the failures to parse the config": {
+			bucketClient:   func() *bucket.ClientMock { return createMockBucketClient([]byte("overrides: [")) },
+			expectedReason: loadFailureParse,
+		},
+		"should track the failures to validate the config": {
+			cfg: Config{
+				MaxTenantConfigSize: 1,
+				Loader: func(_ io.Reader) (any, error) {
+					return &testSizedConfig{sizes: map[string]int{"user1": 10}}, nil
+				},
+			},
+			bucketClient:   func() *bucket.ClientMock { return createMockBucketClient(config) },
+			expectedReason: loadFailureValidate,
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			defaultTestLimits = nil
+
+			cfg := tc.cfg
+			cfg.ReloadPeriod = time.Hour
+			cfg.LoadPath = "runtime-config.yaml"
+			cfg.StorageConfig = bucket.Config{Backend: bucket.Filesystem}
+			if cfg.Loader == nil {
+				cfg.Loader = testLoadOverrides
+			}
+
+			reg := prometheus.NewPedanticRegistry()
+			manager, err := New(cfg, reg, log.NewNopLogger(), mockBucketClientFactory())
+			require.NoError(t, err)
+			manager.bucketClient = tc.bucketClient()
+
+			require.Error(t, manager.loadConfig(context.Background()))
+
+			expected := map[string]int{loadFailureRead: 0, loadFailureDecompress: 0, loadFailureParse: 0, loadFailureValidate: 0}
+			expected[tc.expectedReason] = 1
+			assert.NoError(t, testutil.GatherAndCompare(reg, strings.NewReader(fmt.Sprintf(`
+					# HELP runtime_config_load_failures_total Total number of runtime config load failures, by the reason: read, decompress, parse or validate.
+					# TYPE runtime_config_load_failures_total counter
+					runtime_config_load_failures_total{reason="decompress"} %d
+					runtime_config_load_failures_total{reason="parse"} %d
+					runtime_config_load_failures_total{reason="read"} %d
+					runtime_config_load_failures_total{reason="validate"} %d
+				`, expected[loadFailureDecompress], expected[loadFailureParse], expected[loadFailureRead], expected[loadFailureValidate])), "runtime_config_load_failures_total"))
+		})
+	}
+}
+
+func TestManager_ShouldAbandonTheLoaderOnTimeout(t *testing.T) {
+	config := []byte(`overrides:
+  user1:
+    limit2: 150`)
+	defaultTestLimits = nil
+
+	unblock := make(chan struct{})
+	defer close(unblock)
+
+	blocking := atomic.NewBool(false)
+	reg := prometheus.NewPedanticRegistry()
+	manager, err := New(Config{
+		ReloadPeriod:  time.Hour,
+		LoadPath:      "runtime-config.yaml",
+		LoaderTimeout: 100 * time.Millisecond,
+		StorageConfig: bucket.Config{Backend: bucket.Filesystem},
+		Loader: func(r io.Reader) (any, error) {
+			if blocking.Load() {
+				<-unblock
+			}
+			return testLoadOverrides(r)
+		},
+	}, reg, log.NewNopLogger(), mockBucketClientFactory())
+	require.NoError(t, err)
+
+	// The loader returning in time.
+	manager.bucketClient = createMockBucketClient(config)
+	require.NoError(t, manager.loadConfig(context.Background()))
+	require.Equal(t, 150, manager.GetConfig().(*testOverrides).Overrides["user1"].Limit2)
+
+	// The loader hanging: the load fails and the previous config is kept.
+	blocking.Store(true)
+	manager.bucketClient = createMockBucketClient([]byte(`overrides:
+  user1:
+    limit2: 200`))
+	err = manager.loadConfig(context.Background())
+	require.ErrorIs(t, err, ErrLoaderTimeout)
+	require.Equal(t, 150, manager.GetConfig().(*testOverrides).Overrides["user1"].Limit2)
+
+	assert.NoError(t, testutil.GatherAndCompare(reg, strings.NewReader(`
+		# HELP runtime_config_loader_timeouts_total Total number of runtime config loads abandoned because the loader didn't return within the loader timeout.
+		# TYPE runtime_config_loader_timeouts_total counter
+		runtime_config_loader_timeouts_total 1
+	`), "runtime_config_loader_timeouts_total"))
+}
+
+func TestManager_ShouldEnforceMaxFileSize(t *testing.T) {
+	config := []byte(`overrides:
+  user1:
+    limit2: 150`)
+	defaultTestLimits = nil
+
+	tests := map[string]struct {
+		maxFileSize int
+		expectedErr string
+	}{
+		"should load a file within the max file size": {
+			maxFileSize: len(config),
+		},
+		"should fail on a file exceeding the max file size": {
+			maxFileSize: len(config) - 1,
+			expectedErr: fmt.Sprintf("read file runtime-config.yaml: read entire file: the file exceeds the max size of %d bytes", len(config)-1),
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			manager, err := New(Config{
+				ReloadPeriod:  time.Hour,
+				LoadPath:      "runtime-config.yaml",
+				MaxFileSize:   tc.maxFileSize,
+				Loader:        testLoadOverrides,
+				StorageConfig: bucket.Config{Backend: bucket.Filesystem},
+			}, nil, log.NewNopLogger(), mockBucketClientFactory())
+			require.NoError(t, err)
+			manager.bucketClient = createMockBucketClient(config)
+
+			err = manager.loadConfig(context.Background())
+			if tc.expectedErr != "" {
+				require.EqualError(t, err, tc.expectedErr)
+				assert.Nil(t, manager.GetConfig())
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, &testOverrides{Overrides: map[string]*TestLimits{"user1": {Limit2: 150}}}, manager.GetConfig())
+		})
+	}
+}
+
+func TestManager_ShouldTimeoutSlowReads(t *testing.T) {
+	const readTimeout = 100 * time.Millisecond
+
+	manager, err := New(Config{
+		ReloadPeriod:  time.Hour,
+		LoadPath:      "runtime-config.yaml",
+		ReadTimeout:   readTimeout,
+		Loader:        testLoadOverrides,
+		StorageConfig: bucket.Config{Backend: bucket.Filesystem},
+	}, nil, log.NewNopLogger(), mockBucketClientFactory())
+	require.NoError(t, err)
+
+	// The read hangs until its context is done.
+	bucketClient := &bucket.ClientMock{}
+	bucketClient.On("Attributes", mock.Anything, mock.Anything).Return(objstore.ObjectAttributes{}, nil)
+	bucketClient.On("Get", mock.Anything, mock.Anything).Run(func(args mock.Arguments) {
+		<-args.Get(0).(context.Context).Done()
+	}).Return(nil, context.DeadlineExceeded)
+	manager.bucketClient = bucketClient
+
+	start := time.Now()
+	err = manager.loadConfig(context.Background())
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.Less(t, time.Since(start), 10*readTimeout)
+}
+
+func TestManager_ShouldLogFullConfigOnChange(t *testing.T) {
+	config := []byte(`overrides:
+  user1:
+    limit1: 100
+    limit2: 150`)
+	defaultTestLimits = nil
+
+	logs := &concurrency.SyncBuffer{}
+	logger := level.NewFilter(log.NewLogfmtLogger(logs), level.AllowDebug())
+
+	cfg := Config{
+		ReloadPeriod:    time.Second,
+		LoadPath:        "runtime-config",
+		Loader:          testLoadOverrides,
+		LogFullOnChange: true,
+		// Pretend limit2 is a secret.
+		Redact: func(cfg any) any {
+			redacted := &testOverrides{Overrides: map[string]*TestLimits{}}
+			for user, limits := range cfg.(*testOverrides).Overrides {
+				redacted.Overrides[user] = &TestLimits{Limit1: limits.Limit1}
+			}
+			return redacted
+		},
+		StorageConfig: bucket.Config{Backend: bucket.Filesystem},
+	}
+
+	manager, err := New(cfg, nil, logger, mockBucketClientFactory(config, config))
+	require.NoError(t, err)
+	require.NoError(t, services.StartAndAwaitRunning(context.Background(), manager))
+	t.Cleanup(func() {
+		require.NoError(t, services.StopAndAwaitTerminated(context.Background(), manager))
+	})
+
+	_, hash := manager.GetConfigWithHash()
+	assert.Equal(t, fmt.Sprintf("level=debug msg=\"applied runtime config\" sha256=%s config=\"overrides:\\n  user1:\\n    limit1: 100\\n    limit2: 0\\n\"\n", hash), logs.String())
+
+	// Reloading the same config should not log it again.
+	require.NoError(t, manager.loadConfig(context.Background()))
+	assert.Equal(t, 1, strings.Count(logs.String(), "applied runtime config"))
+}
+
+func mockBucketClientFactory(configs ...[]byte) BucketClientFactory {
+	return func(ctx context.Context) (objstore.Bucket, error) {
+		return createMockBucketClient(configs...), nil
+	}
+}
+
+func mockFallbackBucketClientFactory(bucketClients ...objstore.Bucket) FallbackBucketClientFactory {
+	return func(_ context.Context, index int, _ Source) (objstore.Bucket, error) {
+		return bucketClients[index], nil
+	}
+}
+
+func createMockBucketClient(configs ...[]byte) *bucket.ClientMock {
+	bucketClient := bucket.ClientMock{}
+	// No attributes, so that the files are always downloaded.
+	bucketClient.On("Attributes", mock.Anything, mock.Anything).Return(objstore.ObjectAttributes{}, nil).Maybe()
+	bucketClient.On("Exists", mock.Anything, mock.Anything).Return(true, nil).Maybe()
+	for _, config := range configs {
+		bucketClient.On("Get", mock.Anything, mock.Anything).Return(io.NopCloser(bytes.NewBuffer(config)), nil).Once()
+	}
+	return &bucketClient
+}
+
+func TestLoadAndValidate(t *testing.T) {
+	validator := func(cfg any, _ any) error {
+		if _, ok := cfg.(*testOverrides).Overrides["unknown"]; ok {
+			return errors.New("unknown tenant")
+		}
+		return nil
+	}
+
+	// The loader is slower than the loader timeout, which is ignored.
+	slowLoader := func(r io.Reader) (any, error) {
+		time.Sleep(50 * time.Millisecond)
+		return testLoadOverrides(r)
+	}
+
+	// The fallback would load successfully, but is never read.
+	fallback := createMockBucketClient([]byte(`overrides:
+  user1:
+    limit2: 150`))
+
+	tests := map[string]struct {
+		config      string
+		cfg         Config
+		loader      Loader
+		expectedErr string
+	}{
+		"should return the loaded config": {
+			config: `overrides:
+  user1:
+    limit2: 150`,
+			cfg: Config{LoadPath: "runtime-config", StorageConfig: bucket.Config{Backend: bucket.Filesystem}},
+		},
+		"should fail on invalid config": {
+			cfg:         Config{StorageConfig: bucket.Config{Backend: bucket.Filesystem}},
+			expectedErr: "LoadPath is empty",
+		},
+		"should fail on invalid file": {
+			config:      `overrides: [`,
+			cfg:         Config{LoadPath: "runtime-config", StorageConfig: bucket.Config{Backend: bucket.Filesystem}},
+			expectedErr: "load file",
+		},
+		"should fail on missing file": {
+			cfg:         Config{LoadPath: "runtime-config", StorageConfig: bucket.Config{Backend: bucket.Filesystem}},
+			expectedErr: "read file runtime-config",
+		},
+		"should fail on config rejected by the validator": {
+			config: `overrides:
+  unknown:
+    limit2: 150`,
+			cfg:         Config{LoadPath: "runtime-config", ClusterStateValidator: validator, StorageConfig: bucket.Config{Backend: bucket.Filesystem}},
+			expectedErr: "validate against cluster state: unknown tenant",
+		},
+		"should fail on missing file even if a fallback is configured": {
+			cfg: Config{
+				LoadPath:                    "runtime-config",
+				StorageConfig:               bucket.Config{Backend: bucket.Filesystem},
+				Fallbacks:                   []Source{{LoadPath: "runtime-config", StorageConfig: bucket.Config{Backend: bucket.Filesystem}}},
+				FallbackBucketClientFactory: mockFallbackBucketClientFactory(fallback),
+			},
+			expectedErr: "read file runtime-config",
+		},
+		"should call the loader regardless of the loader timeout": {
+			config: `overrides:
+  user1:
+    limit2: 150`,
+			cfg:    Config{LoadPath: "runtime-config", LoaderTimeout: time.Millisecond, StorageConfig: bucket.Config{Backend: bucket.Filesystem}},
+			loader: slowLoader,
+		},
+	}
+
+	for testName, testData := range tests {
+		t.Run(testName, func(t *testing.T) {
+			bucketClient := &bucket.ClientMock{}
+			if testData.config != "" {
+				bucketClient.MockGet("runtime-config", testData.config, nil)
+			} else {
+				bucketClient.On("Attributes", mock.Anything, "runtime-config").Return(objstore.ObjectAttributes{}, errors.New("object not found"))
+				bucketClient.On("Get", mock.Anything, "runtime-config").Return(nil, errors.New("object not found"))
+			}
+
+			testData.cfg.Loader = testLoadOverrides
+			if testData.loader != nil {
+				testData.cfg.Loader = testData.loader
+			}
+			loaded, err := LoadAndValidate(context.Background(), testData.cfg, func(context.Context) (objstore.Bucket, error) {
+				return bucketClient, nil
+			})
+			if testData.expectedErr != "" {
+				require.ErrorContains(t, err, testData.expectedErr)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, 150, loaded.(*testOverrides).Overrides["user1"].Limit2)
+		})
 	}
-	return &bucketClient
 }
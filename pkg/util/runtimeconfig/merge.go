@@ -0,0 +1,97 @@
+package runtimeconfig
+
+import (
+	"fmt"
+
+	"gopkg.in/yaml.v2"
+)
+
+const (
+	// MergeStrategyDeepMerge recursively merges the maps of a section, the values of the
+	// last file taking precedence. Values other than maps are replaced.
+	MergeStrategyDeepMerge = "deep-merge"
+	// MergeStrategyReplace replaces the whole section with the one of the last file.
+	MergeStrategyReplace = "replace"
+	// MergeStrategyAppend concatenates the lists of a section, in file order.
+	MergeStrategyAppend = "append"
+)
+
+func validateMergeStrategies(strategies map[string]string) error {
+	for section, strategy := range strategies {
+		switch strategy {
+		case MergeStrategyDeepMerge, MergeStrategyReplace, MergeStrategyAppend:
+		default:
+			return fmt.Errorf("unsupported merge strategy %q for section %s", strategy, section)
+		}
+	}
+	return nil
+}
+
+// NewSectionMerger returns a Merger parsing each file as YAML and merging each top-level
+// section with the strategy configured for it. Sections without a configured strategy
+// are deep-merged.
+func NewSectionMerger(strategies map[string]string) Merger {
+	return func(parts [][]byte) ([]byte, error) {
+		// Keep a single file as is.
+		if len(parts) == 1 {
+			return parts[0], nil
+		}
+
+		merged := map[any]any{}
+		for _, part := range parts {
+			sections := map[any]any{}
+			if err := yaml.Unmarshal(part, &sections); err != nil {
+				return nil, NewYAMLDecodeError(part, err)
+			}
+
+			for section, value := range sections {
+				prev, ok := merged[section]
+				if !ok {
+					merged[section] = value
+					continue
+				}
+
+				var err error
+				if merged[section], err = mergeSection(fmt.Sprint(section), strategies[fmt.Sprint(section)], prev, value); err != nil {
+					return nil, err
+				}
+			}
+		}
+
+		return yaml.Marshal(merged)
+	}
+}
+
+func mergeSection(section, strategy string, prev, next any) (any, error) {
+	switch strategy {
+	case MergeStrategyReplace:
+		return next, nil
+	case MergeStrategyAppend:
+		prevList, prevOK := prev.([]any)
+		nextList, nextOK := next.([]any)
+		if !prevOK || !nextOK {
+			return nil, fmt.Errorf("section %s can't be appended because it's not a list", section)
+		}
+		return append(prevList, nextList...), nil
+	default:
+		return deepMerge(prev, next), nil
+	}
+}
+
+// deepMerge recursively merges next into prev if both are maps, otherwise returns next.
+func deepMerge(prev, next any) any {
+	prevMap, prevOK := prev.(map[any]any)
+	nextMap, nextOK := next.(map[any]any)
+	if !prevOK || !nextOK {
+		return next
+	}
+
+	for key, value := range nextMap {
+		if prevValue, ok := prevMap[key]; ok {
+			prevMap[key] = deepMerge(prevValue, value)
+		} else {
+			prevMap[key] = value
+		}
+	}
+	return prevMap
+}
@@ -0,0 +1,81 @@
+package runtimeconfig
+
+import (
+	"testing"
+
+	"github.com/go-kit/log"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/yaml.v2"
+
+	"github.com/cortexproject/cortex/pkg/storage/bucket"
+)
+
+func TestNewSectionMerger(t *testing.T) {
+	base := []byte(`
+overrides:
+  user1:
+    limit1: 100
+    limit2: 100
+rules:
+  - first
+settings:
+  a: 1
+`)
+	env := []byte(`
+overrides:
+  user1:
+    limit2: 200
+  user2:
+    limit1: 300
+rules:
+  - second
+settings:
+  b: 2
+`)
+
+	merger := NewSectionMerger(map[string]string{
+		"rules":    MergeStrategyAppend,
+		"settings": MergeStrategyReplace,
+	})
+
+	out, err := merger([][]byte{base, env})
+	require.NoError(t, err)
+
+	actual := map[string]any{}
+	require.NoError(t, yaml.Unmarshal(out, &actual))
+
+	assert.Equal(t, map[string]any{
+		// Sections without a strategy are deep-merged.
+		"overrides": map[any]any{
+			"user1": map[any]any{"limit1": 100, "limit2": 200},
+			"user2": map[any]any{"limit1": 300},
+		},
+		"rules":    []any{"first", "second"},
+		"settings": map[any]any{"b": 2},
+	}, actual)
+}
+
+func TestNewSectionMerger_ShouldKeepSingleFileAsIs(t *testing.T) {
+	content := []byte("overrides:\n  user1:\n    limit1: 100\n")
+
+	out, err := NewSectionMerger(nil)([][]byte{content})
+	require.NoError(t, err)
+	assert.Equal(t, content, out)
+}
+
+func TestNewSectionMerger_ShouldFailAppendingNonListSections(t *testing.T) {
+	merger := NewSectionMerger(map[string]string{"overrides": MergeStrategyAppend})
+
+	_, err := merger([][]byte{[]byte("overrides:\n  user1: {}\n"), []byte("overrides:\n  user2: {}\n")})
+	require.ErrorContains(t, err, "section overrides can't be appended")
+}
+
+func TestNew_ShouldRejectUnsupportedMergeStrategy(t *testing.T) {
+	_, err := New(Config{
+		LoadPath:        "runtime-config",
+		MergeStrategies: map[string]string{"overrides": "unknown"},
+		StorageConfig:   bucket.Config{Backend: bucket.Filesystem},
+	}, nil, log.NewNopLogger(), mockBucketClientFactory())
+	require.ErrorContains(t, err, `unsupported merge strategy "unknown" for section overrides`)
+}
@@ -0,0 +1,144 @@
+package runtimeconfig
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// maxScheduleLookahead is how far in the future the next time matching a reload schedule is
+// searched. It's long enough for the schedules matching once every leap year.
+const maxScheduleLookahead = 5 * 365 * 24 * time.Hour
+
+// cronSchedule is a cron expression made of the 5 standard fields: minute, hour, day of the
+// month, month and day of the week. Each field is a set of the matching values.
+type cronSchedule struct {
+	minutes, hours, days, months, weekdays uint64
+
+	// daysRestricted and weekdaysRestricted are whether the day of the month and the day of the
+	// week fields aren't "*". As in cron, if both are restricted, a day matching either of them
+	// matches the schedule.
+	daysRestricted, weekdaysRestricted bool
+}
+
+// parseCronSchedule parses a cron expression made of the 5 standard fields. Each field is
+// either "*" or a comma-separated list of values and ranges, e.g. "1-5", optionally with a
+// step, e.g. "*/15". Days of the week range from 0 to 7, where both 0 and 7 are Sunday.
+func parseCronSchedule(expr string) (*cronSchedule, error) {
+	fields := strings.Fields(expr)
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("expected 5 fields, got %d", len(fields))
+	}
+
+	var (
+		s   cronSchedule
+		err error
+	)
+	if s.minutes, err = parseCronField(fields[0], 0, 59); err != nil {
+		return nil, fmt.Errorf("minute: %w", err)
+	}
+	if s.hours, err = parseCronField(fields[1], 0, 23); err != nil {
+		return nil, fmt.Errorf("hour: %w", err)
+	}
+	if s.days, err = parseCronField(fields[2], 1, 31); err != nil {
+		return nil, fmt.Errorf("day of month: %w", err)
+	}
+	if s.months, err = parseCronField(fields[3], 1, 12); err != nil {
+		return nil, fmt.Errorf("month: %w", err)
+	}
+	if s.weekdays, err = parseCronField(fields[4], 0, 7); err != nil {
+		return nil, fmt.Errorf("day of week: %w", err)
+	}
+
+	// Sunday is both 0 and 7.
+	if s.weekdays&(1<<7) != 0 {
+		s.weekdays |= 1
+	}
+	s.daysRestricted = fields[2] != "*"
+	s.weekdaysRestricted = fields[4] != "*"
+
+	if s.next(time.Now()).IsZero() {
+		return nil, fmt.Errorf("the schedule never matches")
+	}
+	return &s, nil
+}
+
+// parseCronField returns the set of values, between minValue and maxValue, matched by the field.
+func parseCronField(field string, minValue, maxValue int) (uint64, error) {
+	var set uint64
+	for _, part := range strings.Split(field, ",") {
+		rng, step := part, 1
+		if i := strings.IndexByte(part, '/'); i >= 0 {
+			var err error
+			if step, err = strconv.Atoi(part[i+1:]); err != nil || step <= 0 {
+				return 0, fmt.Errorf("invalid step in %q", part)
+			}
+			rng = part[:i]
+		}
+
+		var start, end int
+		switch {
+		case rng == "*":
+			start, end = minValue, maxValue
+		case strings.Contains(rng, "-"):
+			bounds := strings.SplitN(rng, "-", 2)
+			var err1, err2 error
+			start, err1 = strconv.Atoi(bounds[0])
+			end, err2 = strconv.Atoi(bounds[1])
+			if err1 != nil || err2 != nil || start > end {
+				return 0, fmt.Errorf("invalid range %q", rng)
+			}
+		default:
+			var err error
+			if start, err = strconv.Atoi(rng); err != nil {
+				return 0, fmt.Errorf("invalid value %q", rng)
+			}
+			// A single value with a step matches from the value onward.
+			end = start
+			if strings.Contains(part, "/") {
+				end = maxValue
+			}
+		}
+
+		if start < minValue || end > maxValue {
+			return 0, fmt.Errorf("%q is out of the range [%d, %d]", rng, minValue, maxValue)
+		}
+		for v := start; v <= end; v += step {
+			set |= 1 << v
+		}
+	}
+	return set, nil
+}
+
+// next returns the first time, strictly after the given one and truncated to the minute,
+// matching the schedule in UTC. It returns the zero time if none is found within
+// maxScheduleLookahead.
+func (s *cronSchedule) next(after time.Time) time.Time {
+	t := after.UTC().Truncate(time.Minute).Add(time.Minute)
+	for limit := t.Add(maxScheduleLookahead); t.Before(limit); {
+		switch {
+		case s.months&(1<<int(t.Month())) == 0:
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, time.UTC)
+		case !s.matchesDay(t):
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, time.UTC)
+		case s.hours&(1<<t.Hour()) == 0:
+			t = t.Truncate(time.Hour).Add(time.Hour)
+		case s.minutes&(1<<t.Minute()) == 0:
+			t = t.Add(time.Minute)
+		default:
+			return t
+		}
+	}
+	return time.Time{}
+}
+
+func (s *cronSchedule) matchesDay(t time.Time) bool {
+	day := s.days&(1<<t.Day()) != 0
+	weekday := s.weekdays&(1<<int(t.Weekday())) != 0
+
+	if s.daysRestricted && s.weekdaysRestricted {
+		return day || weekday
+	}
+	return day && weekday
+}
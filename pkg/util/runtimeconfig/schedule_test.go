@@ -0,0 +1,106 @@
+package runtimeconfig
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCronSchedule_Next(t *testing.T) {
+	// A Wednesday.
+	now := time.Date(2024, time.January, 10, 10, 15, 30, 0, time.UTC)
+
+	tests := map[string]struct {
+		schedule string
+		after    time.Time
+		expected time.Time
+	}{
+		"every minute": {
+			schedule: "* * * * *",
+			after:    now,
+			expected: time.Date(2024, time.January, 10, 10, 16, 0, 0, time.UTC),
+		},
+		"every 15 minutes": {
+			schedule: "*/15 * * * *",
+			after:    now,
+			expected: time.Date(2024, time.January, 10, 10, 30, 0, 0, time.UTC),
+		},
+		"strictly after the given time": {
+			schedule: "30 10 * * *",
+			after:    time.Date(2024, time.January, 10, 10, 30, 0, 0, time.UTC),
+			expected: time.Date(2024, time.January, 11, 10, 30, 0, 0, time.UTC),
+		},
+		"every day at 02:30": {
+			schedule: "30 2 * * *",
+			after:    now,
+			expected: time.Date(2024, time.January, 11, 2, 30, 0, 0, time.UTC),
+		},
+		"list of hours": {
+			schedule: "0 6,12,18 * * *",
+			after:    now,
+			expected: time.Date(2024, time.January, 10, 12, 0, 0, 0, time.UTC),
+		},
+		"weekdays only": {
+			schedule: "0 0 * * 1-5",
+			after:    time.Date(2024, time.January, 12, 12, 0, 0, 0, time.UTC),
+			expected: time.Date(2024, time.January, 15, 0, 0, 0, 0, time.UTC),
+		},
+		"sunday as 7": {
+			schedule: "0 0 * * 7",
+			after:    now,
+			expected: time.Date(2024, time.January, 14, 0, 0, 0, 0, time.UTC),
+		},
+		"first day of the quarter": {
+			schedule: "0 0 1 */3 *",
+			after:    now,
+			expected: time.Date(2024, time.April, 1, 0, 0, 0, 0, time.UTC),
+		},
+		"day of month or day of week": {
+			schedule: "0 0 20 * 5",
+			after:    now,
+			expected: time.Date(2024, time.January, 12, 0, 0, 0, 0, time.UTC),
+		},
+		"leap day": {
+			schedule: "0 0 29 2 *",
+			after:    time.Date(2024, time.March, 1, 0, 0, 0, 0, time.UTC),
+			expected: time.Date(2028, time.February, 29, 0, 0, 0, 0, time.UTC),
+		},
+		"in UTC": {
+			schedule: "0 12 * * *",
+			// 11:30 in UTC.
+			after:    time.Date(2024, time.January, 10, 12, 30, 0, 0, time.FixedZone("UTC+1", 3600)),
+			expected: time.Date(2024, time.January, 10, 12, 0, 0, 0, time.UTC),
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			s, err := parseCronSchedule(tc.schedule)
+			require.NoError(t, err)
+			assert.Equal(t, tc.expected, s.next(tc.after))
+		})
+	}
+}
+
+func TestParseCronSchedule_ShouldRejectInvalidSchedules(t *testing.T) {
+	tests := map[string]string{
+		"* * * *":       "expected 5 fields, got 4",
+		"60 * * * *":    `minute: "60" is out of the range [0, 59]`,
+		"* 1-24 * * *":  `hour: "1-24" is out of the range [0, 23]`,
+		"* * 0 * *":     `day of month: "0" is out of the range [1, 31]`,
+		"* * * 5-1 *":   `month: invalid range "5-1"`,
+		"* * * * mon":   `day of week: invalid value "mon"`,
+		"*/0 * * * *":   `minute: invalid step in "*/0"`,
+		"0 0 30 2 *":    "the schedule never matches",
+		"0 0 31 4,6 * ": "the schedule never matches",
+	}
+
+	for schedule, expectedErr := range tests {
+		t.Run(schedule, func(t *testing.T) {
+			_, err := parseCronSchedule(schedule)
+			require.EqualError(t, err, expectedErr)
+		})
+	}
+}
@@ -0,0 +1,56 @@
+package runtimeconfig
+
+import (
+	"path/filepath"
+	"slices"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+
+	"github.com/cortexproject/cortex/pkg/storage/bucket"
+)
+
+// watchDebounce is how long the Manager waits for the watched files to stop changing
+// before reloading them, since editors often write a file more than once when saving it.
+const watchDebounce = 100 * time.Millisecond
+
+// watchedFiles returns the local paths of the runtime config files to watch, if watching
+// is enabled and supported by the storage backend.
+func (om *Manager) watchedFiles() []string {
+	if !om.cfg.WatchFilesystem || om.cfg.HTTPURL != "" || om.cfg.StorageConfig.Backend != bucket.Filesystem {
+		return nil
+	}
+
+	var files []string
+	for _, path := range om.cfg.loadPaths() {
+		files = append(files, filepath.Clean(filepath.Join(om.cfg.StorageConfig.Filesystem.Directory, objectKey(om.cfg.Prefix, path))))
+	}
+	return files
+}
+
+// newFilesWatcher returns a watcher of the given files. The parent directories are watched,
+// instead of the files themselves, so that the files replaced by renaming another file
+// over them keep being watched.
+func newFilesWatcher(files []string) (*fsnotify.Watcher, error) {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, file := range files {
+		if err := watcher.Add(filepath.Dir(file)); err != nil {
+			_ = watcher.Close()
+			return nil, err
+		}
+	}
+	return watcher, nil
+}
+
+// isWatchedFileChange returns whether the event is about the content of a watched file
+// having changed.
+func isWatchedFileChange(event fsnotify.Event, files []string) bool {
+	if !event.Has(fsnotify.Write) && !event.Has(fsnotify.Create) && !event.Has(fsnotify.Rename) {
+		return false
+	}
+	return slices.Contains(files, filepath.Clean(event.Name))
+}
@@ -0,0 +1,125 @@
+package runtimeconfig
+
+import (
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/stretchr/testify/require"
+	"github.com/thanos-io/objstore"
+	"github.com/thanos-io/objstore/providers/filesystem"
+
+	"github.com/cortexproject/cortex/pkg/storage/bucket"
+	"github.com/cortexproject/cortex/pkg/util/services"
+	"github.com/cortexproject/cortex/pkg/util/test"
+)
+
+func TestManager_ShouldReloadWatchedFilesOnChange(t *testing.T) {
+	tests := map[string]struct {
+		watch          bool
+		backend        string
+		expectedReload bool
+	}{
+		"should reload on change if watching is enabled": {
+			watch:          true,
+			backend:        bucket.Filesystem,
+			expectedReload: true,
+		},
+		"should not reload on change if watching is disabled": {
+			watch:   false,
+			backend: bucket.Filesystem,
+		},
+		"should not reload on change if the backend can't be watched": {
+			watch:   true,
+			backend: bucket.S3,
+		},
+	}
+
+	for testName, testData := range tests {
+		t.Run(testName, func(t *testing.T) {
+			dir := t.TempDir()
+			require.NoError(t, os.WriteFile(filepath.Join(dir, "runtime.yaml"), []byte("1"), 0600))
+
+			cfg := Config{
+				ReloadPeriod:    time.Hour,
+				LoadPath:        "runtime.yaml",
+				WatchFilesystem: testData.watch,
+				Loader: func(r io.Reader) (any, error) {
+					buf, err := io.ReadAll(r)
+					if err != nil {
+						return nil, err
+					}
+					return strconv.Atoi(strings.TrimSpace(string(buf)))
+				},
+			}
+			cfg.StorageConfig.Backend = testData.backend
+			cfg.StorageConfig.Filesystem.Directory = dir
+
+			manager, err := New(cfg, nil, log.NewNopLogger(), func(context.Context) (objstore.Bucket, error) {
+				return filesystem.NewBucket(dir)
+			})
+			require.NoError(t, err)
+			require.NoError(t, services.StartAndAwaitRunning(context.Background(), manager))
+			defer services.StopAndAwaitTerminated(context.Background(), manager) //nolint:errcheck
+			require.Equal(t, 1, manager.GetConfig())
+
+			// Write the file twice, as editors often do.
+			require.NoError(t, os.WriteFile(filepath.Join(dir, "runtime.yaml"), []byte("2"), 0600))
+			require.NoError(t, os.WriteFile(filepath.Join(dir, "runtime.yaml"), []byte("22"), 0600))
+
+			if testData.expectedReload {
+				test.Poll(t, 2*time.Second, 22, func() any {
+					return manager.GetConfig()
+				})
+				return
+			}
+
+			time.Sleep(4 * watchDebounce)
+			require.Equal(t, 1, manager.GetConfig())
+		})
+	}
+}
+
+func TestManager_ShouldReloadWatchedFilesReplacedByRename(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "runtime.yaml"), []byte("1"), 0600))
+
+	cfg := Config{
+		ReloadPeriod:    time.Hour,
+		LoadPath:        "runtime.yaml",
+		WatchFilesystem: true,
+		Loader: func(r io.Reader) (any, error) {
+			buf, err := io.ReadAll(r)
+			if err != nil {
+				return nil, err
+			}
+			return strconv.Atoi(strings.TrimSpace(string(buf)))
+		},
+	}
+	cfg.StorageConfig.Backend = bucket.Filesystem
+	cfg.StorageConfig.Filesystem.Directory = dir
+
+	manager, err := New(cfg, nil, log.NewNopLogger(), func(context.Context) (objstore.Bucket, error) {
+		return filesystem.NewBucket(dir)
+	})
+	require.NoError(t, err)
+	require.NoError(t, services.StartAndAwaitRunning(context.Background(), manager))
+	defer services.StopAndAwaitTerminated(context.Background(), manager) //nolint:errcheck
+
+	// Atomically replace the file, which keeps being watched afterwards.
+	for _, value := range []string{"2", "3"} {
+		require.NoError(t, os.WriteFile(filepath.Join(dir, "runtime.yaml.tmp"), []byte(value), 0600))
+		require.NoError(t, os.Rename(filepath.Join(dir, "runtime.yaml.tmp"), filepath.Join(dir, "runtime.yaml")))
+
+		expected, _ := strconv.Atoi(value)
+		test.Poll(t, 2*time.Second, expected, func() any {
+			return manager.GetConfig()
+		})
+	}
+}
@@ -218,6 +218,7 @@ type Limits struct {
 	// Store-gateway.
 	StoreGatewayTenantShardSize  float64 `yaml:"store_gateway_tenant_shard_size" json:"store_gateway_tenant_shard_size"`
 	MaxDownloadedBytesPerRequest int     `yaml:"max_downloaded_bytes_per_request" json:"max_downloaded_bytes_per_request"`
+	StoreGatewayPartialResponse  bool    `yaml:"store_gateway_partial_response" json:"store_gateway_partial_response"`
 
 	// Compactor.
 	CompactorBlocksRetentionPeriod   model.Duration `yaml:"compactor_blocks_retention_period" json:"compactor_blocks_retention_period"`
@@ -347,6 +348,7 @@ func (l *Limits) RegisterFlags(f *flag.FlagSet) {
 	// Store-gateway.
 	f.Float64Var(&l.StoreGatewayTenantShardSize, "store-gateway.tenant-shard-size", 0, "The default tenant's shard size when the shuffle-sharding strategy is used. Must be set when the store-gateway sharding is enabled with the shuffle-sharding strategy. When this setting is specified in the per-tenant overrides, a value of 0 disables shuffle sharding for the tenant. If the value is < 1 the shard size will be a percentage of the total store-gateways.")
 	f.IntVar(&l.MaxDownloadedBytesPerRequest, "store-gateway.max-downloaded-bytes-per-request", 0, "The maximum number of data bytes to download per gRPC request in Store Gateway, including Series/LabelNames/LabelValues requests. 0 to disable.")
+	f.BoolVar(&l.StoreGatewayPartialResponse, "querier.store-gateway-partial-response", false, "If enabled, when a store-gateway fails a Series request with a non-retryable error other than a limit error, the querier returns the series of the other store-gateways along with a warning, instead of failing the query. The query fails if all the store-gateways fail.")
 
 	// Alertmanager.
 	f.Var(&l.AlertmanagerReceiversBlockCIDRNetworks, "alertmanager.receivers-firewall-block-cidr-networks", "Comma-separated list of network CIDRs to block in Alertmanager receiver integrations.")
@@ -987,6 +989,12 @@ func (o *Overrides) StoreGatewayTenantShardSize(userID string) float64 {
 	return o.GetOverridesForUser(userID).StoreGatewayTenantShardSize
 }
 
+// StoreGatewayPartialResponse returns whether the queries of a given user return the series
+// of the store-gateways which succeeded, with a warning, if other store-gateways failed.
+func (o *Overrides) StoreGatewayPartialResponse(userID string) bool {
+	return o.GetOverridesForUser(userID).StoreGatewayPartialResponse
+}
+
 // MaxHAReplicaGroups returns maximum number of clusters that HA tracker will track for a user.
 func (o *Overrides) MaxHAReplicaGroups(user string) int {
 	return o.GetOverridesForUser(user).HAMaxClusters